@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"math/rand"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,6 +21,7 @@ import (
 
 	"garydmenezes.com/mathgame/server/api"
 	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/youtubefake"
 )
 
 func main() {
@@ -22,6 +29,8 @@ func main() {
 	// gin's request log. CLI flags can still override.
 	flag.Set("alsologtostderr", "true")
 	flag.Set("stderrthreshold", "INFO")
+	fakeYouTube := flag.Bool("fake_youtube", false, "dev mode: serve YouTube from the recorded fixtures in server/youtubefake instead of googleapis")
+	shutdownTimeout := flag.Duration("shutdown_timeout", 30*time.Second, "on SIGTERM/SIGINT, how long to drain in-flight requests and background jobs before cancelling them")
 	// call this for glog to work
 	flag.Parse()
 
@@ -30,6 +39,21 @@ func main() {
 	if err != nil {
 		glog.Fatal(err)
 	}
+	if *fakeYouTube {
+		if gin.Mode() == gin.ReleaseMode {
+			glog.Fatal("-fake_youtube is a dev mode; refusing to start in release mode")
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			glog.Fatal(err)
+		}
+		go http.Serve(ln, youtubefake.New())
+		c.YouTubeAPIBaseURL = "http://" + ln.Addr().String() + youtubefake.APIPath
+		if c.YouTubeAPIKey == "" {
+			c.YouTubeAPIKey = "fake"
+		}
+		glog.Infof("YouTube: serving recorded fixtures from %s", c.YouTubeAPIBaseURL)
+	}
 	if err := c.Validate(); err != nil {
 		glog.Fatal(err)
 	}
@@ -48,10 +72,50 @@ func main() {
 	if err != nil {
 		glog.Fatal(err)
 	}
-	api_router := api.GetRouter()
-	if gin.Mode() == gin.ReleaseMode {
-		api_router.RunTLS(fmt.Sprintf(":%s", c.ApiPort), "/etc/letsencrypt/live/mikeymath.org/fullchain.pem", "/etc/letsencrypt/live/mikeymath.org/privkey.pem")
-	} else {
-		api_router.Run(fmt.Sprintf(":%s", c.ApiPort))
+	if err := api.StartGenerationWorkers(); err != nil {
+		glog.Fatal(err)
+	}
+	// Not fatal: the first serve retries the load.
+	if err := api.LoadCandidateIndex(); err != nil {
+		glog.Errorf("candidate index: %v", err)
+	}
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", c.ApiPort),
+		Handler: api.GetRouter(),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if gin.Mode() == gin.ReleaseMode {
+			serveErr <- srv.ListenAndServeTLS("/etc/letsencrypt/live/mikeymath.org/fullchain.pem", "/etc/letsencrypt/live/mikeymath.org/privkey.pem")
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	// systemd stops the unit with SIGTERM; Ctrl-C sends SIGINT in dev.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	select {
+	case err := <-serveErr:
+		glog.Fatalf("server: %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Stop accepting and drain in-flight requests, then let background jobs
+	// finish; both share one deadline, after which the jobs are cancelled.
+	glog.Infof("shutting down (deadline %s)", *shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		glog.Errorf("http shutdown: %v", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		glog.Errorf("server: %v", err)
+	}
+	if err := api.Shutdown(shutdownCtx); err != nil {
+		glog.Errorf("background shutdown: %v", err)
 	}
+	glog.Info("shutdown complete")
+	glog.Flush()
 }
@@ -1,31 +1,25 @@
 // check_disabled_videos lists videos with disabled=1 and checks if each can be played
-// (exists, public, embeddable) via YouTube Data API v3 or oembed fallback.
+// through its video provider: for YouTube, exists, public and embeddable via the
+// YouTube Data API v3 (or an oembed fallback without an API key); for the local
+// media library, the file is still in the library.
 package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
 	"os"
 	"strings"
-	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 
+	"garydmenezes.com/mathgame/server/api"
 	"garydmenezes.com/mathgame/server/common"
 )
 
 const (
-	queryDisabledVideos = `SELECT id, title, url, thumbnailurl, you_tube_id, disabled FROM videos WHERE disabled = 1`
+	queryDisabledVideos = `SELECT id, title, url, thumbnailurl, you_tube_id, disabled, provider FROM videos WHERE disabled = 1`
 	updateVideoEnabled  = `UPDATE videos SET disabled=0 WHERE id=?`
-	youTubeVideosURL    = "https://www.googleapis.com/youtube/v3/videos"
-	youTubeOembedURL    = "https://www.youtube.com/oembed"
-	batchSize           = 50
-	httpTimeout         = 15 * time.Second
 )
 
 type videoRow struct {
@@ -35,27 +29,12 @@ type videoRow struct {
 	ThumbnailURL string
 	YouTubeId    string
 	Disabled     bool
+	Provider     string
 }
 
-type youTubeVideosResponse struct {
-	Items []struct {
-		Id     string `json:"id"`
-		Status struct {
-			Embeddable      bool   `json:"embeddable"`
-			PrivacyStatus   string `json:"privacyStatus"`
-			UploadStatus    string `json:"uploadStatus"`
-			RejectionReason string `json:"rejectionReason"`
-		} `json:"status"`
-	} `json:"items"`
-}
-
-type result string
-
 const (
-	resultPlayable    result = "playable"
-	resultNotPlayable result = "not playable"
-	resultError       result = "error"
-	resultNoID        result = "no YouTube ID"
+	resultNoID       api.Playability = "no external ID"
+	resultNoProvider api.Playability = "provider not configured"
 )
 
 func main() {
@@ -94,7 +73,7 @@ func main() {
 	for rows.Next() {
 		var v videoRow
 		var thumb string
-		if err := rows.Scan(&v.Id, &v.Title, &v.URL, &thumb, &v.YouTubeId, &v.Disabled); err != nil {
+		if err := rows.Scan(&v.Id, &v.Title, &v.URL, &thumb, &v.YouTubeId, &v.Disabled, &v.Provider); err != nil {
 			fmt.Fprintf(os.Stderr, "scan row: %v\n", err)
 			os.Exit(1)
 		}
@@ -111,36 +90,51 @@ func main() {
 		return
 	}
 
-	client := &http.Client{Timeout: httpTimeout}
-	playableByID := make(map[string]result)
-
-	if strings.TrimSpace(c.YouTubeAPIKey) != "" {
-		checkViaYouTubeAPI(client, c.YouTubeAPIKey, videos, playableByID)
-	} else {
-		fmt.Fprintln(os.Stderr, "No youtube_api_key in config; using oembed fallback (best-effort only).")
-		checkViaOembed(client, videos, playableByID)
+	providers, err := api.NewVideoProviders(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "video providers: %v\n", err)
+		os.Exit(1)
+	}
+	if strings.TrimSpace(c.YouTubeAPIKey) == "" {
+		fmt.Fprintln(os.Stderr, "No youtube_api_key in config; using oembed fallback for YouTube (best-effort only).")
+	}
+	idsByProvider := make(map[string][]string)
+	for _, v := range videos {
+		if strings.TrimSpace(v.YouTubeId) != "" {
+			idsByProvider[v.Provider] = append(idsByProvider[v.Provider], v.YouTubeId)
+		}
+	}
+	resultByProvider := make(map[string]map[string]api.Playability)
+	for name, ids := range idsByProvider {
+		if p, ok := providers[name]; ok {
+			resultByProvider[name] = p.CheckPlayable(ids)
+		}
 	}
 
 	fmt.Printf("%d disabled video(s) checked:\n", len(videos))
-	fmt.Println("id\ttitle\tyou_tube_id\tresult")
+	fmt.Println("id\ttitle\tprovider\tyou_tube_id\tresult")
+	playable := make(map[uint32]bool)
 	for _, v := range videos {
 		res := resultNoID
 		if v.YouTubeId != "" {
-			res = playableByID[v.YouTubeId]
-			if res == "" {
-				res = resultError
+			results, ok := resultByProvider[v.Provider]
+			if !ok {
+				res = resultNoProvider
+			} else if res = results[v.YouTubeId]; res == "" {
+				res = api.VideoPlayabilityError
 			}
 		}
+		playable[v.Id] = res == api.VideoPlayable
 		title := v.Title
 		if len(title) > 50 {
 			title = title[:47] + "..."
 		}
-		fmt.Printf("%d\t%s\t%s\t%s\n", v.Id, title, v.YouTubeId, res)
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\n", v.Id, title, v.Provider, v.YouTubeId, res)
 	}
 
 	if *enable {
 		for _, v := range videos {
-			if v.YouTubeId == "" || playableByID[v.YouTubeId] != resultPlayable {
+			if !playable[v.Id] {
 				continue
 			}
 			_, err := db.Exec(updateVideoEnabled, v.Id)
@@ -152,98 +146,3 @@ func main() {
 		}
 	}
 }
-
-func checkViaYouTubeAPI(client *http.Client, apiKey string, videos []videoRow, out map[string]result) {
-	idsWithVideo := make([]string, 0, len(videos))
-	for _, v := range videos {
-		if strings.TrimSpace(v.YouTubeId) == "" {
-			continue
-		}
-		idsWithVideo = append(idsWithVideo, v.YouTubeId)
-	}
-
-	for i := 0; i < len(idsWithVideo); i += batchSize {
-		end := i + batchSize
-		if end > len(idsWithVideo) {
-			end = len(idsWithVideo)
-		}
-		batch := idsWithVideo[i:end]
-		idsParam := strings.Join(batch, ",")
-		reqURL := fmt.Sprintf("%s?part=status&id=%s&key=%s",
-			youTubeVideosURL, url.QueryEscape(idsParam), url.QueryEscape(apiKey))
-
-		resp, err := client.Get(reqURL)
-		if err != nil {
-			for _, id := range batch {
-				out[id] = resultError
-			}
-			fmt.Fprintf(os.Stderr, "YouTube API request: %v\n", err)
-			continue
-		}
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			for _, id := range batch {
-				out[id] = resultError
-			}
-			fmt.Fprintf(os.Stderr, "read response: %v\n", err)
-			continue
-		}
-		if resp.StatusCode != http.StatusOK {
-			for _, id := range batch {
-				out[id] = resultError
-			}
-			fmt.Fprintf(os.Stderr, "YouTube API %d: %s\n", resp.StatusCode, string(body))
-			continue
-		}
-
-		var data youTubeVideosResponse
-		if err := json.Unmarshal(body, &data); err != nil {
-			for _, id := range batch {
-				out[id] = resultError
-			}
-			fmt.Fprintf(os.Stderr, "decode response: %v\n", err)
-			continue
-		}
-
-		found := make(map[string]bool)
-		for _, item := range data.Items {
-			found[item.Id] = true
-			playable := item.Status.Embeddable && item.Status.PrivacyStatus == "public"
-			if playable {
-				out[item.Id] = resultPlayable
-			} else {
-				out[item.Id] = resultNotPlayable
-			}
-		}
-		for _, id := range batch {
-			if !found[id] {
-				out[id] = resultNotPlayable
-			}
-		}
-	}
-}
-
-func checkViaOembed(client *http.Client, videos []videoRow, out map[string]result) {
-	for _, v := range videos {
-		if strings.TrimSpace(v.YouTubeId) == "" {
-			continue
-		}
-		watchURL := "https://www.youtube.com/watch?v=" + v.YouTubeId
-		reqURL := youTubeOembedURL + "?url=" + url.QueryEscape(watchURL)
-
-		resp, err := client.Get(reqURL)
-		if err != nil {
-			out[v.YouTubeId] = resultError
-			fmt.Fprintf(os.Stderr, "oembed %s: %v\n", v.YouTubeId, err)
-			continue
-		}
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
-		if resp.StatusCode == http.StatusOK {
-			out[v.YouTubeId] = resultPlayable
-		} else {
-			out[v.YouTubeId] = resultNotPlayable
-		}
-	}
-}
@@ -0,0 +1,85 @@
+// Part of the problem-generation system - documented in docs/problem-generation.md.
+// export_problems writes the problem pool as JSON Lines or CSV, in the format
+// import_problems reads (see server/api/problem_import.go). -bitmap keeps the
+// problems playable under that topic envelope (bitmap a subset of it);
+// retired problems are never written, disabled ones only with
+// -include-disabled.
+//
+// Usage:
+//
+//	./export_problems -config=conf.json > problems.jsonl
+//	./export_problems -config=conf.json -format=csv -bitmap=3 -max-difficulty=6 -out=easy.csv
+//	./export_problems -config=conf.json -generator=import
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/api"
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func main() {
+	configPath := flag.String("config", "conf.json", "path to config JSON")
+	format := flag.String("format", "", "jsonl or csv (default: from -out's extension, else jsonl)")
+	outPath := flag.String("out", "", "file to write (default stdout)")
+	bitmap := flag.Uint64("bitmap", 0, "keep problems whose bitmap is a subset of this (0 = all)")
+	generator := flag.String("generator", "", "keep only this generator's problems")
+	minDiff := flag.Float64("min-difficulty", 0, "keep problems at least this difficult")
+	maxDiff := flag.Float64("max-difficulty", 0, "keep problems at most this difficult (0 = no limit)")
+	includeDisabled := flag.Bool("include-disabled", false, "also write disabled (but not retired) problems")
+	flag.Parse()
+	if *format == "" {
+		*format = api.ProblemFormatFromPath(*outPath)
+	}
+
+	c, err := common.ReadConfig(*configPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	connectStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&time_zone=UTC",
+		c.MySQLUser, c.MySQLPass, c.MySQLHost, c.MySQLPort, c.MySQLDatabase)
+	db, err := sql.Open("mysql", connectStr)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := api.RunMigrations(db); err != nil {
+		glog.Fatalf("migrations: %v", err)
+	}
+
+	a, err := api.NewApi(db, c)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outPath != "" {
+		f, err := os.Create(*outPath)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	n, err := a.ExportProblems(out, *format, api.ProblemExportFilter{
+		Bitmap:          *bitmap,
+		Generator:       *generator,
+		MinDifficulty:   *minDiff,
+		MaxDifficulty:   *maxDiff,
+		IncludeDisabled: *includeDisabled,
+	})
+	if err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d problems\n", n)
+}
@@ -0,0 +1,114 @@
+// Part of the problem-generation system - documented in docs/problem-generation.md.
+// import_problems adds problems from a JSON Lines or CSV file (expression,
+// answer, explanation, symbolic_expression) to the pool. Each row runs the
+// admission pipeline and the answer check, gets its bitmap and difficulty
+// computed, and is reported accepted, rejected (with the reason) or
+// duplicate (see server/api/problem_import.go). Exits 1 if any row was
+// rejected.
+//
+// Usage:
+//
+//	./import_problems -config=conf.json worksheet.csv
+//	./import_problems -config=conf.json -generator=teacher-fractions -dry-run problems.jsonl
+//	cat problems.jsonl | ./import_problems -config=conf.json -format=jsonl -
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/api"
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func main() {
+	configPath := flag.String("config", "conf.json", "path to config JSON")
+	format := flag.String("format", "", "jsonl or csv (default: from the file's extension)")
+	generator := flag.String("generator", api.DefaultImportGenerator, "generator column for the imported problems")
+	dryRun := flag.Bool("dry-run", false, "stamp and report every row; insert nothing")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import_problems [flags] FILE (- for stdin)")
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	if *format == "" {
+		*format = api.ProblemFormatFromPath(path)
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			glog.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+	recs, err := api.ParseProblemRecords(in, *format)
+	if err != nil {
+		glog.Fatalf("%s: %v", path, err)
+	}
+
+	c, err := common.ReadConfig(*configPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	connectStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&time_zone=UTC",
+		c.MySQLUser, c.MySQLPass, c.MySQLHost, c.MySQLPort, c.MySQLDatabase)
+	db, err := sql.Open("mysql", connectStr)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := api.RunMigrations(db); err != nil {
+		glog.Fatalf("migrations: %v", err)
+	}
+
+	a, err := api.NewApi(db, c)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	report, err := a.ImportProblems(recs, api.ProblemImportOptions{Generator: *generator, DryRun: *dryRun})
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if *asJSON {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else {
+		printReport(report)
+	}
+	glog.Flush()
+	if report.Rejected > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(r *api.ProblemImportReport) {
+	for _, row := range r.Rows {
+		switch row.Status {
+		case api.IMPORT_ACCEPTED:
+			fmt.Printf("line %d: accepted %d %q (bitmap=%d difficulty=%.2f)\n", row.Line, row.Id, row.Expression, row.Bitmap, row.Difficulty)
+		default:
+			fmt.Printf("line %d: %s %q: %s\n", row.Line, row.Status, row.Expression, row.Reason)
+		}
+	}
+	prefix := ""
+	if r.DryRun {
+		prefix = "dry run: "
+	}
+	fmt.Printf("%s%d rows: %d accepted, %d rejected, %d duplicates (generator %q)\n",
+		prefix, len(r.Rows), r.Accepted, r.Rejected, r.Duplicates, r.Generator)
+}
@@ -0,0 +1,100 @@
+// prewarm_pool fills the problem pool ahead of demand (see
+// server/api/pool_coverage.go). It counts the live problems in each
+// difficulty band of every settings bitmap in use, prints the thin and empty
+// bands, and queues generation jobs for up to -max_jobs of the thinnest. The
+// apiserver's generation workers run the jobs; a band whose job is already
+// queued isn't queued twice.
+//
+// Usage:
+//
+//	./prewarm_pool -config=conf.json
+//	./prewarm_pool -config=conf.json -max_jobs=5 -dry-run
+//	./prewarm_pool -config=conf.json -json
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/api"
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func main() {
+	configPath := flag.String("config", "conf.json", "path to config JSON")
+	maxJobs := flag.Int("max_jobs", api.DefaultPrewarmJobs, "most bands to queue generation for, thinnest first")
+	dryRun := flag.Bool("dry-run", false, "print the coverage and plan; queue nothing")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	c, err := common.ReadConfig(*configPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	connectStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&time_zone=UTC",
+		c.MySQLUser, c.MySQLPass, c.MySQLHost, c.MySQLPort, c.MySQLDatabase)
+	db, err := sql.Open("mysql", connectStr)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := api.RunMigrations(db); err != nil {
+		glog.Fatalf("migrations: %v", err)
+	}
+
+	a, err := api.NewApi(db, c)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	report, err := a.PrewarmPool(context.Background(), api.PrewarmOptions{MaxJobs: *maxJobs, DryRun: *dryRun})
+	if report != nil {
+		if *asJSON {
+			out, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(out))
+		} else {
+			printReport(report, *dryRun)
+		}
+	}
+	glog.Flush()
+	if err != nil {
+		glog.Fatal(err)
+	}
+}
+
+func printReport(r *api.PrewarmReport, dryRun bool) {
+	for _, bc := range r.Coverage.Bitmaps {
+		for _, b := range bc.Bands {
+			if b.Status == api.COVERAGE_OK {
+				continue
+			}
+			fmt.Printf("bitmap %d band %d: %d problems (%s), %d users\n", bc.ProblemTypeBitmap, b.Band, b.Problems, b.Status, b.Users)
+		}
+	}
+	queued := 0
+	for _, j := range r.Jobs {
+		switch {
+		case dryRun:
+			fmt.Printf("  would queue bitmap %d band %d\n", j.ProblemTypeBitmap, j.Band)
+		case j.Queued:
+			queued++
+			fmt.Printf("  queued bitmap %d band %d\n", j.ProblemTypeBitmap, j.Band)
+		default:
+			fmt.Printf("  already queued bitmap %d band %d\n", j.ProblemTypeBitmap, j.Band)
+		}
+	}
+	prefix := ""
+	if dryRun {
+		prefix = "dry run: "
+	}
+	fmt.Printf("%s%d bitmaps: %d thin bands, %d empty (under %d problems); %d of %d picked bands queued\n",
+		prefix, len(r.Coverage.Bitmaps), r.Coverage.Thin, r.Coverage.Empty, r.Coverage.ThinThreshold, queued, len(r.Jobs))
+}
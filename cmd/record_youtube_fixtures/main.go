@@ -0,0 +1,206 @@
+// record_youtube_fixtures records real YouTube Data API responses for one or
+// more playlists into the fixture layout server/youtubefake serves: the
+// playlist metadata, every playlistItems page, and each video's status and
+// duration. The API key is only sent, never written. Re-recording a playlist
+// that changed upstream with -revision=2 captures an etag change for the fake
+// to replay.
+//
+// Usage:
+//
+//	./record_youtube_fixtures -config=conf.json -playlist=PLxxx -out=server/youtubefake/fixtures
+//	./record_youtube_fixtures -config=conf.json -playlist=PLxxx -revision=2 -out=server/youtubefake/fixtures
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+const (
+	defaultAPIHost = "https://www.googleapis.com/youtube/v3"
+	statusBatch    = 50
+)
+
+type recorder struct {
+	client  *http.Client
+	apiHost string
+	apiKey  string
+	out     string
+}
+
+func main() {
+	configPath := flag.String("config", "conf.json", "path to config JSON (for youtube_api_key)")
+	playlists := flag.String("playlist", "", "comma-separated YouTube playlist IDs to record")
+	out := flag.String("out", "server/youtubefake/fixtures", "fixture directory to write")
+	revision := flag.Int("revision", 1, "record as this revision of the playlists (>1 writes <id>@<revision>.json)")
+	flag.Parse()
+
+	c, err := common.ReadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "read config: %v\n", err)
+		os.Exit(1)
+	}
+	if strings.TrimSpace(c.YouTubeAPIKey) == "" || *playlists == "" {
+		fmt.Fprintln(os.Stderr, "need youtube_api_key in the config and -playlist")
+		os.Exit(2)
+	}
+	r := &recorder{client: &http.Client{Timeout: 15 * time.Second}, apiHost: defaultAPIHost, apiKey: c.YouTubeAPIKey, out: *out}
+	if c.YouTubeAPIBaseURL != "" {
+		r.apiHost = strings.TrimRight(c.YouTubeAPIBaseURL, "/")
+	}
+	for _, dir := range []string{"playlists", "playlistItems", "videos"} {
+		if err := os.MkdirAll(filepath.Join(*out, dir), 0o755); err != nil {
+			fmt.Fprintf(os.Stderr, "mkdir: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	failed := false
+	for _, id := range strings.Split(*playlists, ",") {
+		id = strings.TrimSpace(id)
+		if err := r.recordPlaylist(id, *revision); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", id, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func (r *recorder) recordPlaylist(id string, revision int) error {
+	name := id
+	if revision > 1 {
+		name = fmt.Sprintf("%s@%d", id, revision)
+	}
+	if _, _, err := r.record("playlists?part=snippet&id="+url.QueryEscape(id), filepath.Join("playlists", name+".json")); err != nil {
+		return err
+	}
+	var videoIDs []string
+	pageToken := ""
+	for page := 0; ; page++ {
+		query := "playlistItems?part=snippet&maxResults=50&playlistId=" + url.QueryEscape(id)
+		file := name
+		if pageToken != "" {
+			query += "&pageToken=" + url.QueryEscape(pageToken)
+			file += "." + pageToken
+		}
+		status, body, err := r.record(query, filepath.Join("playlistItems", file+".json"))
+		if err != nil {
+			return err
+		}
+		if status != http.StatusOK {
+			fmt.Printf("%s: playlistItems page %d returned %d (recorded)\n", id, page, status)
+			break
+		}
+		var data struct {
+			Items []struct {
+				Snippet struct {
+					ResourceID struct {
+						VideoID string `json:"videoId"`
+					} `json:"resourceId"`
+				} `json:"snippet"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return fmt.Errorf("decode playlistItems: %w", err)
+		}
+		for _, item := range data.Items {
+			videoIDs = append(videoIDs, item.Snippet.ResourceID.VideoID)
+		}
+		if data.NextPageToken == "" {
+			break
+		}
+		pageToken = data.NextPageToken
+	}
+	recorded, err := r.recordVideos(videoIDs)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: recorded as %s, %d videos (%d with status; the rest are deleted or private to the key)\n", id, name, len(videoIDs), recorded)
+	return nil
+}
+
+// recordVideos writes one videos/<id>.json per video YouTube returns; the
+// fake reports the rest as deleted, as YouTube does.
+func (r *recorder) recordVideos(ids []string) (int, error) {
+	n := 0
+	for i := 0; i < len(ids); i += statusBatch {
+		end := i + statusBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		status, body, err := r.get("videos?part=status,contentDetails&id=" + url.QueryEscape(strings.Join(ids[i:end], ",")))
+		if err != nil {
+			return n, err
+		}
+		if status != http.StatusOK {
+			return n, fmt.Errorf("videos: %d %s", status, body)
+		}
+		var data struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(body, &data); err != nil {
+			return n, fmt.Errorf("decode videos: %w", err)
+		}
+		for _, item := range data.Items {
+			var head struct {
+				Id string `json:"id"`
+			}
+			if err := json.Unmarshal(item, &head); err != nil || head.Id == "" {
+				continue
+			}
+			if err := writeIndented(filepath.Join(r.out, "videos", head.Id+".json"), item); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// record fetches an API path and writes it as {"status", "body"}.
+func (r *recorder) record(query, file string) (int, []byte, error) {
+	status, body, err := r.get(query)
+	if err != nil {
+		return 0, nil, err
+	}
+	wrapped, err := json.Marshal(struct {
+		Status int             `json:"status"`
+		Body   json.RawMessage `json:"body"`
+	}{status, body})
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: response is not JSON: %w", query, err)
+	}
+	return status, body, writeIndented(filepath.Join(r.out, file), wrapped)
+}
+
+func (r *recorder) get(query string) (int, []byte, error) {
+	resp, err := r.client.Get(r.apiHost + "/" + query + "&key=" + url.QueryEscape(r.apiKey))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, body, err
+}
+
+func writeIndented(path string, raw []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	return os.WriteFile(path, buf.Bytes(), 0o644)
+}
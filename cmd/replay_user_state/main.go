@@ -0,0 +1,92 @@
+// replay_user_state folds a user's event log up to -as_of with processEvent's
+// rules and prints how the replayed settings/gamestate differ from the current
+// rows. It is the dry run for the admin restore endpoint
+// (POST /api/v1/admin/users/:user_id/restore); pass -restore to apply it here
+// instead.
+//
+// Usage:
+//
+//	./replay_user_state -config=conf.json -user_id=42 -as_of=2026-01-31T18:00:00Z
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/api"
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func main() {
+	configPath := flag.String("config", "conf.json", "path to config JSON")
+	userID := flag.Uint("user_id", 0, "user to replay (required)")
+	asOfStr := flag.String("as_of", "", "RFC3339 instant to replay to (empty = now)")
+	restore := flag.Bool("restore", false, "write the replayed state instead of only printing the diff")
+	flag.Parse()
+
+	if *userID == 0 {
+		glog.Fatal("-user_id is required")
+	}
+	asOf := time.Now().UTC()
+	if *asOfStr != "" {
+		t, err := time.Parse(time.RFC3339, *asOfStr)
+		if err != nil {
+			glog.Fatalf("-as_of: %v", err)
+		}
+		asOf = t
+	}
+
+	c, err := common.ReadConfig(*configPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	connectStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&time_zone=UTC",
+		c.MySQLUser, c.MySQLPass, c.MySQLHost, c.MySQLPort, c.MySQLDatabase)
+	db, err := sql.Open("mysql", connectStr)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := api.RunMigrations(db); err != nil {
+		glog.Fatalf("migrations: %v", err)
+	}
+
+	a, err := api.NewApi(db, c)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	logPrefix := fmt.Sprintf("[replay_user_state user=%d]", *userID)
+	var result *api.ReplayResult
+	if *restore {
+		result, err = a.RestoreUserState(logPrefix, uint32(*userID), asOf)
+	} else {
+		result, err = a.ReplayUserState(uint32(*userID), asOf)
+	}
+	if err != nil {
+		glog.Fatalf("%s %v", logPrefix, err)
+	}
+
+	fmt.Printf("user %d as of %s: %d events applied, %d skipped\n",
+		result.UserId, result.AsOf.Format(time.RFC3339), result.EventsApplied, len(result.EventsSkipped))
+	for _, s := range result.EventsSkipped {
+		fmt.Printf("  skipped event %d %s=%q: %s\n", s.EventId, s.EventType, s.Value, s.Reason)
+	}
+	if len(result.Diffs) == 0 {
+		fmt.Println("no differences")
+		return
+	}
+	for _, d := range result.Diffs {
+		fmt.Printf("  %s.%s: %s -> %s\n", d.Table, d.Field, d.Current, d.Replayed)
+	}
+	if *restore {
+		fmt.Println("restored")
+	}
+}
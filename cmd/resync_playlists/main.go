@@ -0,0 +1,103 @@
+// resync_playlists re-checks every subscribed or catalog playlist against its
+// video provider (see server/api/playlist_resync.go). A playlist whose etag
+// changed has its videos re-fetched: new ones are added, removed ones dropped,
+// and ones that can no longer be played disabled; subscribers' video lists are
+// then refreshed. The run spends at most -quota YouTube Data API units across
+// all playlists; playlists it doesn't reach go first next run.
+//
+// Usage:
+//
+//	./resync_playlists -config=conf.json
+//	./resync_playlists -config=conf.json -quota=200 -dry-run
+//	./resync_playlists -config=conf.json -json
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/api"
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func main() {
+	configPath := flag.String("config", "conf.json", "path to config JSON")
+	quota := flag.Int64("quota", 1000, "most YouTube Data API units to spend this run, across all playlists (0 = no limit)")
+	dryRun := flag.Bool("dry-run", false, "print the diff; change nothing")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Parse()
+
+	c, err := common.ReadConfig(*configPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	connectStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&time_zone=UTC",
+		c.MySQLUser, c.MySQLPass, c.MySQLHost, c.MySQLPort, c.MySQLDatabase)
+	db, err := sql.Open("mysql", connectStr)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := api.RunMigrations(db); err != nil {
+		glog.Fatalf("migrations: %v", err)
+	}
+
+	a, err := api.NewApi(db, c)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	report, err := a.ResyncPlaylists(api.PlaylistResyncOptions{QuotaBudget: *quota, DryRun: *dryRun})
+	if report != nil {
+		if *asJSON {
+			out, _ := json.MarshalIndent(report, "", "  ")
+			fmt.Println(string(out))
+		} else {
+			printReport(report)
+		}
+	}
+	glog.Flush()
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if report.Count(api.RESYNC_ERROR) > 0 {
+		os.Exit(1)
+	}
+}
+
+func printReport(r *api.PlaylistResyncReport) {
+	for _, p := range r.Playlists {
+		if p.Status == api.RESYNC_UNCHANGED {
+			continue
+		}
+		fmt.Printf("%s %d %s %q: %s", p.Provider, p.PlaylistId, p.ExternalId, p.Title, p.Status)
+		if p.Error != "" {
+			fmt.Printf(": %s", p.Error)
+		}
+		fmt.Println()
+		for _, v := range p.Added {
+			fmt.Printf("  + %d %s\n", v.Id, v.Title)
+		}
+		for _, v := range p.Removed {
+			fmt.Printf("  - %d %s\n", v.Id, v.Title)
+		}
+		for _, v := range p.Disabled {
+			fmt.Printf("  x %d %s (disabled)\n", v.Id, v.Title)
+		}
+	}
+	prefix := ""
+	if r.DryRun {
+		prefix = "dry run: "
+	}
+	fmt.Printf("%s%d playlists: %d changed, %d unchanged, %d deferred, %d errors; %d users refreshed; %d/%d quota units\n",
+		prefix, len(r.Playlists), r.Count(api.RESYNC_CHANGED), r.Count(api.RESYNC_UNCHANGED), r.Count(api.RESYNC_DEFERRED),
+		r.Count(api.RESYNC_ERROR), r.UsersRefreshed, r.QuotaUnits, r.QuotaBudget)
+}
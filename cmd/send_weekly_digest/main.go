@@ -0,0 +1,137 @@
+// send_weekly_digest mails the weekly parent progress digest (see
+// server/api/digest.go) to every user who opted in on the settings page, for
+// the last complete Monday-to-Monday UTC week. A week already recorded in
+// digest_sends is skipped, so re-runs are safe.
+//
+// Mail goes through the smtp_* config fields unless -capture_dir is set, in
+// which case nothing is sent and each message is written there as an .eml
+// file (the local stand-in for dev hosts). -dry-run prints the plaintext
+// bodies and records nothing.
+//
+// Usage:
+//
+//	./send_weekly_digest -config=conf.json
+//	./send_weekly_digest -config=conf.json -user_id=42 -capture_dir=/tmp/digests
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/api"
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func main() {
+	configPath := flag.String("config", "conf.json", "path to config JSON")
+	userID := flag.Uint("user_id", 0, "send to a single user, opted in or not (0 = all opted-in users)")
+	weekOf := flag.String("week_of", "", "YYYY-MM-DD inside the week to report (empty = last complete week)")
+	captureDir := flag.String("capture_dir", "", "write messages as .eml files here instead of sending over SMTP")
+	dryRun := flag.Bool("dry-run", false, "print the plaintext digests; send and record nothing")
+	flag.Parse()
+
+	c, err := common.ReadConfig(*configPath)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	weekStart := api.DigestWeekStart(time.Now().UTC())
+	if *weekOf != "" {
+		t, err := time.Parse("2006-01-02", *weekOf)
+		if err != nil {
+			glog.Fatalf("-week_of: %v", err)
+		}
+		// The Monday starting the week that contains t.
+		weekStart = api.DigestWeekStart(t.AddDate(0, 0, 7))
+	}
+
+	var transport api.MailTransport
+	if *captureDir != "" {
+		transport = &api.CaptureTransport{Dir: *captureDir}
+	} else if !*dryRun {
+		smtpTransport, err := api.NewSMTPTransportFromConfig(c)
+		if err != nil {
+			glog.Fatalf("%v (set smtp_* in config, or pass -capture_dir)", err)
+		}
+		transport = smtpTransport
+	}
+	from := c.DigestFrom
+	if from == "" {
+		if *captureDir != "" {
+			from = "mathgame-digest@localhost"
+		} else if !*dryRun {
+			glog.Fatal("digest_from is not set in config")
+		}
+	}
+
+	connectStr := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true&time_zone=UTC",
+		c.MySQLUser, c.MySQLPass, c.MySQLHost, c.MySQLPort, c.MySQLDatabase)
+	db, err := sql.Open("mysql", connectStr)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := api.RunMigrations(db); err != nil {
+		glog.Fatalf("migrations: %v", err)
+	}
+
+	a, err := api.NewApi(db, c)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	var userIDs []uint32
+	if *userID > 0 {
+		userIDs = []uint32{uint32(*userID)}
+	} else {
+		userIDs, err = a.DigestOptedInUserIds()
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	var sent, skipped, failed int
+	for _, uid := range userIDs {
+		logPrefix := fmt.Sprintf("[send_weekly_digest user=%d]", uid)
+		if *dryRun {
+			d, err := a.BuildWeeklyDigest(logPrefix, uid, weekStart)
+			if err != nil {
+				glog.Errorf("%s %v", logPrefix, err)
+				failed++
+				continue
+			}
+			subject, text, _, err := api.RenderWeeklyDigest(d)
+			if err != nil {
+				glog.Errorf("%s %v", logPrefix, err)
+				failed++
+				continue
+			}
+			fmt.Printf("To: %s\nSubject: %s\n\n%s\n", d.Email, subject, text)
+			continue
+		}
+		err := a.SendWeeklyDigest(logPrefix, transport, from, uid, weekStart)
+		if errors.Is(err, api.ErrDigestAlreadySent) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			glog.Errorf("%s %v", logPrefix, err)
+			failed++
+			continue
+		}
+		sent++
+	}
+	fmt.Printf("week of %s: %d sent, %d already sent, %d failed\n", weekStart.Format("2006-01-02"), sent, skipped, failed)
+	glog.Flush()
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
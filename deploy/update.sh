@@ -8,16 +8,22 @@ SERVICES=(
     mathgame-web
     mathgame-maintenance
     mathgame-compress-events
+    mathgame-resync-playlists
     mathgame-check-disabled-videos
     mathgame-update-statistics
     mathgame-trim-recently-shown-problems
+    mathgame-send-weekly-digest
+    mathgame-prewarm-pool
     mathgame-watchdog
 )
 TIMERS=(
     mathgame-compress-events
+    mathgame-resync-playlists
     mathgame-check-disabled-videos
     mathgame-update-statistics
     mathgame-trim-recently-shown-problems
+    mathgame-send-weekly-digest
+    mathgame-prewarm-pool
     mathgame-watchdog
 )
 
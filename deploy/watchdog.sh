@@ -17,6 +17,11 @@
 # Each watch is rate-limited independently to at most one notification per
 # COOLDOWN window (1 hour) via $STATE_DIR/mathgame-watchdog-<slug>.last.
 #
+# After the journal watches it probes the apiserver's /readyz (DB reachable,
+# no pending migrations). A single failed probe is expected mid-deploy, so it
+# alerts only after READY_FAILS consecutive failures, counted in
+# $STATE_DIR/mathgame-watchdog-readyz.fails, under the same per-slug cooldown.
+#
 # The ntfy topic comes from "ntfy_topic" in conf.json (gitignored, alongside
 # the other secrets). It is effectively a shared secret: anyone who knows the
 # topic can push to it. Empty/absent -> the watchdog quietly no-ops.
@@ -26,6 +31,7 @@ UNIT=mathgame-api
 COOLDOWN=3600  # seconds; at most one notification per watch per hour
 CONF="${1:-/home/ubuntu/mathgame_2/conf.json}"
 STATE_DIR="${STATE_DIR:-/run}"
+READY_FAILS=2  # consecutive failed /readyz probes (runs, 5 minutes apart) before alerting
 
 WATCHES=(
     "openai|5|OpenAI errors|OpenAI error"
@@ -42,6 +48,28 @@ if [ -z "$topic" ]; then
     exit 0
 fi
 
+# notify SLUG TITLE BODY: push to ntfy unless SLUG paged within COOLDOWN.
+notify() {
+    local slug="$1" title="$2" body="$3"
+    local stamp="$STATE_DIR/mathgame-watchdog-${slug}.last"
+    if [ -f "$stamp" ]; then
+        local last
+        last=$(cat "$stamp" 2>/dev/null || echo 0)
+        if [ $(( now - last )) -lt "$COOLDOWN" ]; then
+            return 0
+        fi
+    fi
+    # Only stamp the cooldown on a successful send, so a failed push retries.
+    if curl -fsS \
+        -H "Title: $title" \
+        -H "Priority: high" \
+        -H "Tags: warning" \
+        -d "$body" \
+        "ntfy.sh/$topic"; then
+        echo "$now" > "$stamp"
+    fi
+}
+
 # Read the window once and grep it per watch, rather than re-querying journalctl.
 log=$(journalctl -u "$UNIT" --since "1 hour ago" --no-pager)
 now=$(date +%s)
@@ -55,23 +83,23 @@ for watch in "${WATCHES[@]}"; do
         continue
     fi
 
-    # Rate-limit per watch: skip if we already paged for this slug this hour.
-    stamp="$STATE_DIR/mathgame-watchdog-${slug}.last"
-    if [ -f "$stamp" ]; then
-        last=$(cat "$stamp" 2>/dev/null || echo 0)
-        if [ $(( now - last )) -lt "$COOLDOWN" ]; then
-            continue
-        fi
-    fi
+    notify "$slug" "Mathgame: $count $label in last hour" "$(grep "$pattern" <<< "$log" | tail -3)"
+done
 
-    body=$(grep "$pattern" <<< "$log" | tail -3)
-    # Only stamp the cooldown on a successful send, so a failed push retries.
-    if curl -fsS \
-        -H "Title: Mathgame: $count $label in last hour" \
-        -H "Priority: high" \
-        -H "Tags: warning" \
-        -d "$body" \
-        "ntfy.sh/$topic"; then
-        echo "$now" > "$stamp"
+# Readiness probe. The apiserver serves TLS for the public hostname, so skip
+# verification when calling it over loopback.
+api_port=$(python3 -c 'import json,sys; print(json.load(open(sys.argv[1])).get("api_port",""))' "$CONF")
+if [ -n "$api_port" ]; then
+    fails_file="$STATE_DIR/mathgame-watchdog-readyz.fails"
+    # Keep the body on a 503: it names the failing check.
+    ready=$(curl -sS -k --max-time 10 -w ' (HTTP %{http_code})' "https://127.0.0.1:${api_port}/readyz" 2>&1 || true)
+    if [[ "$ready" == *"(HTTP 200)" ]]; then
+        rm -f "$fails_file"
+    else
+        fails=$(( $(cat "$fails_file" 2>/dev/null || echo 0) + 1 ))
+        echo "$fails" > "$fails_file"
+        if [ "$fails" -ge "$READY_FAILS" ]; then
+            notify readyz "Mathgame: apiserver not ready ($fails probes)" "$ready"
+        fi
     fi
-done
+fi
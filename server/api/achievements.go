@@ -0,0 +1,343 @@
+// achievements.go: the rules-driven achievements engine.
+//
+// Rules are data (achievementRules): a key, display text, the counter they
+// read and the target it must reach. The engine keeps the counters in
+// achievement_counters, folding each committed processEvent batch into them
+// (foldAchievementCounters), and earns every rule whose counter has reached
+// its target: a user_achievements row plus an ACHIEVEMENT_EARNED event in the
+// log, which the statistics cache counts and the companion shows. Documented
+// in docs/events.md.
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// Counters the rules read. Topic first-try counters are
+// topicFirstTryMetric(<ProblemType name>).
+const (
+	metricSolvedTotal = "solved_total"  // SOLVED_PROBLEM events, all time
+	metricStreakDays  = "streak_days"   // consecutive UTC days with a solve, ending on streak_last_day
+	metricFirstTryRun = "first_try_run" // solves in a row with no wrong answer on the problem
+)
+
+// Engine bookkeeping counters; no rule reads these.
+const (
+	metricStreakLastDay = "streak_last_day" // UTC day number (days since the epoch) of the last solve
+	metricProblemMissed = "problem_missed"  // 1 once the current problem got a wrong answer
+)
+
+// topicFirstTryMetric counts first-try solves of problems with the named
+// ProblemType bit ("fractions", "mismatched_denominators", ...).
+func topicFirstTryMetric(topic string) string {
+	return "first_try:" + topic
+}
+
+// AchievementRule is one declarative achievement: earned once the named
+// counter reaches Target.
+type AchievementRule struct {
+	Key         string
+	Title       string
+	Description string
+	Metric      string
+	Target      int64
+}
+
+// achievementRules is the achievement catalog, in display order. Keys are
+// stored in user_achievements and logged as ACHIEVEMENT_EARNED values, so never
+// rename one; retire it instead.
+var achievementRules = []AchievementRule{
+	{Key: "first_solve", Title: "First steps", Description: "Solve your first problem", Metric: metricSolvedTotal, Target: 1},
+	{Key: "solved_100", Title: "Century", Description: "Solve 100 problems", Metric: metricSolvedTotal, Target: 100},
+	{Key: "solved_1000", Title: "Thousand club", Description: "Solve 1,000 problems", Metric: metricSolvedTotal, Target: 1000},
+	{Key: "streak_3", Title: "On a roll", Description: "Solve a problem 3 days in a row", Metric: metricStreakDays, Target: 3},
+	{Key: "streak_7", Title: "Full week", Description: "Solve a problem 7 days in a row", Metric: metricStreakDays, Target: 7},
+	{Key: "streak_30", Title: "Habit", Description: "Solve a problem 30 days in a row", Metric: metricStreakDays, Target: 30},
+	{Key: "first_try_10", Title: "Sharpshooter", Description: "Solve 10 problems in a row on the first try", Metric: metricFirstTryRun, Target: 10},
+	{Key: "first_try_25", Title: "Unstoppable", Description: "Solve 25 problems in a row on the first try", Metric: metricFirstTryRun, Target: 25},
+	{Key: "mastered_fractions", Title: "Fraction fan", Description: "Solve 25 fraction problems on the first try", Metric: topicFirstTryMetric("fractions"), Target: 25},
+	{Key: "mastered_mismatched_denominators", Title: "Common ground", Description: "Solve 25 mismatched-denominator problems on the first try", Metric: topicFirstTryMetric("mismatched_denominators"), Target: 25},
+	{Key: "mastered_negatives", Title: "Below zero", Description: "Solve 25 problems with negative numbers on the first try", Metric: topicFirstTryMetric("negatives"), Target: 25},
+	{Key: "mastered_pemdas", Title: "Order of operations", Description: "Solve 25 order-of-operations problems on the first try", Metric: topicFirstTryMetric("pemdas"), Target: 25},
+}
+
+// achievementEventTypes are the event types foldAchievementCounters reads; a
+// batch with none of them skips the engine entirely (the common case: the
+// once-a-second WORKING_ON_PROBLEM / WATCHING_VIDEO reports).
+var achievementEventTypes = map[string]bool{
+	SELECTED_PROBLEM: true,
+	ANSWERED_PROBLEM: true,
+	SOLVED_PROBLEM:   true,
+}
+
+// utcDayNumber is t's UTC calendar day as days since the epoch.
+func utcDayNumber(t time.Time) int64 {
+	return t.UTC().Unix() / 86400
+}
+
+// foldAchievementCounters applies one committed batch to counters in place and
+// returns the names of the counters it changed. solvedBitmap is the
+// ProblemTypeBitmap of the problem solved in the batch (processEvent commits at
+// most one solve per batch); now dates the batch for streaks.
+//
+// An ANSWERED_PROBLEM immediately followed by SOLVED_PROBLEM is the correct
+// answer (processEvent appends the solve right after it); one without is a
+// miss, which breaks the first-try run and marks the problem missed until the
+// next SELECTED_PROBLEM.
+func foldAchievementCounters(counters map[string]int64, events []*Event, solvedBitmap uint64, now time.Time) []string {
+	changed := map[string]bool{}
+	set := func(metric string, v int64) {
+		if counters[metric] != v {
+			counters[metric] = v
+			changed[metric] = true
+		}
+	}
+	for i, e := range events {
+		switch e.EventType {
+		case SELECTED_PROBLEM:
+			set(metricProblemMissed, 0)
+		case ANSWERED_PROBLEM:
+			if i+1 < len(events) && events[i+1].EventType == SOLVED_PROBLEM {
+				continue
+			}
+			set(metricProblemMissed, 1)
+			set(metricFirstTryRun, 0)
+		case SOLVED_PROBLEM:
+			set(metricSolvedTotal, counters[metricSolvedTotal]+1)
+			if counters[metricProblemMissed] == 0 {
+				set(metricFirstTryRun, counters[metricFirstTryRun]+1)
+				for _, topic := range mathcore.ProblemTypeToFeatures(mathcore.ProblemType(solvedBitmap)) {
+					m := topicFirstTryMetric(topic)
+					set(m, counters[m]+1)
+				}
+			}
+			day := utcDayNumber(now)
+			switch last := counters[metricStreakLastDay]; {
+			case last == day && counters[metricStreakDays] > 0:
+				// Already counted today.
+			case last == day-1:
+				set(metricStreakDays, counters[metricStreakDays]+1)
+			default:
+				set(metricStreakDays, 1)
+			}
+			set(metricStreakLastDay, day)
+		}
+	}
+	out := make([]string, 0, len(changed))
+	for m := range changed {
+		out = append(out, m)
+	}
+	return out
+}
+
+// currentAchievementProgress is a rule's counter as of now. A streak whose last
+// solve is older than yesterday has already lapsed, though its counter only
+// resets on the next solve.
+func currentAchievementProgress(rule AchievementRule, counters map[string]int64, now time.Time) int64 {
+	v := counters[rule.Metric]
+	if rule.Metric == metricStreakDays && counters[metricStreakLastDay] < utcDayNumber(now)-1 {
+		return 0
+	}
+	return v
+}
+
+// newlyEarnedAchievements returns the rules not in earned whose counter has
+// reached the target.
+func newlyEarnedAchievements(counters map[string]int64, earned map[string]bool) []AchievementRule {
+	var out []AchievementRule
+	for _, rule := range achievementRules {
+		if !earned[rule.Key] && counters[rule.Metric] >= rule.Target {
+			out = append(out, rule)
+		}
+	}
+	return out
+}
+
+func (a *Api) loadAchievementCounters(userID uint32) (map[string]int64, error) {
+	rows, err := a.DB.Query(`SELECT metric, value FROM achievement_counters WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counters := map[string]int64{}
+	for rows.Next() {
+		var metric string
+		var v int64
+		if err := rows.Scan(&metric, &v); err != nil {
+			return nil, err
+		}
+		counters[metric] = v
+	}
+	return counters, rows.Err()
+}
+
+// loadEarnedAchievements maps achievement key to earned_at.
+func (a *Api) loadEarnedAchievements(userID uint32) (map[string]time.Time, error) {
+	rows, err := a.DB.Query(`SELECT achievement_key, earned_at FROM user_achievements WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	earned := map[string]time.Time{}
+	for rows.Next() {
+		var key string
+		var at time.Time
+		if err := rows.Scan(&key, &at); err != nil {
+			return nil, err
+		}
+		earned[key] = at
+	}
+	return earned, rows.Err()
+}
+
+// evaluateAchievements folds a committed batch into the user's counters and
+// earns any rule that now meets its target. Best-effort like the other derived
+// caches processEvent maintains: errors are logged, never surfaced, because
+// achievements must not fail a gameplay request.
+func (a *Api) evaluateAchievements(logPrefix string, userID uint32, events []*Event, solvedBitmap uint64) {
+	relevant := false
+	for _, e := range events {
+		if achievementEventTypes[e.EventType] {
+			relevant = true
+			break
+		}
+	}
+	if !relevant {
+		return
+	}
+
+	counters, err := a.loadAchievementCounters(userID)
+	if err != nil {
+		glog.Errorf("%s achievements: load counters: %v", logPrefix, err)
+		return
+	}
+	changed := foldAchievementCounters(counters, events, solvedBitmap, time.Now())
+	if len(changed) == 0 {
+		return
+	}
+	placeholders := make([]string, len(changed))
+	args := make([]interface{}, 0, len(changed)*3)
+	for i, m := range changed {
+		placeholders[i] = "(?, ?, ?)"
+		args = append(args, userID, m, counters[m])
+	}
+	if _, err := a.DB.Exec(
+		`INSERT INTO achievement_counters (user_id, metric, value) VALUES `+strings.Join(placeholders, ", ")+
+			` ON DUPLICATE KEY UPDATE value = VALUES(value)`,
+		args...,
+	); err != nil {
+		glog.Errorf("%s achievements: save counters: %v", logPrefix, err)
+		return
+	}
+
+	earnedAt, err := a.loadEarnedAchievements(userID)
+	if err != nil {
+		glog.Errorf("%s achievements: load earned: %v", logPrefix, err)
+		return
+	}
+	earned := make(map[string]bool, len(earnedAt))
+	for k := range earnedAt {
+		earned[k] = true
+	}
+	var earnedEvents []*Event
+	for _, rule := range newlyEarnedAchievements(counters, earned) {
+		// INSERT IGNORE + RowsAffected: a concurrent request earning the same
+		// rule logs it once.
+		res, err := a.DB.Exec(
+			`INSERT IGNORE INTO user_achievements (user_id, achievement_key) VALUES (?, ?)`, userID, rule.Key,
+		)
+		if err != nil {
+			glog.Errorf("%s achievements: earn %s: %v", logPrefix, rule.Key, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		glog.Infof("%s achievement earned: %s", logPrefix, rule.Key)
+		earnedEvents = append(earnedEvents, &Event{EventType: ACHIEVEMENT_EARNED, Value: rule.Key})
+	}
+	if err := a.createEventsBatch(userID, earnedEvents); err != nil {
+		glog.Errorf("%s achievements: log earned events: %v", logPrefix, err)
+	}
+}
+
+// AchievementStatus is one catalog entry for a user: earned (with when) or in
+// progress toward Target.
+type AchievementStatus struct {
+	Key         string     `json:"key"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Progress    int64      `json:"progress"`
+	Target      int64      `json:"target"`
+	Earned      bool       `json:"earned"`
+	EarnedAt    *time.Time `json:"earned_at,omitempty"`
+}
+
+// achievementStatuses lists every rule in catalog order. Progress is capped at
+// Target; an earned rule reports Target even if its counter has since reset
+// (a lapsed streak stays earned).
+func achievementStatuses(counters map[string]int64, earnedAt map[string]time.Time, now time.Time) []AchievementStatus {
+	out := make([]AchievementStatus, 0, len(achievementRules))
+	for _, rule := range achievementRules {
+		s := AchievementStatus{
+			Key:         rule.Key,
+			Title:       rule.Title,
+			Description: rule.Description,
+			Target:      rule.Target,
+			Progress:    currentAchievementProgress(rule, counters, now),
+		}
+		if at, ok := earnedAt[rule.Key]; ok {
+			at := at
+			s.Earned = true
+			s.EarnedAt = &at
+			s.Progress = rule.Target
+		}
+		if s.Progress > rule.Target {
+			s.Progress = rule.Target
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+// getAchievements lists the catalog with the user's earned and in-progress
+// state. Users may only read their own.
+func (a *Api) getAchievements(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	glog.Infof("%s fcn start", logPrefix)
+
+	user := GetUserFromContext(c)
+
+	var params struct {
+		UserId uint32 `uri:"user_id"`
+	}
+	if BindModelFromURI(logPrefix, c, &params) != nil {
+		return
+	}
+	if params.UserId != user.Id {
+		c.JSON(http.StatusForbidden, common.GetError("Forbidden"))
+		return
+	}
+
+	counters, err := a.loadAchievementCounters(user.Id)
+	if err != nil {
+		glog.Errorf("%s load counters: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not get achievements"))
+		return
+	}
+	earnedAt, err := a.loadEarnedAchievements(user.Id)
+	if err != nil {
+		glog.Errorf("%s load earned: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not get achievements"))
+		return
+	}
+	HandleMngrRespWriteCtx(logPrefix, c, http.StatusOK, "", nil, achievementStatuses(counters, earnedAt, time.Now()))
+}
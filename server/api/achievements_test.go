@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+func achEv(eventType, value string) *Event {
+	return &Event{EventType: eventType, Value: value}
+}
+
+func TestFoldAchievementCounters_FirstTryRun(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	fracs := uint64(mathcore.FRACTIONS | mathcore.MISMATCHED_DENOMINATORS)
+	counters := map[string]int64{}
+
+	// A first-try solve: the correct answer and its solve commit together.
+	foldAchievementCounters(counters, []*Event{achEv(ANSWERED_PROBLEM, "1/2"), achEv(SOLVED_PROBLEM, "10")}, fracs, now)
+	foldAchievementCounters(counters, []*Event{achEv(SELECTED_PROBLEM, "11")}, 0, now)
+	if counters[metricFirstTryRun] != 1 || counters[topicFirstTryMetric("mismatched_denominators")] != 1 || counters[topicFirstTryMetric("fractions")] != 1 {
+		t.Fatalf("after one first-try solve: got %v", counters)
+	}
+
+	// A miss breaks the run, and the eventual solve of that problem does not
+	// restart it or count toward the topic.
+	foldAchievementCounters(counters, []*Event{achEv(ANSWERED_PROBLEM, "7")}, 0, now)
+	foldAchievementCounters(counters, []*Event{achEv(ANSWERED_PROBLEM, "3/4"), achEv(SOLVED_PROBLEM, "11")}, fracs, now)
+	if counters[metricFirstTryRun] != 0 || counters[topicFirstTryMetric("fractions")] != 1 {
+		t.Errorf("after a missed solve: want run 0 and fractions 1, got %v", counters)
+	}
+	if counters[metricSolvedTotal] != 2 {
+		t.Errorf("solved_total: want 2, got %d", counters[metricSolvedTotal])
+	}
+
+	// The next problem starts clean.
+	foldAchievementCounters(counters, []*Event{achEv(SELECTED_PROBLEM, "12")}, 0, now)
+	changed := foldAchievementCounters(counters, []*Event{achEv(ANSWERED_PROBLEM, "5"), achEv(SOLVED_PROBLEM, "12")}, uint64(mathcore.ADDITION), now)
+	if counters[metricFirstTryRun] != 1 || counters[topicFirstTryMetric("addition")] != 1 {
+		t.Errorf("after a fresh first-try solve: got %v", counters)
+	}
+	if len(changed) == 0 {
+		t.Errorf("want the changed counters reported")
+	}
+}
+
+func TestFoldAchievementCounters_Streak(t *testing.T) {
+	day1 := time.Date(2026, 10, 12, 8, 0, 0, 0, time.UTC)
+	solve := []*Event{achEv(ANSWERED_PROBLEM, "1"), achEv(SOLVED_PROBLEM, "1")}
+	counters := map[string]int64{}
+
+	foldAchievementCounters(counters, solve, 0, day1)
+	foldAchievementCounters(counters, solve, 0, day1.Add(10*time.Hour)) // same day
+	if counters[metricStreakDays] != 1 {
+		t.Fatalf("same day: want streak 1, got %d", counters[metricStreakDays])
+	}
+	foldAchievementCounters(counters, solve, 0, day1.AddDate(0, 0, 1))
+	foldAchievementCounters(counters, solve, 0, day1.AddDate(0, 0, 2))
+	if counters[metricStreakDays] != 3 {
+		t.Fatalf("three consecutive days: want streak 3, got %d", counters[metricStreakDays])
+	}
+	// A skipped day restarts the streak.
+	foldAchievementCounters(counters, solve, 0, day1.AddDate(0, 0, 4))
+	if counters[metricStreakDays] != 1 {
+		t.Errorf("after a gap: want streak 1, got %d", counters[metricStreakDays])
+	}
+}
+
+func TestCurrentAchievementProgress_LapsedStreak(t *testing.T) {
+	rule := AchievementRule{Key: "streak_7", Metric: metricStreakDays, Target: 7}
+	today := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	counters := map[string]int64{metricStreakDays: 4, metricStreakLastDay: utcDayNumber(today.AddDate(0, 0, -1))}
+	if got := currentAchievementProgress(rule, counters, today); got != 4 {
+		t.Errorf("streak through yesterday: want 4, got %d", got)
+	}
+	counters[metricStreakLastDay] = utcDayNumber(today.AddDate(0, 0, -2))
+	if got := currentAchievementProgress(rule, counters, today); got != 0 {
+		t.Errorf("lapsed streak: want 0, got %d", got)
+	}
+}
+
+func TestAchievementStatuses(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	counters := map[string]int64{metricSolvedTotal: 150}
+	earned := newlyEarnedAchievements(counters, map[string]bool{"first_solve": true})
+	if len(earned) != 1 || earned[0].Key != "solved_100" {
+		t.Fatalf("want solved_100 newly earned, got %+v", earned)
+	}
+
+	earnedAt := map[string]time.Time{"first_solve": now.AddDate(0, -1, 0), "solved_100": now}
+	statuses := achievementStatuses(counters, earnedAt, now)
+	if len(statuses) != len(achievementRules) {
+		t.Fatalf("want every rule listed, got %d", len(statuses))
+	}
+	byKey := map[string]AchievementStatus{}
+	for _, s := range statuses {
+		byKey[s.Key] = s
+	}
+	if s := byKey["solved_100"]; !s.Earned || s.Progress != 100 || s.EarnedAt == nil {
+		t.Errorf("solved_100: want earned with progress capped at 100, got %+v", s)
+	}
+	if s := byKey["solved_1000"]; s.Earned || s.Progress != 150 || s.Target != 1000 {
+		t.Errorf("solved_1000: want in progress 150/1000, got %+v", s)
+	}
+}
+
+// TestAchievements_EarnedThroughProcessEvent solves a problem over the API and
+// checks the first-solve achievement is recorded, logged and listed.
+func TestAchievements_EarnedThroughProcessEvent(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|achievements", "achievements@test.com", "achievementsuser")
+	insertVideosAndUserHasVideo(t, api, user.Id, 2)
+
+	gs := &Gamestate{}
+	fetchGamestate(t, r, user, gs)
+	p := Problem{}
+	fetchProblem(t, r, user, gs.ProblemId, &p)
+	reportEvent(t, r, user, ANSWERED_PROBLEM, p.Answer)
+
+	var logged int
+	if err := api.DB.QueryRow(
+		"SELECT COUNT(*) FROM events WHERE user_id = ? AND event_type = ? AND value = ?", user.Id, ACHIEVEMENT_EARNED, "first_solve",
+	).Scan(&logged); err != nil || logged != 1 {
+		t.Fatalf("want one first_solve event, got %d (%v)", logged, err)
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/achievements/%d?test_auth0_id=%s", user.Id, user.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("list: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	var statuses []AchievementStatus
+	if err := json.Unmarshal(resp.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	for _, s := range statuses {
+		if s.Key == "first_solve" && !s.Earned {
+			t.Errorf("first_solve: want earned, got %+v", s)
+		}
+		if s.Key == "first_try_10" && (s.Earned || s.Progress != 1) {
+			t.Errorf("first_try_10: want 1/10 in progress, got %+v", s)
+		}
+	}
+
+	// Another user's achievements are forbidden.
+	other := createTestUser(t, r, "auth0|achievements-other", "achievements-other@test.com", "achievementsother")
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/v1/achievements/%d?test_auth0_id=%s", user.Id, other.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("cross-user: want 403, got %d", resp.Code)
+	}
+}
@@ -10,12 +10,14 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"net/http"
 	"sort"
 	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
@@ -105,18 +107,14 @@ func (a *Api) adminDifficultyCalibration(c *gin.Context) {
 // returning immediately. A second request while one is running is a no-op.
 func (a *Api) adminRecomputeCalibration(c *gin.Context) {
 	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
 	if !calibrationComputing.CompareAndSwap(false, true) {
 		c.JSON(http.StatusOK, gin.H{"computing": true})
 		return
 	}
-	go func() {
+	started := a.goBackground(logPrefix, "calibration", func(ctx context.Context) {
 		defer calibrationComputing.Store(false)
-		defer func() {
-			if r := recover(); r != nil {
-				glog.Errorf("%s calibration recompute panicked: %v", logPrefix, r)
-			}
-		}()
-		report, err := a.computeCalibrationReport()
+		report, err := a.computeCalibrationReport(ctx)
 		if err != nil {
 			glog.Errorf("%s calibration recompute: %v", logPrefix, err)
 			return
@@ -126,21 +124,33 @@ func (a *Api) adminRecomputeCalibration(c *gin.Context) {
 			glog.Errorf("%s calibration marshal: %v", logPrefix, err)
 			return
 		}
-		if _, err := a.DB.Exec(
+		var before interface{}
+		var prev time.Time
+		if err := a.DB.QueryRowContext(ctx, "SELECT computed_at FROM calibration_report WHERE id = 1").Scan(&prev); err == nil {
+			before = gin.H{"computed_at": prev}
+		}
+		if _, err := a.DB.ExecContext(ctx,
 			"INSERT INTO calibration_report (id, report, computed_at) VALUES (1, ?, NOW()) "+
 				"ON DUPLICATE KEY UPDATE report = VALUES(report), computed_at = VALUES(computed_at)",
 			string(blob),
 		); err != nil {
 			glog.Errorf("%s calibration cache write: %v", logPrefix, err)
+			return
 		}
-	}()
+		a.audit(logPrefix, admin.Id, AUDIT_CALIBRATION_RECOMPUTE, AUDIT_TARGET_CALIBRATION, "", before, gin.H{"computed_at": time.Now()})
+	})
+	if !started {
+		calibrationComputing.Store(false)
+		c.JSON(http.StatusServiceUnavailable, common.GetError("Server is shutting down"))
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"computing": true})
 }
 
 // computeCalibrationReport samples the live pool into difficulty buckets: per
 // bucket and generator version, one example of each distinct problem-type
 // bitmap, with that generator's live count and each example's factor breakdown.
-func (a *Api) computeCalibrationReport() (CalibrationData, error) {
+func (a *Api) computeCalibrationReport(ctx context.Context) (CalibrationData, error) {
 	// Pass 1: live/disabled counts and per-generator live counts per bucket.
 	type aggBucket struct {
 		live, disabled int
@@ -148,8 +158,9 @@ func (a *Api) computeCalibrationReport() (CalibrationData, error) {
 	}
 	agg := map[int]*aggBucket{}
 	maxBucket := 0
-	rows, err := a.DB.Query(
-		"SELECT " + calibBucketExpr + " AS bucket, disabled, generator, COUNT(*) " +
+	rows, err := a.DB.QueryContext(
+		ctx,
+		"SELECT "+calibBucketExpr+" AS bucket, disabled, generator, COUNT(*) "+
 			"FROM problems GROUP BY bucket, disabled, generator")
 	if err != nil {
 		return CalibrationData{}, err
@@ -185,14 +196,15 @@ func (a *Api) computeCalibrationReport() (CalibrationData, error) {
 		generator string
 	}
 	samples := map[sampleKey][]CalibrationProblem{}
-	srows, err := a.DB.Query(
-		"WITH ranked AS (" +
-			"SELECT id, bucket, generator FROM (" +
-			"SELECT id, " + calibBucketExpr + " AS bucket, generator, " +
-			"ROW_NUMBER() OVER (PARTITION BY " + calibBucketExpr + ", generator, problem_type_bitmap ORDER BY id) AS rn " +
-			"FROM problems WHERE disabled = 0) t WHERE rn = 1) " +
-			"SELECT r.bucket, r.generator, p.expression, p.symbolic_expression, p.difficulty, p.problem_type_bitmap " +
-			"FROM ranked r JOIN problems p ON p.id = r.id " +
+	srows, err := a.DB.QueryContext(
+		ctx,
+		"WITH ranked AS ("+
+			"SELECT id, bucket, generator FROM ("+
+			"SELECT id, "+calibBucketExpr+" AS bucket, generator, "+
+			"ROW_NUMBER() OVER (PARTITION BY "+calibBucketExpr+", generator, problem_type_bitmap ORDER BY id) AS rn "+
+			"FROM problems WHERE disabled = 0) t WHERE rn = 1) "+
+			"SELECT r.bucket, r.generator, p.expression, p.symbolic_expression, p.difficulty, p.problem_type_bitmap "+
+			"FROM ranked r JOIN problems p ON p.id = r.id "+
 			"ORDER BY r.bucket, r.generator")
 	if err != nil {
 		return CalibrationData{}, err
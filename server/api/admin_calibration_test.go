@@ -1,6 +1,7 @@
 package api // import "garydmenezes.com/mathgame/server/api"
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -46,7 +47,7 @@ func TestComputeCalibrationReport(t *testing.T) {
 	defer cleanup()
 	seedCalibrationProblems(t, api)
 
-	data, err := api.computeCalibrationReport()
+	data, err := api.computeCalibrationReport(context.Background())
 	if err != nil {
 		t.Fatalf("computeCalibrationReport: %v", err)
 	}
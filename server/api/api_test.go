@@ -62,6 +62,7 @@ func setupTestAPI(t *testing.T, c *common.Config) (*Api, *gin.Engine, func()) {
 		t.Fatalf("run migrations: %v", err)
 	}
 	api.isTest = true
+	useFakeYouTube(t, api)
 	r := api.GetRouter()
 	cleanup := func() {
 		db.Close()
@@ -0,0 +1,683 @@
+// assignments.go: parent-authored assignments.
+//
+// Settings only set an envelope; an assignment says "do these problems". A
+// parent creates one on the settings page from a list of problem ids, from a
+// bitmap + difficulty spec (drawn from the candidate index), or from typed
+// expressions (stamped like an import), optionally with a due date. Until
+// every item is solved or skipped, selectProblem serves the next item ahead
+// of everything else, whatever the envelope and difficulty. Answers to a
+// served item are tracked per item (attempts, first-try correctness, when it
+// was shown and solved) and GET /assignments/:user_id is the completion
+// report the companion shows. Documented in docs/gameplay.md "Assignments".
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// Where an assignment's items came from, as stored in assignments.source.
+const (
+	ASSIGNMENT_FROM_PROBLEMS    = "problems"
+	ASSIGNMENT_FROM_SPEC        = "spec"
+	ASSIGNMENT_FROM_EXPRESSIONS = "expressions"
+)
+
+// Assignment statuses, derived when read.
+const (
+	ASSIGNMENT_OPEN     = "open"
+	ASSIGNMENT_OVERDUE  = "overdue"
+	ASSIGNMENT_COMPLETE = "complete"
+)
+
+// Item statuses, derived when read.
+const (
+	ITEM_PENDING = "pending" // not served yet
+	ITEM_STARTED = "started" // served, not solved
+	ITEM_SOLVED  = "solved"
+	ITEM_SKIPPED = "skipped" // reported bad, or its problem went away
+)
+
+const (
+	maxAssignmentItems = 50
+	maxAssignmentTitle = 128
+	// AssignmentGenerator is the generator column of problems created from
+	// an assignment's typed expressions. They are inserted disabled, so only
+	// the assignment serves them.
+	AssignmentGenerator = "assignment"
+	// assignmentReportLimit caps the assignments one report lists.
+	assignmentReportLimit = 50
+)
+
+// AssignmentSpec asks for Count problems from the pool whose bitmaps are a
+// subset of ProblemTypeBitmap, within problemSelectionEpsilon of Difficulty.
+type AssignmentSpec struct {
+	ProblemTypeBitmap uint64  `json:"problem_type_bitmap"`
+	Difficulty        float64 `json:"difficulty"`
+	Count             int     `json:"count"`
+}
+
+// AssignmentRequest is the POST /assignments/:user_id body. Exactly one of
+// ProblemIds, Spec and Expressions is set.
+type AssignmentRequest struct {
+	Title       string          `json:"title"`
+	DueAt       *time.Time      `json:"due_at"`
+	ProblemIds  []uint32        `json:"problem_ids"`
+	Spec        *AssignmentSpec `json:"spec"`
+	Expressions []ProblemRecord `json:"expressions"`
+	// ClassroomId is set when a teacher pushes the assignment to a roster
+	// (classrooms.go); never read from the body.
+	ClassroomId uint32 `json:"-"`
+}
+
+// AssignmentItem is one problem of an assignment and how it went.
+type AssignmentItem struct {
+	Position        int        `json:"position"`
+	ProblemId       uint32     `json:"problem_id"`
+	Expression      string     `json:"expression"`
+	Status          string     `json:"status"`
+	Attempts        int        `json:"attempts"`
+	FirstTryCorrect bool       `json:"first_try_correct"`
+	FirstShownAt    *time.Time `json:"first_shown_at"`
+	SolvedAt        *time.Time `json:"solved_at"`
+}
+
+// Assignment is an assignment with its completion report.
+type Assignment struct {
+	Id              uint32           `json:"id"`
+	Title           string           `json:"title"`
+	Source          string           `json:"source"`
+	ClassroomId     *uint32          `json:"classroom_id"` // pushed by this classroom's teacher; nil for the family's own
+	DueAt           *time.Time       `json:"due_at"`
+	CreatedAt       time.Time        `json:"created_at"`
+	CompletedAt     *time.Time       `json:"completed_at"`
+	Status          string           `json:"status"`
+	Late            bool             `json:"late"` // completed after due_at
+	Total           int              `json:"total"`
+	Solved          int              `json:"solved"`
+	Skipped         int              `json:"skipped"`
+	FirstTryCorrect int              `json:"first_try_correct"`
+	Items           []AssignmentItem `json:"items"`
+}
+
+// errAssignmentInput marks a create error that is the caller's fault.
+var errAssignmentInput = errors.New("invalid assignment")
+
+func assignmentInputError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errAssignmentInput, fmt.Sprintf(format, args...))
+}
+
+// assignmentItemRef locates an open item and its problem.
+type assignmentItemRef struct {
+	assignmentID uint32
+	position     int
+	problemID    uint32
+}
+
+// peekAssignmentItem returns the item selection would serve next - the
+// first unsolved, unskipped item of the user's open assignment due soonest
+// (undated ones last, then oldest first) - and, ahead of it, the items that
+// must be skipped because their problem is gone or was disabled. It writes
+// nothing; nil means no item is due.
+func (a *Api) peekAssignmentItem(userID uint32) (*assignmentItemRef, []assignmentItemRef, error) {
+	rows, err := a.DB.Query(`
+		SELECT i.assignment_id, i.position, i.problem_id, i.private, COALESCE(p.disabled, 1)
+		FROM assignments s
+		JOIN assignment_items i ON i.assignment_id = s.id
+		LEFT JOIN problems p ON p.id = i.problem_id
+		WHERE s.user_id = ? AND s.completed_at IS NULL AND i.solved_at IS NULL AND i.skipped_at IS NULL
+		ORDER BY s.due_at IS NULL, s.due_at, s.id, i.position`, userID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query assignment items: %w", err)
+	}
+	defer rows.Close()
+	var skip []assignmentItemRef
+	for rows.Next() {
+		var ref assignmentItemRef
+		var private, disabled bool
+		if err := rows.Scan(&ref.assignmentID, &ref.position, &ref.problemID, &private, &disabled); err != nil {
+			return nil, nil, fmt.Errorf("scan assignment item: %w", err)
+		}
+		// A private problem is disabled by design; a pool problem that
+		// was disabled (reported bad) since it was assigned is skipped.
+		if disabled && !private {
+			skip = append(skip, ref)
+			continue
+		}
+		return &ref, skip, nil
+	}
+	return nil, skip, rows.Err()
+}
+
+// nextAssignmentProblem is selection's assignment stage: it skips the items
+// peekAssignmentItem says to, marks the next one shown and returns its
+// problem, or nil when no item is due. Errors are logged and fall through
+// to normal selection.
+func (a *Api) nextAssignmentProblem(logPrefix string, userID uint32) *Problem {
+	ref, skip, err := a.peekAssignmentItem(userID)
+	if err != nil {
+		glog.Errorf("%s peekAssignmentItem: %v", logPrefix, err)
+		return nil
+	}
+	for _, s := range skip {
+		glog.Infof("%s assignment %d item %d: problem %d unavailable, skipping", logPrefix, s.assignmentID, s.position, s.problemID)
+		a.skipAssignmentItem(logPrefix, s)
+	}
+	if ref == nil {
+		return nil
+	}
+	p, status, msg, err := a.problemManager.Get(ref.problemID)
+	if err != nil || status != http.StatusOK {
+		glog.Errorf("%s assignment %d item %d: problem %d: %s %v", logPrefix, ref.assignmentID, ref.position, ref.problemID, msg, err)
+		return nil
+	}
+	if _, err := a.DB.Exec(
+		`UPDATE assignment_items SET first_shown_at = COALESCE(first_shown_at, NOW())
+		 WHERE assignment_id = ? AND position = ?`, ref.assignmentID, ref.position); err != nil {
+		glog.Errorf("%s mark assignment item shown: %v", logPrefix, err)
+	}
+	glog.Infof("%s serving assignment %d item %d (problem=%d)", logPrefix, ref.assignmentID, ref.position, p.Id)
+	return p
+}
+
+// skipAssignmentItem marks an item skipped, completing its assignment if it
+// was the last one left.
+func (a *Api) skipAssignmentItem(logPrefix string, ref assignmentItemRef) {
+	if _, err := a.DB.Exec(
+		`UPDATE assignment_items SET skipped_at = NOW()
+		 WHERE assignment_id = ? AND position = ? AND solved_at IS NULL AND skipped_at IS NULL`,
+		ref.assignmentID, ref.position); err != nil {
+		glog.Errorf("%s skip assignment item: %v", logPrefix, err)
+		return
+	}
+	a.completeAssignmentIfDone(logPrefix, ref.assignmentID)
+}
+
+// completeAssignmentIfDone stamps completed_at once no item is left.
+func (a *Api) completeAssignmentIfDone(logPrefix string, assignmentID uint32) {
+	if _, err := a.DB.Exec(`
+		UPDATE assignments SET completed_at = NOW()
+		WHERE id = ? AND completed_at IS NULL AND NOT EXISTS (
+		  SELECT 1 FROM assignment_items
+		  WHERE assignment_id = ? AND solved_at IS NULL AND skipped_at IS NULL)`,
+		assignmentID, assignmentID); err != nil {
+		glog.Errorf("%s complete assignment %d: %v", logPrefix, assignmentID, err)
+	}
+}
+
+// openAssignmentItem finds the user's served, unsolved item for problemID.
+func (a *Api) openAssignmentItem(userID, problemID uint32) (*assignmentItemRef, int, error) {
+	ref := &assignmentItemRef{problemID: problemID}
+	var attempts int
+	err := a.DB.QueryRow(`
+		SELECT i.assignment_id, i.position, i.attempts
+		FROM assignments s
+		JOIN assignment_items i ON i.assignment_id = s.id
+		WHERE s.user_id = ? AND s.completed_at IS NULL AND i.problem_id = ?
+		  AND i.first_shown_at IS NOT NULL AND i.solved_at IS NULL AND i.skipped_at IS NULL
+		ORDER BY s.due_at IS NULL, s.due_at, s.id, i.position
+		LIMIT 1`, userID, problemID).Scan(&ref.assignmentID, &ref.position, &attempts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ref, attempts, nil
+}
+
+// recordAssignmentAnswer counts an ANSWERED_PROBLEM against the assignment
+// item being served, if the answered problem is one. Failures are logged:
+// the answer itself still counts.
+func (a *Api) recordAssignmentAnswer(logPrefix string, userID, problemID uint32, correct bool) {
+	ref, attempts, err := a.openAssignmentItem(userID, problemID)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		glog.Errorf("%s openAssignmentItem: %v", logPrefix, err)
+		return
+	}
+	if correct {
+		_, err = a.DB.Exec(
+			`UPDATE assignment_items SET attempts = attempts + 1, first_try_correct = ?, solved_at = NOW()
+			 WHERE assignment_id = ? AND position = ?`, attempts == 0, ref.assignmentID, ref.position)
+	} else {
+		_, err = a.DB.Exec(
+			`UPDATE assignment_items SET attempts = attempts + 1 WHERE assignment_id = ? AND position = ?`,
+			ref.assignmentID, ref.position)
+	}
+	if err != nil {
+		glog.Errorf("%s record assignment answer: %v", logPrefix, err)
+		return
+	}
+	if correct {
+		a.completeAssignmentIfDone(logPrefix, ref.assignmentID)
+	}
+}
+
+// skipReportedAssignmentItem skips the served item for a problem reported
+// bad, so the next selection moves on rather than serving it again.
+func (a *Api) skipReportedAssignmentItem(logPrefix string, userID, problemID uint32) {
+	ref, _, err := a.openAssignmentItem(userID, problemID)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		glog.Errorf("%s openAssignmentItem: %v", logPrefix, err)
+		return
+	}
+	a.skipAssignmentItem(logPrefix, *ref)
+}
+
+// resolveAssignmentItems turns a request into the problem ids to assign, in
+// order, and which of them are private. Input errors wrap
+// errAssignmentInput.
+func (a *Api) resolveAssignmentItems(logPrefix string, userID uint32, req *AssignmentRequest) (string, []uint32, map[uint32]bool, error) {
+	set := 0
+	for _, given := range []bool{len(req.ProblemIds) > 0, req.Spec != nil, len(req.Expressions) > 0} {
+		if given {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", nil, nil, assignmentInputError("give exactly one of problem_ids, spec and expressions")
+	}
+	switch {
+	case len(req.ProblemIds) > 0:
+		if len(req.ProblemIds) > maxAssignmentItems {
+			return "", nil, nil, assignmentInputError("at most %d problems", maxAssignmentItems)
+		}
+		for _, id := range req.ProblemIds {
+			p, status, _, err := a.problemManager.Get(id)
+			if status == http.StatusNotFound {
+				return "", nil, nil, assignmentInputError("problem %d not found", id)
+			} else if err != nil {
+				return "", nil, nil, fmt.Errorf("get problem %d: %w", id, err)
+			}
+			if p.Disabled {
+				return "", nil, nil, assignmentInputError("problem %d is disabled", id)
+			}
+		}
+		return ASSIGNMENT_FROM_PROBLEMS, req.ProblemIds, nil, nil
+
+	case req.Spec != nil:
+		ids, err := a.pickAssignmentProblems(logPrefix, userID, req.Spec)
+		return ASSIGNMENT_FROM_SPEC, ids, nil, err
+
+	default:
+		ids, private, err := a.stampAssignmentExpressions(req.Expressions)
+		return ASSIGNMENT_FROM_EXPRESSIONS, ids, private, err
+	}
+}
+
+// pickAssignmentProblems draws spec.Count problems from the candidate index,
+// leaving out the user's recently shown ones. A pool too small for the count
+// is an input error, and queues generation for the spec so a retry can
+// succeed.
+func (a *Api) pickAssignmentProblems(logPrefix string, userID uint32, spec *AssignmentSpec) ([]uint32, error) {
+	bitmap := mathcore.ProblemType(spec.ProblemTypeBitmap)
+	if bitmap == 0 || bitmap&^mathcore.ALL_PROBLEM_TYPES != 0 {
+		return nil, assignmentInputError("problem_type_bitmap must be 1-%d", uint64(mathcore.ALL_PROBLEM_TYPES))
+	}
+	if ceiling := mathcore.MaxDiffForBitmap(spec.ProblemTypeBitmap); spec.Difficulty < mathcore.MinTargetDifficulty || spec.Difficulty > ceiling {
+		return nil, assignmentInputError("difficulty must be %.0f-%.1f for these problem types", mathcore.MinTargetDifficulty, ceiling)
+	}
+	if spec.Count < 1 || spec.Count > maxAssignmentItems {
+		return nil, assignmentInputError("count must be 1-%d", maxAssignmentItems)
+	}
+	ids, err := a.lookupCandidates(logPrefix, spec.ProblemTypeBitmap,
+		spec.Difficulty-problemSelectionEpsilon, spec.Difficulty+problemSelectionEpsilon,
+		loadRecentProblemIds(logPrefix, a.DB, userID))
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) < spec.Count {
+		a.enqueueGeneration(logPrefix, &Settings{UserId: userID, ProblemTypeBitmap: spec.ProblemTypeBitmap, TargetDifficulty: spec.Difficulty})
+		return nil, assignmentInputError("only %d problems match; more are being generated, try again later", len(ids))
+	}
+	rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+	return ids[:spec.Count], nil
+}
+
+// stampAssignmentExpressions stamps typed expressions as an import does. An
+// expression already in the pool is assigned as that problem (private if the
+// pool has disabled it); a new one is inserted disabled, with
+// AssignmentGenerator, so the shared pool never serves a parent's problem to
+// anyone else.
+func (a *Api) stampAssignmentExpressions(recs []ProblemRecord) ([]uint32, map[uint32]bool, error) {
+	if len(recs) > maxAssignmentItems {
+		return nil, nil, assignmentInputError("at most %d expressions", maxAssignmentItems)
+	}
+	var stamped []*Problem
+	for i, rec := range recs {
+		p, reason := stampImportRecord(rec)
+		if reason != "" {
+			return nil, nil, assignmentInputError("expression %d (%s): %s", i+1, rec.Expression, reason)
+		}
+		stamped = append(stamped, p)
+	}
+	ids := make([]uint32, 0, len(stamped))
+	private := map[uint32]bool{}
+	for _, p := range stamped {
+		inserted, err := a.insertPrivateProblem(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if inserted {
+			a.candidates.put(p)
+			private[p.Id] = true
+			ids = append(ids, p.Id)
+			continue
+		}
+		var existing uint32
+		var disabled bool
+		err = a.DB.QueryRow("SELECT id, disabled FROM problems WHERE id = ? OR expression = ? LIMIT 1", p.Id, p.Expression).Scan(&existing, &disabled)
+		if err != nil {
+			return nil, nil, fmt.Errorf("look up %q: %w", p.Expression, err)
+		}
+		// The parent typed it, so serve it even if the pool has disabled it.
+		private[existing] = disabled
+		ids = append(ids, existing)
+	}
+	return ids, private, nil
+}
+
+// insertPrivateProblem inserts p disabled, under AssignmentGenerator, in one
+// statement: ProblemManager.Create would leave it live until a second write
+// disabled it. It reports false when the expression is already stored (by id
+// or text), leaving that row untouched.
+func (a *Api) insertPrivateProblem(p *Problem) (bool, error) {
+	p.Generator = AssignmentGenerator
+	p.Disabled = true
+	res, err := a.DB.Exec(`
+		INSERT INTO problems (id, problem_type_bitmap, expression, answer, explanation, symbolic_expression, difficulty, disabled, generator, difficulty_version)
+		SELECT ?, ?, ?, ?, ?, ?, ?, 1, ?, ? FROM DUAL
+		WHERE NOT EXISTS (SELECT 1 FROM problems WHERE expression = ?)`,
+		p.Id, p.ProblemTypeBitmap, p.Expression, p.Answer, p.Explanation, p.SymbolicExpression, p.Difficulty,
+		p.Generator, p.DifficultyVersion, p.Expression)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return false, nil
+		}
+		return false, fmt.Errorf("insert %q: %w", p.Expression, err)
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// CreateAssignment validates req and stores the assignment for userID.
+// Input errors wrap errAssignmentInput.
+func (a *Api) CreateAssignment(logPrefix string, userID uint32, req *AssignmentRequest) (*Assignment, error) {
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" || len(req.Title) > maxAssignmentTitle {
+		return nil, assignmentInputError("title must be 1-%d characters", maxAssignmentTitle)
+	}
+	source, ids, private, err := a.resolveAssignmentItems(logPrefix, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var classroomID *uint32
+	if req.ClassroomId != 0 {
+		classroomID = &req.ClassroomId
+	}
+	res, err := tx.Exec("INSERT INTO assignments (user_id, title, source, due_at, classroom_id) VALUES (?, ?, ?, ?, ?)",
+		userID, req.Title, source, req.DueAt, classroomID)
+	if err != nil {
+		return nil, fmt.Errorf("insert assignment: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	for i, pid := range ids {
+		if _, err := tx.Exec("INSERT INTO assignment_items (assignment_id, position, problem_id, private) VALUES (?, ?, ?, ?)",
+			id, i+1, pid, private[pid]); err != nil {
+			return nil, fmt.Errorf("insert assignment item: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	glog.Infof("%s created assignment %d (%s, %d items) for user %d", logPrefix, id, source, len(ids), userID)
+	return a.getAssignment(userID, uint32(id))
+}
+
+// listAssignments returns the user's assignmentReportLimit newest
+// assignments with their reports, newest first; openOnly leaves out
+// completed ones.
+func (a *Api) listAssignments(userID uint32, openOnly bool) ([]Assignment, error) {
+	where := "user_id = ?"
+	if openOnly {
+		where += " AND completed_at IS NULL"
+	}
+	return a.assignmentReports(where+" ORDER BY id DESC LIMIT ?", userID, assignmentReportLimit)
+}
+
+// assignmentReports loads the assignments matching where (a WHERE clause
+// with any ORDER BY and LIMIT) and builds their reports.
+func (a *Api) assignmentReports(where string, args ...interface{}) ([]Assignment, error) {
+	rows, err := a.DB.Query(`SELECT id, title, source, classroom_id, due_at, created_at, completed_at
+		FROM assignments WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query assignments: %w", err)
+	}
+	defer rows.Close()
+	out := []Assignment{}
+	byID := map[uint32]int{}
+	for rows.Next() {
+		var s Assignment
+		if err := rows.Scan(&s.Id, &s.Title, &s.Source, &s.ClassroomId, &s.DueAt, &s.CreatedAt, &s.CompletedAt); err != nil {
+			return nil, fmt.Errorf("scan assignment: %w", err)
+		}
+		s.Items = []AssignmentItem{}
+		byID[s.Id] = len(out)
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(out)), ",")
+	args = make([]interface{}, 0, len(out))
+	for _, s := range out {
+		args = append(args, s.Id)
+	}
+	items, err := a.DB.Query(`
+		SELECT i.assignment_id, i.position, i.problem_id, COALESCE(p.expression, ''), i.attempts,
+		  i.first_try_correct, i.first_shown_at, i.solved_at, i.skipped_at IS NOT NULL
+		FROM assignment_items i
+		LEFT JOIN problems p ON p.id = i.problem_id
+		WHERE i.assignment_id IN (`+placeholders+`)
+		ORDER BY i.assignment_id, i.position`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query assignment items: %w", err)
+	}
+	defer items.Close()
+	for items.Next() {
+		var assignmentID uint32
+		var it AssignmentItem
+		var skipped bool
+		if err := items.Scan(&assignmentID, &it.Position, &it.ProblemId, &it.Expression, &it.Attempts,
+			&it.FirstTryCorrect, &it.FirstShownAt, &it.SolvedAt, &skipped); err != nil {
+			return nil, fmt.Errorf("scan assignment item: %w", err)
+		}
+		s := &out[byID[assignmentID]]
+		switch {
+		case it.SolvedAt != nil:
+			it.Status = ITEM_SOLVED
+			s.Solved++
+			if it.FirstTryCorrect {
+				s.FirstTryCorrect++
+			}
+		case skipped:
+			it.Status = ITEM_SKIPPED
+			s.Skipped++
+		case it.FirstShownAt != nil:
+			it.Status = ITEM_STARTED
+		default:
+			it.Status = ITEM_PENDING
+		}
+		s.Total++
+		s.Items = append(s.Items, it)
+	}
+	if err := items.Err(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range out {
+		s := &out[i]
+		switch {
+		case s.CompletedAt != nil:
+			s.Status = ASSIGNMENT_COMPLETE
+			s.Late = s.DueAt != nil && s.CompletedAt.After(*s.DueAt)
+		case s.DueAt != nil && now.After(*s.DueAt):
+			s.Status = ASSIGNMENT_OVERDUE
+		default:
+			s.Status = ASSIGNMENT_OPEN
+		}
+	}
+	return out, nil
+}
+
+// getAssignment returns one of the user's assignments, or sql.ErrNoRows.
+func (a *Api) getAssignment(userID, assignmentID uint32) (*Assignment, error) {
+	list, err := a.assignmentReports("id = ? AND user_id = ?", assignmentID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(list) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &list[0], nil
+}
+
+// assignmentUser binds :user_id and checks it is the caller.
+func assignmentUser(logPrefix string, c *gin.Context) (*User, bool) {
+	user := GetUserFromContext(c)
+	var params struct {
+		UserId uint32 `uri:"user_id"`
+	}
+	if BindModelFromURI(logPrefix, c, &params) != nil {
+		return nil, false
+	}
+	if params.UserId != user.Id {
+		c.JSON(http.StatusForbidden, common.GetError("Forbidden"))
+		return nil, false
+	}
+	return user, true
+}
+
+// customListAssignments handles GET /assignments/:user_id?status=open.
+func (a *Api) customListAssignments(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user, ok := assignmentUser(logPrefix, c)
+	if !ok {
+		return
+	}
+	list, err := a.listAssignments(user.Id, c.Query("status") == ASSIGNMENT_OPEN)
+	if err != nil {
+		glog.Errorf("%s listAssignments: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list assignments"))
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// customCreateAssignment handles POST /assignments/:user_id.
+func (a *Api) customCreateAssignment(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user, ok := assignmentUser(logPrefix, c)
+	if !ok {
+		return
+	}
+	var req AssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	s, err := a.CreateAssignment(logPrefix, user.Id, &req)
+	if errors.Is(err, errAssignmentInput) {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	} else if err != nil {
+		glog.Errorf("%s CreateAssignment: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not create assignment"))
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// assignmentID binds :assignment_id.
+func assignmentID(c *gin.Context) (uint32, bool) {
+	id, err := strconv.ParseUint(c.Param("assignment_id"), 10, 32)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("Invalid assignment_id: %s", c.Param("assignment_id"))))
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// customGetAssignment handles GET /assignments/:user_id/:assignment_id.
+func (a *Api) customGetAssignment(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user, ok := assignmentUser(logPrefix, c)
+	if !ok {
+		return
+	}
+	id, ok := assignmentID(c)
+	if !ok {
+		return
+	}
+	s, err := a.getAssignment(user.Id, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, common.GetError("Assignment not found"))
+		return
+	} else if err != nil {
+		glog.Errorf("%s getAssignment: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not get assignment"))
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// customDeleteAssignment handles DELETE /assignments/:user_id/:assignment_id.
+// The items go with it; the problems stay.
+func (a *Api) customDeleteAssignment(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user, ok := assignmentUser(logPrefix, c)
+	if !ok {
+		return
+	}
+	id, ok := assignmentID(c)
+	if !ok {
+		return
+	}
+	res, err := a.DB.Exec("DELETE FROM assignments WHERE id = ? AND user_id = ?", id, user.Id)
+	if err != nil {
+		glog.Errorf("%s delete assignment %d: %v", logPrefix, id, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not delete assignment"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, common.GetError("Assignment not found"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
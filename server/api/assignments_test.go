@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// TestAssignments creates an assignment from each source, serves it ahead of
+// normal selection, answers its items and checks the completion report.
+func TestAssignments(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|assign", "assign@test.com", "assignuser")
+	other := createTestUser(t, r, "auth0|assign-other", "assignother@test.com", "assignother")
+	add := uint64(mathcore.ADDITION)
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := api.DB.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	exec("UPDATE problems SET disabled = 1")
+	exec("DELETE FROM generation_jobs")
+	exec("DELETE FROM recently_shown_problems")
+	exec("UPDATE settings SET problem_type_bitmap = ?, target_difficulty = 3 WHERE user_id = ?", add, user.Id)
+	for id := uint32(9801); id <= 9806; id++ {
+		exec(`INSERT INTO problems (id, problem_type_bitmap, expression, symbolic_expression, answer, difficulty, disabled, generator, difficulty_version)
+			 VALUES (?, ?, ?, '', '1', 3, 0, 'llm_0.5', '0.2')`, id, add, fmt.Sprintf("assign %d", id))
+	}
+	if err := api.LoadCandidateIndex(); err != nil {
+		t.Fatalf("LoadCandidateIndex: %v", err)
+	}
+	path := fmt.Sprintf("/assignments/%d", user.Id)
+	create := func(body string) (int, Assignment) {
+		t.Helper()
+		resp := catalogRequest(t, r, user, "POST", path, body)
+		var s Assignment
+		if resp.Code == 200 {
+			if err := json.Unmarshal(resp.Body.Bytes(), &s); err != nil {
+				t.Fatalf("decode assignment: %v %s", err, resp.Body.String())
+			}
+		}
+		return resp.Code, s
+	}
+
+	for _, bad := range []string{
+		`{"title":"none"}`,
+		`{"title":"","problem_ids":[9801]}`,
+		`{"title":"both","problem_ids":[9801],"spec":{"problem_type_bitmap":1,"difficulty":3,"count":1}}`,
+		`{"title":"missing","problem_ids":[1]}`,
+		`{"title":"too many","spec":{"problem_type_bitmap":1,"difficulty":3,"count":7}}`,
+		`{"title":"bad expr","expressions":[{"expression":"9 -","answer":"9"}]}`,
+	} {
+		if code, _ := create(bad); code != 400 {
+			t.Errorf("%s: want 400, got %d", bad, code)
+		}
+	}
+	if resp := catalogRequest(t, r, other, "GET", path, ""); resp.Code != 403 {
+		t.Errorf("another user's assignments: want 403, got %d", resp.Code)
+	}
+
+	// Due soonest is served first, whatever was created first.
+	code, undated := create(`{"title":"later","spec":{"problem_type_bitmap":1,"difficulty":3,"count":2}}`)
+	if code != 200 || undated.Source != ASSIGNMENT_FROM_SPEC || undated.Total != 2 {
+		t.Fatalf("spec: want 2 items, got %d %+v", code, undated)
+	}
+	code, dated := create(`{"title":"homework","due_at":"2099-01-01T00:00:00Z","problem_ids":[9805,9803]}`)
+	if code != 200 || dated.Status != ASSIGNMENT_OPEN || dated.Items[0].ProblemId != 9805 {
+		t.Fatalf("problem_ids: got %d %+v", code, dated)
+	}
+	code, typed := create(`{"title":"typed","expressions":[{"expression":"173 + 219","answer":"392"}]}`)
+	if code != 200 || typed.Total != 1 || typed.Items[0].Expression == "" {
+		t.Fatalf("expressions: got %d %+v", code, typed)
+	}
+	private, _, _, err := api.problemManager.Get(typed.Items[0].ProblemId)
+	if err != nil || !private.Disabled || private.Generator != AssignmentGenerator {
+		t.Fatalf("want the typed problem stored disabled, got %+v %v", private, err)
+	}
+	// Flagged, it stays out of the review workbench and can't be published.
+	exec("INSERT INTO problem_flags (problem_id, user_id, source) VALUES (?, ?, ?)", private.Id, user.Id, FLAG_SOURCE_USER)
+	queue, err := api.listFlaggedProblems("", "", 50, 0)
+	if err != nil {
+		t.Fatalf("listFlaggedProblems: %v", err)
+	}
+	for _, f := range queue {
+		if f.Id == private.Id {
+			t.Errorf("want the private problem out of the review queue")
+		}
+	}
+	exec("UPDATE users SET role = ? WHERE id = ?", RoleAdmin, other.Id)
+	for _, action := range []string{"enable", "edit"} {
+		resp := catalogRequest(t, r, other, "POST", fmt.Sprintf("/admin/problem-review/%d/%s", private.Id, action), `{"note":"x"}`)
+		if resp.Code != 409 {
+			t.Errorf("%s a private problem: want 409, got %d", action, resp.Code)
+		}
+	}
+
+	settings, _, _, err := api.settingsManager.Get(user.Id)
+	if err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	serve := func() uint32 {
+		t.Helper()
+		p, err := api.selectProblem("[test]", ctx, settings, &[]uint32{})
+		if err != nil {
+			t.Fatalf("selectProblem: %v", err)
+		}
+		return p.Id
+	}
+	if id := serve(); id != 9805 {
+		t.Fatalf("want the dated assignment's first item, got %d", id)
+	}
+	// A wrong answer then a right one: solved, but not first try.
+	api.recordAssignmentAnswer("[test]", user.Id, 9805, false)
+	api.recordAssignmentAnswer("[test]", user.Id, 9805, true)
+	if id := serve(); id != 9803 {
+		t.Fatalf("want the second item, got %d", id)
+	}
+	api.recordAssignmentAnswer("[test]", user.Id, 9803, true)
+
+	resp := catalogRequest(t, r, user, "GET", fmt.Sprintf("%s/%d", path, dated.Id), "")
+	var got Assignment
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode report: %v %s", err, resp.Body.String())
+	}
+	if got.Status != ASSIGNMENT_COMPLETE || got.Late || got.Solved != 2 || got.FirstTryCorrect != 1 ||
+		got.Items[0].Attempts != 2 || got.Items[0].FirstTryCorrect || !got.Items[1].FirstTryCorrect {
+		t.Errorf("report: want complete with 1 of 2 first try, got %+v", got)
+	}
+
+	// The undated ones follow, oldest first. A pool problem disabled after it
+	// was assigned is skipped; a typed one is served although disabled.
+	exec("UPDATE problems SET disabled = 1 WHERE id IN (?, ?)", undated.Items[0].ProblemId, undated.Items[1].ProblemId)
+	if id := serve(); id != typed.Items[0].ProblemId {
+		t.Fatalf("want the typed problem, got %d", id)
+	}
+	resp = catalogRequest(t, r, user, "GET", path+"?status=open", "")
+	var open []Assignment
+	if err := json.Unmarshal(resp.Body.Bytes(), &open); err != nil {
+		t.Fatalf("decode open: %v %s", err, resp.Body.String())
+	}
+	if len(open) != 1 || open[0].Id != typed.Id {
+		t.Errorf("want only the typed assignment open, got %+v", open)
+	}
+
+	// Reporting it bad skips it; with nothing left, selection is normal.
+	api.skipReportedAssignmentItem("[test]", user.Id, typed.Items[0].ProblemId)
+	exec("UPDATE problems SET disabled = 0 WHERE id = 9806")
+	api.LoadCandidateIndex()
+	if id := serve(); id < 9801 || id > 9806 {
+		t.Errorf("want a pool problem once assignments are done, got %d", id)
+	}
+
+	if resp := catalogRequest(t, r, other, "DELETE", fmt.Sprintf("/assignments/%d/%d", other.Id, typed.Id), ""); resp.Code != 404 {
+		t.Errorf("deleting another user's assignment: want 404, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, user, "DELETE", fmt.Sprintf("%s/%d", path, typed.Id), ""); resp.Code != 200 {
+		t.Errorf("delete: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, user, "GET", fmt.Sprintf("%s/%d", path, typed.Id), ""); resp.Code != 404 {
+		t.Errorf("after delete: want 404, got %d", resp.Code)
+	}
+
+	// An assignment older than the newest assignmentReportLimit is left out
+	// of the list but can still be fetched.
+	var oldest uint32
+	for i := 0; i <= assignmentReportLimit; i++ {
+		res, err := api.DB.Exec("INSERT INTO assignments (user_id, title, source) VALUES (?, ?, ?)",
+			other.Id, fmt.Sprintf("bulk %d", i), ASSIGNMENT_FROM_PROBLEMS)
+		if err != nil {
+			t.Fatalf("insert assignment: %v", err)
+		}
+		if i == 0 {
+			id, _ := res.LastInsertId()
+			oldest = uint32(id)
+		}
+	}
+	otherPath := fmt.Sprintf("/assignments/%d", other.Id)
+	resp = catalogRequest(t, r, other, "GET", otherPath, "")
+	var page []Assignment
+	if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil || len(page) != assignmentReportLimit {
+		t.Fatalf("list: want %d assignments, got %d (%v)", assignmentReportLimit, len(page), err)
+	}
+	resp = catalogRequest(t, r, other, "GET", fmt.Sprintf("%s/%d", otherPath, oldest), "")
+	var oldestGot Assignment
+	if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &oldestGot) != nil || oldestGot.Id != oldest || oldestGot.Title != "bulk 0" {
+		t.Errorf("oldest of %d: want it fetched, got %d %s", assignmentReportLimit+1, resp.Code, resp.Body.String())
+	}
+}
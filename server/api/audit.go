@@ -0,0 +1,382 @@
+// audit.go: the audit log of privileged actions, and the admin role
+// management endpoints that are its first writer.
+//
+// Every handler that changes shared state on an operator's say-so records
+// one audit_log row: who (actor_id), what (action), to what (target_type,
+// target_id), the value before and after, as JSON, and an optional note. The
+// log is append-only: recordAuditNote is the only writer and nothing updates
+// or deletes a row. It is also the problem review trail: problem_review_log
+// is a view of its problem rows (problem_review.go). A role change, a video
+// disable and a problem disable write their row in the same transaction as
+// the change; the other actions record after their write succeeds, so a
+// failed audit insert is logged but doesn't undo the action.
+// Documented in docs/accounts.md "Audit log".
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// Audit actions.
+const (
+	AUDIT_ROLE_CHANGE           = "role_change"
+	AUDIT_PROBLEM_ENABLE        = "problem_enable"
+	AUDIT_PROBLEM_DISABLE       = "problem_disable"
+	AUDIT_PROBLEM_EDIT          = "problem_edit"
+	AUDIT_PROBLEM_RETIRE        = "problem_retire"
+	AUDIT_PROBLEM_IMPORT        = "problem_import"
+	AUDIT_CALIBRATION_RECOMPUTE = "calibration_recompute"
+	AUDIT_VIDEO_DISABLED        = "video_disabled"
+	AUDIT_CATALOG_UPSERT        = "catalog_upsert"
+	AUDIT_CATALOG_REMOVE        = "catalog_remove"
+	AUDIT_USER_RESTORE          = "user_restore"
+)
+
+// Audit target types; target_id is the row's id, or "" for an action on a
+// table as a whole (an import, the calibration report).
+const (
+	AUDIT_TARGET_USER        = "user"
+	AUDIT_TARGET_PROBLEM     = "problem"
+	AUDIT_TARGET_VIDEO       = "video"
+	AUDIT_TARGET_PLAYLIST    = "playlist"
+	AUDIT_TARGET_CALIBRATION = "calibration"
+)
+
+const (
+	defaultAdminPage = 50
+	maxAdminPage     = 200
+)
+
+// AuditEntry is one audit_log row.
+type AuditEntry struct {
+	Id         uint64          `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorId    uint32          `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetId   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	Note       string          `json:"note"`
+}
+
+// AuditPage is a page of the audit log, newest first. NextCursor is "" on
+// the last page.
+type AuditPage struct {
+	Entries    []AuditEntry `json:"entries"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+// AdminUser is a users row as the role management endpoints show it: no pin.
+type AdminUser struct {
+	Id       uint32 `json:"id"`
+	Auth0Id  string `json:"auth0_id"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// AdminUserPage is a page of users by id. NextCursor is "" on the last page.
+type AdminUserPage struct {
+	Users      []AdminUser `json:"users"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+// auditValue encodes one side of an entry; nil is SQL NULL.
+func auditValue(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// recordAudit appends an entry. db is a.DB, or the transaction the audited
+// write runs in.
+func recordAudit(db interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, actorID uint32, action, targetType, targetID string, before, after interface{}) error {
+	return recordAuditNote(db, actorID, action, targetType, targetID, "", before, after)
+}
+
+// recordAuditNote is recordAudit with the note the actor gave.
+func recordAuditNote(db interface {
+	Exec(string, ...interface{}) (sql.Result, error)
+}, actorID uint32, action, targetType, targetID, note string, before, after interface{}) error {
+	b, err := auditValue(before)
+	if err != nil {
+		return fmt.Errorf("encode audit before: %w", err)
+	}
+	af, err := auditValue(after)
+	if err != nil {
+		return fmt.Errorf("encode audit after: %w", err)
+	}
+	_, err = db.Exec(
+		"INSERT INTO audit_log (actor_id, action, target_type, target_id, before_value, after_value, note) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		actorID, action, targetType, targetID, b, af, note)
+	return err
+}
+
+// audit records an entry for an action that has already happened, logging
+// rather than returning a failure.
+func (a *Api) audit(logPrefix string, actorID uint32, action, targetType, targetID string, before, after interface{}) {
+	if err := recordAudit(a.DB, actorID, action, targetType, targetID, before, after); err != nil {
+		glog.Errorf("%s audit %s %s=%s: %v", logPrefix, action, targetType, targetID, err)
+	}
+}
+
+// parseCursorLimit reads the cursor and limit query parameters the admin
+// listings share.
+func parseCursorLimit(v url.Values) (cursor uint64, limit int, err error) {
+	limit = defaultAdminPage
+	if s := v.Get("cursor"); s != "" {
+		if cursor, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid cursor")
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxAdminPage {
+			return 0, 0, fmt.Errorf("limit must be 1 to %d", maxAdminPage)
+		}
+	}
+	return cursor, limit, nil
+}
+
+// AuditQuery filters the audit log; zero fields don't filter.
+type AuditQuery struct {
+	ActorId    uint32
+	Action     string
+	TargetType string
+	TargetId   string
+	BeforeId   uint64 // the cursor: entries older than this one
+	Limit      int
+}
+
+// parseAuditQuery reads an AuditQuery from query parameters, returning an
+// error whose text is the 400 body.
+func parseAuditQuery(v url.Values) (AuditQuery, error) {
+	var q AuditQuery
+	var err error
+	if q.BeforeId, q.Limit, err = parseCursorLimit(v); err != nil {
+		return q, err
+	}
+	if s := v.Get("actor_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return q, fmt.Errorf("actor_id must be an unsigned integer")
+		}
+		q.ActorId = uint32(id)
+	}
+	q.Action = v.Get("action")
+	q.TargetType = v.Get("target_type")
+	q.TargetId = v.Get("target_id")
+	return q, nil
+}
+
+// listAudit returns a page of entries matching q, newest first.
+func (a *Api) listAudit(q AuditQuery) (*AuditPage, error) {
+	where := []string{"1=1"}
+	args := []interface{}{}
+	if q.BeforeId != 0 {
+		where = append(where, "id < ?")
+		args = append(args, q.BeforeId)
+	}
+	if q.ActorId != 0 {
+		where = append(where, "actor_id = ?")
+		args = append(args, q.ActorId)
+	}
+	for col, val := range map[string]string{"action": q.Action, "target_type": q.TargetType, "target_id": q.TargetId} {
+		if val != "" {
+			where = append(where, col+" = ?")
+			args = append(args, val)
+		}
+	}
+	args = append(args, q.Limit+1)
+	rows, err := a.DB.Query(`
+		SELECT id, created_at, actor_id, action, target_type, target_id, before_value, after_value, note
+		FROM audit_log WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY id DESC LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query audit_log: %w", err)
+	}
+	defer rows.Close()
+	page := &AuditPage{Entries: []AuditEntry{}}
+	for rows.Next() {
+		var e AuditEntry
+		var before, after sql.NullString
+		if err := rows.Scan(&e.Id, &e.CreatedAt, &e.ActorId, &e.Action, &e.TargetType, &e.TargetId, &before, &after, &e.Note); err != nil {
+			return nil, fmt.Errorf("scan audit_log: %w", err)
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(page.Entries) > q.Limit {
+		page.Entries = page.Entries[:q.Limit]
+		page.NextCursor = strconv.FormatUint(page.Entries[q.Limit-1].Id, 10)
+	}
+	return page, nil
+}
+
+// adminListAudit handles GET /admin/audit with actor_id, action, target_type,
+// target_id, cursor and limit.
+func (a *Api) adminListAudit(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	q, err := parseAuditQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	page, err := a.listAudit(q)
+	if err != nil {
+		glog.Errorf("%s listAudit: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list the audit log"))
+		return
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// adminListUsers handles GET /admin/users with role, q (a substring of email,
+// username or auth0_id), cursor and limit.
+func (a *Api) adminListUsers(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	v := c.Request.URL.Query()
+	cursor, limit, err := parseCursorLimit(v)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	where := []string{"id > ?"}
+	args := []interface{}{cursor}
+	if role := v.Get("role"); role != "" {
+		if !validRole(role) {
+			c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("Unknown role: %s", role)))
+			return
+		}
+		where = append(where, "role = ?")
+		args = append(args, role)
+	}
+	if q := strings.TrimSpace(v.Get("q")); q != "" {
+		pattern := "%" + escapeLike(q) + "%"
+		where = append(where, "(email LIKE ? OR username LIKE ? OR auth0_id LIKE ?)")
+		args = append(args, pattern, pattern, pattern)
+	}
+	args = append(args, limit+1)
+	rows, err := a.DB.Query("SELECT id, auth0_id, email, username, role FROM users WHERE "+
+		strings.Join(where, " AND ")+" ORDER BY id LIMIT ?", args...)
+	if err != nil {
+		glog.Errorf("%s query users: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list users"))
+		return
+	}
+	defer rows.Close()
+	page := AdminUserPage{Users: []AdminUser{}}
+	for rows.Next() {
+		var u AdminUser
+		if err := rows.Scan(&u.Id, &u.Auth0Id, &u.Email, &u.Username, &u.Role); err != nil {
+			glog.Errorf("%s scan users: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not list users"))
+			return
+		}
+		page.Users = append(page.Users, u)
+	}
+	if len(page.Users) > limit {
+		page.Users = page.Users[:limit]
+		page.NextCursor = strconv.FormatUint(uint64(page.Users[limit-1].Id), 10)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// adminSetUserRole handles POST /admin/users/:user_id/role {"role": ...}.
+// An admin can't change their own role, so there is always an admin left to
+// undo a mistake. Setting the role a user already has is a no-op and isn't
+// audited.
+func (a *Api) adminSetUserRole(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	targetID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("Invalid user_id: %s", c.Param("user_id"))))
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	if !validRole(body.Role) {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("Unknown role: %s", body.Role)))
+		return
+	}
+	if uint32(targetID) == admin.Id {
+		c.JSON(http.StatusBadRequest, common.GetError("You can't change your own role"))
+		return
+	}
+
+	tx, err := a.DB.Begin()
+	if err != nil {
+		glog.Errorf("%s begin: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not change role"))
+		return
+	}
+	defer tx.Rollback()
+	var u AdminUser
+	err = tx.QueryRow("SELECT id, auth0_id, email, username, role FROM users WHERE id = ? FOR UPDATE", targetID).
+		Scan(&u.Id, &u.Auth0Id, &u.Email, &u.Username, &u.Role)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, common.GetError("User not found"))
+		return
+	} else if err != nil {
+		glog.Errorf("%s load user %d: %v", logPrefix, targetID, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not change role"))
+		return
+	}
+	if u.Role == body.Role {
+		c.JSON(http.StatusOK, u)
+		return
+	}
+	before := u.Role
+	if _, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", body.Role, u.Id); err != nil {
+		glog.Errorf("%s update role: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not change role"))
+		return
+	}
+	if err := recordAudit(tx, admin.Id, AUDIT_ROLE_CHANGE, AUDIT_TARGET_USER, strconv.FormatUint(uint64(u.Id), 10),
+		gin.H{"role": before}, gin.H{"role": body.Role}); err != nil {
+		glog.Errorf("%s audit role change: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not change role"))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		glog.Errorf("%s commit: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not change role"))
+		return
+	}
+	u.Role = body.Role
+	glog.Infof("%s admin %d changed user %d role %s -> %s", logPrefix, admin.Id, u.Id, before, u.Role)
+	c.JSON(http.StatusOK, u)
+}
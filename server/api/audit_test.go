@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestRolePermissions(t *testing.T) {
+	cases := []struct {
+		role string
+		perm Permission
+		want bool
+	}{
+		{RoleAdmin, PermAdminAccess, true},
+		{RoleAdmin, PermManageRoles, true},
+		{RoleAdmin, PermTeach, false},
+		{RoleTeacher, PermTeach, true},
+		{RoleTeacher, PermAdminAccess, false},
+		{RoleStudent, PermAdminAccess, false},
+		{RoleStudent, PermTeach, false},
+		{"superuser", PermAdminAccess, false},
+	}
+	for _, tc := range cases {
+		if got := HasPermission(tc.role, tc.perm); got != tc.want {
+			t.Errorf("HasPermission(%q, %q) = %v, want %v", tc.role, tc.perm, got, tc.want)
+		}
+	}
+	if validRole("superuser") || !validRole(RoleTeacher) {
+		t.Errorf("validRole: want only the roles rolePermissions knows")
+	}
+}
+
+// TestRoleManagementAudit grants and revokes a role through the admin API and
+// checks each privileged action lands in the audit log with its before and
+// after values.
+func TestRoleManagementAudit(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	admin := createTestUser(t, r, "auth0|audit-admin", "auditadmin@test.com", "auditadmin")
+	user := createTestUser(t, r, "auth0|audit-user", "audituser@test.com", "audituser")
+	if resp := catalogRequest(t, r, admin, "GET", "/admin/users", ""); resp.Code != http.StatusForbidden {
+		t.Fatalf("student listing users: want 403, got %d", resp.Code)
+	}
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE id=?", RoleAdmin, admin.Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+
+	resp := catalogRequest(t, r, admin, "GET", "/admin/users?q=audituser", "")
+	var users AdminUserPage
+	if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &users) != nil ||
+		len(users.Users) != 1 || users.Users[0].Id != user.Id || users.Users[0].Role != RoleStudent {
+		t.Fatalf("list users: got %d %s", resp.Code, resp.Body.String())
+	}
+
+	rolePath := fmt.Sprintf("/admin/users/%d/role", user.Id)
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{rolePath, `{"role":"superuser"}`, 400},
+		{fmt.Sprintf("/admin/users/%d/role", admin.Id), `{"role":"student"}`, 400},
+		{"/admin/users/999999/role", `{"role":"teacher"}`, 404},
+	} {
+		if resp := catalogRequest(t, r, admin, "POST", tc.path, tc.body); resp.Code != tc.want {
+			t.Errorf("%s %s: want %d, got %d", tc.path, tc.body, tc.want, resp.Code)
+		}
+	}
+	if resp := catalogRequest(t, r, admin, "POST", rolePath, `{"role":"teacher"}`); resp.Code != 200 {
+		t.Fatalf("grant teacher: got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, user, "GET", "/teacher/classrooms", ""); resp.Code != 200 {
+		t.Errorf("new teacher: want the teacher surface, got %d", resp.Code)
+	}
+	// Setting the same role again is a no-op, and not audited.
+	catalogRequest(t, r, admin, "POST", rolePath, `{"role":"teacher"}`)
+	if resp := catalogRequest(t, r, admin, "POST", rolePath, `{"role":"student"}`); resp.Code != 200 {
+		t.Fatalf("revoke: got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, user, "GET", "/teacher/classrooms", ""); resp.Code != http.StatusForbidden {
+		t.Errorf("revoked teacher: want 403, got %d", resp.Code)
+	}
+
+	if _, err := api.DB.Exec(`INSERT INTO problems (id, problem_type_bitmap, expression, symbolic_expression, answer, difficulty, disabled, generator, difficulty_version)
+		VALUES (9951, 1, 'audit 9951', '', '1', 3, 1, 'llm_0.5', '0.2')`); err != nil {
+		t.Fatalf("insert problem: %v", err)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", "/admin/problem-review/9951/enable", ""); resp.Code != 200 {
+		t.Fatalf("enable: got %d %s", resp.Code, resp.Body.String())
+	}
+
+	audit := func(query string) []AuditEntry {
+		t.Helper()
+		resp := catalogRequest(t, r, admin, "GET", "/admin/audit?"+query, "")
+		var page AuditPage
+		if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &page) != nil {
+			t.Fatalf("audit %s: got %d %s", query, resp.Code, resp.Body.String())
+		}
+		return page.Entries
+	}
+	roles := audit(fmt.Sprintf("target_type=user&target_id=%d", user.Id))
+	if len(roles) != 2 || roles[0].Action != AUDIT_ROLE_CHANGE || roles[0].ActorId != admin.Id ||
+		string(roles[0].Before) != `{"role":"teacher"}` || string(roles[0].After) != `{"role":"student"}` ||
+		string(roles[1].After) != `{"role":"teacher"}` {
+		t.Errorf("role changes: want revoke then grant, newest first, got %+v", roles)
+	}
+	enabled := audit("action=problem_enable&target_id=9951")
+	var before, after Problem
+	if len(enabled) != 1 || json.Unmarshal(enabled[0].Before, &before) != nil || json.Unmarshal(enabled[0].After, &after) != nil ||
+		!before.Disabled || after.Disabled {
+		t.Errorf("problem enable: got %+v", enabled)
+	}
+	if page := audit(fmt.Sprintf("actor_id=%d&limit=1", admin.Id)); len(page) != 1 || page[0].Action != AUDIT_PROBLEM_ENABLE {
+		t.Errorf("limit 1: want the newest entry, got %+v", page)
+	}
+	if resp := catalogRequest(t, r, admin, "GET", "/admin/audit?limit=0", ""); resp.Code != 400 {
+		t.Errorf("limit 0: want 400, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,142 @@
+// background.go: the apiserver's tracked background work.
+//
+// Work that outlives its request (the generation queue workers, the
+// calibration rebuild) starts its goroutine through Api.goBackground rather
+// than a bare go statement, so Shutdown can wait for it. Long-running loops
+// watch draining() and return once shutdown begins. Each job gets a
+// context that Shutdown cancels once its deadline passes; jobs thread it into
+// their OpenAI and database calls so a cancelled job returns promptly instead
+// of being killed mid-write. The shutdown sequence is in cmd/apiserver/main.go
+// and docs/ops-runbook.md.
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// backgroundCancelGrace is how long Shutdown waits for jobs to return after
+// cancelling them.
+const backgroundCancelGrace = 5 * time.Second
+
+// backgroundJobs tracks running background goroutines. The zero value is
+// ready to use.
+type backgroundJobs struct {
+	once    sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+	drain   chan struct{} // closed when shutdown begins
+	mu      sync.Mutex
+	closed  bool
+	running map[string]int // job name -> goroutines running
+	wg      sync.WaitGroup
+}
+
+func (b *backgroundJobs) init() {
+	b.once.Do(func() {
+		b.ctx, b.cancel = context.WithCancel(context.Background())
+		b.drain = make(chan struct{})
+		b.running = map[string]int{}
+	})
+}
+
+// start runs fn in a tracked goroutine, recovering a panic. It returns false,
+// without running fn, once shutdown has begun.
+func (b *backgroundJobs) start(logPrefix, name string, fn func(ctx context.Context)) bool {
+	b.init()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		glog.Infof("%s shutting down; not starting background %s", logPrefix, name)
+		return false
+	}
+	b.running[name]++
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer func() {
+			b.mu.Lock()
+			b.running[name]--
+			b.mu.Unlock()
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				glog.Errorf("%s background %s panicked: %v", logPrefix, name, r)
+			}
+		}()
+		fn(b.ctx)
+	}()
+	return true
+}
+
+// draining is closed once shutdown begins: a loop waiting for more work
+// should return instead.
+func (b *backgroundJobs) draining() <-chan struct{} {
+	b.init()
+	return b.drain
+}
+
+// runningJobs renders the running jobs as "name=count, ..." for logs.
+func (b *backgroundJobs) runningJobs() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	parts := []string{}
+	for name, n := range b.running {
+		if n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", name, n))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// shutdown stops new jobs and waits for running ones until ctx is done, then
+// cancels them and waits up to backgroundCancelGrace more. It errors if any
+// job is still running after that.
+func (b *backgroundJobs) shutdown(ctx context.Context) error {
+	b.init()
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.drain)
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	glog.Warningf("shutdown deadline passed; cancelling background jobs: %s", b.runningJobs())
+	b.cancel()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(backgroundCancelGrace):
+		return fmt.Errorf("background jobs still running after cancel: %s", b.runningJobs())
+	}
+}
+
+// goBackground starts fn as tracked background work named name; see
+// backgroundJobs.start.
+func (a *Api) goBackground(logPrefix, name string, fn func(ctx context.Context)) bool {
+	return a.background.start(logPrefix, name, fn)
+}
+
+// Shutdown drains background work: no new jobs start, running ones get until
+// ctx is done to finish and are then cancelled. Call it after the HTTP
+// server has stopped accepting requests.
+func (a *Api) Shutdown(ctx context.Context) error {
+	return a.background.shutdown(ctx)
+}
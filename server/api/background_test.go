@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBackgroundJobs_ShutdownWaits: a job that finishes within the deadline
+// runs to completion, and no job starts once shutdown has begun.
+func TestBackgroundJobs_ShutdownWaits(t *testing.T) {
+	var b backgroundJobs
+	var finished atomic.Bool
+	if !b.start("[test]", "slow", func(ctx context.Context) {
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(ctx.Err() == nil)
+	}) {
+		t.Fatal("start before shutdown: want true")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := b.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if !finished.Load() {
+		t.Error("want the job to finish uncancelled")
+	}
+	select {
+	case <-b.draining():
+	default:
+		t.Error("want draining closed after shutdown")
+	}
+	if b.start("[test]", "late", func(context.Context) { t.Error("job started after shutdown") }) {
+		t.Error("start after shutdown: want false")
+	}
+}
+
+// TestBackgroundJobs_ShutdownCancels: a job still running at the deadline is
+// cancelled, and a panicking job doesn't take the process down.
+func TestBackgroundJobs_ShutdownCancels(t *testing.T) {
+	var b backgroundJobs
+	var cancelled atomic.Bool
+	b.start("[test]", "panics", func(context.Context) { panic("boom") })
+	b.start("[test]", "blocks", func(ctx context.Context) {
+		<-ctx.Done()
+		cancelled.Store(true)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.shutdown(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if !cancelled.Load() {
+		t.Error("want the blocked job's context cancelled")
+	}
+	if got := b.runningJobs(); got != "" {
+		t.Errorf("running after shutdown: %q", got)
+	}
+}
@@ -0,0 +1,337 @@
+// candidate_index.go: the in-process index selection reads candidates from.
+//
+// getSatisfyingProblemIds used to run a full-table query per serve. The index
+// instead holds every live problem (disabled=0, non-zero bitmap) bucketed by
+// problem_type_bitmap, each bucket sorted by difficulty, so a lookup visits
+// only the buckets that are subsets of the envelope and, in each, only the
+// difficulty window. The apiserver loads it at startup (LoadCandidateIndex)
+// or on the first serve. In-process writes update it as they happen: every
+// problemManager.Create/Update on the problems table is followed by
+// candidates.put. Writers in other processes (import_problems, the
+// recompute_* backfills, hand-run SQL) are picked up by a full rebuild in
+// the background once the index is candidateIndexMaxAge old. Part of the
+// selection system - documented in docs/selection.md.
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// candidateIndexMaxAge is how long a load serves before a background rebuild
+// replaces it; it bounds how stale out-of-process writes can be.
+const candidateIndexMaxAge = 5 * time.Minute
+
+// indexedProblem is one live problem in a bitmap bucket.
+type indexedProblem struct {
+	difficulty float64
+	id         uint32
+	rank       int32 // generatorRank of the problem's generator
+}
+
+// indexKey locates a problem's entry, for updates.
+type indexKey struct {
+	bitmap     uint64
+	difficulty float64
+}
+
+// candidateSet is the index's data: buckets ordered by (difficulty, id).
+type candidateSet struct {
+	buckets map[uint64][]indexedProblem
+	bitmaps []uint64 // bucket keys, ascending, so lookups are deterministic
+	where   map[uint32]indexKey
+}
+
+func newCandidateSet() *candidateSet {
+	return &candidateSet{buckets: map[uint64][]indexedProblem{}, where: map[uint32]indexKey{}}
+}
+
+func indexedLess(a, b indexedProblem) bool {
+	if a.difficulty != b.difficulty {
+		return a.difficulty < b.difficulty
+	}
+	return a.id < b.id
+}
+
+// put records p's current state: removed if it's disabled or unstamped,
+// else inserted or moved to its bitmap and difficulty.
+func (s *candidateSet) put(p *Problem) {
+	if old, ok := s.where[p.Id]; ok {
+		s.remove(p.Id, old)
+	}
+	if p.Disabled || p.ProblemTypeBitmap == 0 {
+		return
+	}
+	e := indexedProblem{difficulty: p.Difficulty, id: p.Id, rank: int32(generatorRank[p.Generator])}
+	b, ok := s.buckets[p.ProblemTypeBitmap]
+	if !ok {
+		i := sort.Search(len(s.bitmaps), func(i int) bool { return s.bitmaps[i] >= p.ProblemTypeBitmap })
+		s.bitmaps = append(s.bitmaps, 0)
+		copy(s.bitmaps[i+1:], s.bitmaps[i:])
+		s.bitmaps[i] = p.ProblemTypeBitmap
+	}
+	i := sort.Search(len(b), func(i int) bool { return !indexedLess(b[i], e) })
+	b = append(b, indexedProblem{})
+	copy(b[i+1:], b[i:])
+	b[i] = e
+	s.buckets[p.ProblemTypeBitmap] = b
+	s.where[p.Id] = indexKey{bitmap: p.ProblemTypeBitmap, difficulty: p.Difficulty}
+}
+
+func (s *candidateSet) remove(id uint32, k indexKey) {
+	delete(s.where, id)
+	b := s.buckets[k.bitmap]
+	i := sort.Search(len(b), func(i int) bool { return !indexedLess(b[i], indexedProblem{difficulty: k.difficulty, id: id}) })
+	if i == len(b) || b[i].id != id {
+		return
+	}
+	s.buckets[k.bitmap] = append(b[:i], b[i+1:]...)
+}
+
+// lookup returns the newest generator tier of the problems a user with the
+// given envelope could be served in [lo, hi], leaving out exclude: the same
+// set the subset rule (see getSatisfyingProblemIds) and generatorRank give.
+func (s *candidateSet) lookup(envelope uint64, lo, hi float64, exclude []uint32) []uint32 {
+	skip := make(map[uint32]bool, len(exclude))
+	for _, id := range exclude {
+		skip[id] = true
+	}
+	ids := []uint32{}
+	var best int32
+	for _, bitmap := range s.bitmaps {
+		if bitmap&^envelope != 0 {
+			continue
+		}
+		b := s.buckets[bitmap]
+		for i := sort.Search(len(b), func(i int) bool { return b[i].difficulty >= lo }); i < len(b) && b[i].difficulty <= hi; i++ {
+			e := b[i]
+			if skip[e.id] {
+				continue
+			}
+			if len(ids) == 0 || e.rank > best {
+				best = e.rank
+				ids = ids[:0]
+			}
+			if e.rank == best {
+				ids = append(ids, e.id)
+			}
+		}
+	}
+	return ids
+}
+
+// candidateTier counts one generator rank's problems in a lookup's envelope
+// and window.
+type candidateTier struct {
+	problems int // not excluded
+	excluded int // left out by exclude
+}
+
+// tiers is lookup broken down by generator rank, for explaining a pick.
+func (s *candidateSet) tiers(envelope uint64, lo, hi float64, exclude []uint32) map[int32]*candidateTier {
+	skip := make(map[uint32]bool, len(exclude))
+	for _, id := range exclude {
+		skip[id] = true
+	}
+	out := map[int32]*candidateTier{}
+	for _, bitmap := range s.bitmaps {
+		if bitmap&^envelope != 0 {
+			continue
+		}
+		b := s.buckets[bitmap]
+		for i := sort.Search(len(b), func(i int) bool { return b[i].difficulty >= lo }); i < len(b) && b[i].difficulty <= hi; i++ {
+			t := out[b[i].rank]
+			if t == nil {
+				t = &candidateTier{}
+				out[b[i].rank] = t
+			}
+			if skip[b[i].id] {
+				t.excluded++
+			} else {
+				t.problems++
+			}
+		}
+	}
+	return out
+}
+
+// bitmapsOf returns each id's problem_type_bitmap, or 0 for an id the set no
+// longer holds.
+func (s *candidateSet) bitmapsOf(ids []uint32) []uint64 {
+	out := make([]uint64, len(ids))
+	for i, id := range ids {
+		out[i] = s.where[id].bitmap
+	}
+	return out
+}
+
+// candidateIndex guards the live candidateSet and its reloads. The zero
+// value is an unloaded index.
+type candidateIndex struct {
+	loadMu    sync.Mutex // serializes loads
+	mu        sync.RWMutex
+	set       *candidateSet
+	loadedAt  time.Time
+	reloading bool
+	pending   []Problem // puts made while a load runs, replayed onto its result
+}
+
+// put applies an in-process write to the index. Call it after every
+// successful create or update of a problems row.
+func (ix *candidateIndex) put(p *Problem) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.set != nil {
+		ix.set.put(p)
+	}
+	if ix.reloading {
+		ix.pending = append(ix.pending, *p)
+	}
+}
+
+// ensureLoaded loads the index unless it already is.
+func (ix *candidateIndex) ensureLoaded(ctx context.Context, a *Api) error {
+	ix.loadMu.Lock()
+	defer ix.loadMu.Unlock()
+	ix.mu.RLock()
+	loaded := ix.set != nil
+	ix.mu.RUnlock()
+	if loaded {
+		return nil
+	}
+	return ix.loadLocked(ctx, a)
+}
+
+// reload rebuilds the index from the problems table.
+func (ix *candidateIndex) reload(ctx context.Context, a *Api) error {
+	ix.loadMu.Lock()
+	defer ix.loadMu.Unlock()
+	return ix.loadLocked(ctx, a)
+}
+
+// loadLocked reads a fresh set and swaps it in, replaying the puts made
+// while it read. The caller holds loadMu.
+func (ix *candidateIndex) loadLocked(ctx context.Context, a *Api) error {
+	ix.mu.Lock()
+	ix.reloading = true
+	// Writes before this point are committed, so the read below sees them.
+	ix.pending = nil
+	ix.mu.Unlock()
+	set, err := readCandidateSet(ctx, a)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.reloading = false
+	if err != nil {
+		ix.pending = nil
+		return err
+	}
+	for i := range ix.pending {
+		set.put(&ix.pending[i])
+	}
+	ix.pending = nil
+	ix.set, ix.loadedAt = set, time.Now()
+	return nil
+}
+
+// readCandidateSet reads every live problem, bucketing and sorting once at
+// the end rather than per row.
+func readCandidateSet(ctx context.Context, a *Api) (*candidateSet, error) {
+	rows, err := a.DB.QueryContext(ctx, "SELECT id, problem_type_bitmap, difficulty, generator FROM problems WHERE disabled = 0 AND problem_type_bitmap != 0")
+	if err != nil {
+		return nil, fmt.Errorf("load candidate index: %w", err)
+	}
+	defer rows.Close()
+	set := newCandidateSet()
+	for rows.Next() {
+		var e indexedProblem
+		var bitmap uint64
+		var generator string
+		if err := rows.Scan(&e.id, &bitmap, &e.difficulty, &generator); err != nil {
+			return nil, fmt.Errorf("scan candidate: %w", err)
+		}
+		e.rank = int32(generatorRank[generator])
+		set.append(bitmap, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("load candidate index: %w", err)
+	}
+	set.sort()
+	return set, nil
+}
+
+// append adds e unsorted, for a bulk load; sort must follow before lookups.
+func (s *candidateSet) append(bitmap uint64, e indexedProblem) {
+	s.buckets[bitmap] = append(s.buckets[bitmap], e)
+	s.where[e.id] = indexKey{bitmap: bitmap, difficulty: e.difficulty}
+}
+
+// sort orders every bucket and the bucket keys after a bulk load.
+func (s *candidateSet) sort() {
+	s.bitmaps = s.bitmaps[:0]
+	for bitmap, b := range s.buckets {
+		sort.Slice(b, func(i, j int) bool { return indexedLess(b[i], b[j]) })
+		s.bitmaps = append(s.bitmaps, bitmap)
+	}
+	sort.Slice(s.bitmaps, func(i, j int) bool { return s.bitmaps[i] < s.bitmaps[j] })
+}
+
+// lookupCandidates runs a candidateSet.lookup against the index, loading it
+// on first use and starting a background rebuild once it's
+// candidateIndexMaxAge old; the stale index keeps serving meanwhile.
+func (a *Api) lookupCandidates(logPrefix string, envelope uint64, lo, hi float64, exclude []uint32) ([]uint32, error) {
+	ix := &a.candidates
+	if err := ix.ensureLoaded(context.Background(), a); err != nil {
+		return nil, err
+	}
+	ix.mu.Lock()
+	rebuild := !ix.reloading && time.Since(ix.loadedAt) > candidateIndexMaxAge
+	if rebuild {
+		ix.reloading = true
+	}
+	ix.mu.Unlock()
+	if rebuild && !a.goBackground(logPrefix, "candidate-index", func(ctx context.Context) {
+		if err := ix.reload(ctx, a); err != nil {
+			glog.Errorf("%s rebuild candidate index: %v", logPrefix, err)
+		}
+	}) {
+		ix.mu.Lock()
+		ix.reloading = false
+		ix.mu.Unlock()
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.set.lookup(envelope, lo, hi, exclude), nil
+}
+
+// readCandidates runs fn against the index under its read lock, loading it
+// if need be but never starting a rebuild.
+func (a *Api) readCandidates(fn func(s *candidateSet)) error {
+	ix := &a.candidates
+	if err := ix.ensureLoaded(context.Background(), a); err != nil {
+		return err
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	fn(ix.set)
+	return nil
+}
+
+// LoadCandidateIndex loads the selection index. The apiserver calls it at
+// startup so the first serve doesn't pay for the load.
+func (a *Api) LoadCandidateIndex() error {
+	start := time.Now()
+	if err := a.candidates.reload(context.Background(), a); err != nil {
+		return err
+	}
+	a.candidates.mu.RLock()
+	n := len(a.candidates.set.where)
+	a.candidates.mu.RUnlock()
+	glog.Infof("candidate index: %d live problems in %s", n, time.Since(start))
+	return nil
+}
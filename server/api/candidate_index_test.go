@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// randomProblem draws a problem over a few low bits, so envelopes overlap.
+func randomProblem(rng *rand.Rand, id uint32, generators []string) *Problem {
+	return &Problem{
+		Id:                id,
+		ProblemTypeBitmap: uint64(rng.Intn(16)),
+		Difficulty:        float64(rng.Intn(200)) / 10,
+		Disabled:          rng.Intn(10) == 0,
+		Generator:         generators[rng.Intn(len(generators))],
+	}
+}
+
+// bruteForceTier is the old SQL's answer: the subset rule, the window, the
+// exclusions, then the newest generator tier.
+func bruteForceTier(problems map[uint32]*Problem, envelope uint64, lo, hi float64, exclude []uint32) []uint32 {
+	skip := map[uint32]bool{}
+	for _, id := range exclude {
+		skip[id] = true
+	}
+	byRank := map[int][]uint32{}
+	best, haveBest := 0, false
+	for id, p := range problems {
+		if p.Disabled || p.ProblemTypeBitmap == 0 || p.ProblemTypeBitmap&^envelope != 0 ||
+			p.Difficulty < lo || p.Difficulty > hi || skip[id] {
+			continue
+		}
+		r := generatorRank[p.Generator]
+		byRank[r] = append(byRank[r], id)
+		if !haveBest || r > best {
+			best, haveBest = r, true
+		}
+	}
+	return byRank[best]
+}
+
+func sortedIds(ids []uint32) []uint32 {
+	out := append([]uint32{}, ids...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// TestCandidateSet_MatchesSQLSemantics: after random inserts, moves and
+// disables, every lookup returns the same ids as the filter the selection
+// SQL applied.
+func TestCandidateSet_MatchesSQLSemantics(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	generators := []string{"legacy"}
+	for g := range generatorRank {
+		generators = append(generators, g)
+	}
+	sort.Strings(generators)
+	set := newCandidateSet()
+	problems := map[uint32]*Problem{}
+	for i := 0; i < 3000; i++ {
+		// Ids repeat, so later puts move or disable earlier problems.
+		p := randomProblem(rng, uint32(rng.Intn(1500)+1), generators)
+		set.put(p)
+		problems[p.Id] = p
+	}
+	for i := 0; i < 500; i++ {
+		envelope := uint64(rng.Intn(16))
+		target := float64(rng.Intn(20))
+		var exclude []uint32
+		for j := rng.Intn(50); j > 0; j-- {
+			exclude = append(exclude, uint32(rng.Intn(1500)+1))
+		}
+		got := sortedIds(set.lookup(envelope, target-problemSelectionEpsilon, target+problemSelectionEpsilon, exclude))
+		want := sortedIds(bruteForceTier(problems, envelope, target-problemSelectionEpsilon, target+problemSelectionEpsilon, exclude))
+		if len(got) != len(want) {
+			t.Fatalf("envelope=%d target=%v: got %d ids, want %d", envelope, target, len(got), len(want))
+		}
+		for k := range got {
+			if got[k] != want[k] {
+				t.Fatalf("envelope=%d target=%v: got %v, want %v", envelope, target, got, want)
+			}
+		}
+	}
+}
+
+// TestCandidateIndex checks the index loads from the table, follows
+// in-process writes, and picks up out-of-process ones on a rebuild.
+func TestCandidateIndex(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	api, _, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	add := uint64(mathcore.ADDITION)
+	seed := func(id uint32) {
+		t.Helper()
+		if _, err := api.DB.Exec(
+			`INSERT INTO problems (id, problem_type_bitmap, expression, symbolic_expression, answer, difficulty, disabled, generator, difficulty_version)
+			 VALUES (?, ?, 'seed', '', '1', 5, 0, 'test', '0.2')`, id, add); err != nil {
+			t.Fatalf("seed %d: %v", id, err)
+		}
+	}
+	lookup := func() []uint32 {
+		t.Helper()
+		ids, err := api.lookupCandidates("[test]", add, 4, 6, nil)
+		if err != nil {
+			t.Fatalf("lookupCandidates: %v", err)
+		}
+		return sortedIds(ids)
+	}
+	seed(9501)
+	if got := lookup(); len(got) != 1 || got[0] != 9501 {
+		t.Fatalf("first lookup loads the table: got %v", got)
+	}
+
+	// An in-process create or disable shows up at once.
+	created := &Problem{Id: 9502, ProblemTypeBitmap: add, Expression: "1+1", Answer: "2", Difficulty: 5, Generator: "test", DifficultyVersion: "0.2"}
+	if _, _, err := api.problemManager.Create(created); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	api.candidates.put(created)
+	first := &Problem{Id: 9501, ProblemTypeBitmap: add, Difficulty: 5, Disabled: true, Generator: "test"}
+	api.candidates.put(first)
+	if got := lookup(); len(got) != 1 || got[0] != 9502 {
+		t.Errorf("after create and disable: got %v, want [9502]", got)
+	}
+
+	// A row written behind the index's back waits for the rebuild.
+	seed(9503)
+	if got := lookup(); len(got) != 1 {
+		t.Errorf("before the rebuild: want the out-of-process row unseen, got %v", got)
+	}
+	api.candidates.mu.Lock()
+	api.candidates.loadedAt = time.Now().Add(-2 * candidateIndexMaxAge)
+	api.candidates.mu.Unlock()
+	lookup() // serves the stale index and starts the rebuild
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := api.Shutdown(ctx); err != nil {
+		t.Fatalf("wait for the rebuild: %v", err)
+	}
+	// 9501 is still enabled in the table: the put above was never written.
+	if got := lookup(); len(got) != 3 {
+		t.Errorf("after the rebuild: want 9501-9503, got %v", got)
+	}
+}
+
+// BenchmarkCandidateIndexLookup measures a serve's candidate lookup over a
+// million live problems spread across 256 bitmaps, excluding the 50 most
+// recently shown.
+func BenchmarkCandidateIndexLookup(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	set := newCandidateSet()
+	generators := []string{"heuristic_1.0", "llm_0.4", "llm_0.5"}
+	for id := uint32(1); id <= 1000000; id++ {
+		set.append(uint64(rng.Intn(255)+1), indexedProblem{
+			difficulty: float64(rng.Intn(150)) / 10,
+			id:         id,
+			rank:       int32(generatorRank[generators[rng.Intn(len(generators))]]),
+		})
+	}
+	set.sort()
+	exclude := make([]uint32, recentProblemHistorySize)
+	for i := range exclude {
+		exclude[i] = uint32(rng.Intn(1000000) + 1)
+	}
+	envelopes := []uint64{0x0f, 0x3c, 0xff}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		envelope := envelopes[i%len(envelopes)]
+		target := float64(3 + i%8)
+		set.lookup(envelope, target-problemSelectionEpsilon, target+problemSelectionEpsilon, exclude)
+	}
+}
@@ -0,0 +1,375 @@
+// catalog.go: admin-curated catalog playlists.
+//
+// A catalog playlist is an ordinary playlists row with a catalog_playlists row
+// alongside it carrying the curation: an age range, topics and a description.
+// Any user can subscribe to it through user_playlist, and GET /playlists lists
+// the catalog next to the user's own playlists with a subscribed flag.
+// Subscribing never calls the provider: the catalog playlist was synced when
+// it was curated, and ResyncPlaylists keeps it current whether or not anyone
+// subscribes, so every family shares one fetch. Disabling a video is already
+// global (videos.disabled); POST /admin/videos/:id/disabled lets an admin do
+// it by hand and moves every child whose current reward it is onto another.
+// Documented in docs/videos.md.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+const (
+	maxCatalogAge        = 18
+	maxCatalogTopics     = 10
+	maxCatalogTopicLen   = 32
+	maxCatalogDescLength = 1024
+)
+
+// CatalogInfo is a catalog playlist's curation metadata. A nil age is no
+// bound.
+type CatalogInfo struct {
+	MinAge      *uint8   `json:"min_age"`
+	MaxAge      *uint8   `json:"max_age"`
+	Topics      []string `json:"topics"`
+	Description string   `json:"description"`
+}
+
+// CatalogEntry is one row of GET /admin/catalog.
+type CatalogEntry struct {
+	Playlist
+	CatalogInfo
+	SubscriberCount int `json:"subscriber_count"`
+	VideoCount      int `json:"video_count"`
+	DisabledCount   int `json:"disabled_count"`
+}
+
+// normalizeCatalogInfo trims and lower-cases the topics, dropping blanks and
+// duplicates, and checks the fields' bounds.
+func normalizeCatalogInfo(info *CatalogInfo) error {
+	for _, age := range []*uint8{info.MinAge, info.MaxAge} {
+		if age != nil && *age > maxCatalogAge {
+			return fmt.Errorf("ages must be 0 to %d", maxCatalogAge)
+		}
+	}
+	if info.MinAge != nil && info.MaxAge != nil && *info.MinAge > *info.MaxAge {
+		return fmt.Errorf("min_age must not exceed max_age")
+	}
+	seen := map[string]bool{}
+	topics := []string{}
+	for _, t := range info.Topics {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if len(t) > maxCatalogTopicLen || strings.Contains(t, ",") {
+			return fmt.Errorf("topic %q must be at most %d characters, without commas", t, maxCatalogTopicLen)
+		}
+		seen[t] = true
+		topics = append(topics, t)
+	}
+	if len(topics) > maxCatalogTopics {
+		return fmt.Errorf("at most %d topics", maxCatalogTopics)
+	}
+	info.Topics = topics
+	info.Description = strings.TrimSpace(info.Description)
+	if len(info.Description) > maxCatalogDescLength {
+		return fmt.Errorf("description must be at most %d characters", maxCatalogDescLength)
+	}
+	return nil
+}
+
+// splitTopics parses catalog_playlists.topics.
+func splitTopics(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// catalogPlaylistID returns the catalog playlist with the given external ID.
+func (a *Api) catalogPlaylistID(externalID string) (uint32, bool, error) {
+	var id uint32
+	err := a.DB.QueryRow(`
+		SELECT p.id FROM playlists p
+		INNER JOIN catalog_playlists cp ON cp.playlist_id = p.id
+		WHERE p.you_tube_id = ?`, externalID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	return id, err == nil, err
+}
+
+// catalogInfos returns the curation metadata of every catalog playlist.
+func (a *Api) catalogInfos() (map[uint32]CatalogInfo, error) {
+	rows, err := a.DB.Query("SELECT playlist_id, min_age, max_age, topics, description FROM catalog_playlists")
+	if err != nil {
+		return nil, fmt.Errorf("query catalog_playlists: %w", err)
+	}
+	defer rows.Close()
+	out := map[uint32]CatalogInfo{}
+	for rows.Next() {
+		var id uint32
+		var info CatalogInfo
+		var minAge, maxAge sql.NullInt16
+		var topics string
+		if err := rows.Scan(&id, &minAge, &maxAge, &topics, &info.Description); err != nil {
+			return nil, fmt.Errorf("scan catalog_playlists: %w", err)
+		}
+		if minAge.Valid {
+			v := uint8(minAge.Int16)
+			info.MinAge = &v
+		}
+		if maxAge.Valid {
+			v := uint8(maxAge.Int16)
+			info.MaxAge = &v
+		}
+		info.Topics = splitTopics(topics)
+		out[id] = info
+	}
+	return out, rows.Err()
+}
+
+// catalogPlaylists returns the catalog playlists the user hasn't subscribed
+// to, for GET /playlists.
+func (a *Api) catalogPlaylists(userID uint32, infos map[uint32]CatalogInfo) ([]UserPlaylist, error) {
+	rows, err := a.DB.Query(`
+		SELECT p.id, p.you_tube_id, p.title, p.thumbnailurl, p.etag, p.provider
+		FROM playlists p
+		INNER JOIN catalog_playlists cp ON cp.playlist_id = p.id
+		WHERE NOT EXISTS (SELECT 1 FROM user_playlist up WHERE up.playlist_id = p.id AND up.user_id = ?)
+		ORDER BY p.title, p.id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query catalog: %w", err)
+	}
+	defer rows.Close()
+	var out []UserPlaylist
+	for rows.Next() {
+		var p UserPlaylist
+		if err := rows.Scan(&p.Id, &p.YouTubeId, &p.Title, &p.ThumbnailURL, &p.Etag, &p.Provider); err != nil {
+			return nil, fmt.Errorf("scan catalog: %w", err)
+		}
+		info := infos[p.Id]
+		p.Catalog = &info
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// adminListCatalog handles GET /admin/catalog: every catalog playlist with
+// its subscriber and video counts.
+func (a *Api) adminListCatalog(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	infos, err := a.catalogInfos()
+	if err != nil {
+		glog.Errorf("%s catalogInfos: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list catalog"))
+		return
+	}
+	rows, err := a.DB.Query(`
+		SELECT p.id, p.you_tube_id, p.title, p.thumbnailurl, p.etag, p.provider,
+		  (SELECT COUNT(*) FROM user_playlist up WHERE up.playlist_id = p.id),
+		  (SELECT COUNT(*) FROM playlist_video pv WHERE pv.playlist_id = p.id),
+		  (SELECT COUNT(*) FROM playlist_video pv INNER JOIN videos v ON v.id = pv.video_id
+		   WHERE pv.playlist_id = p.id AND v.disabled = 1)
+		FROM playlists p
+		INNER JOIN catalog_playlists cp ON cp.playlist_id = p.id
+		ORDER BY p.title, p.id`)
+	if err != nil {
+		glog.Errorf("%s list catalog: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list catalog"))
+		return
+	}
+	defer rows.Close()
+	list := []CatalogEntry{}
+	for rows.Next() {
+		var e CatalogEntry
+		if err := rows.Scan(&e.Id, &e.YouTubeId, &e.Title, &e.ThumbnailURL, &e.Etag, &e.Provider,
+			&e.SubscriberCount, &e.VideoCount, &e.DisabledCount); err != nil {
+			glog.Errorf("%s scan catalog: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not list catalog"))
+			return
+		}
+		e.CatalogInfo = infos[e.Id]
+		e.ThumbnailURL = a.signMediaURL(e.ThumbnailURL)
+		list = append(list, e)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Errorf("%s rows.Err: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list catalog"))
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// adminUpsertCatalog handles POST /admin/catalog: it adds a playlist to the
+// catalog, fetching it first if it isn't stored yet, or updates the curation
+// of one already there. The playlist is named as for POST /playlists.
+func (a *Api) adminUpsertCatalog(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user := GetUserFromContext(c)
+	var body struct {
+		playlistRef
+		CatalogInfo
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	if err := normalizeCatalogInfo(&body.CatalogInfo); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	playlistID, status, msg, err := a.resolvePlaylistRef(logPrefix, body.playlistRef)
+	if err != nil {
+		glog.Errorf("%s resolvePlaylistRef: %v", logPrefix, err)
+		c.JSON(status, common.GetError(msg))
+		return
+	}
+	info := body.CatalogInfo
+	infos, err := a.catalogInfos()
+	if err != nil {
+		glog.Errorf("%s catalogInfos: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not save catalog playlist"))
+		return
+	}
+	var before interface{}
+	if prev, ok := infos[playlistID]; ok {
+		before = prev
+	}
+	_, err = a.DB.Exec(`
+		INSERT INTO catalog_playlists (playlist_id, min_age, max_age, topics, description, curated_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE min_age=VALUES(min_age), max_age=VALUES(max_age), topics=VALUES(topics),
+		  description=VALUES(description), curated_by=VALUES(curated_by)`,
+		playlistID, info.MinAge, info.MaxAge, strings.Join(info.Topics, ","), info.Description, user.Id)
+	if err != nil {
+		glog.Errorf("%s upsert catalog_playlists: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not save catalog playlist"))
+		return
+	}
+	a.audit(logPrefix, user.Id, AUDIT_CATALOG_UPSERT, AUDIT_TARGET_PLAYLIST, strconv.FormatUint(uint64(playlistID), 10), before, info)
+	c.JSON(http.StatusOK, gin.H{"id": playlistID, "catalog": info})
+}
+
+// adminRemoveCatalog handles DELETE /admin/catalog/:playlist_id. Existing
+// subscriptions keep the playlist; it just stops being offered.
+func (a *Api) adminRemoveCatalog(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	var uri struct {
+		PlaylistID uint32 `uri:"playlist_id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid playlist_id"))
+		return
+	}
+	infos, err := a.catalogInfos()
+	if err != nil {
+		glog.Errorf("%s catalogInfos: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not remove catalog playlist"))
+		return
+	}
+	result, err := a.DB.Exec("DELETE FROM catalog_playlists WHERE playlist_id=?", uri.PlaylistID)
+	if err != nil {
+		glog.Errorf("%s delete catalog_playlists: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not remove catalog playlist"))
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, common.GetError("Playlist not in the catalog"))
+		return
+	}
+	a.audit(logPrefix, admin.Id, AUDIT_CATALOG_REMOVE, AUDIT_TARGET_PLAYLIST, strconv.FormatUint(uint64(uri.PlaylistID), 10), infos[uri.PlaylistID], nil)
+	c.Status(http.StatusNoContent)
+}
+
+// adminSetVideoDisabled handles POST /admin/videos/:id/disabled with
+// {"disabled": bool}. The flag is on the shared videos row, so it applies to
+// every playlist and user at once; each user whose current reward it is gets
+// another.
+func (a *Api) adminSetVideoDisabled(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	var uri struct {
+		VideoID uint32 `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid video id"))
+		return
+	}
+	var body struct {
+		Disabled *bool `json:"disabled"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.Disabled == nil {
+		c.JSON(http.StatusBadRequest, common.GetError("disabled is required"))
+		return
+	}
+	// The flag and its audit row change together, under a lock on the
+	// video, so concurrent toggles each audit the value they replaced.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		glog.Errorf("%s begin: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+		return
+	}
+	defer tx.Rollback()
+	var wasDisabled bool
+	if err := tx.QueryRow("SELECT disabled FROM videos WHERE id=? FOR UPDATE", uri.VideoID).Scan(&wasDisabled); err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, common.GetError("Video not found"))
+		return
+	} else if err != nil {
+		glog.Errorf("%s select videos.disabled: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+		return
+	}
+	if wasDisabled != *body.Disabled {
+		if _, err := tx.Exec("UPDATE videos SET disabled=? WHERE id=?", *body.Disabled, uri.VideoID); err != nil {
+			glog.Errorf("%s update videos.disabled: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+			return
+		}
+		if err := recordAudit(tx, admin.Id, AUDIT_VIDEO_DISABLED, AUDIT_TARGET_VIDEO, strconv.FormatUint(uint64(uri.VideoID), 10),
+			gin.H{"disabled": wasDisabled}, gin.H{"disabled": *body.Disabled}); err != nil {
+			glog.Errorf("%s audit video disabled: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		glog.Errorf("%s commit: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+		return
+	}
+	moved := 0
+	if *body.Disabled {
+		rows, err := a.DB.Query("SELECT user_id FROM gamestates WHERE video_id=?", uri.VideoID)
+		if err != nil {
+			glog.Errorf("%s query gamestates: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not replace the video"))
+			return
+		}
+		var userIDs []uint32
+		for rows.Next() {
+			var id uint32
+			if err := rows.Scan(&id); err == nil {
+				userIDs = append(userIDs, id)
+			}
+		}
+		rows.Close()
+		for _, userID := range userIDs {
+			if err := a.replaceCurrentVideo(logPrefix, c, userID, uri.VideoID); err != nil {
+				glog.Errorf("%s replaceCurrentVideo user=%d: %v", logPrefix, userID, err)
+				continue
+			}
+			moved++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"id": uri.VideoID, "disabled": *body.Disabled, "users_moved": moved})
+}
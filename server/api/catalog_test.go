@@ -0,0 +1,176 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/youtubefake"
+)
+
+func TestNormalizeCatalogInfo(t *testing.T) {
+	age := func(v uint8) *uint8 { return &v }
+	info := CatalogInfo{MinAge: age(4), MaxAge: age(8), Topics: []string{" Space ", "space", "", "Science"}, Description: " Rockets. "}
+	if err := normalizeCatalogInfo(&info); err != nil {
+		t.Fatalf("valid info: %v", err)
+	}
+	if fmt.Sprint(info.Topics) != "[space science]" || info.Description != "Rockets." {
+		t.Errorf("want topics trimmed, lower-cased and deduplicated, got %+v", info)
+	}
+	bad := []CatalogInfo{
+		{MinAge: age(9), MaxAge: age(8)},
+		{MaxAge: age(maxCatalogAge + 1)},
+		{Topics: []string{"a,b"}},
+		{Topics: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"}},
+	}
+	for _, b := range bad {
+		if err := normalizeCatalogInfo(&b); err == nil {
+			t.Errorf("%+v: want an error", b)
+		}
+	}
+}
+
+func catalogRequest(t *testing.T, r http.Handler, user *User, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	req, _ := http.NewRequest(method, fmt.Sprintf("/api/v1%s%stest_auth0_id=%s", path, sep, user.Auth0Id), bytes.NewBufferString(body))
+	r.ServeHTTP(resp, req)
+	return resp
+}
+
+func listUserPlaylists(t *testing.T, r http.Handler, user *User) []UserPlaylist {
+	t.Helper()
+	resp := catalogRequest(t, r, user, "GET", "/playlists", "")
+	var list []UserPlaylist
+	if err := json.Unmarshal(resp.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode playlists: %v (%s)", err, resp.Body.String())
+	}
+	return list
+}
+
+// TestCatalog_CurateAndSubscribe checks an admin-curated playlist is offered
+// to every user, and that subscribing to it costs no provider calls.
+func TestCatalog_CurateAndSubscribe(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	fake := useFakeYouTube(t, api)
+	admin := createTestUser(t, r, "auth0|catalog-admin", "catalogadmin@test.com", "catalogadmin")
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE id=?", RoleAdmin, admin.Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+	user := createTestUser(t, r, "auth0|catalog-user", "cataloguser@test.com", "cataloguser")
+
+	curate := fmt.Sprintf(`{"youtube_playlist_id": %q, "min_age": 4, "max_age": 8, "topics": ["Space", "science"], "description": "Rockets"}`,
+		youtubefake.ChangingPlaylist)
+	if resp := catalogRequest(t, r, user, "POST", "/admin/catalog", curate); resp.Code != http.StatusForbidden {
+		t.Errorf("student curating: want 403, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", "/admin/catalog", `{"youtube_playlist_id": "x", "min_age": 9, "max_age": 3}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("bad ages: want 400, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", "/admin/catalog", curate); resp.Code != http.StatusOK {
+		t.Fatalf("curate: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	list := listUserPlaylists(t, r, user)
+	if len(list) != 1 || list[0].Subscribed || list[0].Catalog == nil ||
+		fmt.Sprint(list[0].Catalog.Topics) != "[space science]" || *list[0].Catalog.MinAge != 4 {
+		t.Fatalf("want the catalog playlist offered, unsubscribed, with its curation, got %+v", list)
+	}
+
+	units := fake.Units()
+	body := fmt.Sprintf(`{"youtube_playlist_id": %q}`, youtubefake.ChangingPlaylist)
+	if resp := catalogRequest(t, r, user, "POST", "/playlists", body); resp.Code != http.StatusOK {
+		t.Fatalf("subscribe: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	if fake.Units() != units {
+		t.Errorf("subscribing to a catalog playlist: want no YouTube calls, got %d units", fake.Units()-units)
+	}
+	if titles := userVideoTitles(t, r, user.Auth0Id); len(titles) != 2 {
+		t.Errorf("want the catalog playlist's videos, got %v", titles)
+	}
+	list = listUserPlaylists(t, r, user)
+	if len(list) != 1 || !list[0].Subscribed || list[0].Catalog == nil {
+		t.Errorf("want the catalog playlist listed once, subscribed, got %+v", list)
+	}
+
+	resp := catalogRequest(t, r, admin, "GET", "/admin/catalog", "")
+	var entries []CatalogEntry
+	if err := json.Unmarshal(resp.Body.Bytes(), &entries); err != nil || len(entries) != 1 ||
+		entries[0].SubscriberCount != 1 || entries[0].VideoCount != 2 {
+		t.Errorf("admin list: want one entry with 1 subscriber and 2 videos, got %+v (%v)", entries, err)
+	}
+
+	if resp := catalogRequest(t, r, admin, "DELETE", fmt.Sprintf("/admin/catalog/%d", list[0].Id), ""); resp.Code != http.StatusNoContent {
+		t.Fatalf("uncurate: want 204, got %d", resp.Code)
+	}
+	if list := listUserPlaylists(t, r, user); len(list) != 1 || !list[0].Subscribed || list[0].Catalog != nil {
+		t.Errorf("uncurated: want the subscription kept without catalog info, got %+v", list)
+	}
+	if resp := catalogRequest(t, r, admin, "DELETE", fmt.Sprintf("/admin/catalog/%d", list[0].Id), ""); resp.Code != http.StatusNotFound {
+		t.Errorf("uncurate twice: want 404, got %d", resp.Code)
+	}
+}
+
+// TestAdminSetVideoDisabled checks disabling a video moves every child whose
+// current reward it is onto another.
+func TestAdminSetVideoDisabled(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	admin := createTestUser(t, r, "auth0|disable-admin", "disableadmin@test.com", "disableadmin")
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE id=?", RoleAdmin, admin.Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+	user := createTestUser(t, r, "auth0|disable-user", "disableuser@test.com", "disableuser")
+	ids := insertVideosAndUserHasVideo(t, api, user.Id, 3)
+	var gs Gamestate
+	fetchGamestate(t, r, user, &gs)
+	if _, err := api.DB.Exec("UPDATE gamestates SET video_id=? WHERE user_id=?", ids[0], user.Id); err != nil {
+		t.Fatalf("set current video: %v", err)
+	}
+
+	resp := catalogRequest(t, r, admin, "POST", fmt.Sprintf("/admin/videos/%d/disabled", ids[0]), `{"disabled": true}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("disable: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	var out struct {
+		UsersMoved int `json:"users_moved"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &out); err != nil || out.UsersMoved != 1 {
+		t.Errorf("want one user moved, got %s", resp.Body.String())
+	}
+	fetchGamestate(t, r, user, &gs)
+	if gs.VideoId == ids[0] {
+		t.Errorf("want the disabled video replaced as the current reward")
+	}
+	if n, _ := api.countEnabledVideosForUser(user.Id); n != 2 {
+		t.Errorf("want 2 enabled videos left, got %d", n)
+	}
+
+	if resp := catalogRequest(t, r, admin, "POST", fmt.Sprintf("/admin/videos/%d/disabled", ids[0]), `{"disabled": false}`); resp.Code != http.StatusOK {
+		t.Errorf("enable: want 200, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", "/admin/videos/999999/disabled", `{"disabled": true}`); resp.Code != http.StatusNotFound {
+		t.Errorf("unknown video: want 404, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", fmt.Sprintf("/admin/videos/%d/disabled", ids[0]), `{}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("no flag: want 400, got %d", resp.Code)
+	}
+}
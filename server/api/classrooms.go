@@ -0,0 +1,949 @@
+// classrooms.go: teacher classrooms and their rosters.
+//
+// A teacher (users.role = RoleTeacher) creates classrooms under
+// /api/v1/teacher; a family account joins one with its invite code
+// (POST /classrooms/:user_id/join) and is then on that roster. The teacher
+// can set a class-wide envelope and difficulty floor, which every rostered
+// student's settings follow (classroomPolicy), read the roster's aggregated
+// statistics, and push an assignment to every student on it (assignments.go).
+// RequireTeacher admits a caller to the teacher surface; requireOwnClassroom
+// and requireRosterStudent then scope each request to the caller's own
+// classroom and its students, answering 404 for anyone else's so a teacher
+// can't probe for other rosters. Documented in docs/accounts.md
+// "Classrooms".
+package api
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+const (
+	// Invite codes skip 0/O and 1/I so they survive being read aloud.
+	inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	inviteCodeLength   = 8
+	maxClassroomName   = 128
+	// maxRosterSize bounds a roster, and with it the per-student work the
+	// statistics and push endpoints do in one request.
+	maxRosterSize = 100
+	// classroomPolicyTTL is how long the difficulty adjuster trusts a cached
+	// classroom policy. This process forgets a student's entry when their
+	// membership or class policy changes; the TTL bounds how long another
+	// process's change takes to reach it.
+	classroomPolicyTTL = time.Minute
+	// classStatsRecent is the window of a student's recent attempts in the
+	// class statistics; the class topic figures cover the attempts since
+	// topicWindowStart, the only ones read.
+	classStatsRecent = 7 * 24 * time.Hour
+	classroomKey     = "classroom"
+)
+
+// Classroom is a teacher's classroom and, when read on its own, its roster.
+type Classroom struct {
+	Id                uint32          `json:"id"`
+	TeacherId         uint32          `json:"teacher_id"`
+	Name              string          `json:"name"`
+	InviteCode        string          `json:"invite_code"`
+	ProblemTypeBitmap uint64          `json:"problem_type_bitmap"` // 0: no class envelope
+	DifficultyFloor   float64         `json:"difficulty_floor"`    // 0: no floor
+	CreatedAt         time.Time       `json:"created_at"`
+	Students          []RosterStudent `json:"students,omitempty"`
+}
+
+// RosterStudent is one student on a roster with the settings the class
+// policy governs.
+type RosterStudent struct {
+	UserId            uint32    `json:"user_id"`
+	Username          string    `json:"username"`
+	JoinedAt          time.Time `json:"joined_at"`
+	ProblemTypeBitmap uint64    `json:"problem_type_bitmap"`
+	TargetDifficulty  float64   `json:"target_difficulty"`
+}
+
+// ClassroomRequest is the body of a classroom create or update.
+type ClassroomRequest struct {
+	Name              string  `json:"name"`
+	ProblemTypeBitmap uint64  `json:"problem_type_bitmap"`
+	DifficultyFloor   float64 `json:"difficulty_floor"`
+}
+
+// ClassroomMembership is the family side of a roster entry.
+type ClassroomMembership struct {
+	ClassroomId       uint32    `json:"classroom_id"`
+	Name              string    `json:"name"`
+	Teacher           string    `json:"teacher"`
+	ProblemTypeBitmap uint64    `json:"problem_type_bitmap"`
+	DifficultyFloor   float64   `json:"difficulty_floor"`
+	JoinedAt          time.Time `json:"joined_at"`
+}
+
+// ClassroomStudentStats is one student's row of the class statistics.
+type ClassroomStudentStats struct {
+	UserId                uint32     `json:"user_id"`
+	Username              string     `json:"username"`
+	TargetDifficulty      float64    `json:"target_difficulty"`
+	ProblemsSolved        int64      `json:"problems_solved"`
+	WorkMinutes           int64      `json:"work_minutes"`
+	RecentAttempts        int64      `json:"recent_attempts"` // in the last classStatsRecent
+	RecentFirstTryCorrect int64      `json:"recent_first_try_correct"`
+	LastActiveAt          *time.Time `json:"last_active_at"` // last attempt since topicWindowStart
+	OpenAssignments       int        `json:"open_assignments"`
+	OverdueAssignments    int        `json:"overdue_assignments"`
+}
+
+// ClassroomStatistics is the GET /teacher/classrooms/:classroom_id/statistics
+// payload: per-student rows, their sums, and the roster's topics.
+type ClassroomStatistics struct {
+	ClassroomId           uint32                  `json:"classroom_id"`
+	Students              []ClassroomStudentStats `json:"students"`
+	ProblemsSolved        int64                   `json:"problems_solved"`
+	WorkMinutes           int64                   `json:"work_minutes"`
+	RecentAttempts        int64                   `json:"recent_attempts"`
+	RecentFirstTryCorrect int64                   `json:"recent_first_try_correct"`
+	Topics                []TopicStats            `json:"topics"`
+}
+
+// PushedAssignment is one student's outcome of a pushed assignment.
+type PushedAssignment struct {
+	UserId       uint32 `json:"user_id"`
+	AssignmentId uint32 `json:"assignment_id,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// classroomPolicy is the part of a classroom its students' settings follow.
+type classroomPolicy struct {
+	bitmap uint64  // replaces the family's envelope when non-zero
+	floor  float64 // target_difficulty never goes below it
+}
+
+// apply brings s into line with the policy and reports whether it changed.
+// The floor gives way to the envelope's ceiling, as every difficulty lever
+// does (MaxDiffForBitmap).
+func (p *classroomPolicy) apply(s *Settings) bool {
+	changed := false
+	if p.bitmap != 0 && s.ProblemTypeBitmap != p.bitmap {
+		s.ProblemTypeBitmap = p.bitmap
+		changed = true
+	}
+	ceiling := mathcore.MaxDiffForBitmap(s.ProblemTypeBitmap)
+	target := math.Min(math.Max(s.TargetDifficulty, math.Min(p.floor, ceiling)), ceiling)
+	if target != s.TargetDifficulty {
+		s.TargetDifficulty = target
+		changed = true
+	}
+	return changed
+}
+
+// validateClassroomRequest checks a create or update body.
+func validateClassroomRequest(req *ClassroomRequest) error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxClassroomName {
+		return fmt.Errorf("Invalid name (must be 1-%d characters)", maxClassroomName)
+	}
+	if req.ProblemTypeBitmap&^uint64(mathcore.ALL_PROBLEM_TYPES) != 0 {
+		return fmt.Errorf("Invalid problem_type_bitmap: %d (must be 0-%d)", req.ProblemTypeBitmap, uint64(mathcore.ALL_PROBLEM_TYPES))
+	}
+	envelope := req.ProblemTypeBitmap
+	if envelope == 0 {
+		envelope = uint64(mathcore.ALL_PROBLEM_TYPES)
+	}
+	if ceiling := mathcore.MaxDiffForBitmap(envelope); req.DifficultyFloor != 0 &&
+		(req.DifficultyFloor < mathcore.MinTargetDifficulty || req.DifficultyFloor > ceiling) {
+		return fmt.Errorf("Invalid difficulty_floor: %v (must be 0 or %.0f-%.1f)", req.DifficultyFloor, mathcore.MinTargetDifficulty, ceiling)
+	}
+	return nil
+}
+
+// newInviteCode returns a random inviteCodeLength code.
+func newInviteCode() (string, error) {
+	b := make([]byte, inviteCodeLength)
+	for i := range b {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(inviteCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b[i] = inviteCodeAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
+
+// withInviteCode runs write with fresh codes until one isn't taken.
+func withInviteCode(write func(code string) error) (string, error) {
+	for i := 0; i < 3; i++ {
+		code, err := newInviteCode()
+		if err != nil {
+			return "", err
+		}
+		err = write(code)
+		if err == nil {
+			return code, nil
+		}
+		if !strings.Contains(err.Error(), "Duplicate entry") {
+			return "", err
+		}
+	}
+	return "", errors.New("no free invite code after 3 tries")
+}
+
+const classroomColumns = "id, teacher_id, name, invite_code, problem_type_bitmap, difficulty_floor, created_at"
+
+func scanClassroom(row interface{ Scan(...interface{}) error }) (*Classroom, error) {
+	cl := &Classroom{}
+	if err := row.Scan(&cl.Id, &cl.TeacherId, &cl.Name, &cl.InviteCode, &cl.ProblemTypeBitmap, &cl.DifficultyFloor, &cl.CreatedAt); err != nil {
+		return nil, err
+	}
+	return cl, nil
+}
+
+// loadClassroomPolicy returns the policy of the classroom userID is on, or
+// nil when they are on none or it sets nothing.
+func (a *Api) loadClassroomPolicy(userID uint32) (*classroomPolicy, error) {
+	p := &classroomPolicy{}
+	err := a.DB.QueryRow(`
+		SELECT c.problem_type_bitmap, c.difficulty_floor
+		FROM classroom_members m JOIN classrooms c ON c.id = m.classroom_id
+		WHERE m.user_id = ?`, userID).Scan(&p.bitmap, &p.floor)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("load classroom policy: %w", err)
+	}
+	if p.bitmap == 0 && p.floor == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// policyCache holds the classroom policy each recently active student
+// follows (nil for none), for the difficulty adjuster, which reads it on
+// every DONE_WATCHING_VIDEO.
+type policyCache struct {
+	mu      sync.Mutex
+	entries map[uint32]policyCacheEntry
+	swept   time.Time
+}
+
+type policyCacheEntry struct {
+	policy *classroomPolicy
+	until  time.Time
+}
+
+// get returns userID's cached policy and whether there is one.
+func (pc *policyCache) get(userID uint32, now time.Time) (*classroomPolicy, bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	e, ok := pc.entries[userID]
+	if !ok || !now.Before(e.until) {
+		return nil, false
+	}
+	return e.policy, true
+}
+
+// put caches userID's policy for classroomPolicyTTL, dropping expired
+// entries at most once a TTL.
+func (pc *policyCache) put(userID uint32, policy *classroomPolicy, now time.Time) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.entries == nil {
+		pc.entries = map[uint32]policyCacheEntry{}
+	}
+	if now.Sub(pc.swept) >= classroomPolicyTTL {
+		for id, e := range pc.entries {
+			if !now.Before(e.until) {
+				delete(pc.entries, id)
+			}
+		}
+		pc.swept = now
+	}
+	pc.entries[userID] = policyCacheEntry{policy: policy, until: now.Add(classroomPolicyTTL)}
+}
+
+// forget drops the named students' entries, after their membership or their
+// class's policy changed.
+func (pc *policyCache) forget(userIDs ...uint32) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	for _, id := range userIDs {
+		delete(pc.entries, id)
+	}
+}
+
+// cachedClassroomPolicy is loadClassroomPolicy through a.classroomPolicies.
+func (a *Api) cachedClassroomPolicy(userID uint32) (*classroomPolicy, error) {
+	now := time.Now()
+	if p, ok := a.classroomPolicies.get(userID, now); ok {
+		return p, nil
+	}
+	p, err := a.loadClassroomPolicy(userID)
+	if err != nil {
+		return nil, err
+	}
+	a.classroomPolicies.put(userID, p, now)
+	return p, nil
+}
+
+// enforceClassroomPolicy brings a student's stored settings into line with
+// policy, logging the SET_* events a settings change logs so the event log
+// (and replay) stays the source of truth.
+func (a *Api) enforceClassroomPolicy(logPrefix string, userID uint32, policy *classroomPolicy) error {
+	if policy == nil {
+		return nil
+	}
+	settings, _, msg, err := a.settingsManager.Get(userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	before := *settings
+	if !policy.apply(settings) {
+		return nil
+	}
+	if _, msg, err := a.settingsManager.Update(settings); err != nil {
+		return fmt.Errorf("%s: %w", msg, err)
+	}
+	var events []*Event
+	if settings.ProblemTypeBitmap != before.ProblemTypeBitmap {
+		events = append(events, &Event{EventType: SET_PROBLEM_TYPE_BITMAP, Value: strconv.FormatUint(settings.ProblemTypeBitmap, 10)})
+		a.enqueueGeneration(logPrefix, settings)
+	}
+	if settings.TargetDifficulty != before.TargetDifficulty {
+		events = append(events, &Event{EventType: SET_TARGET_DIFFICULTY, Value: strconv.FormatFloat(settings.TargetDifficulty, 'E', -1, 64)})
+	}
+	glog.Infof("%s classroom policy applied to user %d: bitmap %d -> %d, difficulty %.2f -> %.2f", logPrefix, userID,
+		before.ProblemTypeBitmap, settings.ProblemTypeBitmap, before.TargetDifficulty, settings.TargetDifficulty)
+	return a.createEventsBatch(userID, events)
+}
+
+// rosterIds returns the user ids on a classroom's roster.
+func (a *Api) rosterIds(classroomID uint32) ([]uint32, error) {
+	rows, err := a.DB.Query("SELECT user_id FROM classroom_members WHERE classroom_id = ? ORDER BY user_id", classroomID)
+	if err != nil {
+		return nil, fmt.Errorf("query roster: %w", err)
+	}
+	defer rows.Close()
+	ids := []uint32{}
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// loadRoster returns a classroom's students by username.
+func (a *Api) loadRoster(classroomID uint32) ([]RosterStudent, error) {
+	rows, err := a.DB.Query(`
+		SELECT m.user_id, u.username, m.joined_at, COALESCE(s.problem_type_bitmap, 0), COALESCE(s.target_difficulty, 0)
+		FROM classroom_members m
+		JOIN users u ON u.id = m.user_id
+		LEFT JOIN settings s ON s.user_id = m.user_id
+		WHERE m.classroom_id = ?
+		ORDER BY u.username, m.user_id`, classroomID)
+	if err != nil {
+		return nil, fmt.Errorf("query roster: %w", err)
+	}
+	defer rows.Close()
+	out := []RosterStudent{}
+	for rows.Next() {
+		var st RosterStudent
+		if err := rows.Scan(&st.UserId, &st.Username, &st.JoinedAt, &st.ProblemTypeBitmap, &st.TargetDifficulty); err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
+// staleRosterIds returns the students of a classroom whose statistics cache
+// is missing or behind their events.
+func (a *Api) staleRosterIds(classroomID uint32) ([]uint32, error) {
+	rows, err := a.DB.Query(`
+		SELECT m.user_id
+		FROM classroom_members m
+		LEFT JOIN statistics_cache_meta c ON c.user_id = m.user_id
+		WHERE m.classroom_id = ?
+		  AND (c.user_id IS NULL OR EXISTS (SELECT 1 FROM events e WHERE e.user_id = m.user_id AND e.id > c.last_event_id))`,
+		classroomID)
+	if err != nil {
+		return nil, fmt.Errorf("query stale roster: %w", err)
+	}
+	defer rows.Close()
+	var ids []uint32
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ClassroomStatistics aggregates a roster's cached statistics. Students whose
+// cache is behind are refreshed first, as GET /statistics does; one that
+// fails is logged and shown as last cached.
+func (a *Api) ClassroomStatistics(logPrefix string, classroomID uint32, now time.Time) (*ClassroomStatistics, error) {
+	stale, err := a.staleRosterIds(classroomID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range stale {
+		if err := a.UpdateStatisticsForUser(logPrefix, id); err != nil {
+			glog.Warningf("%s class statistics: refresh user %d: %v", logPrefix, id, err)
+		}
+	}
+	out := &ClassroomStatistics{ClassroomId: classroomID, Students: []ClassroomStudentStats{}, Topics: []TopicStats{}}
+	rows, err := a.DB.Query(`
+		SELECT m.user_id, u.username, COALESCE(s.target_difficulty, 0),
+		  COALESCE(t.total_problems_solved, 0), COALESCE(t.total_work_minutes, 0),
+		  (SELECT COUNT(*) FROM assignments x WHERE x.user_id = m.user_id AND x.classroom_id = m.classroom_id AND x.completed_at IS NULL),
+		  (SELECT COUNT(*) FROM assignments x WHERE x.user_id = m.user_id AND x.classroom_id = m.classroom_id AND x.completed_at IS NULL AND x.due_at < ?)
+		FROM classroom_members m
+		JOIN users u ON u.id = m.user_id
+		LEFT JOIN settings s ON s.user_id = m.user_id
+		LEFT JOIN statistics_totals t ON t.user_id = m.user_id
+		WHERE m.classroom_id = ?
+		ORDER BY u.username, m.user_id`, now, classroomID)
+	if err != nil {
+		return nil, fmt.Errorf("query class statistics: %w", err)
+	}
+	defer rows.Close()
+	byUser := map[uint32]int{}
+	for rows.Next() {
+		var st ClassroomStudentStats
+		if err := rows.Scan(&st.UserId, &st.Username, &st.TargetDifficulty, &st.ProblemsSolved, &st.WorkMinutes,
+			&st.OpenAssignments, &st.OverdueAssignments); err != nil {
+			return nil, fmt.Errorf("scan class statistics: %w", err)
+		}
+		byUser[st.UserId] = len(out.Students)
+		out.Students = append(out.Students, st)
+		out.ProblemsSolved += st.ProblemsSolved
+		out.WorkMinutes += st.WorkMinutes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attemptRows, err := a.DB.Query(`
+		SELECT a.user_id, a.ended_at, a.problem_type_bitmap, a.difficulty, a.answers, a.solved, a.solve_ms
+		FROM statistics_topic_attempts a
+		JOIN classroom_members m ON m.user_id = a.user_id
+		WHERE m.classroom_id = ? AND a.ended_at >= ?`,
+		classroomID, topicWindowStart(now))
+	if err != nil {
+		return nil, fmt.Errorf("query class attempts: %w", err)
+	}
+	defer attemptRows.Close()
+	var attempts []topicAttempt
+	recent := now.Add(-classStatsRecent)
+	for attemptRows.Next() {
+		var userID uint32
+		var t topicAttempt
+		if err := attemptRows.Scan(&userID, &t.endedAt, &t.problemTypeBitmap, &t.difficulty, &t.answers, &t.solved, &t.solveMs); err != nil {
+			return nil, fmt.Errorf("scan class attempts: %w", err)
+		}
+		attempts = append(attempts, t)
+		i, ok := byUser[userID]
+		if !ok {
+			continue
+		}
+		st := &out.Students[i]
+		if st.LastActiveAt == nil || t.endedAt.After(*st.LastActiveAt) {
+			ended := t.endedAt
+			st.LastActiveAt = &ended
+		}
+		if t.endedAt.After(recent) {
+			st.RecentAttempts++
+			out.RecentAttempts++
+			if t.firstTry() {
+				st.RecentFirstTryCorrect++
+				out.RecentFirstTryCorrect++
+			}
+		}
+	}
+	if err := attemptRows.Err(); err != nil {
+		return nil, err
+	}
+	out.Topics = aggregateTopicStats(attempts, now)
+	return out, nil
+}
+
+// requireOwnClassroom loads :classroom_id for the handlers after it, and
+// answers 404 unless the caller is its teacher.
+func (a *Api) requireOwnClassroom() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logPrefix := common.GetLogPrefix(c)
+		user := GetUserFromContext(c)
+		id, err := strconv.ParseUint(c.Param("classroom_id"), 10, 32)
+		if err != nil || id == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("Invalid classroom_id: %s", c.Param("classroom_id"))))
+			return
+		}
+		cl, err := scanClassroom(a.DB.QueryRow("SELECT "+classroomColumns+" FROM classrooms WHERE id = ?", id))
+		if err == sql.ErrNoRows || (err == nil && cl.TeacherId != user.Id) {
+			c.AbortWithStatusJSON(http.StatusNotFound, common.GetError("Classroom not found"))
+			return
+		} else if err != nil {
+			glog.Errorf("%s load classroom %d: %v", logPrefix, id, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, common.GetError("Could not get classroom"))
+			return
+		}
+		c.Set(classroomKey, cl)
+		c.Next()
+	}
+}
+
+// requireRosterStudent answers 404 unless :student_id is on the roster of the
+// classroom requireOwnClassroom loaded.
+func (a *Api) requireRosterStudent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logPrefix := common.GetLogPrefix(c)
+		cl := classroomFromContext(c)
+		id, err := strconv.ParseUint(c.Param("student_id"), 10, 32)
+		if err != nil || id == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("Invalid student_id: %s", c.Param("student_id"))))
+			return
+		}
+		var n int
+		if err := a.DB.QueryRow("SELECT COUNT(*) FROM classroom_members WHERE classroom_id = ? AND user_id = ?", cl.Id, id).Scan(&n); err != nil {
+			glog.Errorf("%s check roster: %v", logPrefix, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, common.GetError("Could not check roster"))
+			return
+		}
+		if n == 0 {
+			c.AbortWithStatusJSON(http.StatusNotFound, common.GetError("Student not found"))
+			return
+		}
+		c.Next()
+	}
+}
+
+func classroomFromContext(c *gin.Context) *Classroom {
+	return c.MustGet(classroomKey).(*Classroom)
+}
+
+// teacherListClassrooms handles GET /teacher/classrooms.
+func (a *Api) teacherListClassrooms(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user := GetUserFromContext(c)
+	rows, err := a.DB.Query("SELECT "+classroomColumns+" FROM classrooms WHERE teacher_id = ? ORDER BY id", user.Id)
+	if err != nil {
+		glog.Errorf("%s list classrooms: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list classrooms"))
+		return
+	}
+	defer rows.Close()
+	out := []Classroom{}
+	for rows.Next() {
+		cl, err := scanClassroom(rows)
+		if err != nil {
+			glog.Errorf("%s scan classroom: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not list classrooms"))
+			return
+		}
+		out = append(out, *cl)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// teacherCreateClassroom handles POST /teacher/classrooms.
+func (a *Api) teacherCreateClassroom(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user := GetUserFromContext(c)
+	var req ClassroomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	if err := validateClassroomRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	var id int64
+	_, err := withInviteCode(func(code string) error {
+		res, err := a.DB.Exec(
+			"INSERT INTO classrooms (teacher_id, name, invite_code, problem_type_bitmap, difficulty_floor) VALUES (?, ?, ?, ?, ?)",
+			user.Id, req.Name, code, req.ProblemTypeBitmap, req.DifficultyFloor)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		glog.Errorf("%s create classroom: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not create classroom"))
+		return
+	}
+	glog.Infof("%s teacher %d created classroom %d", logPrefix, user.Id, id)
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
+
+// teacherGetClassroom handles GET /teacher/classrooms/:classroom_id.
+func (a *Api) teacherGetClassroom(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	students, err := a.loadRoster(cl.Id)
+	if err != nil {
+		glog.Errorf("%s loadRoster: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not get classroom"))
+		return
+	}
+	cl.Students = students
+	c.JSON(http.StatusOK, cl)
+}
+
+// teacherUpdateClassroom handles POST /teacher/classrooms/:classroom_id,
+// replacing the name and policy and applying the policy to the roster.
+func (a *Api) teacherUpdateClassroom(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	var req ClassroomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	if err := validateClassroomRequest(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	if _, err := a.DB.Exec("UPDATE classrooms SET name = ?, problem_type_bitmap = ?, difficulty_floor = ? WHERE id = ?",
+		req.Name, req.ProblemTypeBitmap, req.DifficultyFloor, cl.Id); err != nil {
+		glog.Errorf("%s update classroom %d: %v", logPrefix, cl.Id, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update classroom"))
+		return
+	}
+	ids, err := a.rosterIds(cl.Id)
+	if err != nil {
+		glog.Errorf("%s rosterIds: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not apply the class settings"))
+		return
+	}
+	a.classroomPolicies.forget(ids...)
+	policy := &classroomPolicy{bitmap: req.ProblemTypeBitmap, floor: req.DifficultyFloor}
+	for _, id := range ids {
+		if err := a.enforceClassroomPolicy(logPrefix, id, policy); err != nil {
+			glog.Errorf("%s enforce classroom policy for user %d: %v", logPrefix, id, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not apply the class settings"))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"id": cl.Id})
+}
+
+// teacherDeleteClassroom handles DELETE /teacher/classrooms/:classroom_id.
+// The roster goes with it; assignments already pushed stay with the
+// students.
+func (a *Api) teacherDeleteClassroom(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	ids, err := a.rosterIds(cl.Id)
+	if err != nil {
+		glog.Errorf("%s rosterIds: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not delete classroom"))
+		return
+	}
+	if _, err := a.DB.Exec("DELETE FROM classrooms WHERE id = ?", cl.Id); err != nil {
+		glog.Errorf("%s delete classroom %d: %v", logPrefix, cl.Id, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not delete classroom"))
+		return
+	}
+	a.classroomPolicies.forget(ids...)
+	c.JSON(http.StatusOK, gin.H{"id": cl.Id})
+}
+
+// teacherRotateInviteCode handles POST
+// /teacher/classrooms/:classroom_id/invite-code: the old code stops working,
+// the roster stays.
+func (a *Api) teacherRotateInviteCode(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	code, err := withInviteCode(func(code string) error {
+		_, err := a.DB.Exec("UPDATE classrooms SET invite_code = ? WHERE id = ?", code, cl.Id)
+		return err
+	})
+	if err != nil {
+		glog.Errorf("%s rotate invite code for classroom %d: %v", logPrefix, cl.Id, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not rotate invite code"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invite_code": code})
+}
+
+// teacherClassroomStatistics handles GET
+// /teacher/classrooms/:classroom_id/statistics.
+func (a *Api) teacherClassroomStatistics(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	stats, err := a.ClassroomStatistics(logPrefix, cl.Id, time.Now())
+	if err != nil {
+		glog.Errorf("%s ClassroomStatistics: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not get class statistics"))
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// teacherPushAssignment handles POST
+// /teacher/classrooms/:classroom_id/assignments: the body is an assignment
+// (as POST /assignments/:user_id takes) created for every student on the
+// roster. A spec draws separately per student. Per-student failures are
+// reported alongside the successes; a request no student could take is a
+// 400 with the first error.
+func (a *Api) teacherPushAssignment(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	var req AssignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	req.ClassroomId = cl.Id
+	ids, err := a.rosterIds(cl.Id)
+	if err != nil {
+		glog.Errorf("%s rosterIds: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not push assignment"))
+		return
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusBadRequest, common.GetError("No students on the roster"))
+		return
+	}
+	out := []PushedAssignment{}
+	var firstInputErr error
+	created := 0
+	for _, id := range ids {
+		studentReq := req
+		s, err := a.CreateAssignment(logPrefix, id, &studentReq)
+		switch {
+		case errors.Is(err, errAssignmentInput):
+			if firstInputErr == nil {
+				firstInputErr = err
+			}
+			out = append(out, PushedAssignment{UserId: id, Error: err.Error()})
+		case err != nil:
+			glog.Errorf("%s push assignment to user %d: %v", logPrefix, id, err)
+			out = append(out, PushedAssignment{UserId: id, Error: "Could not create assignment"})
+		default:
+			created++
+			out = append(out, PushedAssignment{UserId: id, AssignmentId: s.Id})
+		}
+	}
+	if created == 0 && firstInputErr != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(firstInputErr.Error()))
+		return
+	}
+	glog.Infof("%s classroom %d: pushed assignment to %d of %d students", logPrefix, cl.Id, created, len(ids))
+	c.JSON(http.StatusOK, gin.H{"assignments": out})
+}
+
+// teacherStudentAssignments handles GET
+// /teacher/classrooms/:classroom_id/students/:student_id/assignments: the
+// report of the assignments this classroom pushed to the student.
+func (a *Api) teacherStudentAssignments(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	studentID, _ := strconv.ParseUint(c.Param("student_id"), 10, 32)
+	all, err := a.listAssignments(uint32(studentID), false)
+	if err != nil {
+		glog.Errorf("%s listAssignments: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list assignments"))
+		return
+	}
+	out := []Assignment{}
+	for _, s := range all {
+		if s.ClassroomId != nil && *s.ClassroomId == cl.Id {
+			out = append(out, s)
+		}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// teacherRemoveStudent handles DELETE
+// /teacher/classrooms/:classroom_id/students/:student_id. The student's
+// settings keep their last values; the class policy just stops applying.
+func (a *Api) teacherRemoveStudent(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cl := classroomFromContext(c)
+	studentID, _ := strconv.ParseUint(c.Param("student_id"), 10, 32)
+	if _, err := a.DB.Exec("DELETE FROM classroom_members WHERE classroom_id = ? AND user_id = ?", cl.Id, studentID); err != nil {
+		glog.Errorf("%s remove student %d: %v", logPrefix, studentID, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not remove student"))
+		return
+	}
+	a.classroomPolicies.forget(uint32(studentID))
+	c.JSON(http.StatusOK, gin.H{"user_id": studentID})
+}
+
+// loadMembership returns the classroom userID is on, or nil.
+func (a *Api) loadMembership(userID uint32) (*ClassroomMembership, error) {
+	m := &ClassroomMembership{}
+	err := a.DB.QueryRow(`
+		SELECT c.id, c.name, u.username, c.problem_type_bitmap, c.difficulty_floor, m.joined_at
+		FROM classroom_members m
+		JOIN classrooms c ON c.id = m.classroom_id
+		JOIN users u ON u.id = c.teacher_id
+		WHERE m.user_id = ?`, userID).Scan(&m.ClassroomId, &m.Name, &m.Teacher, &m.ProblemTypeBitmap, &m.DifficultyFloor, &m.JoinedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return m, err
+}
+
+// classroomUser binds :user_id and checks it is the caller, as the other
+// family-scoped routes do.
+func classroomUser(logPrefix string, c *gin.Context) (*User, bool) {
+	return assignmentUser(logPrefix, c)
+}
+
+// customGetClassroom handles GET /classrooms/:user_id: the caller's
+// classroom, or null.
+func (a *Api) customGetClassroom(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user, ok := classroomUser(logPrefix, c)
+	if !ok {
+		return
+	}
+	m, err := a.loadMembership(user.Id)
+	if err != nil {
+		glog.Errorf("%s loadMembership: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not get classroom"))
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// joinRoster adds a student to a classroom's roster unless it already has
+// maxRosterSize students, reporting whether they joined. The classroom row
+// is locked around the count and the insert, so concurrent joins can't
+// overfill it; sql.ErrNoRows means the classroom is gone.
+func (a *Api) joinRoster(classroomID, userID uint32) (bool, error) {
+	tx, err := a.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var id uint32
+	if err := tx.QueryRow("SELECT id FROM classrooms WHERE id = ? FOR UPDATE", classroomID).Scan(&id); err != nil {
+		return false, err
+	}
+	var n int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM classroom_members WHERE classroom_id = ?", classroomID).Scan(&n); err != nil {
+		return false, fmt.Errorf("count roster: %w", err)
+	}
+	if n >= maxRosterSize {
+		return false, nil
+	}
+	if _, err := tx.Exec("INSERT INTO classroom_members (classroom_id, user_id) VALUES (?, ?)", classroomID, userID); err != nil {
+		return false, fmt.Errorf("insert member: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// customJoinClassroom handles POST /classrooms/:user_id/join
+// {"invite_code": ...}, putting the caller on the code's roster and their
+// settings under its policy.
+func (a *Api) customJoinClassroom(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user, ok := classroomUser(logPrefix, c)
+	if !ok {
+		return
+	}
+	var body struct {
+		InviteCode string `json:"invite_code"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	code := strings.ToUpper(strings.TrimSpace(body.InviteCode))
+	cl, err := scanClassroom(a.DB.QueryRow("SELECT "+classroomColumns+" FROM classrooms WHERE invite_code = ?", code))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, common.GetError("Unknown invite code"))
+		return
+	} else if err != nil {
+		glog.Errorf("%s look up invite code: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not join classroom"))
+		return
+	}
+	if cl.TeacherId == user.Id {
+		c.JSON(http.StatusBadRequest, common.GetError("You can't join your own classroom"))
+		return
+	}
+	current, err := a.loadMembership(user.Id)
+	if err != nil {
+		glog.Errorf("%s loadMembership: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not join classroom"))
+		return
+	}
+	if current != nil && current.ClassroomId != cl.Id {
+		c.JSON(http.StatusConflict, common.GetError("Already in a classroom; leave it first"))
+		return
+	}
+	if current == nil {
+		joined, err := a.joinRoster(cl.Id, user.Id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, common.GetError("Unknown invite code"))
+			return
+		} else if err != nil {
+			glog.Errorf("%s join classroom %d: %v", logPrefix, cl.Id, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not join classroom"))
+			return
+		}
+		if !joined {
+			c.JSON(http.StatusConflict, common.GetError("That classroom is full"))
+			return
+		}
+		a.classroomPolicies.forget(user.Id)
+		glog.Infof("%s user %d joined classroom %d", logPrefix, user.Id, cl.Id)
+	}
+	policy, err := a.loadClassroomPolicy(user.Id)
+	if err == nil {
+		err = a.enforceClassroomPolicy(logPrefix, user.Id, policy)
+	}
+	if err != nil {
+		glog.Errorf("%s apply classroom policy: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not apply the class settings"))
+		return
+	}
+	m, err := a.loadMembership(user.Id)
+	if err != nil {
+		glog.Errorf("%s loadMembership: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not join classroom"))
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// customLeaveClassroom handles DELETE /classrooms/:user_id. Settings keep
+// their last values.
+func (a *Api) customLeaveClassroom(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user, ok := classroomUser(logPrefix, c)
+	if !ok {
+		return
+	}
+	if _, err := a.DB.Exec("DELETE FROM classroom_members WHERE user_id = ?", user.Id); err != nil {
+		glog.Errorf("%s leave classroom: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not leave classroom"))
+		return
+	}
+	a.classroomPolicies.forget(user.Id)
+	c.JSON(http.StatusOK, gin.H{"user_id": user.Id})
+}
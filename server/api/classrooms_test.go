@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+func TestClassroomPolicyApply(t *testing.T) {
+	add := uint64(mathcore.ADDITION)
+	all := uint64(mathcore.ALL_PROBLEM_TYPES)
+	addCeiling := mathcore.MaxDiffForBitmap(add)
+	cases := []struct {
+		name          string
+		policy        classroomPolicy
+		in            Settings
+		wantBitmap    uint64
+		wantTarget    float64
+		wantUnchanged bool
+	}{
+		{"nothing set", classroomPolicy{}, Settings{ProblemTypeBitmap: all, TargetDifficulty: 4}, all, 4, true},
+		{"envelope replaces", classroomPolicy{bitmap: add}, Settings{ProblemTypeBitmap: all, TargetDifficulty: 4}, add, 4, false},
+		{"envelope lowers ceiling", classroomPolicy{bitmap: add}, Settings{ProblemTypeBitmap: all, TargetDifficulty: 20}, add, addCeiling, false},
+		{"floor raises", classroomPolicy{floor: 8}, Settings{ProblemTypeBitmap: all, TargetDifficulty: 4}, all, 8, false},
+		{"above floor kept", classroomPolicy{floor: 8}, Settings{ProblemTypeBitmap: all, TargetDifficulty: 12}, all, 12, true},
+		{"floor capped by ceiling", classroomPolicy{floor: 8}, Settings{ProblemTypeBitmap: add, TargetDifficulty: 3}, add, addCeiling, false},
+	}
+	for _, tc := range cases {
+		s := tc.in
+		changed := tc.policy.apply(&s)
+		if s.ProblemTypeBitmap != tc.wantBitmap || s.TargetDifficulty != tc.wantTarget || changed == tc.wantUnchanged {
+			t.Errorf("%s: got bitmap %d target %v changed %v, want %d %v %v",
+				tc.name, s.ProblemTypeBitmap, s.TargetDifficulty, changed, tc.wantBitmap, tc.wantTarget, !tc.wantUnchanged)
+		}
+	}
+}
+
+// TestPolicyCache: a cached policy, nil included, is served until it expires
+// or is forgotten.
+func TestPolicyCache(t *testing.T) {
+	var pc policyCache
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if _, ok := pc.get(1, now); ok {
+		t.Fatal("empty cache: want a miss")
+	}
+	pc.put(1, &classroomPolicy{floor: 8}, now)
+	pc.put(2, nil, now)
+	if p, ok := pc.get(1, now.Add(time.Second)); !ok || p == nil || p.floor != 8 {
+		t.Errorf("cached policy: got %+v %v", p, ok)
+	}
+	if p, ok := pc.get(2, now.Add(time.Second)); !ok || p != nil {
+		t.Errorf("cached no-policy: want a nil hit, got %+v %v", p, ok)
+	}
+	pc.forget(1)
+	if _, ok := pc.get(1, now.Add(time.Second)); ok {
+		t.Error("forgotten: want a miss")
+	}
+	if _, ok := pc.get(2, now.Add(classroomPolicyTTL)); ok {
+		t.Error("expired: want a miss")
+	}
+	pc.put(3, nil, now.Add(classroomPolicyTTL))
+	if _, ok := pc.entries[2]; ok {
+		t.Error("want expired entries swept on put")
+	}
+}
+
+// TestClassrooms runs a classroom end to end: the teacher gate and roster
+// scoping, joining by invite code under the class policy, a settings save
+// that can't escape it, class statistics, a pushed assignment and removal.
+func TestClassrooms(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	teacher := createTestUser(t, r, "auth0|class-teacher", "classteacher@test.com", "classteacher")
+	rival := createTestUser(t, r, "auth0|class-rival", "classrival@test.com", "classrival")
+	student := createTestUser(t, r, "auth0|class-student", "classstudent@test.com", "classstudent")
+	outsider := createTestUser(t, r, "auth0|class-outsider", "classoutsider@test.com", "classoutsider")
+	add := uint64(mathcore.ADDITION)
+	all := uint64(mathcore.ALL_PROBLEM_TYPES)
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := api.DB.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	exec("UPDATE problems SET disabled = 1")
+	exec("DELETE FROM generation_jobs")
+	exec("DELETE FROM recently_shown_problems")
+	for id := uint32(9901); id <= 9904; id++ {
+		exec(`INSERT INTO problems (id, problem_type_bitmap, expression, symbolic_expression, answer, difficulty, disabled, generator, difficulty_version)
+			 VALUES (?, ?, ?, '', '1', 3, 0, 'llm_0.5', '0.2')`, id, add, fmt.Sprintf("class %d", id))
+	}
+	if err := api.LoadCandidateIndex(); err != nil {
+		t.Fatalf("LoadCandidateIndex: %v", err)
+	}
+	exec("UPDATE settings SET problem_type_bitmap = ?, target_difficulty = 4 WHERE user_id = ?", all, student.Id)
+
+	if resp := catalogRequest(t, r, teacher, "GET", "/teacher/classrooms", ""); resp.Code != 403 {
+		t.Fatalf("non-teacher: want 403, got %d", resp.Code)
+	}
+	exec("UPDATE users SET role = ? WHERE id IN (?, ?)", RoleTeacher, teacher.Id, rival.Id)
+
+	for _, bad := range []string{
+		`{"name":""}`,
+		`{"name":"bits","problem_type_bitmap":18446744073709551615}`,
+		`{"name":"floor","difficulty_floor":1}`,
+		`{"name":"floor","problem_type_bitmap":1,"difficulty_floor":40}`,
+	} {
+		if resp := catalogRequest(t, r, teacher, "POST", "/teacher/classrooms", bad); resp.Code != 400 {
+			t.Errorf("%s: want 400, got %d", bad, resp.Code)
+		}
+	}
+	resp := catalogRequest(t, r, teacher, "POST", "/teacher/classrooms", fmt.Sprintf(`{"name":"Room 4","problem_type_bitmap":%d}`, add))
+	var created struct{ Id uint32 }
+	if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &created) != nil {
+		t.Fatalf("create: got %d %s", resp.Code, resp.Body.String())
+	}
+	path := fmt.Sprintf("/teacher/classrooms/%d", created.Id)
+	getClassroom := func() Classroom {
+		t.Helper()
+		resp := catalogRequest(t, r, teacher, "GET", path, "")
+		var cl Classroom
+		if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &cl) != nil {
+			t.Fatalf("get classroom: got %d %s", resp.Code, resp.Body.String())
+		}
+		return cl
+	}
+	cl := getClassroom()
+	if len(cl.InviteCode) != inviteCodeLength || len(cl.Students) != 0 {
+		t.Fatalf("new classroom: got %+v", cl)
+	}
+	if resp := catalogRequest(t, r, rival, "GET", path, ""); resp.Code != 404 {
+		t.Errorf("another teacher's classroom: want 404, got %d", resp.Code)
+	}
+
+	join := func(u *User, code string) int {
+		t.Helper()
+		return catalogRequest(t, r, u, "POST", fmt.Sprintf("/classrooms/%d/join", u.Id), fmt.Sprintf(`{"invite_code":%q}`, code)).Code
+	}
+	if code := join(student, "NOPE2345"); code != 404 {
+		t.Errorf("unknown code: want 404, got %d", code)
+	}
+	if code := join(teacher, cl.InviteCode); code != 400 {
+		t.Errorf("own classroom: want 400, got %d", code)
+	}
+	if code := catalogRequest(t, r, outsider, "POST", fmt.Sprintf("/classrooms/%d/join", student.Id), `{"invite_code":"x"}`).Code; code != 403 {
+		t.Errorf("joining for someone else: want 403, got %d", code)
+	}
+	// Codes are read case-insensitively; joining twice is a no-op.
+	if code := join(student, " "+string(bytes.ToLower([]byte(cl.InviteCode)))); code != 200 {
+		t.Fatalf("join: want 200, got %d", code)
+	}
+	if code := join(student, cl.InviteCode); code != 200 {
+		t.Errorf("rejoin: want 200, got %d", code)
+	}
+	resp = catalogRequest(t, r, rival, "POST", "/teacher/classrooms", `{"name":"Other room"}`)
+	var otherRoom struct{ Id uint32 }
+	json.Unmarshal(resp.Body.Bytes(), &otherRoom)
+	var otherCode string
+	if err := api.DB.QueryRow("SELECT invite_code FROM classrooms WHERE id = ?", otherRoom.Id).Scan(&otherCode); err != nil {
+		t.Fatalf("other code: %v", err)
+	}
+	if code := join(student, otherCode); code != 409 {
+		t.Errorf("second classroom: want 409, got %d", code)
+	}
+
+	settings, _, _, err := api.settingsManager.Get(student.Id)
+	if err != nil || settings.ProblemTypeBitmap != add || settings.TargetDifficulty != 4 {
+		t.Fatalf("join: want the class envelope, got %+v %v", settings, err)
+	}
+	var events int
+	api.DB.QueryRow("SELECT COUNT(*) FROM events WHERE user_id = ? AND event_type = ?", student.Id, SET_PROBLEM_TYPE_BITMAP).Scan(&events)
+	if events == 0 {
+		t.Errorf("join: want a %s event", SET_PROBLEM_TYPE_BITMAP)
+	}
+
+	// Dropping the envelope leaves the student's as it is, so a floor above
+	// its ceiling gives way to it.
+	resp = catalogRequest(t, r, teacher, "POST", path, `{"name":"Room 4","difficulty_floor":6}`)
+	if resp.Code != 200 {
+		t.Fatalf("update: got %d %s", resp.Code, resp.Body.String())
+	}
+	settings, _, _, _ = api.settingsManager.Get(student.Id)
+	if settings.ProblemTypeBitmap != add || settings.TargetDifficulty != mathcore.MaxDiffForBitmap(add) {
+		t.Errorf("floor: want target at the ceiling, got %+v", settings)
+	}
+
+	// With a wider envelope, a family save below the floor is raised to it.
+	body, _ := json.Marshal(Settings{UserId: student.Id, ProblemTypeBitmap: all, TargetDifficulty: 3, TargetWorkPercentage: 50})
+	saveResp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/settings/%d?test_auth0_id=%s", student.Id, student.Auth0Id), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(saveResp, req)
+	if saveResp.Code != 200 {
+		t.Fatalf("settings save: got %d %s", saveResp.Code, saveResp.Body.String())
+	}
+	settings, _, _, _ = api.settingsManager.Get(student.Id)
+	if settings.TargetDifficulty != 6 {
+		t.Errorf("settings save: want the floor kept, got %v", settings.TargetDifficulty)
+	}
+
+	if cl = getClassroom(); len(cl.Students) != 1 || cl.Students[0].UserId != student.Id {
+		t.Errorf("roster: got %+v", cl.Students)
+	}
+	resp = catalogRequest(t, r, teacher, "GET", path+"/statistics", "")
+	var stats ClassroomStatistics
+	if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &stats) != nil || len(stats.Students) != 1 {
+		t.Errorf("statistics: got %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp = catalogRequest(t, r, teacher, "POST", path+"/assignments", `{"title":"homework","problem_ids":[9901,9902]}`)
+	var pushed struct{ Assignments []PushedAssignment }
+	if resp.Code != 200 || json.Unmarshal(resp.Body.Bytes(), &pushed) != nil ||
+		len(pushed.Assignments) != 1 || pushed.Assignments[0].AssignmentId == 0 {
+		t.Fatalf("push: got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, teacher, "POST", path+"/assignments", `{"title":"bad","problem_ids":[1]}`); resp.Code != 400 {
+		t.Errorf("push bad: want 400, got %d", resp.Code)
+	}
+	studentPath := fmt.Sprintf("%s/students/%d", path, student.Id)
+	resp = catalogRequest(t, r, teacher, "GET", studentPath+"/assignments", "")
+	var reports []Assignment
+	if json.Unmarshal(resp.Body.Bytes(), &reports) != nil || len(reports) != 1 ||
+		reports[0].ClassroomId == nil || *reports[0].ClassroomId != created.Id {
+		t.Errorf("student assignments: got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, teacher, "GET", fmt.Sprintf("%s/students/%d/assignments", path, outsider.Id), ""); resp.Code != 404 {
+		t.Errorf("student off the roster: want 404, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, rival, "DELETE", studentPath, ""); resp.Code != 404 {
+		t.Errorf("another teacher removing: want 404, got %d", resp.Code)
+	}
+
+	if resp := catalogRequest(t, r, teacher, "DELETE", studentPath, ""); resp.Code != 200 {
+		t.Errorf("remove: want 200, got %d", resp.Code)
+	}
+	resp = catalogRequest(t, r, student, "GET", fmt.Sprintf("/classrooms/%d", student.Id), "")
+	if resp.Code != 200 || resp.Body.String() != "null" {
+		t.Errorf("after removal: want null membership, got %d %s", resp.Code, resp.Body.String())
+	}
+	settings, _, _, _ = api.settingsManager.Get(student.Id)
+	if settings.ProblemTypeBitmap != all || settings.TargetDifficulty != 6 {
+		t.Errorf("after removal: want settings kept, got %+v", settings)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
@@ -22,44 +23,54 @@ const (
 	nullVideoId = math.MaxUint32
 )
 
-func (a *Api) selectVideo(logPrefix string, c *gin.Context, userId uint32, exclusions map[uint32]bool) (uint32, error) {
+// selectVideo picks the user's next reward video: the least-recently-watched
+// share of their pool (video_rotation.go), weighted by their preferences and
+// by how well each duration fits budgetSeconds (video_budget.go), 0 for no
+// fit weighting.
+func (a *Api) selectVideo(logPrefix string, c *gin.Context, userId uint32, exclusions map[uint32]bool, budgetSeconds uint32) (uint32, error) {
 	rows, err := a.DB.Query(`
-		SELECT uhv.video_id FROM user_has_video uhv
+		SELECT uhv.video_id, v.duration_seconds,
+		  COALESCE(p.rating, 0), COALESCE(p.favourite, FALSE), COALESCE(p.pinned, FALSE), w.watched_at
+		FROM user_has_video uhv
 		INNER JOIN videos v ON v.id = uhv.video_id AND v.disabled = 0
-		WHERE uhv.user_id = ?`, userId)
+		LEFT JOIN user_video_preferences p ON p.user_id = uhv.user_id AND p.video_id = uhv.video_id
+		LEFT JOIN recently_watched_videos w ON w.user_id = uhv.user_id AND w.video_id = uhv.video_id
+		WHERE uhv.user_id = ? AND COALESCE(p.blocked, FALSE) = FALSE`, userId)
 	if err != nil {
 		glog.Errorf("%s selectVideo user_has_video: %v", logPrefix, err)
 		return 0, err
 	}
 	defer rows.Close()
-	var videoIds []uint32
+	var candidates []videoCandidate
 	for rows.Next() {
-		var id uint32
-		if err := rows.Scan(&id); err != nil {
+		var v videoCandidate
+		var pref VideoPreference
+		var watchedAt sql.NullTime
+		if err := rows.Scan(&v.id, &v.durationSeconds, &pref.Rating, &pref.Favourite, &pref.Pinned, &watchedAt); err != nil {
 			glog.Errorf("%s selectVideo scan: %v", logPrefix, err)
 			return 0, err
 		}
-		if _, ok := exclusions[id]; ok {
+		if _, ok := exclusions[v.id]; ok {
 			continue
 		}
-		videoIds = append(videoIds, id)
+		v.weight = videoPreferenceWeight(pref)
+		v.pinned = pref.Pinned
+		v.watchedAt = watchedAt.Time
+		candidates = append(candidates, v)
 	}
 	if err := rows.Err(); err != nil {
 		return 0, err
 	}
 
 	// If there are no videos at all in the database, do nothing
-	if len(videoIds) < 1 {
+	if len(candidates) < 1 {
 		msg := fmt.Sprintf("Couldn't find any videos for this user (%d): silently do nothing.", userId)
 		glog.Errorf("%s %s", logPrefix, msg)
 		return nullVideoId, nil
 	}
 
 	// Select video
-	ind := rand.Intn(len(videoIds))
-	videoId := videoIds[ind]
-
-	return videoId, nil
+	return pickVideoForBudget(rotateVideoCandidates(candidates), budgetSeconds, rand.Float64()), nil
 }
 
 func (a *Api) selectVideoIfNull(logPrefix string, c *gin.Context, gamestate *Gamestate, writeCtx bool) error {
@@ -68,7 +79,7 @@ func (a *Api) selectVideoIfNull(logPrefix string, c *gin.Context, gamestate *Gam
 	var err error
 	// Select a video if setup is done and no video is already selected
 	if gamestate.VideoId == nullVideoId {
-		videoId, err := a.selectVideo(logPrefix, c, gamestate.UserId, map[uint32]bool{})
+		videoId, err := a.selectVideo(logPrefix, c, gamestate.UserId, map[uint32]bool{}, 0)
 		if err != nil {
 			return err
 		}
@@ -127,6 +138,18 @@ func (a *Api) customUpdateSettings(c *gin.Context) {
 		return
 	}
 
+	// A student on a classroom roster keeps the class envelope and floor
+	// whatever the family saves (classrooms.go).
+	policy, err := a.loadClassroomPolicy(user.Id)
+	if err != nil {
+		glog.Errorf("%s loadClassroomPolicy: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not load the class settings"))
+		return
+	}
+	if policy != nil && policy.apply(model) {
+		glog.Infof("%s classroom policy applied to the saved settings", logPrefix)
+	}
+
 	// Clamp target_difficulty to the ceiling of the bitmap being saved
 	// BEFORE the write - this PUT persists the model first and only then
 	// runs event validation, so without the clamp an out-of-ceiling value
@@ -139,6 +162,19 @@ func (a *Api) customUpdateSettings(c *gin.Context) {
 		model.TargetDifficulty = ceiling
 	}
 
+	// Screen-time limits are checked before the write, like the clamp above.
+	if err := validateSessionLimits(model); err != nil {
+		glog.Errorf("%s %s", logPrefix, err)
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+
+	if err := validateTopicWeights(model); err != nil {
+		glog.Errorf("%s %s", logPrefix, err)
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+
 	// Write to database
 	status, msg, err = a.settingsManager.Update(model)
 	if HandleMngrRespWriteCtx(logPrefix, c, status, msg, err, model) != nil {
@@ -236,29 +272,37 @@ func (a *Api) customGetPlayData(c *gin.Context) {
 		return
 	}
 	if count < 1 {
-		c.JSON(http.StatusForbidden, common.GetError("Add at least 1 YouTube playlist in Settings to play."))
+		c.JSON(http.StatusForbidden, common.GetError("Add at least 1 playlist in Settings to play."))
 		return
 	}
 
 	// Read from database
-	gamestate, status, msg, err := a.gamestateManager.Get(gamestate.UserId)
-	if HandleMngrResp(logPrefix, c, status, msg, err, gamestate) != nil {
+	gamestate, settings, err := a.loadGamestateAndSettings(gamestate.UserId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, common.GetError("gamestate or settings not found"))
+			return
+		}
+		glog.Errorf("%s loadGamestateAndSettings: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("could not load gamestate/settings"))
 		return
 	}
 
-	a.helpGetPlayData(logPrefix, c, gamestate)
+	a.helpGetPlayData(logPrefix, c, gamestate, settings)
 }
 
-func (a *Api) helpGetPlayData(logPrefix string, c *gin.Context, gamestate *Gamestate) {
+func (a *Api) helpGetPlayData(logPrefix string, c *gin.Context, gamestate *Gamestate, settings *Settings) {
+	// Past a screen-time limit: no problem or video, just when play reopens.
+	if over := a.checkSessionLimits(logPrefix, gamestate.UserId, settings, time.Now()); over != nil {
+		HandleMngrRespWriteCtx(logPrefix, c, http.StatusOK, "", nil, PlayData{Gamestate: gamestate, SessionOver: over})
+		return
+	}
+
 	// Get Problem
 	problem, status, msg, err := a.problemManager.Get(gamestate.ProblemId)
 	if err != nil || status == http.StatusNotFound || gamestate.ProblemId == 0 {
 		// Problem missing or invalid (e.g. id 0); select a new problem and persist it
 		glog.Infof("%s problem not found or invalid (id=%d), selecting new problem", logPrefix, gamestate.ProblemId)
-		settings, status, msg, err := a.settingsManager.Get(gamestate.UserId)
-		if HandleMngrResp(logPrefix, c, status, msg, err, settings) != nil {
-			return
-		}
 		problem, err = a.selectProblem(logPrefix, c, settings, &[]uint32{})
 		if err != nil {
 			glog.Errorf("%s selectProblem: %v", logPrefix, err)
@@ -281,8 +325,8 @@ func (a *Api) helpGetPlayData(logPrefix string, c *gin.Context, gamestate *Games
 
 	// Get Video (by id only; videos table no longer has user_id)
 	video := &Video{}
-	err = a.DB.QueryRow("SELECT id, title, url, thumbnailurl, you_tube_id, disabled FROM videos WHERE id=?", gamestate.VideoId).
-		Scan(&video.Id, &video.Title, &video.URL, &video.ThumbnailURL, &video.YouTubeId, &video.Disabled)
+	err = a.DB.QueryRow("SELECT id, title, url, thumbnailurl, you_tube_id, disabled, provider, duration_seconds FROM videos WHERE id=?", gamestate.VideoId).
+		Scan(&video.Id, &video.Title, &video.URL, &video.ThumbnailURL, &video.YouTubeId, &video.Disabled, &video.Provider, &video.DurationSeconds)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, common.GetError("Video not found"))
@@ -292,12 +336,34 @@ func (a *Api) helpGetPlayData(logPrefix string, c *gin.Context, gamestate *Games
 		c.JSON(http.StatusInternalServerError, common.GetError("Could not get video"))
 		return
 	}
+	video.URL, video.ThumbnailURL = a.signMediaURL(video.URL), a.signMediaURL(video.ThumbnailURL)
+
+	// Once the cycle's problems are solved the player stops the video at
+	// the budget its work earned (video_budget.go).
+	// The player also shows the child's rating of it (video_rotation.go).
+	var cutoff uint32
+	var pref *VideoPreference
+	if gamestate.Solved >= gamestate.Target {
+		cycle, err := a.currentRewardCycle(gamestate.UserId, settings)
+		if err != nil {
+			glog.Errorf("%s currentRewardCycle: %v (no video cutoff)", logPrefix, err)
+		} else {
+			cutoff = cycle.budgetSeconds
+		}
+		if p, err := a.videoPreference(gamestate.UserId, video.Id); err != nil {
+			glog.Errorf("%s videoPreference: %v", logPrefix, err)
+		} else {
+			pref = &p
+		}
+	}
 
 	// Write out the data
 	data := PlayData{
-		Gamestate: gamestate,
-		Problem:   problem,
-		Video:     video,
+		Gamestate:          gamestate,
+		Problem:            problem,
+		Video:              video,
+		VideoCutoffSeconds: cutoff,
+		VideoPreference:    pref,
 	}
 	HandleMngrRespWriteCtx(logPrefix, c, http.StatusOK, "", nil, data)
 }
@@ -333,13 +399,31 @@ func (a *Api) customListEvent(c *gin.Context) {
 	}
 
 	// Get recent events belonging to the specified user
-	sql := fmt.Sprintf("user_id=%d AND timestamp > now() - interval %d second AND event_type IN (\"%s\");", params.UserId, params.Seconds, strings.Join([]string{LOGGED_IN, SELECTED_PROBLEM, ANSWERED_PROBLEM, SOLVED_PROBLEM, DONE_WATCHING_VIDEO}, "\",\""))
+	sql := fmt.Sprintf("user_id=%d AND timestamp > now() - interval %d second AND event_type IN (\"%s\");", params.UserId, params.Seconds, strings.Join([]string{LOGGED_IN, SELECTED_PROBLEM, ANSWERED_PROBLEM, SOLVED_PROBLEM, DONE_WATCHING_VIDEO, ACHIEVEMENT_EARNED}, "\",\""))
 	events, status, msg, err := a.eventManager.CustomList(sql)
 	if HandleMngrRespWriteCtx(logPrefix, c, status, msg, err, events) != nil {
 		return
 	}
 }
 
+// customGetVideo is getVideo with a local library video's URLs signed, so
+// the browser can fetch them (local_media.go).
+func (a *Api) customGetVideo(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	glog.Infof("%s fcn start", logPrefix)
+
+	model := &Video{}
+	if BindModelFromURI(logPrefix, c, model) != nil {
+		return
+	}
+	model, status, msg, err := a.videoManager.Get(model.Id)
+	if HandleMngrResp(logPrefix, c, status, msg, err, model) != nil {
+		return
+	}
+	model.URL, model.ThumbnailURL = a.signMediaURL(model.URL), a.signMediaURL(model.ThumbnailURL)
+	c.JSON(status, model)
+}
+
 // Remove a video from the current user's allowed list only. We never delete or
 // soft-delete video rows, so event and gamestate references remain valid.
 func (a *Api) customDeleteVideo(c *gin.Context) {
@@ -370,7 +454,7 @@ func (a *Api) customDeleteVideo(c *gin.Context) {
 		return
 	}
 	if gamestate.VideoId == model.Id {
-		videoId, err := a.selectVideo(logPrefix, c, user.Id, map[uint32]bool{gamestate.VideoId: true})
+		videoId, err := a.selectVideo(logPrefix, c, user.Id, map[uint32]bool{gamestate.VideoId: true}, 0)
 		if err != nil {
 			return
 		}
@@ -412,6 +496,9 @@ func (a *Api) customCreateVideo(c *gin.Context) {
 	HandleMngrRespWriteCtx(logPrefix, c, status, "", nil, model)
 }
 
+// customListVideo lists the user's pool plus the videos they have blocked
+// (which are out of the pool but must stay listed to be unblocked), with
+// their preferences and watch history.
 func (a *Api) customListVideo(c *gin.Context) {
 	logPrefix := common.GetLogPrefix(c)
 	glog.Infof("%s fcn start", logPrefix)
@@ -419,29 +506,39 @@ func (a *Api) customListVideo(c *gin.Context) {
 	user := GetUserFromContext(c)
 
 	rows, err := a.DB.Query(`
-		SELECT v.id, v.title, v.url, v.thumbnailurl, v.you_tube_id, v.disabled
-		FROM videos v
-		INNER JOIN user_has_video uhv ON uhv.video_id = v.id
-		WHERE uhv.user_id = ?`, user.Id)
+		SELECT v.id, v.title, v.url, v.thumbnailurl, v.you_tube_id, v.disabled, v.provider, v.duration_seconds,
+		  COALESCE(p.rating, 0), COALESCE(p.favourite, FALSE), COALESCE(p.pinned, FALSE), COALESCE(p.blocked, FALSE), w.watched_at
+		FROM (
+		  SELECT video_id FROM user_has_video WHERE user_id = ?
+		  UNION
+		  SELECT video_id FROM user_video_preferences WHERE user_id = ? AND blocked
+		) mine
+		INNER JOIN videos v ON v.id = mine.video_id
+		LEFT JOIN user_video_preferences p ON p.user_id = ? AND p.video_id = v.id
+		LEFT JOIN recently_watched_videos w ON w.user_id = ? AND w.video_id = v.id`,
+		user.Id, user.Id, user.Id, user.Id)
 	if err != nil {
 		glog.Errorf("%s list videos: %v", logPrefix, err)
 		c.JSON(http.StatusInternalServerError, common.GetError("Could not list videos"))
 		return
 	}
 	defer rows.Close()
-	var models []Video
+	models := []UserVideo{}
 	for rows.Next() {
-		var v Video
-		if err := rows.Scan(&v.Id, &v.Title, &v.URL, &v.ThumbnailURL, &v.YouTubeId, &v.Disabled); err != nil {
+		var v UserVideo
+		var watchedAt sql.NullTime
+		if err := rows.Scan(&v.Id, &v.Title, &v.URL, &v.ThumbnailURL, &v.YouTubeId, &v.Disabled, &v.Provider, &v.DurationSeconds,
+			&v.Rating, &v.Favourite, &v.Pinned, &v.Blocked, &watchedAt); err != nil {
 			glog.Errorf("%s scan video: %v", logPrefix, err)
 			c.JSON(http.StatusInternalServerError, common.GetError("Could not list videos"))
 			return
 		}
+		if watchedAt.Valid {
+			v.LastWatchedAt = &watchedAt.Time
+		}
+		v.URL, v.ThumbnailURL = a.signMediaURL(v.URL), a.signMediaURL(v.ThumbnailURL)
 		models = append(models, v)
 	}
-	if models == nil {
-		models = []Video{}
-	}
 	c.JSON(http.StatusOK, models)
 }
 
@@ -603,6 +700,9 @@ func (a *Api) customCreateOrUpdateUser(c *gin.Context) {
 	}
 }
 
+// refreshUserHasVideo rebuilds the user's pool from the union of their
+// playlists, leaving out the videos they have blocked (video_rotation.go) and,
+// from 'review' playlists, the ones not yet approved (video_approvals.go).
 func (a *Api) refreshUserHasVideo(userId uint32) error {
 	_, err := a.DB.Exec("DELETE FROM user_has_video WHERE user_id=?", userId)
 	if err != nil {
@@ -613,11 +713,27 @@ func (a *Api) refreshUserHasVideo(userId uint32) error {
 		SELECT DISTINCT up.user_id, pv.video_id
 		FROM user_playlist up
 		INNER JOIN playlist_video pv ON up.playlist_id = pv.playlist_id
-		WHERE up.user_id = ?`,
+		LEFT JOIN user_video_preferences p ON p.user_id = up.user_id AND p.video_id = pv.video_id
+		LEFT JOIN user_video_approvals ap ON ap.user_id = up.user_id AND ap.video_id = pv.video_id
+		WHERE up.user_id = ? AND COALESCE(p.blocked, FALSE) = FALSE AND `+rewardableVideoSQL,
 		userId)
 	return err
 }
 
+// UserPlaylist is one row of GET /playlists: a subscribed playlist with its
+// approval policy and how many of its videos await review, or a catalog
+// playlist (catalog.go) the user could subscribe to.
+type UserPlaylist struct {
+	Playlist
+	Subscribed     bool         `json:"subscribed"`
+	ApprovalPolicy string       `json:"approval_policy,omitempty"`
+	PendingCount   int          `json:"pending_count"`
+	Catalog        *CatalogInfo `json:"catalog,omitempty"`
+}
+
+// customListPlaylists lists the user's playlists, then the catalog playlists
+// they haven't subscribed to.
+
 func (a *Api) customListPlaylists(c *gin.Context) {
 	logPrefix := common.GetLogPrefix(c)
 	user := GetUserFromContext(c)
@@ -626,7 +742,7 @@ func (a *Api) customListPlaylists(c *gin.Context) {
 		return
 	}
 	rows, err := a.DB.Query(`
-		SELECT p.id, p.you_tube_id, p.title, p.thumbnailurl, p.etag
+		SELECT p.id, p.you_tube_id, p.title, p.thumbnailurl, p.etag, p.provider, up.approval_policy
 		FROM playlists p
 		INNER JOIN user_playlist up ON p.id = up.playlist_id
 		WHERE up.user_id = ?`,
@@ -637,10 +753,11 @@ func (a *Api) customListPlaylists(c *gin.Context) {
 		return
 	}
 	defer rows.Close()
-	var list []Playlist
+	var list []UserPlaylist
 	for rows.Next() {
-		var p Playlist
-		err := rows.Scan(&p.Id, &p.YouTubeId, &p.Title, &p.ThumbnailURL, &p.Etag)
+		var p UserPlaylist
+		err := rows.Scan(&p.Id, &p.YouTubeId, &p.Title, &p.ThumbnailURL, &p.Etag, &p.Provider, &p.ApprovalPolicy)
+		p.Subscribed = true
 		if err != nil {
 			glog.Errorf("%s scan playlist: %v", logPrefix, err)
 			c.JSON(http.StatusInternalServerError, common.GetError("Could not list playlists"))
@@ -654,7 +771,43 @@ func (a *Api) customListPlaylists(c *gin.Context) {
 		return
 	}
 	if list == nil {
-		list = []Playlist{}
+		list = []UserPlaylist{}
+	}
+	pending, err := a.listVideoApprovals(user.Id, APPROVAL_PENDING)
+	if err != nil {
+		glog.Errorf("%s listVideoApprovals: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list playlists"))
+		return
+	}
+	for _, v := range pending {
+		for _, pl := range v.Playlists {
+			for i := range list {
+				if list[i].Id == pl.Id {
+					list[i].PendingCount++
+				}
+			}
+		}
+	}
+	infos, err := a.catalogInfos()
+	if err != nil {
+		glog.Errorf("%s catalogInfos: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list playlists"))
+		return
+	}
+	for i := range list {
+		if info, ok := infos[list[i].Id]; ok {
+			list[i].Catalog = &info
+		}
+	}
+	catalog, err := a.catalogPlaylists(user.Id, infos)
+	if err != nil {
+		glog.Errorf("%s catalogPlaylists: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list playlists"))
+		return
+	}
+	list = append(list, catalog...)
+	for i := range list {
+		list[i].ThumbnailURL = a.signMediaURL(list[i].ThumbnailURL)
 	}
 	c.JSON(http.StatusOK, list)
 }
@@ -674,6 +827,63 @@ func extractPlaylistIDFromURL(raw string) string {
 	return raw
 }
 
+// playlistRef names a playlist to add, by one of: an existing playlists.id,
+// a YouTube playlist ID or URL, or any configured provider's external ID.
+type playlistRef struct {
+	PlaylistID        *uint32 `json:"playlist_id"`
+	YouTubePlaylistID *string `json:"youtube_playlist_id"`
+	PlaylistURL       *string `json:"playlist_url"`
+	// Any configured provider's playlist, e.g. {"provider": "local",
+	// "external_playlist_id": "local:..."} for a media library folder.
+	Provider           *string `json:"provider"`
+	ExternalPlaylistID *string `json:"external_playlist_id"`
+}
+
+// resolvePlaylistRef returns the playlists.id a playlistRef names, syncing it
+// from its provider unless it is already in the catalog (catalog.go), which
+// the scheduled resync keeps current. On failure it returns the status and
+// message to answer with.
+func (a *Api) resolvePlaylistRef(logPrefix string, ref playlistRef) (uint32, int, string, error) {
+	if ref.PlaylistID != nil {
+		_, status, msg, err := a.playlistManager.Get(*ref.PlaylistID)
+		if err != nil {
+			return 0, status, msg, err
+		}
+		return *ref.PlaylistID, http.StatusOK, "", nil
+	}
+	providerName := PROVIDER_YOUTUBE
+	var externalID string
+	if ref.Provider != nil && *ref.Provider != "" {
+		providerName = *ref.Provider
+		if ref.ExternalPlaylistID != nil {
+			externalID = strings.TrimSpace(*ref.ExternalPlaylistID)
+		}
+	} else if ref.YouTubePlaylistID != nil && *ref.YouTubePlaylistID != "" {
+		externalID = strings.TrimSpace(*ref.YouTubePlaylistID)
+	} else if ref.PlaylistURL != nil {
+		externalID = extractPlaylistIDFromURL(*ref.PlaylistURL)
+	}
+	if externalID == "" {
+		msg := "Provide playlist_id, youtube_playlist_id, playlist_url, or provider and external_playlist_id"
+		return 0, http.StatusBadRequest, msg, fmt.Errorf("no playlist given")
+	}
+	provider, err := a.videoProvider(providerName)
+	if err != nil {
+		return 0, http.StatusBadRequest, err.Error(), err
+	}
+	if id, ok, err := a.catalogPlaylistID(externalID); err != nil {
+		glog.Errorf("%s catalogPlaylistID %s: %v", logPrefix, externalID, err)
+	} else if ok {
+		return id, http.StatusOK, "", nil
+	}
+	syncedID, err := a.syncPlaylist(provider, externalID)
+	if err != nil {
+		glog.Errorf("%s syncPlaylist %s %s: %v", logPrefix, providerName, externalID, err)
+		return 0, http.StatusBadRequest, "Could not fetch playlist: " + err.Error(), err
+	}
+	return syncedID, http.StatusOK, "", nil
+}
+
 func (a *Api) customAddPlaylist(c *gin.Context) {
 	logPrefix := common.GetLogPrefix(c)
 	user := GetUserFromContext(c)
@@ -682,43 +892,29 @@ func (a *Api) customAddPlaylist(c *gin.Context) {
 		return
 	}
 	var body struct {
-		PlaylistID        *uint32 `json:"playlist_id"`
-		YouTubePlaylistID *string `json:"youtube_playlist_id"`
-		PlaylistURL       *string `json:"playlist_url"`
+		playlistRef
+		// "auto" (the default) or "review" (video_approvals.go).
+		ApprovalPolicy *string `json:"approval_policy"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
 		return
 	}
-	var playlistID uint32
-	if body.PlaylistID != nil {
-		_, status, msg, err := a.playlistManager.Get(*body.PlaylistID)
-		if err != nil {
-			glog.Errorf("%s get playlist: %v", logPrefix, err)
-			c.JSON(status, common.GetError(msg))
-			return
-		}
-		playlistID = *body.PlaylistID
-	} else {
-		var ytID string
-		if body.YouTubePlaylistID != nil && *body.YouTubePlaylistID != "" {
-			ytID = strings.TrimSpace(*body.YouTubePlaylistID)
-		} else if body.PlaylistURL != nil {
-			ytID = extractPlaylistIDFromURL(*body.PlaylistURL)
-		}
-		if ytID == "" {
-			c.JSON(http.StatusBadRequest, common.GetError("Provide playlist_id, youtube_playlist_id, or playlist_url"))
-			return
-		}
-		syncedID, err := a.syncPlaylistFromYouTube(ytID)
-		if err != nil {
-			glog.Errorf("%s syncPlaylistFromYouTube %s: %v", logPrefix, ytID, err)
-			c.JSON(http.StatusBadRequest, common.GetError("Could not fetch playlist from YouTube: "+err.Error()))
-			return
-		}
-		playlistID = syncedID
+	policy := APPROVAL_POLICY_AUTO
+	if body.ApprovalPolicy != nil {
+		policy = *body.ApprovalPolicy
+	}
+	if !validApprovalPolicy(policy) {
+		c.JSON(http.StatusBadRequest, common.GetError("approval_policy must be auto or review"))
+		return
+	}
+	playlistID, status, msg, err := a.resolvePlaylistRef(logPrefix, body.playlistRef)
+	if err != nil {
+		glog.Errorf("%s resolvePlaylistRef: %v", logPrefix, err)
+		c.JSON(status, common.GetError(msg))
+		return
 	}
-	_, err := a.DB.Exec("INSERT IGNORE INTO user_playlist (user_id, playlist_id) VALUES (?, ?)", user.Id, playlistID)
+	_, err = a.DB.Exec("INSERT IGNORE INTO user_playlist (user_id, playlist_id, approval_policy) VALUES (?, ?, ?)", user.Id, playlistID, policy)
 	if err != nil {
 		glog.Errorf("%s add user_playlist: %v", logPrefix, err)
 		c.JSON(http.StatusInternalServerError, common.GetError("Could not add playlist"))
@@ -0,0 +1,358 @@
+// Package api: the weekly parent digest.
+//
+// BuildWeeklyDigest summarizes one user's last complete week (Monday 00:00 UTC
+// to the next Monday) from the statistics cache and the events in that window:
+// problems solved, first-try accuracy by topic (the per-topic stats in
+// statistics_topics.go), how the target difficulty moved, problems the child
+// flagged, and math vs video time against TargetWorkPercentage.
+// RenderWeeklyDigest turns it into a plaintext and an HTML body, and
+// SendWeeklyDigest delivers it through a MailTransport, recording each delivery
+// in digest_sends so a week is mailed at most once. The send_weekly_digest
+// command runs it on a weekly timer for every user with settings.digest_opt_in.
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// DigestTopic is one topic the child practiced during the digest week.
+type DigestTopic struct {
+	Topic           string `json:"topic"`
+	Attempts        int64  `json:"attempts"`
+	FirstTryCorrect int64  `json:"first_try_correct"`
+	Trend           string `json:"trend"`
+}
+
+// FirstTryPercent is the week's first-try accuracy, rounded.
+func (t DigestTopic) FirstTryPercent() int {
+	if t.Attempts == 0 {
+		return 0
+	}
+	return int((100*t.FirstTryCorrect + t.Attempts/2) / t.Attempts)
+}
+
+// DigestFlaggedProblem is a problem the child reported as bad (BAD_PROBLEM_USER).
+type DigestFlaggedProblem struct {
+	ProblemId  uint32 `json:"problem_id"`
+	Expression string `json:"expression"`
+}
+
+// WeeklyDigest is everything one digest email says.
+type WeeklyDigest struct {
+	UserId            uint32                 `json:"user_id"`
+	Username          string                 `json:"username"`
+	Email             string                 `json:"email"`
+	WeekStart         time.Time              `json:"week_start"`
+	ProblemsSolved    int64                  `json:"problems_solved"`
+	Topics            []DigestTopic          `json:"topics"`
+	DifficultyStart   float64                `json:"difficulty_start"`
+	DifficultyEnd     float64                `json:"difficulty_end"`
+	Flagged           []DigestFlaggedProblem `json:"flagged"`
+	WorkMinutes       int64                  `json:"work_minutes"`
+	VideoMinutes      int64                  `json:"video_minutes"`
+	TargetWorkPercent uint8                  `json:"target_work_percent"`
+}
+
+// WeekEnd is the exclusive end of the digest week.
+func (d *WeeklyDigest) WeekEnd() time.Time {
+	return d.WeekStart.AddDate(0, 0, 7)
+}
+
+// WorkPercent is math time as a share of math+video time (0 with no time).
+func (d *WeeklyDigest) WorkPercent() int {
+	total := d.WorkMinutes + d.VideoMinutes
+	if total == 0 {
+		return 0
+	}
+	return int((100*d.WorkMinutes + total/2) / total)
+}
+
+// DifficultyTrend describes how the target difficulty moved over the week.
+func (d *WeeklyDigest) DifficultyTrend() string {
+	switch {
+	case d.DifficultyEnd > d.DifficultyStart+0.05:
+		return "up"
+	case d.DifficultyEnd < d.DifficultyStart-0.05:
+		return "down"
+	}
+	return "steady"
+}
+
+// DigestWeekStart is the Monday (UTC) starting the last complete week before now.
+func DigestWeekStart(now time.Time) time.Time {
+	return topicWeekStart(now).AddDate(0, 0, -7)
+}
+
+// digestWeekEvents is the part of a digest folded from the week's events.
+type digestWeekEvents struct {
+	solved            int64
+	workMs, videoMs   int64
+	lastDifficulty    string
+	firstDifficulty   string
+	flaggedProblemIds []uint32
+}
+
+// summarizeDigestEvents folds a week's events (in id order). Durations sum in
+// ms and the caller divides once, per the statistics ms->minutes contract.
+func summarizeDigestEvents(events []progressEventRow) digestWeekEvents {
+	var s digestWeekEvents
+	seen := map[uint32]bool{}
+	for _, e := range events {
+		switch e.eventType {
+		case SOLVED_PROBLEM:
+			s.solved++
+		case WORKING_ON_PROBLEM:
+			if v, _ := parseEventDurationMs(e.value); v > 0 {
+				s.workMs += v
+			}
+		case WATCHING_VIDEO:
+			if v, _ := parseEventDurationMs(e.value); v > 0 {
+				s.videoMs += v
+			}
+		case SET_TARGET_DIFFICULTY:
+			if s.firstDifficulty == "" {
+				s.firstDifficulty = e.value
+			}
+			s.lastDifficulty = e.value
+		case BAD_PROBLEM_USER:
+			if id := parseBadProblemID(e.value); id != 0 && !seen[id] {
+				seen[id] = true
+				s.flaggedProblemIds = append(s.flaggedProblemIds, id)
+			}
+		}
+	}
+	return s
+}
+
+// BuildWeeklyDigest assembles userID's digest for the week starting weekStart.
+// It refreshes the statistics cache first so the topic figures are current.
+func (a *Api) BuildWeeklyDigest(logPrefix string, userID uint32, weekStart time.Time) (*WeeklyDigest, error) {
+	weekStart = topicWeekStart(weekStart)
+	weekEnd := weekStart.AddDate(0, 0, 7)
+
+	user := &User{}
+	if err := a.DB.QueryRow(`SELECT id, email, username FROM users WHERE id = ?`, userID).Scan(&user.Id, &user.Email, &user.Username); err != nil {
+		return nil, fmt.Errorf("loading user: %w", err)
+	}
+	settings, _, _, err := a.settingsManager.Get(userID)
+	if err != nil {
+		return nil, fmt.Errorf("loading settings: %w", err)
+	}
+	if err := a.UpdateStatisticsForUser(logPrefix, userID); err != nil {
+		return nil, fmt.Errorf("updating statistics: %w", err)
+	}
+
+	rows, err := a.DB.Query(`
+		SELECT id, event_type, value, timestamp FROM events
+		WHERE user_id = ? AND timestamp >= ? AND timestamp < ? AND event_type IN (?, ?, ?, ?, ?)
+		ORDER BY id`,
+		userID, weekStart, weekEnd,
+		SOLVED_PROBLEM, WORKING_ON_PROBLEM, WATCHING_VIDEO, SET_TARGET_DIFFICULTY, BAD_PROBLEM_USER,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("loading events: %w", err)
+	}
+	defer rows.Close()
+	var events []progressEventRow
+	for rows.Next() {
+		var r progressEventRow
+		if err := rows.Scan(&r.id, &r.eventType, &r.value, &r.timestamp); err != nil {
+			return nil, err
+		}
+		events = append(events, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	week := summarizeDigestEvents(events)
+
+	const msPerMinute = 60000
+	d := &WeeklyDigest{
+		UserId:            userID,
+		Username:          user.Username,
+		Email:             user.Email,
+		WeekStart:         weekStart,
+		ProblemsSolved:    week.solved,
+		Topics:            []DigestTopic{},
+		Flagged:           []DigestFlaggedProblem{},
+		WorkMinutes:       week.workMs / msPerMinute,
+		VideoMinutes:      week.videoMs / msPerMinute,
+		TargetWorkPercent: settings.TargetWorkPercentage,
+	}
+
+	// Difficulty at the start of the week is the last value set before it;
+	// with none (a new account), the week's first value, else the current one.
+	var before string
+	err = a.DB.QueryRow(
+		`SELECT value FROM events WHERE user_id = ? AND event_type = ? AND timestamp < ? ORDER BY id DESC LIMIT 1`,
+		userID, SET_TARGET_DIFFICULTY, weekStart,
+	).Scan(&before)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("loading starting difficulty: %w", err)
+	}
+	parseDiff := func(vals ...string) float64 {
+		for _, v := range vals {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
+			}
+		}
+		return settings.TargetDifficulty
+	}
+	d.DifficultyStart = parseDiff(before, week.firstDifficulty)
+	d.DifficultyEnd = parseDiff(week.lastDifficulty, before)
+
+	// Topic accuracy from the cached attempts, rolled up as of the week's end
+	// so its weekly bucket (and trend) is the digest week.
+	topics, err := a.readTopicStats(userID, weekEnd.Add(-time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("reading topic stats: %w", err)
+	}
+	label := weekStart.Format("2006-01-02")
+	for _, t := range topics {
+		for _, w := range t.Weekly {
+			if w.Period == label && w.Attempts > 0 {
+				d.Topics = append(d.Topics, DigestTopic{Topic: t.Topic, Attempts: w.Attempts, FirstTryCorrect: w.FirstTryCorrect, Trend: t.Trend})
+			}
+		}
+	}
+
+	for _, id := range week.flaggedProblemIds {
+		p := DigestFlaggedProblem{ProblemId: id}
+		if problem, _, _, err := a.problemManager.Get(id); err == nil {
+			p.Expression = problem.Expression
+		}
+		d.Flagged = append(d.Flagged, p)
+	}
+	return d, nil
+}
+
+var digestTemplateFuncs = map[string]interface{}{
+	"topicName": func(t string) string { return strings.ReplaceAll(t, "_", " ") },
+	"date":      func(t time.Time) string { return t.Format("Jan 2") },
+	"diff":      func(f float64) string { return strconv.FormatFloat(f, 'f', 1, 64) },
+}
+
+var digestTextTemplate = texttemplate.Must(texttemplate.New("digest").Funcs(digestTemplateFuncs).Parse(
+	`{{.Username}}'s week in math ({{date .WeekStart}} - {{date .WeekEnd}})
+
+Problems solved: {{.ProblemsSolved}}
+Math time: {{.WorkMinutes}} min, video time: {{.VideoMinutes}} min ({{.WorkPercent}}% math, target {{.TargetWorkPercent}}%)
+Difficulty: {{diff .DifficultyStart}} -> {{diff .DifficultyEnd}} ({{.DifficultyTrend}})
+{{if .Topics}}
+Accuracy by topic (first try):
+{{range .Topics}}  - {{topicName .Topic}}: {{.FirstTryPercent}}% of {{.Attempts}}{{if .Trend}}, {{.Trend}}{{end}}
+{{end}}{{else}}
+No problems attempted this week.
+{{end}}{{if .Flagged}}
+Problems {{.Username}} flagged as wrong:
+{{range .Flagged}}  - #{{.ProblemId}} {{.Expression}}
+{{end}}{{end}}
+You are receiving this because the weekly digest is turned on in Settings.
+`))
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Funcs(digestTemplateFuncs).Parse(
+	`<!DOCTYPE html>
+<html><body style="font-family: sans-serif; color: #222;">
+<h2>{{.Username}}'s week in math</h2>
+<p>{{date .WeekStart}} &ndash; {{date .WeekEnd}}</p>
+<table cellpadding="4">
+<tr><td>Problems solved</td><td><b>{{.ProblemsSolved}}</b></td></tr>
+<tr><td>Math time</td><td>{{.WorkMinutes}} min</td></tr>
+<tr><td>Video time</td><td>{{.VideoMinutes}} min</td></tr>
+<tr><td>Time on math</td><td>{{.WorkPercent}}% (target {{.TargetWorkPercent}}%)</td></tr>
+<tr><td>Difficulty</td><td>{{diff .DifficultyStart}} &rarr; {{diff .DifficultyEnd}} ({{.DifficultyTrend}})</td></tr>
+</table>
+{{if .Topics}}<h3>Accuracy by topic (first try)</h3>
+<table cellpadding="4">
+<tr><th align="left">Topic</th><th align="left">First try</th><th align="left">Attempts</th><th align="left">Trend</th></tr>
+{{range .Topics}}<tr><td>{{topicName .Topic}}</td><td>{{.FirstTryPercent}}%</td><td>{{.Attempts}}</td><td>{{.Trend}}</td></tr>
+{{end}}</table>
+{{else}}<p>No problems attempted this week.</p>
+{{end}}{{if .Flagged}}<h3>Problems {{.Username}} flagged as wrong</h3>
+<ul>
+{{range .Flagged}}<li>#{{.ProblemId}} <code>{{.Expression}}</code></li>
+{{end}}</ul>
+{{end}}<p style="color: #888; font-size: small;">You are receiving this because the weekly digest is turned on in Settings.</p>
+</body></html>
+`))
+
+// RenderWeeklyDigest renders d as a subject line, plaintext body and HTML body.
+func RenderWeeklyDigest(d *WeeklyDigest) (subject, text, html string, err error) {
+	subject = fmt.Sprintf("%s's week in math: %d problems solved", d.Username, d.ProblemsSolved)
+	var tb, hb bytes.Buffer
+	if err := digestTextTemplate.Execute(&tb, d); err != nil {
+		return "", "", "", err
+	}
+	if err := digestHTMLTemplate.Execute(&hb, d); err != nil {
+		return "", "", "", err
+	}
+	return subject, tb.String(), hb.String(), nil
+}
+
+// ErrDigestAlreadySent means the user's digest for that week went out already.
+var ErrDigestAlreadySent = errors.New("digest already sent for this week")
+
+// SendWeeklyDigest builds, renders and delivers one user's digest for the week
+// starting weekStart, then records the send. It does not check the opt-in.
+func (a *Api) SendWeeklyDigest(logPrefix string, transport MailTransport, from string, userID uint32, weekStart time.Time) error {
+	weekStart = topicWeekStart(weekStart)
+	var sent int
+	if err := a.DB.QueryRow(
+		`SELECT COUNT(*) FROM digest_sends WHERE user_id = ? AND week_start = ?`, userID, weekStart.Format("2006-01-02"),
+	).Scan(&sent); err != nil {
+		return err
+	}
+	if sent > 0 {
+		return ErrDigestAlreadySent
+	}
+
+	d, err := a.BuildWeeklyDigest(logPrefix, userID, weekStart)
+	if err != nil {
+		return err
+	}
+	if d.Email == "" {
+		return errors.New("user has no email address")
+	}
+	subject, text, html, err := RenderWeeklyDigest(d)
+	if err != nil {
+		return fmt.Errorf("rendering: %w", err)
+	}
+	if err := transport.Send(&MailMessage{From: from, To: d.Email, Subject: subject, Text: text, HTML: html}); err != nil {
+		return fmt.Errorf("sending: %w", err)
+	}
+	if _, err := a.DB.Exec(
+		`INSERT IGNORE INTO digest_sends (user_id, week_start) VALUES (?, ?)`, userID, weekStart.Format("2006-01-02"),
+	); err != nil {
+		return fmt.Errorf("recording send: %w", err)
+	}
+	glog.Infof("%s sent weekly digest for %s to %s", logPrefix, weekStart.Format("2006-01-02"), d.Email)
+	return nil
+}
+
+// DigestOptedInUserIds lists users with settings.digest_opt_in set.
+func (a *Api) DigestOptedInUserIds() ([]uint32, error) {
+	rows, err := a.DB.Query(`SELECT user_id FROM settings WHERE digest_opt_in = 1 ORDER BY user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uint32
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestDigestWeekStart(t *testing.T) {
+	// Sunday 2026-10-18: the last complete week is Mon 10-05 .. Mon 10-12.
+	now := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	if got := DigestWeekStart(now).Format("2006-01-02"); got != "2026-10-05" {
+		t.Errorf("want 2026-10-05, got %s", got)
+	}
+	// Monday morning: the week that just ended.
+	monday := time.Date(2026, 10, 19, 7, 0, 0, 0, time.UTC)
+	if got := DigestWeekStart(monday).Format("2006-01-02"); got != "2026-10-12" {
+		t.Errorf("want 2026-10-12, got %s", got)
+	}
+}
+
+func TestSummarizeDigestEvents(t *testing.T) {
+	at := ts(1000)
+	s := summarizeDigestEvents([]progressEventRow{
+		topicEv(1, SOLVED_PROBLEM, "10", at),
+		topicEv(2, WORKING_ON_PROBLEM, "30000", at),
+		topicEv(3, WORKING_ON_PROBLEM, "30000.5", at),
+		topicEv(4, WATCHING_VIDEO, "-5", at),
+		topicEv(5, SET_TARGET_DIFFICULTY, "3.2E+00", at),
+		topicEv(6, BAD_PROBLEM_USER, `{"problem_id": 44}`, at),
+		topicEv(7, SOLVED_PROBLEM, "11", at),
+		topicEv(8, BAD_PROBLEM_USER, `{"problem_id": 44}`, at),
+		topicEv(9, SET_TARGET_DIFFICULTY, "3.5E+00", at),
+	})
+	if s.solved != 2 || s.workMs != 60000 || s.videoMs != 0 {
+		t.Errorf("want solved=2 work=60000 video=0, got %+v", s)
+	}
+	if s.firstDifficulty != "3.2E+00" || s.lastDifficulty != "3.5E+00" {
+		t.Errorf("difficulty: want 3.2E+00 -> 3.5E+00, got %q -> %q", s.firstDifficulty, s.lastDifficulty)
+	}
+	if len(s.flaggedProblemIds) != 1 || s.flaggedProblemIds[0] != 44 {
+		t.Errorf("flagged: want [44] once, got %v", s.flaggedProblemIds)
+	}
+}
+
+func testDigest() *WeeklyDigest {
+	return &WeeklyDigest{
+		Username:          "Sam",
+		Email:             "parent@example.com",
+		WeekStart:         time.Date(2026, 10, 5, 0, 0, 0, 0, time.UTC),
+		ProblemsSolved:    42,
+		Topics:            []DigestTopic{{Topic: "mismatched_denominators", Attempts: 8, FirstTryCorrect: 5, Trend: "improving"}},
+		DifficultyStart:   3.2,
+		DifficultyEnd:     3.5,
+		Flagged:           []DigestFlaggedProblem{{ProblemId: 7, Expression: "1 < 2 & 3"}},
+		WorkMinutes:       30,
+		VideoMinutes:      10,
+		TargetWorkPercent: 70,
+	}
+}
+
+func TestRenderWeeklyDigest(t *testing.T) {
+	subject, text, html, err := RenderWeeklyDigest(testDigest())
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if subject != "Sam's week in math: 42 problems solved" {
+		t.Errorf("subject: got %q", subject)
+	}
+	for _, want := range []string{
+		"Oct 5 - Oct 12",
+		"Problems solved: 42",
+		"(75% math, target 70%)",
+		"Difficulty: 3.2 -> 3.5 (up)",
+		"mismatched denominators: 63% of 8, improving",
+		"#7 1 < 2 & 3",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("text body missing %q:\n%s", want, text)
+		}
+	}
+	// The HTML body escapes problem text.
+	if !strings.Contains(html, "<code>1 &lt; 2 &amp; 3</code>") {
+		t.Errorf("html body should escape the flagged expression:\n%s", html)
+	}
+	if !strings.Contains(html, "<td>mismatched denominators</td><td>63%</td>") {
+		t.Errorf("html body missing topic row:\n%s", html)
+	}
+}
+
+func TestRenderWeeklyDigest_NoActivity(t *testing.T) {
+	d := testDigest()
+	d.Topics, d.Flagged, d.ProblemsSolved, d.WorkMinutes, d.VideoMinutes = nil, nil, 0, 0, 0
+	d.DifficultyEnd = d.DifficultyStart
+	_, text, _, err := RenderWeeklyDigest(d)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !strings.Contains(text, "No problems attempted this week.") || strings.Contains(text, "flagged") {
+		t.Errorf("quiet week: got\n%s", text)
+	}
+	if !strings.Contains(text, "(steady)") || !strings.Contains(text, "(0% math") {
+		t.Errorf("quiet week: want steady difficulty and 0%% math, got\n%s", text)
+	}
+}
+
+// TestCaptureTransport_WritesParseableMIME checks the stand-in keeps the
+// message and writes an .eml a mail client could read: both alternatives,
+// decoded back to the original bodies.
+func TestCaptureTransport_WritesParseableMIME(t *testing.T) {
+	dir := t.TempDir()
+	transport := &CaptureTransport{Dir: dir}
+	msg := &MailMessage{From: "digest@example.com", To: "parent@example.com", Subject: "Sam's week — 42 solved", Text: "plain = body", HTML: "<p>html body</p>"}
+	if err := transport.Send(msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if got := transport.Messages(); len(got) != 1 || got[0] != msg {
+		t.Fatalf("want the message captured, got %v", got)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "001-parent_at_example.com.eml"))
+	if err != nil {
+		t.Fatalf("read .eml: %v", err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != msg.Subject {
+		t.Errorf("subject: want %q, got %q (%v)", msg.Subject, subject, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type: got %q (%v)", mediaType, err)
+	}
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next part: %v", err)
+		}
+		b, err := io.ReadAll(part) // NextPart decodes quoted-printable
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+		bodies = append(bodies, string(b))
+	}
+	if len(bodies) != 2 || bodies[0] != msg.Text || bodies[1] != msg.HTML {
+		t.Errorf("want [text, html] bodies, got %q", bodies)
+	}
+}
+
+// TestSendWeeklyDigest_RecordsAndDedups sends a digest through the capture
+// transport and checks a second send for the same week is refused.
+func TestSendWeeklyDigest_RecordsAndDedups(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|digest", "digest-parent@test.com", "digestuser")
+	if _, err := api.DB.Exec("UPDATE settings SET digest_opt_in = 1 WHERE user_id = ?", user.Id); err != nil {
+		t.Fatalf("opt in: %v", err)
+	}
+
+	weekStart := DigestWeekStart(time.Now().UTC())
+	inWeek := weekStart.Add(36 * time.Hour)
+	_, err = api.DB.Exec(
+		"INSERT INTO events (timestamp, user_id, event_type, value) VALUES (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?)",
+		inWeek, user.Id, SOLVED_PROBLEM, "1",
+		inWeek, user.Id, WORKING_ON_PROBLEM, "120000",
+		inWeek, user.Id, WATCHING_VIDEO, "60000",
+	)
+	if err != nil {
+		t.Fatalf("insert events: %v", err)
+	}
+
+	ids, err := api.DigestOptedInUserIds()
+	if err != nil || len(ids) != 1 || ids[0] != user.Id {
+		t.Fatalf("opted-in users: want [%d], got %v (%v)", user.Id, ids, err)
+	}
+
+	transport := &CaptureTransport{}
+	if err := api.SendWeeklyDigest("[test]", transport, "digest@test.com", user.Id, weekStart); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msgs := transport.Messages()
+	if len(msgs) != 1 || msgs[0].To != "digest-parent@test.com" {
+		t.Fatalf("want one message to the user's email, got %+v", msgs)
+	}
+	if !strings.Contains(msgs[0].Text, "Problems solved: 1") || !strings.Contains(msgs[0].Text, "Math time: 2 min, video time: 1 min") {
+		t.Errorf("digest body: got\n%s", msgs[0].Text)
+	}
+
+	if err := api.SendWeeklyDigest("[test]", transport, "digest@test.com", user.Id, weekStart); err != ErrDigestAlreadySent {
+		t.Errorf("second send: want ErrDigestAlreadySent, got %v", err)
+	}
+	if len(transport.Messages()) != 1 {
+		t.Errorf("second send must not deliver")
+	}
+}
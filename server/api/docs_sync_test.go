@@ -139,6 +139,7 @@ func TestDocsSyncSelection(t *testing.T) {
 	assertIntAnchor(t, doc, "recency_window", anchors["recency_window"], recencyWindow)
 	assertFloatAnchor(t, doc, "lru_top_frac", anchors["lru_top_frac"], lruTopFrac)
 	assertFloatAnchor(t, doc, "selection_epsilon", anchors["selection_epsilon"], problemSelectionEpsilon)
+	assertIntAnchor(t, doc, "topic_coverage_window", anchors["topic_coverage_window"], topicCoverageWindow)
 }
 
 // TestDocsSyncAdaptiveDifficulty pins docs/adaptive-difficulty.md to the
@@ -177,7 +178,7 @@ func TestDocsSyncEvents(t *testing.T) {
 		LOGGED_IN, SELECTED_PROBLEM, WORKING_ON_PROBLEM, ANSWERED_PROBLEM,
 		SOLVED_PROBLEM, ERROR_PLAYING_VIDEO, WATCHING_VIDEO, DONE_WATCHING_VIDEO,
 		SET_TARGET_DIFFICULTY, SET_TARGET_WORK_PERCENTAGE, SET_PROBLEM_TYPE_BITMAP,
-		SET_GAMESTATE_TARGET, BAD_PROBLEM_SYSTEM, BAD_PROBLEM_USER,
+		SET_GAMESTATE_TARGET, BAD_PROBLEM_SYSTEM, BAD_PROBLEM_USER, ACHIEVEMENT_EARNED,
 	}
 	assertSetAnchor(t, doc, "event_types", anchors["event_types"], allEventTypes)
 
@@ -188,13 +189,16 @@ func TestDocsSyncEvents(t *testing.T) {
 	}
 	assertSetAnchor(t, doc, "summable_event_types", anchors["summable_event_types"], summable)
 
-	// The stats cache counts these three types. There is no single code symbol
+	// The stats cache counts these four types. There is no single code symbol
 	// enumerating them (the accumulation lives in statistics_handlers.go switches),
 	// so the doc is pinned to the named consts - changing the documented set
 	// forces a doc touch.
-	statsCounted := []string{SOLVED_PROBLEM, WORKING_ON_PROBLEM, WATCHING_VIDEO}
+	statsCounted := []string{SOLVED_PROBLEM, WORKING_ON_PROBLEM, WATCHING_VIDEO, ACHIEVEMENT_EARNED}
 	assertSetAnchor(t, doc, "stats_counted_event_types", anchors["stats_counted_event_types"], statsCounted)
 
+	// The per-topic fold reads its own set (statistics_topics.go).
+	assertSetAnchor(t, doc, "topic_stats_event_types", anchors["topic_stats_event_types"], topicStatsEventTypes)
+
 	assertIntAnchor(t, doc, "compress_max_chunk_size", anchors["compress_max_chunk_size"], maxChunkSize)
 }
 
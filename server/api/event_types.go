@@ -19,6 +19,7 @@ const (
 	SET_GAMESTATE_TARGET       = "set_gamestate_target"       // uint32 Target num problems
 	BAD_PROBLEM_SYSTEM         = "bad_problem_system"         // int ProblemID
 	BAD_PROBLEM_USER           = "bad_problem_user"           // int ProblemID
+	ACHIEVEMENT_EARNED         = "achievement_earned"         // string Achievement key (server-emitted)
 	// -end- EventTypes
 )
 
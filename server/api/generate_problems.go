@@ -5,13 +5,10 @@
 package api // import "garydmenezes.com/mathgame/server/api"
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"hash/fnv"
 	"net/http"
-	"strconv"
-	"strings"
-	"sync"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
@@ -30,7 +27,7 @@ const (
 	recentProblemHistorySize = recencyWindow
 
 	// minSelectionPool is the smallest healthy candidate-pool size; below
-	// this we trigger background generation (non-blocking) to refill.
+	// this we queue background generation (non-blocking) to refill.
 	minSelectionPool = 2 * recencyWindow
 
 	// recentlyShownProblemsTrimSize is the max rows per user retained in the
@@ -48,87 +45,52 @@ const (
 	problemSelectionEpsilon = 1.5
 )
 
-// formatUintsForSQLIn formats a slice of unsigned integers as "1,2,3" for use in a SQL "IN (...)" clause.
-func formatUintsForSQLIn[T ~uint32 | ~uint64](vals []T) string {
-	parts := make([]string, len(vals))
-	for i, v := range vals {
-		parts[i] = strconv.FormatUint(uint64(v), 10)
-	}
-	return strings.Join(parts, ",")
-}
-
 // Get all problem ids that satisfy this ProblemTypeBitmap and have similar
-// Difficulty. Bitwise-subset selection: a problem matches iff every bit it
-// carries is enabled in the user's settings -
-// (problem_type_bitmap & ~enabled) = 0.
+// Difficulty, from the candidate index (candidate_index.go). Bitwise-subset
+// selection: a problem matches iff every bit it carries is enabled in the
+// user's settings - (problem_type_bitmap & ~enabled) = 0.
 //
-// problem_type_bitmap != 0 is defense-in-depth: a zero bitmap is a subset of
-// everything, so an unstamped row would be served to every user. The
-// backfill census flags such rows for review; this clause keeps them out of
-// selection regardless.
+// A zero bitmap is a subset of everything, so an unstamped row would be
+// served to every user. The backfill census flags such rows for review; the
+// index never holds them, which keeps them out of selection regardless.
 //
 // An enabled bit means that feature MAY be served, never that it MUST be.
+//
+// Of the matches, only the highest-ranked generator version present is
+// returned (see generatorRank), so selection prefers newer generators and
+// falls back to an older version only when no newer version matches the
+// envelope + difficulty window.
 func (a *Api) getSatisfyingProblemIds(logPrefix string, settings *Settings, prevIds *[]uint32) (*[]uint32, error) {
-	diffLowerBound := settings.TargetDifficulty - problemSelectionEpsilon
-	diffUpperBound := settings.TargetDifficulty + problemSelectionEpsilon
-	clause := fmt.Sprintf("(problem_type_bitmap & ~%d) = 0 AND problem_type_bitmap != 0 AND difficulty >= %g AND difficulty <= %g AND disabled=0",
-		settings.ProblemTypeBitmap,
-		diffLowerBound,
-		diffUpperBound,
-	)
-	if len(*prevIds) > 0 {
-		clause = fmt.Sprintf("id NOT IN (%s) AND ", formatUintsForSQLIn(*prevIds)) + clause
-	}
-	return a.newestVersionTier(logPrefix, clause)
-}
-
-// newestVersionTier runs the satisfying-set query for whereClause and returns
-// the ids of the highest-ranked generator version present (see generatorRank),
-// so selection prefers newer generators and falls back to an older version only
-// when no newer version matches the envelope + difficulty window.
-func (a *Api) newestVersionTier(logPrefix string, whereClause string) (*[]uint32, error) {
-	query := "SELECT id, generator FROM problems WHERE " + whereClause
-	glog.Infof("%s newestVersionTier: %s", logPrefix, query)
-	rows, err := a.DB.Query(query)
+	ids, err := a.lookupCandidates(logPrefix, settings.ProblemTypeBitmap,
+		settings.TargetDifficulty-problemSelectionEpsilon,
+		settings.TargetDifficulty+problemSelectionEpsilon,
+		*prevIds)
 	if err != nil {
-		glog.Errorf("%s newestVersionTier query: %v", logPrefix, err)
+		glog.Errorf("%s lookupCandidates: %v", logPrefix, err)
 		return nil, err
 	}
-	defer rows.Close()
-
-	byRank := map[int][]uint32{}
-	bestRank := 0
-	haveBest := false
-	for rows.Next() {
-		var id uint32
-		var generator string
-		if err := rows.Scan(&id, &generator); err != nil {
-			continue
-		}
-		r := generatorRank[generator]
-		byRank[r] = append(byRank[r], id)
-		if !haveBest || r > bestRank {
-			bestRank = r
-			haveBest = true
-		}
-	}
-	ids := byRank[bestRank]
-	if ids == nil {
-		ids = []uint32{}
-	}
 	return &ids, nil
 }
 
 func (a *Api) selectProblem(logPrefix string, c *gin.Context, settings *Settings, prevIds *[]uint32) (*Problem, error) {
-	// Check spaced repetition review queue first
+	// A parent's open assignment comes before everything else
+	if p := a.nextAssignmentProblem(logPrefix, settings.UserId); p != nil {
+		return p, nil
+	}
+
+	// Then the spaced repetition review queue
 	dueReviewID := a.getDueReviewProblem(logPrefix, settings)
 	if dueReviewID != 0 {
 		p, status, msg, err := a.problemManager.Get(dueReviewID)
 		if err == nil && status == http.StatusOK && !p.Disabled {
 			glog.Infof("%s serving spaced rep review problem=%d", logPrefix, dueReviewID)
+			reviewQueueLookups.Inc("hit")
 			return p, nil
 		}
 		glog.Infof("%s spaced rep problem=%d unavailable: %s %v", logPrefix, dueReviewID, msg, err)
+		reviewQueueLookups.Inc("unavailable")
+	} else {
+		reviewQueueLookups.Inc("miss")
 	}
 
 	pids, err := a.getSatisfyingProblemIds(logPrefix, settings, prevIds)
@@ -136,13 +98,15 @@ func (a *Api) selectProblem(logPrefix string, c *gin.Context, settings *Settings
 		return nil, err
 	}
 
+	selectionPoolSize.Observe(float64(len(*pids)))
 	if len(*pids) < minSelectionPool {
-		glog.Infof("%s generating new problems because there are only %d problems", logPrefix, len(*pids))
-		a.generateProblemsBackground(logPrefix, settings)
+		glog.Infof("%s queueing generation because there are only %d problems", logPrefix, len(*pids))
+		a.enqueueGeneration(logPrefix, settings)
 	}
 
 	if len(*pids) > 0 {
-		pid := a.pickWithRecencyBias(logPrefix, settings.UserId, *pids)
+		pool := a.interleaveTopics(logPrefix, settings, *pids)
+		pid := a.pickWithRecencyBias(logPrefix, settings.UserId, pool)
 		p, status, msg, err := a.problemManager.Get(pid)
 		if HandleMngrResp(logPrefix, c, status, msg, err, p) != nil {
 			glog.Infof("%s unexpected (recoverable) error fetching problem (id=%d): %s : %s", logPrefix, pid, msg, err)
@@ -151,8 +115,8 @@ func (a *Api) selectProblem(logPrefix string, c *gin.Context, settings *Settings
 		}
 	}
 
-	// Pool is empty. The LLM backfill was already kicked off above via
-	// generateProblemsBackground; we don't want the user waiting on an
+	// Pool is empty. The LLM backfill was already queued above via
+	// enqueueGeneration; we don't want the user waiting on an
 	// OpenAI request here. Serve a heuristic-generated problem synchronously
 	// so they see something immediately. The LLM backfill fills the pool for
 	// subsequent requests.
@@ -185,56 +149,15 @@ func (a *Api) generateProblem(logPrefix string, settings *Settings) (*Problem, e
 	return nil, err
 }
 
-// backgroundGenLocks dedups concurrent background problem generations per
-// user. Multiple rapid events (e.g., the 500ms working_on_problem ticker)
-// can trigger generateProblemsBackground many times over a single slow LLM
-// round-trip; without this guard, we stack up goroutines all trying to
-// insert similar problems and pounding the DB + OpenAI quota.
-//
-// Key: user_id (uint32). Value: *sync.Mutex. TryLock wins the right to run;
-// losers log and skip. The mutex is released in the goroutine's defer.
-var backgroundGenLocks sync.Map
-
-// backgroundGenFn is swappable for tests.
-var backgroundGenFn = func(a *Api, logPrefix string, settings *Settings, numProblems int) {
-	a.generateProblems(logPrefix, settings, numProblems)
-}
-
 // llmGenerateProblemFn and llmValidateProblemFn are seams for the LLM
 // problem-generation and validation calls. Production points them at
-// llm_generator.GenerateProblem / ValidateWordProblem; tests override them
+// llm_generator.GenerateProblemContext / ValidateWordProblemContext; tests override them
 // to return canned problems and validation outcomes without hitting OpenAI.
 var (
-	llmGenerateProblemFn = llm_generator.GenerateProblem
-	llmValidateProblemFn = llm_generator.ValidateWordProblem
+	llmGenerateProblemFn = llm_generator.GenerateProblemContext
+	llmValidateProblemFn = llm_generator.ValidateWordProblemContext
 )
 
-func (a *Api) generateProblemsBackground(logPrefix string, settings *Settings) error {
-	userID := settings.UserId
-	muAny, _ := backgroundGenLocks.LoadOrStore(userID, &sync.Mutex{})
-	mu := muAny.(*sync.Mutex)
-	if !mu.TryLock() {
-		glog.Infof("%s background generation already running for user=%d; skipping", logPrefix, userID)
-		return nil
-	}
-
-	// Detach from the request: make a local copy so the goroutine can't see
-	// mutations the main request might make to settings after returning.
-	settingsCopy := *settings
-
-	go func() {
-		defer mu.Unlock()
-		defer func() {
-			if r := recover(); r != nil {
-				glog.Errorf("%s background generation panicked: %v", logPrefix, r)
-			}
-		}()
-		backgroundGenFn(a, logPrefix, &settingsCopy, 20)
-	}()
-
-	return nil
-}
-
 // runHeuristicGenerator generates problems using the heuristic generator.
 // Supports ADDITION, SUBTRACTION, MULTIPLICATION, DIVISION, FRACTIONS, NEGATIVES.
 // WORD problems should be generated via the LLM generator instead.
@@ -286,7 +209,7 @@ func (a *Api) runHeuristicGenerator(logPrefix string, settings *Settings, numPro
 		MaxChainLen:      mathcore.MaxChainLen,
 		SameDenomOnly:    (mathcore.MISMATCHED_DENOMINATORS & problemType) == 0,
 	}
-	funnel := newGenerationFunnel(numProblems)
+	funnel := newGenerationFunnel(heuristic_generator.VERSION, numProblems)
 	for i := 0; i < numProblems; i++ {
 		expr, answer, _, err := heuristic_generator.GenerateProblem(generatorOpts)
 		if err != nil {
@@ -351,11 +274,13 @@ func (a *Api) runHeuristicGenerator(logPrefix string, settings *Settings, numPro
 			glog.Errorf("%s could not create heuristic problem (%d: %s): %v", logPrefix, status, msg, err)
 			continue
 		}
+		a.candidates.put(model)
 		funnel.inserted++
 		newCount++
 		newProblem = model
 	}
 	glog.Infof("%s heuristic %s", logPrefix, funnel)
+	funnel.observe()
 	return newProblem, newCount, uniqueIds
 }
 
@@ -366,6 +291,13 @@ func (a *Api) runHeuristicGenerator(logPrefix string, settings *Settings, numPro
 // writes to a stale context corrupt unrelated in-flight responses).
 // Errors are logged via glog; callers decide how to handle a nil return.
 func (a *Api) generateProblems(logPrefix string, settings *Settings, numProblems int) (*Problem, error) {
+	return a.generateProblemsContext(context.Background(), logPrefix, settings, numProblems)
+}
+
+// generateProblemsContext is generateProblems with ctx bounding the LLM
+// calls. A cancelled ctx (apiserver shutdown) abandons the batch rather than
+// falling back to the heuristic generator.
+func (a *Api) generateProblemsContext(ctx context.Context, logPrefix string, settings *Settings, numProblems int) (*Problem, error) {
 	var model *Problem
 	var newProblem *Problem
 	if settings.ProblemTypeBitmap == 0 {
@@ -389,7 +321,11 @@ func (a *Api) generateProblems(logPrefix string, settings *Settings, numProblems
 		}
 		var err error
 		var generatorProblems []llm_generator.Problem
-		generatorProblems, err = llmGenerateProblemFn(generatorOpts)
+		generatorProblems, err = llmGenerateProblemFn(ctx, generatorOpts)
+		if err != nil && ctx.Err() != nil {
+			glog.Infof("%s generation abandoned: %v", logPrefix, ctx.Err())
+			return nil, ctx.Err()
+		}
 		if err != nil {
 			// Fall back to heuristic when OpenAI fails. Strip WORD since the
 			// heuristic doesn't produce word problems, and fall back on the
@@ -404,7 +340,7 @@ func (a *Api) generateProblems(logPrefix string, settings *Settings, numProblems
 				return nil, err
 			}
 		} else {
-			funnel := newGenerationFunnel(numProblems)
+			funnel := newGenerationFunnel(llm_generator.VERSION, numProblems)
 			funnel.returned = len(generatorProblems)
 			for _, p := range generatorProblems {
 				glog.Infof("%s generated problem: %v", logPrefix, p)
@@ -437,7 +373,7 @@ func (a *Api) generateProblems(logPrefix string, settings *Settings, numProblems
 						continue
 					}
 				} else {
-					features, err := llmValidateProblemFn(&p, constraints, mathcore.ValidatorFeatureNames)
+					features, err := llmValidateProblemFn(ctx, &p, constraints, mathcore.ValidatorFeatureNames)
 					if err != nil {
 						funnel.reject(rejectValidator)
 						glog.Infof("%s LLM validator reject: %v", logPrefix, err)
@@ -527,11 +463,13 @@ func (a *Api) generateProblems(logPrefix string, settings *Settings, numProblems
 					model = nil
 					continue
 				}
+				a.candidates.put(model)
 				funnel.inserted++
 				newCount += 1
 				newProblem = model
 			}
 			glog.Infof("%s LLM %s", logPrefix, funnel)
+			funnel.observe()
 		}
 	}
 
@@ -31,32 +31,49 @@ const (
 )
 
 // generationFunnel counts candidates through the admission pipeline for one
-// generation call. Logged as a single structured line.
+// generation call. Logged as a single structured line and added to
+// generationFunnelTotal by observe.
 type generationFunnel struct {
+	generator string // the Problem.Generator the candidates would be stamped with
 	requested int
 	returned  int
 	rejects   map[string]int
 	inserted  int
 }
 
-func newGenerationFunnel(requested int) *generationFunnel {
-	return &generationFunnel{requested: requested, rejects: map[string]int{}}
+func newGenerationFunnel(generator string, requested int) *generationFunnel {
+	return &generationFunnel{generator: generator, requested: requested, rejects: map[string]int{}}
 }
 
 func (f *generationFunnel) reject(stage string) { f.rejects[stage]++ }
 
+// funnelRejectStages is every reject stage, in pipeline order.
+var funnelRejectStages = []string{mathcore.RejectLexer, mathcore.RejectUnknownRules,
+	rejectCollision, rejectAnswer, rejectEnvelope, rejectValidator, rejectCreate}
+
 // String renders the funnel as one grep-able line.
 func (f *generationFunnel) String() string {
 	var b strings.Builder
 	fmt.Fprintf(&b, "funnel: requested=%d returned=%d", f.requested, f.returned)
-	for _, stage := range []string{mathcore.RejectLexer, mathcore.RejectUnknownRules,
-		rejectCollision, rejectAnswer, rejectEnvelope, rejectValidator, rejectCreate} {
+	for _, stage := range funnelRejectStages {
 		fmt.Fprintf(&b, " %s=%d", stage, f.rejects[stage])
 	}
 	fmt.Fprintf(&b, " inserted=%d", f.inserted)
 	return b.String()
 }
 
+// observe adds the finished funnel to generationFunnelTotal, so the
+// requested, returned, inserted and per-stage reject counts accumulate by
+// generator across calls.
+func (f *generationFunnel) observe() {
+	generationFunnelTotal.Add(float64(f.requested), f.generator, "requested")
+	generationFunnelTotal.Add(float64(f.returned), f.generator, "returned")
+	for _, stage := range funnelRejectStages {
+		generationFunnelTotal.Add(float64(f.rejects[stage]), f.generator, stage)
+	}
+	generationFunnelTotal.Add(float64(f.inserted), f.generator, "inserted")
+}
+
 // RewriteLetterInProse replaces standalone occurrences of a rewritten
 // variable letter in prose (explanations) with '?', keeping the explanation
 // consistent with a stage-1.5-rewritten expression. Best-effort; rewritten
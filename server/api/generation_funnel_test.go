@@ -10,7 +10,7 @@ import (
 // TestGenerationFunnel_NoSilentDrops: every reject lands in a named stage and
 // the funnel line accounts for all of them.
 func TestGenerationFunnel_NoSilentDrops(t *testing.T) {
-	f := newGenerationFunnel(10)
+	f := newGenerationFunnel("test", 10)
 	f.returned = 8
 	f.reject(mathcore.RejectLexer)
 	f.reject(rejectAnswer)
@@ -23,6 +23,14 @@ func TestGenerationFunnel_NoSilentDrops(t *testing.T) {
 			t.Errorf("funnel line missing %q: %s", want, line)
 		}
 	}
+	before := generationFunnelTotal.Value("test", rejectAnswer)
+	f.observe()
+	if got := generationFunnelTotal.Value("test", rejectAnswer) - before; got != 2 {
+		t.Errorf("observe: want 2 answer rejects counted, got %v", got)
+	}
+	if got := generationFunnelTotal.Value("test", "inserted"); got < 5 {
+		t.Errorf("observe: want the inserted count, got %v", got)
+	}
 }
 
 // TestVerifyAnswer covers the exported answer check used by tooling: a form
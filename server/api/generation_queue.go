@@ -0,0 +1,495 @@
+// generation_queue.go: the durable queue for background problem generation.
+//
+// When the selection pool runs short (selectProblem) or a user changes their
+// envelope (SET_PROBLEM_TYPE_BITMAP), enqueueGeneration records a job in
+// generation_jobs keyed by the envelope bitmap and difficulty band, so users
+// sharing an envelope share one job and a restart loses nothing. A fixed pool
+// of workers, started by the apiserver (StartGenerationWorkers), claims due
+// jobs, runs one generateProblemsContext batch each, and retries failures
+// with exponential backoff up to generationJobMaxAttempts. The workers share
+// a budget of LLM batches per hour. Workers run as tracked background work
+// (background.go), so shutdown stops claiming and a cancelled job goes back to
+// the queue. GET /admin/generation-jobs lists the queue. Part of the selection
+// system - documented in docs/selection.md.
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// Job statuses. A job is live (holds its dedup_key) while queued or running.
+const (
+	JOB_QUEUED  = "queued"
+	JOB_RUNNING = "running"
+	JOB_DONE    = "done"
+	JOB_FAILED  = "failed"
+)
+
+const (
+	// generationBandWidth is the width of a difficulty band: targets that
+	// round to the same multiple share a job, generated at that multiple.
+	generationBandWidth = 1.0
+	// generationJobSize is the problems requested per job (one LLM batch).
+	generationJobSize = 20
+	// generationWorkers caps concurrent jobs.
+	generationWorkers = 2
+	// generationJobMaxAttempts is how many runs a job gets before it fails.
+	generationJobMaxAttempts = 4
+	// generationRetryBackoff is the wait before the first retry, doubling
+	// with each attempt after that.
+	generationRetryBackoff = 30 * time.Second
+	// generationPollInterval is how often an idle worker checks for due
+	// jobs (retries come due without an enqueue to wake it).
+	generationPollInterval = 15 * time.Second
+	// llmBatchesPerHour caps jobs started per hour across workers, bounding
+	// LLM spend. The window is in memory and resets on restart.
+	llmBatchesPerHour = 60
+	// generationPendingMemory is how long enqueueGeneration trusts its own
+	// record of a queued key before writing to generation_jobs again; a job
+	// another process finished is noticed within this long.
+	generationPendingMemory = time.Minute
+	// maxGenerationJobList caps GET /admin/generation-jobs.
+	maxGenerationJobList = 200
+)
+
+// GenerationJob is a generation_jobs row.
+type GenerationJob struct {
+	Id                uint64     `json:"id"`
+	ProblemTypeBitmap uint64     `json:"problem_type_bitmap"`
+	DifficultyBand    int        `json:"difficulty_band"`
+	TargetDifficulty  float64    `json:"target_difficulty"`
+	NumProblems       int        `json:"num_problems"`
+	Status            string     `json:"status"`
+	RequestedBy       *uint32    `json:"requested_by"`
+	Requests          int        `json:"requests"`
+	Attempts          int        `json:"attempts"`
+	RunAfter          time.Time  `json:"run_after"`
+	LastError         string     `json:"last_error"`
+	CreatedAt         time.Time  `json:"created_at"`
+	StartedAt         *time.Time `json:"started_at"`
+	FinishedAt        *time.Time `json:"finished_at"`
+}
+
+// GenerationQueue is the GET /admin/generation-jobs payload.
+type GenerationQueue struct {
+	Counts map[string]int  `json:"counts"` // jobs per status
+	Jobs   []GenerationJob `json:"jobs"`
+}
+
+// generationJobFn runs one job's batch; swappable for tests.
+var generationJobFn = func(ctx context.Context, a *Api, logPrefix string, settings *Settings, numProblems int) error {
+	_, err := a.generateProblemsContext(ctx, logPrefix, settings, numProblems)
+	return err
+}
+
+// generationQueue is the workers' shared in-process state. The zero value
+// is usable; wake is made by NewApi.
+type generationQueue struct {
+	wake    chan struct{} // nudges an idle worker after an enqueue
+	spend   spendLimiter
+	pending pendingKeys
+}
+
+// pendingKeys is the dedup keys this process queued recently, so a pool that
+// stays short across serves doesn't write to generation_jobs on every one.
+type pendingKeys struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+// claim reports whether key should be written, remembering it for
+// generationPendingMemory if so.
+func (p *pendingKeys) claim(key string, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Before(p.until[key]) {
+		return false
+	}
+	if p.until == nil {
+		p.until = map[string]time.Time{}
+	}
+	for k, t := range p.until {
+		if !now.Before(t) {
+			delete(p.until, k)
+		}
+	}
+	p.until[key] = now.Add(generationPendingMemory)
+	return true
+}
+
+// forget drops key, once its job is no longer pending.
+func (p *pendingKeys) forget(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.until, key)
+}
+
+// spendLimiter is a sliding one-hour window of job starts.
+type spendLimiter struct {
+	mu     sync.Mutex
+	starts []time.Time
+}
+
+// reserve takes a slot at now if fewer than llmBatchesPerHour were taken in
+// the hour before it, else reports when the oldest slot frees. now is the
+// slot's token for refund.
+func (l *spendLimiter) reserve(now time.Time) (bool, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(l.starts) && !l.starts[i].After(cutoff) {
+		i++
+	}
+	l.starts = l.starts[i:]
+	if len(l.starts) >= llmBatchesPerHour {
+		return false, l.starts[0].Add(time.Hour)
+	}
+	// Keep starts sorted: another worker may have reserved a later now
+	// first.
+	j := sort.Search(len(l.starts), func(k int) bool { return l.starts[k].After(now) })
+	l.starts = append(l.starts, time.Time{})
+	copy(l.starts[j+1:], l.starts[j:])
+	l.starts[j] = now
+	return true, time.Time{}
+}
+
+// refund returns the slot reserved at slot, for a reservation no job used.
+// Other workers' slots are left alone.
+func (l *spendLimiter) refund(slot time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, t := range l.starts {
+		if t.Equal(slot) {
+			l.starts = append(l.starts[:i], l.starts[i+1:]...)
+			return
+		}
+	}
+}
+
+// difficultyBand maps a target difficulty to its band.
+func difficultyBand(target float64) int {
+	return int(math.Round(target / generationBandWidth))
+}
+
+// bandTarget is the target difficulty a band's jobs generate at.
+func bandTarget(band int) float64 {
+	return float64(band) * generationBandWidth
+}
+
+// generationDedupKey is a live job's dedup_key: its bitmap and band.
+func generationDedupKey(bitmap uint64, band int) string {
+	return fmt.Sprintf("%d:%d", bitmap, band)
+}
+
+// generationRetryDelay is the backoff after a job's attempts-th failed run.
+func generationRetryDelay(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	return generationRetryBackoff << (attempts - 1)
+}
+
+// enqueueGeneration queues a batch for settings' envelope and band, or counts
+// another request against the live job already queued for them. A key this
+// process queued in the last generationPendingMemory is taken as still
+// pending and not written again.
+func (a *Api) enqueueGeneration(logPrefix string, settings *Settings) {
+	band := difficultyBand(settings.TargetDifficulty)
+	key := generationDedupKey(settings.ProblemTypeBitmap, band)
+	if !a.generation.pending.claim(key, time.Now()) {
+		backgroundGenerations.Inc("deduplicated")
+		return
+	}
+	queued, err := a.insertGenerationJob(settings.ProblemTypeBitmap, band, settings.UserId)
+	if err != nil {
+		a.generation.pending.forget(key)
+		glog.Errorf("%s enqueue generation %d:%d: %v", logPrefix, settings.ProblemTypeBitmap, band, err)
+		return
+	}
+	if queued {
+		glog.Infof("%s queued generation for bitmap:band=%d:%d", logPrefix, settings.ProblemTypeBitmap, band)
+		backgroundGenerations.Inc("enqueued")
+	} else {
+		backgroundGenerations.Inc("deduplicated")
+	}
+	select {
+	case a.generation.wake <- struct{}{}:
+	default:
+	}
+}
+
+// insertGenerationJob queues a job for bitmap and band, or bumps requests on
+// the live one. It reports whether a new job was queued. requestedBy is the
+// user id, or nil for a job nobody asked for (the pre-warm).
+func (a *Api) insertGenerationJob(bitmap uint64, band int, requestedBy interface{}) (bool, error) {
+	res, err := a.DB.Exec(`
+		INSERT INTO generation_jobs (problem_type_bitmap, difficulty_band, num_problems, dedup_key, requested_by)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE requests = requests + 1`,
+		bitmap, band, generationJobSize, generationDedupKey(bitmap, band), requestedBy)
+	if err != nil {
+		return false, err
+	}
+	// MySQL reports 1 row affected for an insert and 2 for an update.
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// liveGenerationJob returns the queued or running job for bitmap and band,
+// or nil when there is none.
+func (a *Api) liveGenerationJob(bitmap uint64, band int) (*GenerationJob, error) {
+	j := &GenerationJob{ProblemTypeBitmap: bitmap, DifficultyBand: band, TargetDifficulty: bandTarget(band)}
+	err := a.DB.QueryRow(`
+		SELECT id, num_problems, status, requests, attempts, run_after, last_error, created_at
+		FROM generation_jobs WHERE dedup_key = ?`, generationDedupKey(bitmap, band)).
+		Scan(&j.Id, &j.NumProblems, &j.Status, &j.Requests, &j.Attempts, &j.RunAfter, &j.LastError, &j.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("live job %d:%d: %w", bitmap, band, err)
+	}
+	return j, nil
+}
+
+// StartGenerationWorkers requeues jobs a previous process left running and
+// starts the workers. Called once by the apiserver, after migrations.
+func (a *Api) StartGenerationWorkers() error {
+	if _, err := a.DB.Exec(`
+		UPDATE generation_jobs
+		SET status = IF(attempts >= ?, ?, ?),
+		    dedup_key = IF(attempts >= ?, NULL, dedup_key),
+		    finished_at = IF(attempts >= ?, NOW(), NULL),
+		    last_error = 'interrupted by restart'
+		WHERE status = ?`,
+		generationJobMaxAttempts, JOB_FAILED, JOB_QUEUED,
+		generationJobMaxAttempts, generationJobMaxAttempts, JOB_RUNNING); err != nil {
+		return fmt.Errorf("requeue interrupted generation jobs: %w", err)
+	}
+	for i := 1; i <= generationWorkers; i++ {
+		logPrefix := fmt.Sprintf("[generation-worker-%d]", i)
+		a.goBackground(logPrefix, "generation-worker", func(ctx context.Context) {
+			a.generationWorker(ctx, logPrefix)
+		})
+	}
+	return nil
+}
+
+// generationWorker runs due jobs until shutdown begins, waiting between them
+// for an enqueue, the poll interval or the spend window to free a slot.
+func (a *Api) generationWorker(ctx context.Context, logPrefix string) {
+	for {
+		wait := generationPollInterval
+		slot := time.Now()
+		if ok, retryAt := a.generation.spend.reserve(slot); !ok {
+			wait = time.Until(retryAt)
+			glog.Infof("%s LLM budget of %d batches/hour spent; next slot in %s", logPrefix, llmBatchesPerHour, wait)
+		} else {
+			ran, err := a.runNextGenerationJob(ctx, logPrefix)
+			if err != nil {
+				glog.Errorf("%s %v", logPrefix, err)
+			}
+			if ran {
+				continue
+			}
+			a.generation.spend.refund(slot)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.background.draining():
+			return
+		case <-a.generation.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// runNextGenerationJob claims the oldest due job, runs it and records the
+// outcome. It reports whether a job was claimed.
+func (a *Api) runNextGenerationJob(ctx context.Context, logPrefix string) (bool, error) {
+	job, err := a.claimGenerationJob(ctx)
+	if err != nil || job == nil {
+		return false, err
+	}
+	prefix := fmt.Sprintf("%s [job %d]", logPrefix, job.Id)
+	settings := &Settings{ProblemTypeBitmap: job.ProblemTypeBitmap, TargetDifficulty: bandTarget(job.DifficultyBand)}
+	glog.Infof("%s generating %d for bitmap=%d band=%d (attempt %d)", prefix, job.NumProblems, job.ProblemTypeBitmap, job.DifficultyBand, job.Attempts)
+	runErr := generationJobFn(ctx, a, prefix, settings, job.NumProblems)
+
+	// Record the outcome even when ctx was cancelled mid-run.
+	finishCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var result string
+	switch {
+	case ctx.Err() != nil:
+		result = "released"
+		_, err = a.DB.ExecContext(finishCtx, `
+			UPDATE generation_jobs SET status = ?, attempts = attempts - 1, run_after = NOW()
+			WHERE id = ?`, JOB_QUEUED, job.Id)
+	case runErr == nil:
+		result = JOB_DONE
+		_, err = a.DB.ExecContext(finishCtx, `
+			UPDATE generation_jobs SET status = ?, dedup_key = NULL, finished_at = NOW(), last_error = ''
+			WHERE id = ?`, JOB_DONE, job.Id)
+	case job.Attempts >= generationJobMaxAttempts:
+		result = JOB_FAILED
+		_, err = a.DB.ExecContext(finishCtx, `
+			UPDATE generation_jobs SET status = ?, dedup_key = NULL, finished_at = NOW(), last_error = LEFT(?, 512)
+			WHERE id = ?`, JOB_FAILED, runErr.Error(), job.Id)
+	default:
+		result = "retry"
+		_, err = a.DB.ExecContext(finishCtx, `
+			UPDATE generation_jobs SET status = ?, run_after = NOW() + INTERVAL ? SECOND, last_error = LEFT(?, 512)
+			WHERE id = ?`, JOB_QUEUED, int(generationRetryDelay(job.Attempts).Seconds()), runErr.Error(), job.Id)
+	}
+	generationJobsTotal.Inc(result)
+	if runErr != nil {
+		glog.Infof("%s %s: %v", prefix, result, runErr)
+	}
+	if err != nil {
+		return true, fmt.Errorf("record job %d %s: %w", job.Id, result, err)
+	}
+	if result == JOB_DONE || result == JOB_FAILED {
+		// The key is free; the next short pool queues a new job.
+		a.generation.pending.forget(generationDedupKey(job.ProblemTypeBitmap, job.DifficultyBand))
+	}
+	return true, nil
+}
+
+// claimGenerationJob marks the oldest due queued job running and returns it,
+// or nil when none is due.
+func (a *Api) claimGenerationJob(ctx context.Context) (*GenerationJob, error) {
+	tx, err := a.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+	defer tx.Rollback()
+	job := &GenerationJob{}
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, problem_type_bitmap, difficulty_band, num_problems, attempts
+		FROM generation_jobs
+		WHERE status = ? AND run_after <= NOW()
+		ORDER BY run_after, id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`, JOB_QUEUED).Scan(&job.Id, &job.ProblemTypeBitmap, &job.DifficultyBand, &job.NumProblems, &job.Attempts)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("claim job: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE generation_jobs SET status = ?, attempts = attempts + 1, started_at = NOW()
+		WHERE id = ?`, JOB_RUNNING, job.Id); err != nil {
+		return nil, fmt.Errorf("claim job %d: %w", job.Id, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("claim job %d: %w", job.Id, err)
+	}
+	job.Status = JOB_RUNNING
+	job.Attempts++
+	return job, nil
+}
+
+// listGenerationJobs returns per-status counts and the newest jobs, filtered
+// to status when it's set.
+func (a *Api) listGenerationJobs(status string, limit int) (*GenerationQueue, error) {
+	q := &GenerationQueue{Counts: map[string]int{}, Jobs: []GenerationJob{}}
+	rows, err := a.DB.Query("SELECT status, COUNT(*) FROM generation_jobs GROUP BY status")
+	if err != nil {
+		return nil, fmt.Errorf("count jobs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s string
+		var n int
+		if err := rows.Scan(&s, &n); err != nil {
+			return nil, fmt.Errorf("scan count: %w", err)
+		}
+		q.Counts[s] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	where, args := "", []interface{}{}
+	if status != "" {
+		where, args = "WHERE status = ?", append(args, status)
+	}
+	rows, err = a.DB.Query(`
+		SELECT id, problem_type_bitmap, difficulty_band, num_problems, status, requested_by, requests,
+		  attempts, run_after, last_error, created_at, started_at, finished_at
+		FROM generation_jobs `+where+`
+		ORDER BY id DESC
+		LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("list jobs: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var j GenerationJob
+		var requestedBy sql.NullInt64
+		var started, finished sql.NullTime
+		if err := rows.Scan(&j.Id, &j.ProblemTypeBitmap, &j.DifficultyBand, &j.NumProblems, &j.Status, &requestedBy,
+			&j.Requests, &j.Attempts, &j.RunAfter, &j.LastError, &j.CreatedAt, &started, &finished); err != nil {
+			return nil, fmt.Errorf("scan job: %w", err)
+		}
+		j.TargetDifficulty = bandTarget(j.DifficultyBand)
+		if requestedBy.Valid {
+			id := uint32(requestedBy.Int64)
+			j.RequestedBy = &id
+		}
+		if started.Valid {
+			j.StartedAt = &started.Time
+		}
+		if finished.Valid {
+			j.FinishedAt = &finished.Time
+		}
+		q.Jobs = append(q.Jobs, j)
+	}
+	return q, rows.Err()
+}
+
+// adminListGenerationJobs handles GET /admin/generation-jobs?status=&limit=.
+func (a *Api) adminListGenerationJobs(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	status := c.Query("status")
+	switch status {
+	case "", JOB_QUEUED, JOB_RUNNING, JOB_DONE, JOB_FAILED:
+	default:
+		c.JSON(http.StatusBadRequest, common.GetError("status must be queued, running, done or failed"))
+		return
+	}
+	limit := 50
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxGenerationJobList {
+			c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("limit must be 1 to %d", maxGenerationJobList)))
+			return
+		}
+		limit = n
+	}
+	q, err := a.listGenerationJobs(status, limit)
+	if err != nil {
+		glog.Errorf("%s listGenerationJobs: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list generation jobs"))
+		return
+	}
+	c.JSON(http.StatusOK, q)
+}
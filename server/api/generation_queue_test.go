@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestGenerationBandsAndBackoff(t *testing.T) {
+	for target, want := range map[float64]int{0: 0, 2.4: 2, 2.5: 3, 7.9: 8} {
+		if got := difficultyBand(target); got != want {
+			t.Errorf("difficultyBand(%v) = %d, want %d", target, got, want)
+		}
+	}
+	if bandTarget(3) != 3 {
+		t.Errorf("bandTarget(3) = %v", bandTarget(3))
+	}
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute} {
+		if got := generationRetryDelay(attempts); got != want {
+			t.Errorf("generationRetryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+// TestSpendLimiter: the window admits llmBatchesPerHour starts, then reports
+// when the oldest frees; a refunded slot is reusable.
+func TestSpendLimiter(t *testing.T) {
+	var l spendLimiter
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < llmBatchesPerHour; i++ {
+		if ok, _ := l.reserve(start.Add(time.Duration(i) * time.Second)); !ok {
+			t.Fatalf("reserve %d: want a slot", i)
+		}
+	}
+	ok, retryAt := l.reserve(start.Add(10 * time.Minute))
+	if ok || !retryAt.Equal(start.Add(time.Hour)) {
+		t.Errorf("full window: want no slot until %s, got %v %s", start.Add(time.Hour), ok, retryAt)
+	}
+	if ok, _ := l.reserve(start.Add(time.Hour + time.Second)); !ok {
+		t.Error("after the oldest expires: want a slot")
+	}
+	l.refund(start.Add(time.Hour + time.Second))
+	if ok, _ := l.reserve(start.Add(time.Hour + 2*time.Second)); !ok {
+		t.Error("after a refund: want a slot")
+	}
+
+	// A refund returns the refunding worker's own slot, even when another
+	// worker reserved after it.
+	var w spendLimiter
+	mine, theirs := start, start.Add(time.Second)
+	w.reserve(mine)
+	w.reserve(theirs)
+	w.refund(mine)
+	if len(w.starts) != 1 || !w.starts[0].Equal(theirs) {
+		t.Errorf("refund: want only the other worker's slot left, got %v", w.starts)
+	}
+}
+
+// TestPendingKeys: a claimed key isn't claimed again until it is forgotten or
+// generationPendingMemory passes.
+func TestPendingKeys(t *testing.T) {
+	var p pendingKeys
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	if !p.claim("3:4", now) {
+		t.Fatal("first claim: want it written")
+	}
+	if p.claim("3:4", now.Add(time.Second)) {
+		t.Error("pending key: want it skipped")
+	}
+	if !p.claim("3:6", now.Add(time.Second)) {
+		t.Error("another key: want it written")
+	}
+	if !p.claim("3:4", now.Add(generationPendingMemory)) {
+		t.Error("after generationPendingMemory: want it written again")
+	}
+	p.forget("3:6")
+	if !p.claim("3:6", now.Add(2*time.Second)) {
+		t.Error("forgotten key: want it written again")
+	}
+}
+
+// TestGenerationQueue checks dedup by envelope and band, retry with backoff,
+// completion freeing the dedup key, restart recovery and the admin listing.
+func TestGenerationQueue(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	alice := createTestUser(t, r, "auth0|queue-alice", "queuealice@test.com", "queuealice")
+	bob := createTestUser(t, r, "auth0|queue-bob", "queuebob@test.com", "queuebob")
+	admin := createTestUser(t, r, "auth0|queue-admin", "queueadmin@test.com", "queueadmin")
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE id=?", RoleAdmin, admin.Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+
+	var ran []*Settings
+	var fail error
+	original := generationJobFn
+	defer func() { generationJobFn = original }()
+	generationJobFn = func(ctx context.Context, a *Api, logPrefix string, settings *Settings, numProblems int) error {
+		ran = append(ran, settings)
+		return fail
+	}
+
+	api.enqueueGeneration("[test]", &Settings{UserId: alice.Id, ProblemTypeBitmap: 3, TargetDifficulty: 4.2})
+	api.enqueueGeneration("[test]", &Settings{UserId: bob.Id, ProblemTypeBitmap: 3, TargetDifficulty: 3.8})
+	api.enqueueGeneration("[test]", &Settings{UserId: bob.Id, ProblemTypeBitmap: 3, TargetDifficulty: 6})
+	q, err := api.listGenerationJobs("", 10)
+	if err != nil {
+		t.Fatalf("listGenerationJobs: %v", err)
+	}
+	if len(q.Jobs) != 2 || q.Counts[JOB_QUEUED] != 2 || q.Jobs[1].Requests != 1 || q.Jobs[1].DifficultyBand != 4 ||
+		q.Jobs[1].RequestedBy == nil || *q.Jobs[1].RequestedBy != alice.Id {
+		t.Fatalf("want the two band-4 requests merged into one job beside the band-6 job, got %+v", q)
+	}
+	// Past this process's record of the key, a request reaches the live job.
+	api.generation.pending.forget(generationDedupKey(3, 4))
+	api.enqueueGeneration("[test]", &Settings{UserId: bob.Id, ProblemTypeBitmap: 3, TargetDifficulty: 4})
+	if q, _ := api.listGenerationJobs("", 10); len(q.Jobs) != 2 || q.Jobs[1].Requests != 2 {
+		t.Errorf("want the request counted against the live band-4 job, got %+v", q)
+	}
+	first := q.Jobs[1].Id
+
+	fail = errors.New("openai down")
+	if ran, err := api.runNextGenerationJob(context.Background(), "[test]"); !ran || err != nil {
+		t.Fatalf("run: want a job run, got %v %v", ran, err)
+	}
+	if len(ran) != 1 || ran[0].ProblemTypeBitmap != 3 || ran[0].TargetDifficulty != 4 {
+		t.Fatalf("want the oldest job run at its band's target, got %+v", ran)
+	}
+	var status, lastError string
+	var attempts int
+	var deferred bool
+	if err := api.DB.QueryRow("SELECT status, attempts, last_error, run_after > NOW() FROM generation_jobs WHERE id = ?", first).
+		Scan(&status, &attempts, &lastError, &deferred); err != nil {
+		t.Fatalf("read job: %v", err)
+	}
+	if status != JOB_QUEUED || attempts != 1 || lastError != "openai down" || !deferred {
+		t.Errorf("failed run: want requeued with backoff, got %s attempts=%d %q deferred=%v", status, attempts, lastError, deferred)
+	}
+
+	// The retry isn't due yet, so the band-6 job runs next and completes.
+	fail = nil
+	if ran, err := api.runNextGenerationJob(context.Background(), "[test]"); !ran || err != nil {
+		t.Fatalf("run: want the band-6 job, got %v %v", ran, err)
+	}
+	if ran[1].TargetDifficulty != 6 {
+		t.Errorf("want the band-6 job, got %+v", ran[1])
+	}
+	if ran, err := api.runNextGenerationJob(context.Background(), "[test]"); ran || err != nil {
+		t.Errorf("nothing due: want no run, got %v %v", ran, err)
+	}
+	api.enqueueGeneration("[test]", &Settings{UserId: bob.Id, ProblemTypeBitmap: 3, TargetDifficulty: 6})
+	if q, _ := api.listGenerationJobs(JOB_QUEUED, 10); len(q.Jobs) != 2 {
+		t.Errorf("a finished job frees its key: want a new band-6 job queued, got %+v", q.Jobs)
+	}
+
+	// A job left running by a dead process is requeued on startup.
+	if _, err := api.DB.Exec("UPDATE generation_jobs SET status = ? WHERE id = ?", JOB_RUNNING, first); err != nil {
+		t.Fatalf("mark running: %v", err)
+	}
+	generationJobFn = func(ctx context.Context, a *Api, logPrefix string, settings *Settings, numProblems int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	if err := api.StartGenerationWorkers(); err != nil {
+		t.Fatalf("StartGenerationWorkers: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := api.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if err := api.DB.QueryRow("SELECT status, last_error FROM generation_jobs WHERE id = ?", first).Scan(&status, &lastError); err != nil {
+		t.Fatalf("read job: %v", err)
+	}
+	if status != JOB_QUEUED || lastError != "interrupted by restart" {
+		t.Errorf("restart: want the running job requeued, got %s %q", status, lastError)
+	}
+
+	resp := catalogRequest(t, r, admin, "GET", "/admin/generation-jobs?status=queued", "")
+	if resp.Code != http.StatusOK {
+		t.Errorf("admin list: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, admin, "GET", "/admin/generation-jobs?status=stuck", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("bad status: want 400, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, alice, "GET", "/admin/generation-jobs", ""); resp.Code != http.StatusForbidden {
+		t.Errorf("student listing jobs: want 403, got %d", resp.Code)
+	}
+}
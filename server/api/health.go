@@ -0,0 +1,83 @@
+// health.go: liveness and readiness probes for the apiserver.
+//
+// GET /healthz answers 200 while the process can reach MySQL; GET /readyz
+// additionally requires every embedded migration to be recorded in
+// schema_migrations, so a binary deployed ahead of its migrations reports
+// not-ready. Both are served ahead of the auth middleware and answer 503 with
+// the failing check's error when unhealthy. deploy/watchdog.sh probes /readyz.
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// healthCheckTimeout bounds each probe's database round trip.
+const healthCheckTimeout = 2 * time.Second
+
+// HealthStatus is the body of /healthz and /readyz. Checks maps each check
+// name to "ok" or its error.
+type HealthStatus struct {
+	Status string            `json:"status"` // "ok" or "unavailable"
+	Checks map[string]string `json:"checks"`
+}
+
+// checkDatabase pings MySQL.
+func (a *Api) checkDatabase(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	return a.DB.PingContext(ctx)
+}
+
+// checkMigrations errors if any embedded migration is unapplied.
+func (a *Api) checkMigrations() error {
+	pending, err := PendingMigrations(a.DB)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d pending migrations: %v", len(pending), pending)
+	}
+	return nil
+}
+
+// writeHealth runs checks in order, stopping at the first failure, and
+// writes the HealthStatus.
+func writeHealth(c *gin.Context, checks []string, run func(name string) error) {
+	res := HealthStatus{Status: "ok", Checks: map[string]string{}}
+	for _, name := range checks {
+		if err := run(name); err != nil {
+			glog.Warningf("%s health check %s failed: %v", common.GetLogPrefix(c), name, err)
+			res.Status = "unavailable"
+			res.Checks[name] = err.Error()
+			c.JSON(http.StatusServiceUnavailable, res)
+			return
+		}
+		res.Checks[name] = "ok"
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// getHealthz handles GET /healthz.
+func (a *Api) getHealthz(c *gin.Context) {
+	writeHealth(c, []string{"database"}, func(string) error {
+		return a.checkDatabase(c.Request.Context())
+	})
+}
+
+// getReadyz handles GET /readyz.
+func (a *Api) getReadyz(c *gin.Context) {
+	writeHealth(c, []string{"database", "migrations"}, func(name string) error {
+		if name == "database" {
+			return a.checkDatabase(c.Request.Context())
+		}
+		return a.checkMigrations()
+	})
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// TestMetricsAuthorized: with a token the scraper must present it; without
+// one only loopback callers are served.
+func TestMetricsAuthorized(t *testing.T) {
+	cases := []struct {
+		token, remote, auth string
+		want                bool
+	}{
+		{"", "127.0.0.1:5000", "", true},
+		{"", "[::1]:5000", "", true},
+		{"", "203.0.113.9:5000", "", false},
+		{"s3cret", "203.0.113.9:5000", "Bearer s3cret", true},
+		{"s3cret", "127.0.0.1:5000", "", false},
+		{"s3cret", "203.0.113.9:5000", "Bearer wrong", false},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/metrics", nil)
+		c.Request.RemoteAddr = tc.remote
+		if tc.auth != "" {
+			c.Request.Header.Set("Authorization", tc.auth)
+		}
+		a := &Api{metricsToken: tc.token}
+		if got := a.metricsAuthorized(c); got != tc.want {
+			t.Errorf("token=%q remote=%s auth=%q: want %v, got %v", tc.token, tc.remote, tc.auth, tc.want, got)
+		}
+	}
+}
+
+// TestHealthEndpoints checks the probes pass on a migrated database, readyz
+// fails once a migration is unrecorded, and /metrics reports request latency
+// by route pattern without a user token.
+func TestHealthEndpoints(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+
+	probe := func(path string) (int, HealthStatus) {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(resp, req)
+		var res HealthStatus
+		if err := json.Unmarshal(resp.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: decode %q: %v", path, resp.Body.String(), err)
+		}
+		return resp.Code, res
+	}
+	for _, path := range []string{"/healthz", "/readyz"} {
+		if code, res := probe(path); code != http.StatusOK || res.Status != "ok" || res.Checks["database"] != "ok" {
+			t.Errorf("%s: want 200 ok, got %d %+v", path, code, res)
+		}
+	}
+
+	versions, err := embeddedVersions()
+	if err != nil {
+		t.Fatalf("embeddedVersions: %v", err)
+	}
+	latest := versions[len(versions)-1]
+	if _, err := api.DB.Exec("DELETE FROM schema_migrations WHERE version = ?", strconv.Itoa(latest)); err != nil {
+		t.Fatalf("unrecord migration: %v", err)
+	}
+	if code, res := probe("/readyz"); code != http.StatusServiceUnavailable || !strings.Contains(res.Checks["migrations"], fmt.Sprintf("[%d]", latest)) {
+		t.Errorf("readyz with a pending migration: want 503 naming it, got %d %+v", code, res)
+	}
+	if code, _ := probe("/healthz"); code != http.StatusOK {
+		t.Errorf("healthz with a pending migration: want 200, got %d", code)
+	}
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	req.RemoteAddr = "127.0.0.1:5000"
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK || !strings.Contains(resp.Body.String(), `mathgame_http_request_duration_seconds_count{method="GET",route="/readyz",code="503"}`) {
+		t.Errorf("metrics: want the readyz 503 counted by route, got %d %s", resp.Code, resp.Body.String())
+	}
+	req.RemoteAddr = "203.0.113.9:5000"
+	resp = httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("metrics from a remote caller without a token: want 403, got %d", resp.Code)
+	}
+}
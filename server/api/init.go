@@ -56,16 +56,22 @@ var CREATE_TABLES_SQL = []string{
 }
 
 type Api struct {
-	DB               *sql.DB
-	YouTubeAPIKey    string
-	isTest           bool
-	userManager      *UserManager
-	videoManager     *VideoManager
-	problemManager   *ProblemManager
-	settingsManager  *SettingsManager
-	gamestateManager *GamestateManager
-	eventManager     *EventManager
-	playlistManager  *PlaylistManager
+	DB                *sql.DB
+	YouTubeAPIKey     string
+	metricsToken      string
+	isTest            bool
+	userManager       *UserManager
+	videoManager      *VideoManager
+	problemManager    *ProblemManager
+	settingsManager   *SettingsManager
+	gamestateManager  *GamestateManager
+	eventManager      *EventManager
+	playlistManager   *PlaylistManager
+	videoProviders    map[string]VideoProvider
+	background        backgroundJobs
+	generation        generationQueue
+	candidates        candidateIndex
+	classroomPolicies policyCache
 }
 
 func NewApi(db *sql.DB, cfg *common.Config) (*Api, error) {
@@ -83,7 +89,13 @@ func NewApi(db *sql.DB, cfg *common.Config) (*Api, error) {
 	a := &Api{DB: db}
 	if cfg != nil {
 		a.YouTubeAPIKey = cfg.YouTubeAPIKey
+		a.metricsToken = cfg.MetricsToken
 	}
+	providers, err := NewVideoProviders(cfg)
+	if err != nil {
+		return nil, err
+	}
+	a.videoProviders = providers
 	a.userManager = &UserManager{DB: db}
 	a.videoManager = &VideoManager{DB: db}
 	a.problemManager = &ProblemManager{DB: db}
@@ -91,6 +103,7 @@ func NewApi(db *sql.DB, cfg *common.Config) (*Api, error) {
 	a.gamestateManager = &GamestateManager{DB: db}
 	a.eventManager = &EventManager{DB: db}
 	a.playlistManager = &PlaylistManager{DB: db}
+	a.generation.wake = make(chan struct{}, 1)
 	return a, nil
 }
 
@@ -130,6 +143,19 @@ func (a *Api) GetRouter() *gin.Engine {
 
 	// Use our request id middleware
 	router.Use(common.RequestIdMiddleware())
+	router.Use(requestMetricsMiddleware())
+
+	// Probes and the metrics scrape are served ahead of the auth middleware:
+	// the watchdog and Prometheus don't hold a user token.
+	router.GET("/healthz", a.getHealthz)
+	router.GET("/readyz", a.getReadyz)
+	router.GET("/metrics", a.getMetrics)
+
+	// The local media library is served ahead of the auth middleware: <video>
+	// and <img> tags can't send a bearer token. A signed URL stands in for it.
+	if local, ok := a.videoProviders[PROVIDER_LOCAL].(*LocalMediaProvider); ok {
+		local.mount(router)
+	}
 
 	// Use our auth0 jwt middleware
 	if !a.isTest {
@@ -149,6 +175,20 @@ func (a *Api) GetRouter() *gin.Engine {
 		v1.GET("/pageload/:auth0_id", userMiddleware, a.customGetPageLoadData)
 		v1.GET("/play/:user_id", userMiddleware, a.customGetPlayData)
 		v1.GET("/statistics/:user_id", userMiddleware, a.getStatistics)
+		v1.GET("/achievements/:user_id", userMiddleware, a.getAchievements)
+		assignments := v1.Group("/assignments")
+		{
+			assignments.GET("/:user_id", userMiddleware, a.customListAssignments)
+			assignments.POST("/:user_id", userMiddleware, a.customCreateAssignment)
+			assignments.GET("/:user_id/:assignment_id", userMiddleware, a.customGetAssignment)
+			assignments.DELETE("/:user_id/:assignment_id", userMiddleware, a.customDeleteAssignment)
+		}
+		classrooms := v1.Group("/classrooms")
+		{
+			classrooms.GET("/:user_id", userMiddleware, a.customGetClassroom)
+			classrooms.POST("/:user_id/join", userMiddleware, a.customJoinClassroom)
+			classrooms.DELETE("/:user_id", userMiddleware, a.customLeaveClassroom)
+		}
 		user := v1.Group("/users")
 		{
 			user.POST("", userMiddlewareLenient, a.customCreateOrUpdateUser)
@@ -170,8 +210,9 @@ func (a *Api) GetRouter() *gin.Engine {
 			video.POST("", userMiddleware, a.customCreateVideo)
 			video.POST("/", userMiddleware, a.customCreateVideo)
 			video.POST("/:id", userMiddleware, a.updateVideo)
+			video.POST("/:id/preferences", userMiddleware, a.customUpdateVideoPreference)
 			video.DELETE("/:id", userMiddleware, a.customDeleteVideo)
-			video.GET("/:id", userMiddleware, a.getVideo)
+			video.GET("/:id", userMiddleware, a.customGetVideo)
 			video.GET("", userMiddleware, a.customListVideo)
 			video.GET("/", userMiddleware, a.customListVideo)
 		}
@@ -181,8 +222,15 @@ func (a *Api) GetRouter() *gin.Engine {
 			playlists.GET("/", userMiddleware, a.customListPlaylists)
 			playlists.POST("", userMiddleware, a.customAddPlaylist)
 			playlists.POST("/", userMiddleware, a.customAddPlaylist)
+			playlists.POST("/:playlist_id", userMiddleware, a.customUpdateUserPlaylist)
 			playlists.DELETE("/:playlist_id", userMiddleware, a.customRemovePlaylist)
 		}
+		videoApprovals := v1.Group("/video-approvals")
+		{
+			videoApprovals.GET("", userMiddleware, a.customListVideoApprovals)
+			videoApprovals.POST("", userMiddleware, a.customDecideVideoApprovals)
+		}
+		v1.GET("/local-media", userMiddleware, a.customListLocalMedia)
 		problem := v1.Group("/problems")
 		{
 			problem.GET("/:id", a.getProblem)
@@ -194,12 +242,53 @@ func (a *Api) GetRouter() *gin.Engine {
 			event.POST("/", userMiddleware, a.customCreateEvent)
 		}
 		// Operator-only surfaces. Gated by RequireAdmin (after userMiddleware
-		// loads the user from the validated token's identity).
+		// loads the user from the validated token's identity); routes that
+		// change something also require their narrower permission (roles.go)
+		// and record an audit_log entry (audit.go).
 		admin := v1.Group("/admin", userMiddleware, a.RequireAdmin())
 		{
 			admin.GET("/whoami", a.adminWhoami)
+			admin.GET("/users", a.RequirePermission(PermManageRoles), a.adminListUsers)
+			admin.POST("/users/:user_id/role", a.RequirePermission(PermManageRoles), a.adminSetUserRole)
+			admin.GET("/audit", a.adminListAudit)
 			admin.GET("/difficulty-calibration", a.adminDifficultyCalibration)
-			admin.POST("/difficulty-calibration/recompute", a.adminRecomputeCalibration)
+			admin.POST("/difficulty-calibration/recompute", a.RequirePermission(PermCalibrate), a.adminRecomputeCalibration)
+			admin.GET("/generation-jobs", a.adminListGenerationJobs)
+			admin.GET("/pool-coverage", a.adminPoolCoverage)
+			admin.GET("/users/:user_id/replay", a.adminReplayUserState)
+			admin.GET("/users/:user_id/selection", a.adminExplainSelection)
+			admin.POST("/users/:user_id/restore", a.RequirePermission(PermRestoreUsers), a.adminRestoreUserState)
+			admin.GET("/catalog", a.adminListCatalog)
+			admin.POST("/catalog", a.RequirePermission(PermManageVideos), a.adminUpsertCatalog)
+			admin.DELETE("/catalog/:playlist_id", a.RequirePermission(PermManageVideos), a.adminRemoveCatalog)
+			admin.POST("/videos/:id/disabled", a.RequirePermission(PermManageVideos), a.adminSetVideoDisabled)
+			admin.GET("/problems", a.adminSearchProblems)
+			admin.POST("/problems/import", a.RequirePermission(PermManageProblems), a.adminImportProblems)
+			admin.GET("/problems/export", a.adminExportProblems)
+			admin.GET("/problem-review", a.adminListFlaggedProblems)
+			admin.GET("/problem-review/:id", a.adminGetProblemReview)
+			admin.POST("/problem-review/:id/enable", a.RequirePermission(PermManageProblems), a.adminEnableProblem)
+			admin.POST("/problem-review/:id/disable", a.RequirePermission(PermManageProblems), a.adminDisableProblem)
+			admin.POST("/problem-review/:id/edit", a.RequirePermission(PermManageProblems), a.adminEditProblem)
+			admin.POST("/problem-review/:id/retire", a.RequirePermission(PermManageProblems), a.adminRetireProblem)
+		}
+		// Teacher surfaces. RequireTeacher admits the role; requireOwnClassroom
+		// and requireRosterStudent scope each route to the caller's own roster.
+		teacher := v1.Group("/teacher", userMiddleware, a.RequireTeacher())
+		{
+			teacher.GET("/classrooms", a.teacherListClassrooms)
+			teacher.POST("/classrooms", a.teacherCreateClassroom)
+			classroom := teacher.Group("/classrooms/:classroom_id", a.requireOwnClassroom())
+			{
+				classroom.GET("", a.teacherGetClassroom)
+				classroom.POST("", a.teacherUpdateClassroom)
+				classroom.DELETE("", a.teacherDeleteClassroom)
+				classroom.POST("/invite-code", a.teacherRotateInviteCode)
+				classroom.GET("/statistics", a.teacherClassroomStatistics)
+				classroom.POST("/assignments", a.teacherPushAssignment)
+				classroom.GET("/students/:student_id/assignments", a.requireRosterStudent(), a.teacherStudentAssignments)
+				classroom.DELETE("/students/:student_id", a.requireRosterStudent(), a.teacherRemoveStudent)
+			}
 		}
 	}
 	return router
@@ -0,0 +1,490 @@
+// local_media.go: the self-hosted local media library provider.
+//
+// Families can reward with their own downloaded videos instead of YouTube.
+// The library is a directory (local_media_dir) of video files; every folder
+// holding at least one video is a playlist, and its videos are the files
+// directly inside it. A sibling image with the same base name (a.mp4 +
+// a.jpg, as yt-dlp --write-thumbnail leaves them) is the video's thumbnail,
+// and folder.jpg / cover.jpg / poster.jpg the folder's. Durations are read
+// from the MP4 header; other containers report 0 (unknown). The files
+// themselves are served by the apiserver under localMediaRoute, which
+// local_media_url must point at. The route sits ahead of the auth middleware,
+// since <video> and <img> tags can't send a bearer token, so it only serves a
+// URL the API handed out signed (SignURL): an HMAC over the library path and
+// an expiry. Stored URLs are unsigned; handlers sign them as they respond.
+// Documented in docs/videos.md.
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// localMediaRoute is where GetRouter serves the library's files.
+const localMediaRoute = "/media"
+
+// localMediaURLTTL is how long a signed library URL stays valid. It covers a
+// reward video's whole playback: the browser keeps requesting ranges of the
+// file under the URL it started with.
+const localMediaURLTTL = 6 * time.Hour
+
+// localMediaIDPrefix namespaces library IDs in you_tube_id, so they can never
+// collide with a YouTube ID.
+const localMediaIDPrefix = "local:"
+
+// maxLocalTitleLen is the videos.title column width.
+const maxLocalTitleLen = 128
+
+// localVideoExts are the containers browsers play natively.
+var localVideoExts = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".webm": true, ".ogv": true}
+
+var localThumbExts = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+var localFolderThumbNames = []string{"folder", "cover", "poster"}
+
+// LocalMediaFolder is a library folder, as listed for the settings page.
+type LocalMediaFolder struct {
+	Id           string `json:"id"`
+	Title        string `json:"title"`
+	Path         string `json:"path"`
+	VideoCount   int    `json:"video_count"`
+	ThumbnailURL string `json:"thumbnailurl"`
+}
+
+// LocalMediaProvider is the VideoProvider for a directory of video files.
+// It keeps no index: every call rescans, so the library can be edited on disk
+// at any time.
+type LocalMediaProvider struct {
+	Dir     string
+	BaseURL string
+	// signingKey keys the HMAC on served URLs.
+	signingKey []byte
+}
+
+// NewLocalMediaProvider signs URLs with signingKey, or with a random key when
+// it is empty; those URLs stop working when the process restarts, and aren't
+// accepted by another apiserver.
+func NewLocalMediaProvider(dir, baseURL, signingKey string) *LocalMediaProvider {
+	key := []byte(signingKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(fmt.Sprintf("local media signing key: %v", err))
+		}
+	}
+	return &LocalMediaProvider{Dir: dir, BaseURL: strings.TrimRight(baseURL, "/"), signingKey: key}
+}
+
+type localMediaFile struct {
+	rel     string // slash-separated, relative to Dir
+	size    int64
+	modUnix int64
+	thumb   string // rel of the sidecar thumbnail, or ""
+}
+
+type localMediaDir struct {
+	rel    string
+	videos []localMediaFile
+	thumb  string
+}
+
+// localMediaID is the external ID for a library path: a short hash, since
+// the path itself may not fit the you_tube_id columns.
+func localMediaID(rel string) string {
+	sum := sha1.Sum([]byte(rel))
+	return localMediaIDPrefix + hex.EncodeToString(sum[:])[:24]
+}
+
+func (l *LocalMediaProvider) Name() string { return PROVIDER_LOCAL }
+
+// scan walks the library and groups its videos by folder. Hidden files and
+// folders are skipped.
+func (l *LocalMediaProvider) scan() (map[string]*localMediaDir, error) {
+	dirs := map[string]*localMediaDir{}
+	files := map[string]bool{}
+	err := filepath.WalkDir(l.Dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != l.Dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		files[rel] = true
+		if !localVideoExts[strings.ToLower(path.Ext(rel))] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		dir := path.Dir(rel)
+		if dirs[dir] == nil {
+			dirs[dir] = &localMediaDir{rel: dir}
+		}
+		dirs[dir].videos = append(dirs[dir].videos, localMediaFile{rel: rel, size: info.Size(), modUnix: info.ModTime().Unix()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", l.Dir, err)
+	}
+	for _, dir := range dirs {
+		for i := range dir.videos {
+			base := strings.TrimSuffix(dir.videos[i].rel, path.Ext(dir.videos[i].rel))
+			dir.videos[i].thumb = firstExisting(files, base, localThumbExts)
+		}
+		for _, name := range localFolderThumbNames {
+			if dir.thumb = firstExisting(files, path.Join(dir.rel, name), localThumbExts); dir.thumb != "" {
+				break
+			}
+		}
+		if dir.thumb == "" {
+			for _, v := range dir.videos {
+				if v.thumb != "" {
+					dir.thumb = v.thumb
+					break
+				}
+			}
+		}
+	}
+	return dirs, nil
+}
+
+func firstExisting(files map[string]bool, base string, exts []string) string {
+	for _, ext := range exts {
+		if files[base+ext] {
+			return base + ext
+		}
+	}
+	return ""
+}
+
+func (l *LocalMediaProvider) findDir(playlistID string) (*localMediaDir, error) {
+	dirs, err := l.scan()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if localMediaID(dir.rel) == playlistID {
+			return dir, nil
+		}
+	}
+	return nil, fmt.Errorf("playlist not found")
+}
+
+// fileURL is where the browser fetches a library file.
+func (l *LocalMediaProvider) fileURL(rel string) string {
+	if rel == "" {
+		return ""
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return l.BaseURL + "/" + strings.Join(parts, "/")
+}
+
+// mediaSig is the hex HMAC-SHA256 of a library path and an expiry (Unix
+// seconds).
+func (l *LocalMediaProvider) mediaSig(rel string, exp int64) string {
+	mac := hmac.New(sha256.New, l.signingKey)
+	fmt.Fprintf(mac, "%s\n%d", rel, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL appends exp and sig query parameters to a stored library URL,
+// valid for localMediaURLTTL after now. Any other URL is returned as is.
+func (l *LocalMediaProvider) SignURL(u string, now time.Time) string {
+	escaped := strings.TrimPrefix(u, l.BaseURL+"/")
+	if escaped == u || strings.Contains(escaped, "?") {
+		return u
+	}
+	rel, err := url.PathUnescape(escaped)
+	if err != nil {
+		return u
+	}
+	exp := now.Add(localMediaURLTTL).Unix()
+	return fmt.Sprintf("%s?exp=%d&sig=%s", u, exp, l.mediaSig(rel, exp))
+}
+
+// requireSignedURL admits a localMediaRoute request only with an unexpired
+// signature over the path it asks for.
+func (l *LocalMediaProvider) requireSignedURL(c *gin.Context) {
+	rel := strings.TrimPrefix(c.Param("filepath"), "/")
+	exp, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+	if err != nil || time.Now().Unix() > exp ||
+		!hmac.Equal([]byte(c.Query("sig")), []byte(l.mediaSig(rel, exp))) {
+		c.AbortWithStatusJSON(http.StatusForbidden, common.GetError("Invalid or expired media link"))
+		return
+	}
+	c.Next()
+}
+
+// mount serves the library under localMediaRoute, to signed URLs only.
+func (l *LocalMediaProvider) mount(router *gin.Engine) {
+	router.Group(localMediaRoute, l.requireSignedURL).StaticFS("/", gin.Dir(l.Dir, false))
+}
+
+// signMediaURL signs u if it is a local media library URL (see SignURL).
+func (a *Api) signMediaURL(u string) string {
+	if local, ok := a.videoProviders[PROVIDER_LOCAL].(*LocalMediaProvider); ok {
+		return local.SignURL(u, time.Now())
+	}
+	return u
+}
+
+func localDirTitle(rel string) string {
+	if rel == "." {
+		return "Library"
+	}
+	return path.Base(rel)
+}
+
+func localVideoTitle(rel string) string {
+	title := strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+	for len(title) > maxLocalTitleLen {
+		_, size := utf8.DecodeLastRuneInString(title)
+		title = title[:len(title)-size]
+	}
+	return title
+}
+
+// Folders lists the library's playlists, sorted by path.
+func (l *LocalMediaProvider) Folders() ([]LocalMediaFolder, error) {
+	dirs, err := l.scan()
+	if err != nil {
+		return nil, err
+	}
+	folders := make([]LocalMediaFolder, 0, len(dirs))
+	for _, dir := range dirs {
+		folders = append(folders, LocalMediaFolder{
+			Id:           localMediaID(dir.rel),
+			Title:        localDirTitle(dir.rel),
+			Path:         dir.rel,
+			VideoCount:   len(dir.videos),
+			ThumbnailURL: l.fileURL(dir.thumb),
+		})
+	}
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Path < folders[j].Path })
+	return folders, nil
+}
+
+// PlaylistMetadata's etag hashes the folder's file names, sizes and
+// modification times, so it changes whenever a video is added, removed or
+// replaced.
+func (l *LocalMediaProvider) PlaylistMetadata(playlistID string) (*ProviderPlaylist, error) {
+	dir, err := l.findDir(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	h := sha1.New()
+	for _, v := range dir.videos {
+		fmt.Fprintf(h, "%s|%d|%d|%s\n", v.rel, v.size, v.modUnix, v.thumb)
+	}
+	return &ProviderPlaylist{
+		Title:        localDirTitle(dir.rel),
+		ThumbnailURL: l.fileURL(dir.thumb),
+		Etag:         hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+func (l *LocalMediaProvider) PlaylistItems(playlistID string) ([]ProviderVideo, error) {
+	dir, err := l.findDir(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]ProviderVideo, 0, len(dir.videos))
+	for _, v := range dir.videos {
+		seconds, err := probeLocalDuration(filepath.Join(l.Dir, filepath.FromSlash(v.rel)))
+		if err != nil {
+			glog.Warningf("local media duration %s: %v", v.rel, err)
+		}
+		items = append(items, ProviderVideo{
+			ExternalId:      localMediaID(v.rel),
+			Title:           localVideoTitle(v.rel),
+			URL:             l.fileURL(v.rel),
+			ThumbnailURL:    l.fileURL(v.thumb),
+			DurationSeconds: seconds,
+		})
+	}
+	return items, nil
+}
+
+// CheckPlayable: a library video is playable while its file is still in the
+// library.
+func (l *LocalMediaProvider) CheckPlayable(videoIDs []string) map[string]Playability {
+	out := make(map[string]Playability)
+	dirs, err := l.scan()
+	if err != nil {
+		glog.Errorf("local media: %v", err)
+		for _, id := range videoIDs {
+			out[id] = VideoPlayabilityError
+		}
+		return out
+	}
+	present := map[string]bool{}
+	for _, dir := range dirs {
+		for _, v := range dir.videos {
+			present[localMediaID(v.rel)] = true
+		}
+	}
+	for _, id := range videoIDs {
+		if present[id] {
+			out[id] = VideoPlayable
+		} else {
+			out[id] = VideoNotPlayable
+		}
+	}
+	return out
+}
+
+// probeLocalDuration returns a video file's duration in whole seconds, or 0
+// for a container it can't read.
+func probeLocalDuration(p string) (uint32, error) {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".mp4", ".m4v", ".mov":
+	default:
+		return 0, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return mp4Duration(f)
+}
+
+// mp4Duration reads the movie header (moov/mvhd) of an MP4 / QuickTime file.
+func mp4Duration(r io.ReadSeeker) (uint32, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	moovStart, moovEnd, err := findMP4Box(r, 0, end, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhdStart, _, err := findMP4Box(r, moovStart, moovEnd, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.Seek(mvhdStart, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var version [4]byte // version + flags
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return 0, err
+	}
+	var timescale uint32
+	var duration uint64
+	if version[0] == 1 {
+		var hdr struct {
+			Created, Modified uint64
+			Timescale         uint32
+			Duration          uint64
+		}
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return 0, err
+		}
+		timescale, duration = hdr.Timescale, hdr.Duration
+	} else {
+		var hdr struct {
+			Created, Modified, Timescale, Duration uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &hdr); err != nil {
+			return 0, err
+		}
+		timescale, duration = hdr.Timescale, uint64(hdr.Duration)
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("mvhd timescale is 0")
+	}
+	return uint32(duration / uint64(timescale)), nil
+}
+
+// findMP4Box returns the payload bounds of the first box of the given type
+// among the boxes in [start, end).
+func findMP4Box(r io.ReadSeeker, start, end int64, boxType string) (int64, int64, error) {
+	for pos := start; pos+8 <= end; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return 0, 0, err
+		}
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[:4]))
+		payload := pos + 8
+		switch size {
+		case 0: // runs to the end of the enclosing box
+			size = end - pos
+		case 1: // 64-bit size follows the type
+			var large uint64
+			if err := binary.Read(r, binary.BigEndian, &large); err != nil {
+				return 0, 0, err
+			}
+			size, payload = int64(large), pos+16
+		}
+		if size < payload-pos || pos+size > end {
+			return 0, 0, fmt.Errorf("malformed %q box at %d", string(hdr[4:]), pos)
+		}
+		if string(hdr[4:]) == boxType {
+			return payload, pos + size, nil
+		}
+		pos += size
+	}
+	return 0, 0, fmt.Errorf("no %s box", boxType)
+}
+
+// customListLocalMedia lists the library's folders for the settings page. A
+// host without a library returns an empty list, which hides the section.
+func (a *Api) customListLocalMedia(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	provider, ok := a.videoProviders[PROVIDER_LOCAL].(*LocalMediaProvider)
+	if !ok {
+		c.JSON(http.StatusOK, []LocalMediaFolder{})
+		return
+	}
+	folders, err := provider.Folders()
+	if err != nil {
+		glog.Errorf("%s local media folders: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not read the media library"))
+		return
+	}
+	for i := range folders {
+		folders[i].ThumbnailURL = provider.SignURL(folders[i].ThumbnailURL, time.Now())
+	}
+	c.JSON(http.StatusOK, folders)
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// mp4Box frames a payload as an MP4 box.
+func mp4Box(boxType string, payload []byte) []byte {
+	b := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(8+len(payload)))
+	copy(b[4:], boxType)
+	return append(b, payload...)
+}
+
+// fakeMP4 is the smallest file mp4Duration reads: ftyp, then moov/mvhd (v0).
+func fakeMP4(timescale, duration uint32) []byte {
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[12:], timescale)
+	binary.BigEndian.PutUint32(mvhd[16:], duration)
+	return append(mp4Box("ftyp", []byte("isom\x00\x00\x02\x00")), mp4Box("moov", mp4Box("mvhd", mvhd))...)
+}
+
+func writeLibraryFile(t *testing.T, dir, rel string, data []byte) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(p, data, 0o644); err != nil {
+		t.Fatalf("write %s: %v", rel, err)
+	}
+}
+
+func TestMP4Duration(t *testing.T) {
+	got, err := mp4Duration(bytes.NewReader(fakeMP4(1000, 95500)))
+	if err != nil || got != 95 {
+		t.Errorf("v0 mvhd: want 95s, got %d (%v)", got, err)
+	}
+
+	// Version 1 headers carry 64-bit times and duration.
+	mvhd := make([]byte, 32)
+	mvhd[0] = 1
+	binary.BigEndian.PutUint32(mvhd[20:], 600)
+	binary.BigEndian.PutUint64(mvhd[24:], 600*3600)
+	got, err = mp4Duration(bytes.NewReader(mp4Box("moov", mp4Box("mvhd", mvhd))))
+	if err != nil || got != 3600 {
+		t.Errorf("v1 mvhd: want 3600s, got %d (%v)", got, err)
+	}
+
+	if _, err := mp4Duration(bytes.NewReader(mp4Box("ftyp", []byte("isom")))); err == nil {
+		t.Errorf("no moov: want an error")
+	}
+}
+
+func TestLocalMediaProvider_ScansFolders(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryFile(t, dir, "Cartoons/Episode 1.mp4", fakeMP4(1000, 120000))
+	writeLibraryFile(t, dir, "Cartoons/Episode 1.jpg", []byte("jpg"))
+	writeLibraryFile(t, dir, "Cartoons/Episode 2.webm", []byte("webm"))
+	writeLibraryFile(t, dir, "Cartoons/notes.txt", []byte("not a video"))
+	writeLibraryFile(t, dir, "Cartoons/.hidden.mp4", fakeMP4(1000, 1000))
+	writeLibraryFile(t, dir, ".trash/old.mp4", fakeMP4(1000, 1000))
+	writeLibraryFile(t, dir, "Nature/cover.png", []byte("png"))
+	writeLibraryFile(t, dir, "Nature/Whales.m4v", fakeMP4(600, 600*300))
+	writeLibraryFile(t, dir, "Empty/readme.txt", []byte("no videos"))
+
+	l := NewLocalMediaProvider(dir, "https://example.com/media/", "")
+	folders, err := l.Folders()
+	if err != nil {
+		t.Fatalf("folders: %v", err)
+	}
+	if len(folders) != 2 || folders[0].Path != "Cartoons" || folders[1].Path != "Nature" {
+		t.Fatalf("want [Cartoons Nature], got %+v", folders)
+	}
+	if folders[0].VideoCount != 2 || folders[0].ThumbnailURL != "https://example.com/media/Cartoons/Episode%201.jpg" {
+		t.Errorf("Cartoons: want 2 videos and the first video's thumbnail, got %+v", folders[0])
+	}
+	if folders[1].ThumbnailURL != "https://example.com/media/Nature/cover.png" {
+		t.Errorf("Nature: want cover.png as the folder thumbnail, got %q", folders[1].ThumbnailURL)
+	}
+
+	items, err := l.PlaylistItems(folders[0].Id)
+	if err != nil {
+		t.Fatalf("items: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("want 2 items, got %+v", items)
+	}
+	ep1 := items[0]
+	if ep1.Title != "Episode 1" || ep1.URL != "https://example.com/media/Cartoons/Episode%201.mp4" || ep1.DurationSeconds != 120 {
+		t.Errorf("Episode 1: got %+v", ep1)
+	}
+	if ep1.ExternalId != localMediaID("Cartoons/Episode 1.mp4") || len(ep1.ExternalId) > 32 {
+		t.Errorf("external ID must be the namespaced path hash and fit videos.you_tube_id, got %q", ep1.ExternalId)
+	}
+	if items[1].DurationSeconds != 0 || items[1].ThumbnailURL != "" {
+		t.Errorf("webm without a sidecar: want unknown duration and no thumbnail, got %+v", items[1])
+	}
+
+	if _, err := l.PlaylistMetadata("local:missing"); err == nil {
+		t.Errorf("unknown folder: want an error")
+	}
+}
+
+func TestLocalMediaProvider_EtagAndPlayability(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryFile(t, dir, "a.mp4", fakeMP4(1000, 1000))
+	l := NewLocalMediaProvider(dir, "https://example.com/media", "")
+	root := localMediaID(".")
+
+	before, err := l.PlaylistMetadata(root)
+	if err != nil || before.Title != "Library" {
+		t.Fatalf("root folder: got %+v (%v)", before, err)
+	}
+	writeLibraryFile(t, dir, "b.mp4", fakeMP4(1000, 1000))
+	after, err := l.PlaylistMetadata(root)
+	if err != nil || after.Etag == before.Etag {
+		t.Errorf("adding a video must change the etag (%v)", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.mp4")); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	got := l.CheckPlayable([]string{localMediaID("a.mp4"), localMediaID("b.mp4")})
+	if got[localMediaID("a.mp4")] != VideoNotPlayable || got[localMediaID("b.mp4")] != VideoPlayable {
+		t.Errorf("want a.mp4 not playable and b.mp4 playable, got %v", got)
+	}
+}
+
+// TestLocalMediaRoute_SignedURLs serves a library file only to an unexpired
+// URL signed for that file with this provider's key.
+func TestLocalMediaRoute_SignedURLs(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraryFile(t, dir, "Cartoons/Episode 1.mp4", []byte("episode 1"))
+	writeLibraryFile(t, dir, "Cartoons/Episode 2.mp4", []byte("episode 2"))
+	l := NewLocalMediaProvider(dir, "https://example.com/media", "test key")
+	r := gin.New()
+	l.mount(r)
+	get := func(u string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", strings.TrimPrefix(u, "https://example.com"), nil)
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	now := time.Now()
+	ep1, ep2 := l.fileURL("Cartoons/Episode 1.mp4"), l.fileURL("Cartoons/Episode 2.mp4")
+	signed := l.SignURL(ep1, now)
+	if resp := get(signed); resp.Code != 200 || resp.Body.String() != "episode 1" {
+		t.Errorf("signed: want the file, got %d %q", resp.Code, resp.Body.String())
+	}
+	other := l.SignURL(ep2, now)
+	for name, u := range map[string]string{
+		"unsigned":        ep1,
+		"expired":         l.SignURL(ep1, now.Add(-localMediaURLTTL-time.Minute)),
+		"another file":    ep1 + other[strings.Index(other, "?"):],
+		"another key":     NewLocalMediaProvider(dir, "https://example.com/media", "other key").SignURL(ep1, now),
+		"extended expiry": strings.Replace(signed, "exp=", "exp=9", 1),
+	} {
+		if resp := get(u); resp.Code != http.StatusForbidden {
+			t.Errorf("%s: want 403, got %d", name, resp.Code)
+		}
+	}
+	if u := "https://www.youtube.com/watch?v=abc"; l.SignURL(u, now) != u {
+		t.Errorf("a URL outside the library must be left as is")
+	}
+}
+
+func TestNewVideoProviders(t *testing.T) {
+	providers, err := NewVideoProviders(&common.Config{YouTubeAPIKey: "key"})
+	if err != nil || len(providers) != 1 || providers[PROVIDER_YOUTUBE] == nil {
+		t.Fatalf("default: want YouTube only, got %v (%v)", providers, err)
+	}
+	if _, err := NewVideoProviders(&common.Config{LocalMediaDir: "/srv/media"}); err == nil {
+		t.Errorf("local_media_dir without local_media_url: want an error")
+	}
+	providers, err = NewVideoProviders(&common.Config{LocalMediaDir: "/srv/media", LocalMediaURL: "https://example.com/media"})
+	if err != nil || providers[PROVIDER_LOCAL] == nil {
+		t.Errorf("with a library: want the local provider, got %v (%v)", providers, err)
+	}
+}
+
+// TestAddPlaylist_LocalMediaFolder adds a library folder as a playlist and
+// checks its videos become the user's, recorded under the local provider.
+func TestAddPlaylist_LocalMediaFolder(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|localmedia", "localmedia@test.com", "localmediauser")
+
+	dir := t.TempDir()
+	writeLibraryFile(t, dir, "Cartoons/one.mp4", fakeMP4(1000, 60000))
+	writeLibraryFile(t, dir, "Cartoons/two.mp4", fakeMP4(1000, 90000))
+	api.videoProviders[PROVIDER_LOCAL] = NewLocalMediaProvider(dir, "https://example.com/media", "")
+
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/local-media?test_auth0_id=%s", user.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	var folders []LocalMediaFolder
+	if resp.Code != http.StatusOK || json.Unmarshal(resp.Body.Bytes(), &folders) != nil || len(folders) != 1 {
+		t.Fatalf("list folders: got %d %s", resp.Code, resp.Body.String())
+	}
+
+	body, _ := json.Marshal(map[string]string{"provider": PROVIDER_LOCAL, "external_playlist_id": folders[0].Id})
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/v1/playlists?test_auth0_id=%s", user.Auth0Id), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("add folder: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/api/v1/videos?test_auth0_id=%s", user.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	var videos []Video
+	if err := json.Unmarshal(resp.Body.Bytes(), &videos); err != nil {
+		t.Fatalf("unmarshal videos: %v", err)
+	}
+	if len(videos) != 2 {
+		t.Fatalf("want the folder's 2 videos, got %+v", videos)
+	}
+	for _, v := range videos {
+		if v.Provider != PROVIDER_LOCAL || !strings.HasPrefix(v.URL, "https://example.com/media/Cartoons/"+v.Title+".mp4?exp=") {
+			t.Errorf("want a local video served from a signed library URL, got %+v", v)
+		}
+		var stored string
+		if err := api.DB.QueryRow("SELECT url FROM videos WHERE id=?", v.Id).Scan(&stored); err != nil || strings.Contains(stored, "?") {
+			t.Errorf("want the stored URL unsigned, got %q (%v)", stored, err)
+		}
+	}
+
+	body, _ = json.Marshal(map[string]string{"provider": "vimeo", "external_playlist_id": "123"})
+	resp = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/api/v1/playlists?test_auth0_id=%s", user.Auth0Id), bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("unconfigured provider: want 400, got %d", resp.Code)
+	}
+}
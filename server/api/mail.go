@@ -0,0 +1,150 @@
+// Package api: outgoing mail.
+//
+// Senders build a MailMessage and hand it to a MailTransport, so the delivery
+// mechanism is swappable: SMTPTransport talks to a real relay, and
+// CaptureTransport is the local stand-in that keeps every message (and, with a
+// Dir, writes each one as an .eml file) so dev hosts and tests can inspect
+// exactly what would have been sent.
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// MailMessage is one multipart/alternative email: a plaintext body and an HTML
+// body of the same content.
+type MailMessage struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// MailTransport delivers a MailMessage.
+type MailTransport interface {
+	Send(msg *MailMessage) error
+}
+
+// SMTPTransport sends through an SMTP relay. With Username set it authenticates
+// with PLAIN auth (net/smtp only allows that over TLS or to localhost).
+type SMTPTransport struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+}
+
+// NewSMTPTransportFromConfig builds an SMTPTransport from the smtp_* config
+// fields. It fails when smtp_host is unset.
+func NewSMTPTransportFromConfig(c *common.Config) (*SMTPTransport, error) {
+	if strings.TrimSpace(c.SMTPHost) == "" {
+		return nil, errors.New("smtp_host is not set in config")
+	}
+	port := c.SMTPPort
+	if port == "" {
+		port = "587"
+	}
+	return &SMTPTransport{Host: c.SMTPHost, Port: port, Username: c.SMTPUser, Password: c.SMTPPass}, nil
+}
+
+func (t *SMTPTransport) Send(msg *MailMessage) error {
+	body, err := buildMIMEMessage(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if t.Username != "" {
+		auth = smtp.PlainAuth("", t.Username, t.Password, t.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(t.Host, t.Port), auth, msg.From, []string{msg.To}, body)
+}
+
+// CaptureTransport records messages instead of sending them. Safe for
+// concurrent use.
+type CaptureTransport struct {
+	// Dir, if set, receives one <n>-<recipient>.eml file per message.
+	Dir string
+
+	mu       sync.Mutex
+	messages []*MailMessage
+}
+
+func (t *CaptureTransport) Send(msg *MailMessage) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.messages = append(t.messages, msg)
+	if t.Dir == "" {
+		return nil
+	}
+	body, err := buildMIMEMessage(msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%03d-%s.eml", len(t.messages), strings.NewReplacer("@", "_at_", "/", "_").Replace(msg.To))
+	return os.WriteFile(filepath.Join(t.Dir, name), body, 0o644)
+}
+
+// Messages returns a copy of everything captured so far.
+func (t *CaptureTransport) Messages() []*MailMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*MailMessage(nil), t.messages...)
+}
+
+// buildMIMEMessage renders msg as an RFC 5322 message with a
+// multipart/alternative body (plaintext first, so clients prefer HTML).
+func buildMIMEMessage(msg *MailMessage, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", msg.Text},
+		{"text/html; charset=UTF-8", msg.HTML},
+	} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "From: %s\r\n", msg.From)
+	fmt.Fprintf(&out, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&out, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&out, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	out.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&out, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
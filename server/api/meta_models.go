@@ -11,4 +11,14 @@ type PlayData struct {
 	Gamestate *Gamestate `json:"gamestate"`
 	Problem   *Problem   `json:"problem"`
 	Video     *Video     `json:"video"`
+	// SessionOver is set, and Problem and Video are not, once a screen-time
+	// limit applies (session_limits.go).
+	SessionOver *SessionOver `json:"session_over,omitempty"`
+	// VideoCutoffSeconds is where the player stops Video: the reward time
+	// the cycle's work earned (video_budget.go). Set once the cycle's
+	// problems are solved.
+	VideoCutoffSeconds uint32 `json:"video_cutoff_seconds,omitempty"`
+	// VideoPreference is the user's rating of Video, set alongside
+	// VideoCutoffSeconds (video_rotation.go).
+	VideoPreference *VideoPreference `json:"video_preference,omitempty"`
 }
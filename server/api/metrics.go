@@ -0,0 +1,94 @@
+// metrics.go: the apiserver's Prometheus metrics and the /metrics endpoint.
+//
+// The metrics themselves are declared here and updated at their call sites
+// (the generation funnel and queue, selectProblem); the OpenAI call metrics
+// live in llm_generator/retry.go. All of them register
+// with metrics.Default, which GET /metrics renders. The inventory is in
+// docs/ops-runbook.md.
+package api
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/metrics"
+)
+
+var (
+	httpRequestSeconds = metrics.NewHistogramVec("mathgame_http_request_duration_seconds",
+		"API request latency by method, route pattern and status code.",
+		metrics.DefBuckets, "method", "route", "code")
+	generationFunnelTotal = metrics.NewCounterVec("mathgame_generation_funnel_total",
+		"Generation candidates by generator and funnel stage (requested, returned, a reject stage, inserted).",
+		"generator", "stage")
+	selectionPoolSize = metrics.NewHistogramVec("mathgame_selection_pool_size",
+		"Problems satisfying a user's settings at selection time, before the recency pick.",
+		[]float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000})
+	backgroundGenerations = metrics.NewCounterVec("mathgame_background_generation_total",
+		"Background generation requests: enqueued as a new job, or deduplicated onto the live job for the same envelope and band.",
+		"result")
+	generationJobsTotal = metrics.NewCounterVec("mathgame_generation_jobs_total",
+		"Generation job runs by result: done, retry, failed, or released (cancelled by shutdown and requeued).",
+		"result")
+	reviewQueueLookups = metrics.NewCounterVec("mathgame_review_queue_lookups_total",
+		"Spaced-repetition review queue checks in selectProblem: hit, miss, or unavailable (due but not servable).",
+		"result")
+	topicInterleaveTotal = metrics.NewCounterVec("mathgame_topic_interleave_total",
+		"Topic interleaving before the recency pick: forced (an overdue topic), biased (toward an under-covered topic), or none.",
+		"result")
+)
+
+// unmatchedRoute labels requests no route matched, so probes for random paths
+// can't grow the route label without bound.
+const unmatchedRoute = "unmatched"
+
+// requestMetricsMiddleware times every request into httpRequestSeconds,
+// labelled by route pattern (c.FullPath) rather than the raw URL.
+func requestMetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		httpRequestSeconds.Observe(time.Since(start).Seconds(), c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	}
+}
+
+// metricsAuthorized reports whether a /metrics scrape may proceed: with a
+// metrics_token configured the scraper must send it as a Bearer token;
+// without one only loopback callers (a scraper on the host) are served.
+func (a *Api) metricsAuthorized(c *gin.Context) bool {
+	if a.metricsToken != "" {
+		got := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(got), []byte(a.metricsToken)) == 1
+	}
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// getMetrics handles GET /metrics in the Prometheus text format.
+func (a *Api) getMetrics(c *gin.Context) {
+	if !a.metricsAuthorized(c) {
+		c.JSON(http.StatusForbidden, common.GetError("Not authorized to read metrics"))
+		return
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := metrics.Default.WriteText(c.Writer); err != nil {
+		glog.Errorf("%s write metrics: %v", common.GetLogPrefix(c), err)
+	}
+}
@@ -54,9 +54,38 @@ func runMigrations(db *sql.DB, skipOneThroughFourteen bool) error {
 		glog.Info("skipped schema_migrations with versions 1-14 (already applied in all environments)")
 	}
 
+	versions, err := embeddedVersions()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		version := strconv.Itoa(v)
+		if applied[version] {
+			continue
+		}
+		path := "migrations/" + version + ".sql"
+		body, err := migrationsFS.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if err := runOne(db, string(body)); err != nil {
+			return fmt.Errorf("migration %s: %w", path, err)
+		}
+		if _, err := db.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
+			return fmt.Errorf("recording migration %s: %w", version, err)
+		}
+		glog.Infof("migration applied: %s", path)
+	}
+
+	return nil
+}
+
+// embeddedVersions lists the embedded migration versions in numeric order.
+func embeddedVersions() ([]int, error) {
 	entries, err := migrationsFS.ReadDir("migrations")
 	if err != nil {
-		return fmt.Errorf("reading migrations dir: %w", err)
+		return nil, fmt.Errorf("reading migrations dir: %w", err)
 	}
 
 	var versions []int
@@ -76,27 +105,28 @@ func runMigrations(db *sql.DB, skipOneThroughFourteen bool) error {
 		versions = append(versions, n)
 	}
 	sort.Ints(versions)
+	return versions, nil
+}
 
+// PendingMigrations returns the embedded migration versions not yet recorded
+// in schema_migrations, in numeric order. It doesn't create the table: a
+// database that has never been migrated reports an error.
+func PendingMigrations(db *sql.DB) ([]int, error) {
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := embeddedVersions()
+	if err != nil {
+		return nil, err
+	}
+	pending := []int{}
 	for _, v := range versions {
-		version := strconv.Itoa(v)
-		if applied[version] {
-			continue
-		}
-		path := "migrations/" + version + ".sql"
-		body, err := migrationsFS.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("reading %s: %w", path, err)
-		}
-		if err := runOne(db, string(body)); err != nil {
-			return fmt.Errorf("migration %s: %w", path, err)
+		if !applied[strconv.Itoa(v)] {
+			pending = append(pending, v)
 		}
-		if _, err := db.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version); err != nil {
-			return fmt.Errorf("recording migration %s: %w", version, err)
-		}
-		glog.Infof("migration applied: %s", path)
 	}
-
-	return nil
+	return pending, nil
 }
 
 func appliedVersions(db *sql.DB) (map[string]bool, error) {
@@ -0,0 +1,31 @@
+-- Per-topic statistics: one row per finished problem attempt (a presentation
+-- that got at least one answer), stamped with the problem's bitmap and
+-- difficulty. The progress page rolls these up per ProblemType bit by day and
+-- week at read time, since medians can't be merged incrementally.
+CREATE TABLE IF NOT EXISTS statistics_topic_attempts (
+	user_id BIGINT UNSIGNED NOT NULL,
+	end_event_id BIGINT UNSIGNED NOT NULL,
+	ended_at TIMESTAMP NOT NULL,
+	problem_id INT UNSIGNED NOT NULL,
+	problem_type_bitmap BIGINT UNSIGNED NOT NULL DEFAULT 0,
+	difficulty DOUBLE NOT NULL DEFAULT 0,
+	answers INT NOT NULL,
+	solved TINYINT(1) NOT NULL,
+	solve_ms BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, end_event_id),
+	KEY idx_user_ended (user_id, ended_at)
+) DEFAULT CHARSET=utf8mb4;
+
+-- The attempt still open at last_event_id, carried across incremental merges.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'statistics_cache_meta' AND COLUMN_NAME = 'open_problem_id') = 0,
+  'ALTER TABLE statistics_cache_meta ADD COLUMN open_problem_id INT UNSIGNED NOT NULL DEFAULT 0, ADD COLUMN open_answers INT NOT NULL DEFAULT 0, ADD COLUMN open_work_ms BIGINT NOT NULL DEFAULT 0',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- Drop every checkpoint so the next refresh takes the full-backfill path,
+-- which replaces the totals and fills statistics_topic_attempts from history.
+DELETE FROM statistics_cache_meta;
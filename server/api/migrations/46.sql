@@ -0,0 +1,20 @@
+-- Weekly parent digest opt-in (settings.digest_opt_in, modelled in
+-- models.json). Existing users default to opted out. Idempotent via
+-- INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'settings' AND COLUMN_NAME = 'digest_opt_in') = 0,
+  'ALTER TABLE settings ADD COLUMN digest_opt_in TINYINT NOT NULL DEFAULT 0',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- One row per delivered digest, so a re-run of the weekly job (a Persistent
+-- timer catching up, an operator retry) never mails the same week twice.
+CREATE TABLE IF NOT EXISTS digest_sends (
+	user_id BIGINT UNSIGNED NOT NULL,
+	week_start DATE NOT NULL,
+	sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, week_start)
+) DEFAULT CHARSET=utf8mb4;
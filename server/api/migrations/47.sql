@@ -0,0 +1,43 @@
+-- Achievements (achievements.go). achievement_counters holds the per-user
+-- counters the rules read (solved_total, streak_days, first_try_run, the
+-- per-topic first-try counts and the engine's own bookkeeping), keyed by
+-- metric name so a new rule over a new counter needs no migration.
+CREATE TABLE IF NOT EXISTS achievement_counters (
+	user_id BIGINT UNSIGNED NOT NULL,
+	metric VARCHAR(64) NOT NULL,
+	value BIGINT NOT NULL DEFAULT 0,
+	PRIMARY KEY (user_id, metric)
+) DEFAULT CHARSET=utf8mb4;
+
+-- One row per earned achievement. The PK makes earning idempotent.
+CREATE TABLE IF NOT EXISTS user_achievements (
+	user_id BIGINT UNSIGNED NOT NULL,
+	achievement_key VARCHAR(64) NOT NULL,
+	earned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, achievement_key)
+) DEFAULT CHARSET=utf8mb4;
+
+-- Seed solved_total from history so existing users earn the solved-count
+-- achievements on their next solve. Streaks and first-try runs start fresh.
+INSERT IGNORE INTO achievement_counters (user_id, metric, value)
+SELECT user_id, 'solved_total', COUNT(*) FROM events WHERE event_type = 'solved_problem' GROUP BY user_id;
+
+-- achievement_earned is a counted statistics type: add its columns to the
+-- totals and monthly caches.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'statistics_totals' AND COLUMN_NAME = 'total_achievements_earned') = 0,
+  'ALTER TABLE statistics_totals ADD COLUMN total_achievements_earned BIGINT NOT NULL DEFAULT 0',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'statistics_monthly' AND COLUMN_NAME = 'total_achievements_earned') = 0,
+  'ALTER TABLE statistics_monthly ADD COLUMN total_achievements_earned BIGINT NOT NULL DEFAULT 0',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
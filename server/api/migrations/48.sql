@@ -0,0 +1,23 @@
+-- Screen-time limits (session_limits.go), modelled in models.json: daily
+-- total and video minute caps (0 = no cap), a quiet-hours window in minutes
+-- after local midnight (start = end = no window), and the IANA timezone both
+-- are evaluated in. Existing users get no limits. Idempotent via
+-- INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'settings' AND COLUMN_NAME = 'daily_limit_minutes') = 0,
+  'ALTER TABLE settings ADD COLUMN daily_limit_minutes INT UNSIGNED NOT NULL DEFAULT 0, ADD COLUMN daily_video_limit_minutes INT UNSIGNED NOT NULL DEFAULT 0, ADD COLUMN quiet_hours_start INT UNSIGNED NOT NULL DEFAULT 0, ADD COLUMN quiet_hours_end INT UNSIGNED NOT NULL DEFAULT 0, ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT ''UTC''',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- Today's-usage lookups range-scan one user's duration events by time.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'events' AND INDEX_NAME = 'idx_events_user_event_ts') = 0,
+  'CREATE INDEX idx_events_user_event_ts ON events (user_id, event_type, timestamp)',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
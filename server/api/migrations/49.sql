@@ -0,0 +1,21 @@
+-- Video providers (video_provider.go), modelled in models.json: playlists
+-- and videos record the provider they came from (youtube or local), and
+-- you_tube_id stays the external-ID column for every provider. Existing rows
+-- are YouTube's. Idempotent via INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'playlists' AND COLUMN_NAME = 'provider') = 0,
+  'ALTER TABLE playlists ADD COLUMN provider VARCHAR(16) NOT NULL DEFAULT ''youtube''',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'videos' AND COLUMN_NAME = 'provider') = 0,
+  'ALTER TABLE videos ADD COLUMN provider VARCHAR(16) NOT NULL DEFAULT ''youtube''',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
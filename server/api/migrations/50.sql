@@ -0,0 +1,12 @@
+-- Playlist resync (playlist_resync.go, cmd/resync_playlists): when each
+-- playlist's etag was last checked, so a run that stops at its quota budget
+-- starts the next run with the playlists it never reached, and what that
+-- check found.
+CREATE TABLE IF NOT EXISTS playlist_resyncs (
+	playlist_id BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+	checked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	changed_at TIMESTAMP NULL,
+	status VARCHAR(16) NOT NULL,
+	error VARCHAR(512) NOT NULL DEFAULT '',
+	FOREIGN KEY (playlist_id) REFERENCES playlists(id)
+) DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,12 @@
+-- Video durations (videos.duration_seconds, modelled in models.json), from
+-- the provider at sync time, for sizing rewards to the work that earned them
+-- (video_budget.go). 0 means unknown, which existing rows stay until their
+-- playlist next changes. Idempotent via INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'videos' AND COLUMN_NAME = 'duration_seconds') = 0,
+  'ALTER TABLE videos ADD COLUMN duration_seconds INT UNSIGNED NOT NULL DEFAULT 0',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
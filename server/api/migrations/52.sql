@@ -0,0 +1,29 @@
+-- Reward video rotation (video_rotation.go). recently_watched_videos is the
+-- video counterpart of recently_shown_problems: when each video last finished
+-- playing for a user, read by selectVideo's least-recently-watched sort. It
+-- holds one row per (user, video), so it is bounded by the user's pool and
+-- needs no trim job.
+CREATE TABLE IF NOT EXISTS recently_watched_videos (
+    user_id BIGINT UNSIGNED NOT NULL,
+    video_id BIGINT UNSIGNED NOT NULL,
+    watched_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, video_id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+) DEFAULT CHARSET=utf8mb4;
+
+-- Per-user video preferences: the child's rating (-1, 0 = none, 1) and
+-- favourite, and the parent's pin or block. A blocked video is kept out of
+-- user_has_video by refreshUserHasVideo, whatever playlists hold it.
+CREATE TABLE IF NOT EXISTS user_video_preferences (
+    user_id BIGINT UNSIGNED NOT NULL,
+    video_id BIGINT UNSIGNED NOT NULL,
+    rating TINYINT NOT NULL DEFAULT 0,
+    favourite BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    blocked BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, video_id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+) DEFAULT CHARSET=utf8mb4;
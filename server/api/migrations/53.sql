@@ -0,0 +1,36 @@
+-- Video approval queue (video_approvals.go). Each subscription chooses an
+-- approval policy: 'auto' makes every video in the playlist rewardable, as
+-- before, and 'review' admits only the videos the parent approves. Existing
+-- subscriptions stay 'auto'. Idempotent via INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'user_playlist' AND COLUMN_NAME = 'approval_policy') = 0,
+  'ALTER TABLE user_playlist ADD COLUMN approval_policy VARCHAR(16) NOT NULL DEFAULT ''auto''',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- When a video joined a playlist, so the review queue lists the newest
+-- first. Existing memberships are stamped with the migration time.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'playlist_video' AND COLUMN_NAME = 'added_at') = 0,
+  'ALTER TABLE playlist_video ADD COLUMN added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- A parent's decision on a video from a 'review' playlist. No row means the
+-- video is pending. Decisions are per video, so one covers every playlist
+-- that holds it.
+CREATE TABLE IF NOT EXISTS user_video_approvals (
+    user_id BIGINT UNSIGNED NOT NULL,
+    video_id BIGINT UNSIGNED NOT NULL,
+    status VARCHAR(16) NOT NULL,
+    decided_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, video_id),
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (video_id) REFERENCES videos(id)
+) DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,17 @@
+-- Catalog playlists (catalog.go). An admin-curated playlist any user can
+-- subscribe to through user_playlist. The playlist row itself is the shared
+-- playlists row, synced once and kept current by resync_playlists, so a
+-- catalog entry only adds the curation metadata. topics is a comma-separated
+-- list. NULL ages mean no bound.
+CREATE TABLE IF NOT EXISTS catalog_playlists (
+    playlist_id BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+    min_age TINYINT UNSIGNED NULL,
+    max_age TINYINT UNSIGNED NULL,
+    topics VARCHAR(512) NOT NULL DEFAULT '',
+    description VARCHAR(1024) NOT NULL DEFAULT '',
+    curated_by BIGINT UNSIGNED NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (playlist_id) REFERENCES playlists(id),
+    FOREIGN KEY (curated_by) REFERENCES users(id)
+) DEFAULT CHARSET=utf8mb4;
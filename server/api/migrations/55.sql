@@ -0,0 +1,55 @@
+-- Problem review workbench (problem_review.go). problem_flags records every
+-- BAD_PROBLEM_USER / BAD_PROBLEM_SYSTEM report against a problem, with the
+-- reporter and their explanation, so flagged problems can be reviewed instead
+-- of staying disabled unseen.
+CREATE TABLE IF NOT EXISTS problem_flags (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    problem_id BIGINT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    source VARCHAR(8) NOT NULL,
+    explanation VARCHAR(512) NOT NULL DEFAULT '',
+    flagged_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_problem_flags_problem (problem_id, flagged_at),
+    FOREIGN KEY (problem_id) REFERENCES problems(id),
+    FOREIGN KEY (user_id) REFERENCES users(id)
+) DEFAULT CHARSET=utf8mb4;
+
+-- Backfill from the events already recorded. Only JSON values name their
+-- problem (older ones used the current problem, which is lost), so the
+-- others are skipped. The backfill runs only while the table is empty.
+INSERT INTO problem_flags (problem_id, user_id, source, explanation, flagged_at)
+SELECT p.id, e.user_id,
+       IF(e.event_type = 'bad_problem_user', 'user', 'system'),
+       LEFT(COALESCE(IF(JSON_VALID(e.value), JSON_UNQUOTE(JSON_EXTRACT(e.value, '$.explanation')), NULL), ''), 512),
+       e.timestamp
+FROM events e
+INNER JOIN users u ON u.id = e.user_id
+INNER JOIN problems p ON p.id = IF(JSON_VALID(e.value), CAST(JSON_UNQUOTE(JSON_EXTRACT(e.value, '$.problem_id')) AS UNSIGNED), NULL)
+WHERE e.event_type IN ('bad_problem_user', 'bad_problem_system')
+  AND NOT EXISTS (SELECT 1 FROM (SELECT id FROM problem_flags LIMIT 1) f);
+
+-- A retired problem stays disabled for good and leaves the review queue. Its
+-- row is kept, so the generator's collision check never re-creates it.
+CREATE TABLE IF NOT EXISTS retired_problems (
+    problem_id BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+    retired_by BIGINT UNSIGNED NOT NULL,
+    retired_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (problem_id) REFERENCES problems(id),
+    FOREIGN KEY (retired_by) REFERENCES users(id)
+) DEFAULT CHARSET=utf8mb4;
+
+-- The audit trail: one row per admin action on a problem, with the problem
+-- before and after as JSON.
+CREATE TABLE IF NOT EXISTS problem_review_log (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    problem_id BIGINT UNSIGNED NOT NULL,
+    admin_id BIGINT UNSIGNED NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    note VARCHAR(512) NOT NULL DEFAULT '',
+    before_json TEXT NOT NULL,
+    after_json TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_problem_review_log_problem (problem_id, created_at),
+    FOREIGN KEY (problem_id) REFERENCES problems(id),
+    FOREIGN KEY (admin_id) REFERENCES users(id)
+) DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,21 @@
+-- Admin problem search (problem_search.go) joins usage onto each page of
+-- problems: times served counts SELECTED_PROBLEM events by value, and the
+-- first-try rate reads the cached attempts by problem. Index both lookups.
+-- Idempotent via INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'events' AND INDEX_NAME = 'idx_events_type_value') = 0,
+  'CREATE INDEX idx_events_type_value ON events (event_type, value(16))',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'statistics_topic_attempts' AND INDEX_NAME = 'idx_topic_attempts_problem') = 0,
+  'CREATE INDEX idx_topic_attempts_problem ON statistics_topic_attempts (problem_id)',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
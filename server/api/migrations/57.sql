@@ -0,0 +1,24 @@
+-- Durable queue for background problem generation (generation_queue.go).
+-- One row per requested batch for an envelope (problem_type_bitmap) and
+-- difficulty band. dedup_key is "<bitmap>:<band>" while the job is queued or
+-- running and NULL once it is done or failed, so the UNIQUE index admits one
+-- live job per envelope and band and a repeat request only bumps requests.
+CREATE TABLE IF NOT EXISTS generation_jobs (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    problem_type_bitmap BIGINT UNSIGNED NOT NULL,
+    difficulty_band INT NOT NULL,
+    num_problems INT NOT NULL,
+    status VARCHAR(8) NOT NULL DEFAULT 'queued',
+    dedup_key VARCHAR(48) NULL,
+    requested_by BIGINT UNSIGNED NULL,
+    requests INT NOT NULL DEFAULT 1,
+    attempts INT NOT NULL DEFAULT 0,
+    run_after TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP NULL,
+    finished_at TIMESTAMP NULL,
+    UNIQUE INDEX uniq_generation_jobs_dedup (dedup_key),
+    INDEX idx_generation_jobs_claim (status, run_after),
+    FOREIGN KEY (requested_by) REFERENCES users(id) ON DELETE SET NULL
+) DEFAULT CHARSET=utf8mb4;
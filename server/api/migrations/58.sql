@@ -0,0 +1,12 @@
+-- Per-topic weights for selection's topic interleaving (topic_interleave.go),
+-- modelled in models.json: a JSON object of problem-type feature names to
+-- weights, e.g. {"fractions":2,"word":0.5}. Empty means every enabled topic
+-- weighs 1. Idempotent via INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'settings' AND COLUMN_NAME = 'topic_weights') = 0,
+  'ALTER TABLE settings ADD COLUMN topic_weights VARCHAR(1024) NOT NULL DEFAULT ''''',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
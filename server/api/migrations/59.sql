@@ -0,0 +1,33 @@
+-- Parent-authored assignments (assignments.go). source says how the items
+-- were chosen: a problem list, a bitmap and difficulty spec, or typed
+-- expressions. completed_at is set once every item is solved or skipped,
+-- so the open assignments are the rows where it is NULL.
+CREATE TABLE IF NOT EXISTS assignments (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT UNSIGNED NOT NULL,
+    title VARCHAR(128) NOT NULL,
+    source VARCHAR(16) NOT NULL,
+    due_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL,
+    INDEX idx_assignments_open (user_id, completed_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- One row per problem of an assignment, served in position order. private
+-- marks a problem created from the assignment's expressions: it is kept
+-- disabled so only the assignment serves it.
+CREATE TABLE IF NOT EXISTS assignment_items (
+    assignment_id INT UNSIGNED NOT NULL,
+    position INT NOT NULL,
+    problem_id INT UNSIGNED NOT NULL,
+    private TINYINT(1) NOT NULL DEFAULT 0,
+    attempts INT NOT NULL DEFAULT 0,
+    first_try_correct TINYINT(1) NOT NULL DEFAULT 0,
+    first_shown_at TIMESTAMP NULL,
+    solved_at TIMESTAMP NULL,
+    skipped_at TIMESTAMP NULL,
+    PRIMARY KEY (assignment_id, position),
+    INDEX idx_assignment_items_problem (problem_id),
+    FOREIGN KEY (assignment_id) REFERENCES assignments(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
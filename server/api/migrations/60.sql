@@ -0,0 +1,39 @@
+-- Teacher classrooms (classrooms.go). A classroom belongs to one teacher
+-- (users.role = 'teacher') and students join it with invite_code.
+-- problem_type_bitmap and difficulty_floor are the class-wide envelope and
+-- difficulty floor, 0 meaning the family's own settings apply.
+CREATE TABLE IF NOT EXISTS classrooms (
+    id INT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    teacher_id BIGINT UNSIGNED NOT NULL,
+    name VARCHAR(128) NOT NULL,
+    invite_code VARCHAR(16) NOT NULL,
+    problem_type_bitmap BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    difficulty_floor DOUBLE NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX uniq_classrooms_invite_code (invite_code),
+    INDEX idx_classrooms_teacher (teacher_id),
+    FOREIGN KEY (teacher_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- The roster. A student is on at most one roster, so the class policy a
+-- student's settings follow is never ambiguous.
+CREATE TABLE IF NOT EXISTS classroom_members (
+    classroom_id INT UNSIGNED NOT NULL,
+    user_id BIGINT UNSIGNED NOT NULL,
+    joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (classroom_id, user_id),
+    UNIQUE INDEX uniq_classroom_members_user (user_id),
+    FOREIGN KEY (classroom_id) REFERENCES classrooms(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) DEFAULT CHARSET=utf8mb4;
+
+-- The classroom an assignment was pushed from, NULL for a family's own.
+-- Idempotent via INFORMATION_SCHEMA check.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'assignments' AND COLUMN_NAME = 'classroom_id') = 0,
+  'ALTER TABLE assignments ADD COLUMN classroom_id INT UNSIGNED NULL',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
@@ -0,0 +1,18 @@
+-- Append-only audit log of privileged actions (audit.go): role changes,
+-- problem review and import, calibration recomputes, video and catalog
+-- curation, user restores. actor_id has no foreign key so entries outlive
+-- the accounts they name. before_value and after_value are JSON, NULL when a
+-- side doesn't apply. Nothing in the server updates or deletes a row.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor_id BIGINT UNSIGNED NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    target_type VARCHAR(32) NOT NULL,
+    target_id VARCHAR(64) NOT NULL DEFAULT '',
+    before_value TEXT NULL,
+    after_value TEXT NULL,
+    INDEX idx_audit_log_target (target_type, target_id, id),
+    INDEX idx_audit_log_actor (actor_id, id),
+    INDEX idx_audit_log_action (action, id)
+) DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,50 @@
+-- audit_log becomes the one record of problem review actions
+-- (problem_review.go). It gains the note an admin attaches to an action,
+-- the review log's rows that have no audit twin are copied into it, and
+-- problem_review_log is replaced by a view of audit_log's problem rows.
+-- Idempotent via INFORMATION_SCHEMA checks.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'audit_log' AND COLUMN_NAME = 'note') = 0,
+  'ALTER TABLE audit_log ADD COLUMN note VARCHAR(512) NOT NULL DEFAULT '''' AFTER after_value',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- Review actions taken before audit_log existed. Later ones were written to
+-- both tables with the same before value, and are skipped.
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'problem_review_log' AND TABLE_TYPE = 'BASE TABLE') = 1,
+  'INSERT INTO audit_log (created_at, actor_id, action, target_type, target_id, before_value, after_value, note)
+   SELECT l.created_at, l.admin_id, CONCAT(''problem_'', l.action), ''problem'', CAST(l.problem_id AS CHAR),
+     l.before_json, l.after_json, l.note
+   FROM problem_review_log l
+   WHERE NOT EXISTS (
+     SELECT 1 FROM audit_log a
+     WHERE a.target_type = ''problem'' AND a.target_id = CAST(l.problem_id AS CHAR)
+       AND a.action = CONCAT(''problem_'', l.action) AND a.before_value = l.before_json)
+   ORDER BY l.id',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+SET @sql = (SELECT IF(
+  (SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'problem_review_log' AND TABLE_TYPE = 'BASE TABLE') = 1,
+  'DROP TABLE problem_review_log',
+  'SELECT 1'
+));
+PREPARE stmt FROM @sql;
+EXECUTE stmt;
+DEALLOCATE PREPARE stmt;
+
+-- action drops the problem_ prefix, so it reads as the review action.
+-- Imports name no single problem (target_id '') and are left out.
+CREATE OR REPLACE VIEW problem_review_log AS
+SELECT id, CAST(target_id AS UNSIGNED) AS problem_id, actor_id AS admin_id,
+  SUBSTRING(action, 9) AS action, note, before_value AS before_json,
+  after_value AS after_json, created_at
+FROM audit_log
+WHERE target_type = 'problem' AND target_id <> '';
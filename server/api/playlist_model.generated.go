@@ -15,7 +15,8 @@ const (
 	you_tube_id VARCHAR(64) NOT NULL UNIQUE,
 	title VARCHAR(512) NOT NULL,
 	thumbnailurl VARCHAR(1024) NOT NULL,
-	etag VARCHAR(128) NOT NULL
+	etag VARCHAR(128) NOT NULL,
+	provider VARCHAR(16) NOT NULL DEFAULT 'youtube'
     ) DEFAULT CHARSET=utf8mb4 ;`
 
 	createPlaylistSQL = `INSERT INTO playlists (you_tube_id, title, thumbnailurl, etag) VALUES (?, ?, ?, ?);`
@@ -26,7 +27,7 @@ const (
 
 	listPlaylistSQL = `SELECT * FROM playlists;`
 
-	updatePlaylistSQL = `UPDATE playlists SET you_tube_id=?, title=?, thumbnailurl=?, etag=? WHERE id=?;`
+	updatePlaylistSQL = `UPDATE playlists SET you_tube_id=?, title=?, thumbnailurl=?, etag=?, provider=? WHERE id=?;`
 
 	deletePlaylistSQL = `DELETE FROM playlists WHERE id=?;`
 )
@@ -37,10 +38,11 @@ type Playlist struct {
 	Title        string `json:"title" uri:"title" form:"title"`
 	ThumbnailURL string `json:"thumbnailurl" uri:"thumbnailurl" form:"thumbnailurl"`
 	Etag         string `json:"etag" uri:"etag" form:"etag"`
+	Provider     string `json:"provider" uri:"provider" form:"provider"`
 }
 
 func (model Playlist) String() string {
-	return fmt.Sprintf("Id: %v, YouTubeId: %v, Title: %v, ThumbnailURL: %v, Etag: %v", model.Id, model.YouTubeId, model.Title, model.ThumbnailURL, model.Etag)
+	return fmt.Sprintf("Id: %v, YouTubeId: %v, Title: %v, ThumbnailURL: %v, Etag: %v, Provider: %v", model.Id, model.YouTubeId, model.Title, model.ThumbnailURL, model.Etag, model.Provider)
 }
 
 type PlaylistManager struct {
@@ -78,7 +80,7 @@ func (m *PlaylistManager) Create(model *Playlist) (int, string, error) {
 
 func (m *PlaylistManager) Get(id uint32) (*Playlist, int, string, error) {
 	model := &Playlist{}
-	err := m.DB.QueryRow(getPlaylistSQL, id).Scan(&model.Id, &model.YouTubeId, &model.Title, &model.ThumbnailURL, &model.Etag)
+	err := m.DB.QueryRow(getPlaylistSQL, id).Scan(&model.Id, &model.YouTubeId, &model.Title, &model.ThumbnailURL, &model.Etag, &model.Provider)
 	if err == sql.ErrNoRows {
 		msg := "Couldn't find a playlist with that id"
 		return nil, http.StatusNotFound, msg, err
@@ -100,7 +102,7 @@ func (m *PlaylistManager) List() (*[]Playlist, int, string, error) {
 	}
 	for rows.Next() {
 		model := Playlist{}
-		err = rows.Scan(&model.Id, &model.YouTubeId, &model.Title, &model.ThumbnailURL, &model.Etag)
+		err = rows.Scan(&model.Id, &model.YouTubeId, &model.Title, &model.ThumbnailURL, &model.Etag, &model.Provider)
 		if err != nil {
 			msg := "Couldn't scan row from database"
 			return nil, http.StatusInternalServerError, msg, err
@@ -127,7 +129,7 @@ func (m *PlaylistManager) CustomList(sql string) (*[]Playlist, int, string, erro
 	}
 	for rows.Next() {
 		model := Playlist{}
-		err = rows.Scan(&model.Id, &model.YouTubeId, &model.Title, &model.ThumbnailURL, &model.Etag)
+		err = rows.Scan(&model.Id, &model.YouTubeId, &model.Title, &model.ThumbnailURL, &model.Etag, &model.Provider)
 		if err != nil {
 			msg := "Couldn't scan row from database"
 			return nil, http.StatusInternalServerError, msg, err
@@ -185,7 +187,7 @@ func (m *PlaylistManager) Update(model *Playlist) (int, string, error) {
 		return status, msg, err
 	}
 	// Update
-	_, err = m.DB.Exec(updatePlaylistSQL, model.YouTubeId, model.Title, model.ThumbnailURL, model.Etag, model.Id)
+	_, err = m.DB.Exec(updatePlaylistSQL, model.YouTubeId, model.Title, model.ThumbnailURL, model.Etag, model.Provider, model.Id)
 	if err != nil {
 		msg := "Couldn't update playlist in database"
 		return http.StatusInternalServerError, msg, err
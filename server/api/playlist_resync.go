@@ -0,0 +1,391 @@
+// playlist_resync.go: the scheduled playlist resync (cmd/resync_playlists).
+//
+// A playlist is fetched in full once, when a user adds it. ResyncPlaylists
+// keeps it current afterwards. For every playlist with at least one
+// subscriber, and every catalog playlist (catalog.go), it asks the provider
+// for the playlist's metadata and compares the etag with playlists.etag.
+// Unchanged playlists cost that one call. A changed playlist has its items
+// re-fetched and reconciled into playlist_video: new videos are added, videos
+// gone from the playlist are removed, and videos still listed that the
+// provider says can't be played are disabled. Subscribers of a playlist whose
+// membership changed get refreshUserHasVideo.
+//
+// One quota budget covers the whole run. Playlists are visited
+// least-recently-checked first (playlist_resyncs), so when a run stops at the
+// budget the next run starts with the playlists it never reached.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// Per-playlist outcomes in a PlaylistResyncReport.
+const (
+	RESYNC_UNCHANGED = "unchanged"
+	RESYNC_CHANGED   = "changed"
+	// RESYNC_DEFERRED playlists were not reached before the quota budget ran
+	// out; they go first next run.
+	RESYNC_DEFERRED = "deferred"
+	RESYNC_ERROR    = "error"
+)
+
+// quotaMeter is implemented by providers whose calls spend a metered API
+// quota (YouTubeProvider). Providers without it are free to call.
+type quotaMeter interface {
+	QuotaUnits() int64
+}
+
+// PlaylistResyncOptions configures one ResyncPlaylists run.
+type PlaylistResyncOptions struct {
+	// QuotaBudget is the most API quota the run may spend across all
+	// playlists; 0 means no limit. It is checked before every call, so a run
+	// can overshoot by the pages of the one playlist it is fetching.
+	QuotaBudget int64
+	// DryRun computes the diff without writing anything.
+	DryRun bool
+}
+
+// ResyncVideo is one video in a resync diff.
+type ResyncVideo struct {
+	Id    uint32 `json:"id"`
+	Title string `json:"title"`
+}
+
+// PlaylistResyncResult is one playlist's outcome.
+type PlaylistResyncResult struct {
+	PlaylistId uint32        `json:"playlist_id"`
+	Provider   string        `json:"provider"`
+	ExternalId string        `json:"external_id"`
+	Title      string        `json:"title"`
+	Status     string        `json:"status"`
+	Added      []ResyncVideo `json:"added,omitempty"`
+	Removed    []ResyncVideo `json:"removed,omitempty"`
+	Disabled   []ResyncVideo `json:"disabled,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// PlaylistResyncReport is the diff a ResyncPlaylists run produced.
+type PlaylistResyncReport struct {
+	Playlists      []PlaylistResyncResult `json:"playlists"`
+	UsersRefreshed int                    `json:"users_refreshed"`
+	QuotaUnits     int64                  `json:"quota_units"`
+	QuotaBudget    int64                  `json:"quota_budget"`
+	DryRun         bool                   `json:"dry_run"`
+}
+
+// Count is how many playlists ended with status.
+func (r *PlaylistResyncReport) Count(status string) int {
+	n := 0
+	for _, p := range r.Playlists {
+		if p.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+type resyncPlaylistRow struct {
+	id         uint32
+	externalId string
+	provider   string
+	title      string
+	etag       string
+}
+
+// playlistsToResync lists subscribed playlists, never-checked first, then by
+// oldest check.
+func (a *Api) playlistsToResync() ([]resyncPlaylistRow, error) {
+	rows, err := a.DB.Query(`
+		SELECT p.id, p.you_tube_id, p.provider, p.title, p.etag
+		FROM playlists p
+		LEFT JOIN playlist_resyncs r ON r.playlist_id = p.id
+		WHERE EXISTS (SELECT 1 FROM user_playlist up WHERE up.playlist_id = p.id)
+		   OR EXISTS (SELECT 1 FROM catalog_playlists cp WHERE cp.playlist_id = p.id)
+		ORDER BY r.checked_at IS NOT NULL, r.checked_at, p.id`)
+	if err != nil {
+		return nil, fmt.Errorf("query playlists: %w", err)
+	}
+	defer rows.Close()
+	var out []resyncPlaylistRow
+	for rows.Next() {
+		var p resyncPlaylistRow
+		if err := rows.Scan(&p.id, &p.externalId, &p.provider, &p.title, &p.etag); err != nil {
+			return nil, fmt.Errorf("scan playlist: %w", err)
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// ResyncPlaylists re-fetches every subscribed or catalog playlist whose etag
+// changed and applies the diff. Per-playlist failures are reported, not
+// returned; the error is for failures that stop the whole run.
+func (a *Api) ResyncPlaylists(opts PlaylistResyncOptions) (*PlaylistResyncReport, error) {
+	report := &PlaylistResyncReport{QuotaBudget: opts.QuotaBudget, DryRun: opts.DryRun}
+	playlists, err := a.playlistsToResync()
+	if err != nil {
+		return nil, err
+	}
+	spent := func() int64 {
+		var n int64
+		for _, p := range a.videoProviders {
+			if m, ok := p.(quotaMeter); ok {
+				n += m.QuotaUnits()
+			}
+		}
+		return n
+	}
+	startUnits := spent()
+	// canCall reports whether a call to provider fits the budget; a quota
+	// error from the API ends the run the same way.
+	quotaExceeded := false
+	canCall := func(provider VideoProvider) bool {
+		if _, metered := provider.(quotaMeter); !metered {
+			return true
+		}
+		return !quotaExceeded && (opts.QuotaBudget <= 0 || spent()-startUnits < opts.QuotaBudget)
+	}
+
+	affected := map[uint32]bool{}
+	for _, pl := range playlists {
+		res := PlaylistResyncResult{PlaylistId: pl.id, Provider: pl.provider, ExternalId: pl.externalId, Title: pl.title}
+		provider, err := a.videoProvider(pl.provider)
+		switch {
+		case err != nil:
+			res.Status, res.Error = RESYNC_ERROR, err.Error()
+		case !canCall(provider):
+			res.Status = RESYNC_DEFERRED
+		default:
+			err = a.resyncPlaylist(pl, provider, canCall, opts.DryRun, &res)
+			if err != nil {
+				if isQuotaExceeded(err) {
+					quotaExceeded = true
+					res.Status = RESYNC_DEFERRED
+				} else {
+					res.Status, res.Error = RESYNC_ERROR, err.Error()
+				}
+			}
+		}
+		if res.Status == RESYNC_CHANGED && (len(res.Added) > 0 || len(res.Removed) > 0) {
+			affected[pl.id] = true
+		}
+		if !opts.DryRun && res.Status != RESYNC_DEFERRED {
+			if err := a.recordPlaylistResync(&res); err != nil {
+				glog.Errorf("record resync of playlist %d: %v", pl.id, err)
+			}
+		}
+		report.Playlists = append(report.Playlists, res)
+	}
+	report.QuotaUnits = spent() - startUnits
+
+	if opts.DryRun || len(affected) == 0 {
+		return report, nil
+	}
+	userIds, err := a.playlistSubscribers(affected)
+	if err != nil {
+		return report, err
+	}
+	for _, userId := range userIds {
+		if err := a.refreshUserHasVideo(userId); err != nil {
+			return report, fmt.Errorf("refreshUserHasVideo %d: %w", userId, err)
+		}
+		report.UsersRefreshed++
+	}
+	return report, nil
+}
+
+// resyncPlaylist checks one playlist's etag and, when it changed, applies the
+// membership diff and disables unplayable videos, filling in res.
+func (a *Api) resyncPlaylist(pl resyncPlaylistRow, provider VideoProvider, canCall func(VideoProvider) bool, dryRun bool, res *PlaylistResyncResult) error {
+	meta, err := provider.PlaylistMetadata(pl.externalId)
+	if err != nil {
+		return fmt.Errorf("fetch playlist metadata: %w", err)
+	}
+	if meta.Etag != "" && meta.Etag == pl.etag {
+		res.Status = RESYNC_UNCHANGED
+		return nil
+	}
+	if !canCall(provider) {
+		res.Status = RESYNC_DEFERRED
+		return nil
+	}
+	items, err := provider.PlaylistItems(pl.externalId)
+	if err != nil {
+		return fmt.Errorf("fetch playlist items: %w", err)
+	}
+	res.Status = RESYNC_CHANGED
+	res.Title = meta.Title
+
+	if dryRun {
+		res.Added, res.Removed, err = a.diffPlaylistVideos(pl.id, items)
+		if err != nil {
+			return err
+		}
+	} else {
+		added, removed, err := a.reconcilePlaylistVideos(pl.id, provider, items)
+		if err != nil {
+			return err
+		}
+		if res.Added, err = a.resyncVideos(added); err != nil {
+			return err
+		}
+		if res.Removed, err = a.resyncVideos(removed); err != nil {
+			return err
+		}
+	}
+
+	// Videos still listed may have gone private or stopped allowing embeds;
+	// disable them now rather than waiting for an ERROR_PLAYING_VIDEO.
+	if len(items) > 0 && canCall(provider) {
+		ids := make([]string, len(items))
+		for i, item := range items {
+			ids[i] = item.ExternalId
+		}
+		verdicts := provider.CheckPlayable(ids)
+		for _, item := range items {
+			if verdicts[item.ExternalId] != VideoNotPlayable {
+				continue
+			}
+			var v ResyncVideo
+			var disabled bool
+			err := a.DB.QueryRow("SELECT id, title, disabled FROM videos WHERE you_tube_id=?", item.ExternalId).Scan(&v.Id, &v.Title, &disabled)
+			if err == sql.ErrNoRows {
+				// New in a dry run, so there is no row to disable yet.
+				v.Title = item.Title
+			} else if err != nil {
+				return fmt.Errorf("query video %s: %w", item.ExternalId, err)
+			} else if disabled {
+				continue
+			}
+			if !dryRun {
+				if _, err := a.DB.Exec("UPDATE videos SET disabled=1 WHERE id=?", v.Id); err != nil {
+					return fmt.Errorf("disable video %d: %w", v.Id, err)
+				}
+			}
+			res.Disabled = append(res.Disabled, v)
+		}
+	}
+
+	// The etag is stored last, so a playlist whose fetch failed part-way is
+	// retried next run.
+	if !dryRun {
+		return a.updatePlaylistMetadata(pl.id, pl.externalId, provider, meta)
+	}
+	return nil
+}
+
+// diffPlaylistVideos is reconcilePlaylistVideos without the writes. A video
+// with no row yet is reported with id 0.
+func (a *Api) diffPlaylistVideos(playlistDbID uint32, items []ProviderVideo) (added, removed []ResyncVideo, err error) {
+	rows, err := a.DB.Query(`
+		SELECT v.id, v.you_tube_id, v.title FROM playlist_video pv
+		INNER JOIN videos v ON v.id = pv.video_id
+		WHERE pv.playlist_id = ? ORDER BY v.id`, playlistDbID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query playlist_video: %w", err)
+	}
+	var current []ResyncVideo
+	currentIds := map[string]bool{}
+	var externalIds []string
+	for rows.Next() {
+		var v ResyncVideo
+		var externalId string
+		if err := rows.Scan(&v.Id, &externalId, &v.Title); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("scan playlist_video: %w", err)
+		}
+		current = append(current, v)
+		currentIds[externalId] = true
+		externalIds = append(externalIds, externalId)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("query playlist_video: %w", err)
+	}
+	listed := map[string]bool{}
+	for _, item := range items {
+		if listed[item.ExternalId] {
+			continue
+		}
+		listed[item.ExternalId] = true
+		if currentIds[item.ExternalId] {
+			continue
+		}
+		v := ResyncVideo{Title: item.Title}
+		err := a.DB.QueryRow("SELECT id FROM videos WHERE you_tube_id=?", item.ExternalId).Scan(&v.Id)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, nil, fmt.Errorf("query video %s: %w", item.ExternalId, err)
+		}
+		added = append(added, v)
+	}
+	for i, externalId := range externalIds {
+		if !listed[externalId] {
+			removed = append(removed, current[i])
+		}
+	}
+	return added, removed, nil
+}
+
+// resyncVideos names the videos in a diff.
+func (a *Api) resyncVideos(ids []uint32) ([]ResyncVideo, error) {
+	var out []ResyncVideo
+	for _, id := range ids {
+		v := ResyncVideo{Id: id}
+		if err := a.DB.QueryRow("SELECT title FROM videos WHERE id=?", id).Scan(&v.Title); err != nil {
+			return nil, fmt.Errorf("query video %d: %w", id, err)
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// recordPlaylistResync stamps the playlist's check in playlist_resyncs, which
+// orders the next run.
+func (a *Api) recordPlaylistResync(res *PlaylistResyncResult) error {
+	errMsg := res.Error
+	if len(errMsg) > 512 {
+		errMsg = errMsg[:512]
+	}
+	_, err := a.DB.Exec(`
+		INSERT INTO playlist_resyncs (playlist_id, checked_at, changed_at, status, error)
+		VALUES (?, UTC_TIMESTAMP(), IF(? = ?, UTC_TIMESTAMP(), NULL), ?, ?)
+		ON DUPLICATE KEY UPDATE checked_at = VALUES(checked_at),
+			changed_at = IFNULL(VALUES(changed_at), changed_at),
+			status = VALUES(status), error = VALUES(error)`,
+		res.PlaylistId, res.Status, RESYNC_CHANGED, res.Status, errMsg)
+	return err
+}
+
+// playlistSubscribers returns the users subscribed to any of the playlists.
+func (a *Api) playlistSubscribers(playlistIds map[uint32]bool) ([]uint32, error) {
+	args := make([]interface{}, 0, len(playlistIds))
+	for id := range playlistIds {
+		args = append(args, id)
+	}
+	rows, err := a.DB.Query(
+		"SELECT DISTINCT user_id FROM user_playlist WHERE playlist_id IN (?"+strings.Repeat(",?", len(args)-1)+") ORDER BY user_id",
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("query subscribers: %w", err)
+	}
+	defer rows.Close()
+	var out []uint32
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("scan subscriber: %w", err)
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// isQuotaExceeded reports YouTube's daily-quota 403, which no retry this run
+// can get past.
+func isQuotaExceeded(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "quotaExceeded")
+}
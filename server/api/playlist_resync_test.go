@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/youtubefake"
+)
+
+// subscribeToFakePlaylist syncs a fake YouTube playlist and subscribes the
+// user to it, as adding it in Settings does.
+func subscribeToFakePlaylist(t *testing.T, api *Api, userID uint32, playlistID string) uint32 {
+	t.Helper()
+	pid, err := api.syncPlaylist(api.videoProviders[PROVIDER_YOUTUBE], playlistID)
+	if err != nil {
+		t.Fatalf("sync %s: %v", playlistID, err)
+	}
+	if _, err := api.DB.Exec("INSERT INTO user_playlist (user_id, playlist_id) VALUES (?, ?)", userID, pid); err != nil {
+		t.Fatalf("insert user_playlist: %v", err)
+	}
+	if err := api.refreshUserHasVideo(userID); err != nil {
+		t.Fatalf("refreshUserHasVideo: %v", err)
+	}
+	return pid
+}
+
+func userVideoTitles(t *testing.T, r http.Handler, auth0Id string) map[string]bool {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/videos?test_auth0_id=%s", auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	var videos []Video
+	if err := json.Unmarshal(resp.Body.Bytes(), &videos); err != nil {
+		t.Fatalf("unmarshal videos: %v (%s)", err, resp.Body.String())
+	}
+	titles := map[string]bool{}
+	for _, v := range videos {
+		titles[v.Title] = true
+	}
+	return titles
+}
+
+func resyncResult(t *testing.T, report *PlaylistResyncReport, playlistID uint32) PlaylistResyncResult {
+	t.Helper()
+	for _, p := range report.Playlists {
+		if p.PlaylistId == playlistID {
+			return p
+		}
+	}
+	t.Fatalf("playlist %d not in report %+v", playlistID, report)
+	return PlaylistResyncResult{}
+}
+
+// TestResyncPlaylists_EtagChange checks an unchanged etag costs one call and
+// changes nothing, and a changed one adds and removes videos for subscribers.
+func TestResyncPlaylists_EtagChange(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	fake := useFakeYouTube(t, api)
+	user := createTestUser(t, r, "auth0|resyncetag", "resyncetag@test.com", "resyncetaguser")
+	pid := subscribeToFakePlaylist(t, api, user.Id, youtubefake.ChangingPlaylist)
+
+	report, err := api.ResyncPlaylists(PlaylistResyncOptions{})
+	if err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if got := resyncResult(t, report, pid); got.Status != RESYNC_UNCHANGED || report.QuotaUnits != 1 {
+		t.Errorf("same etag: want unchanged for 1 unit, got %+v (%d units)", got, report.QuotaUnits)
+	}
+
+	fake.Advance(youtubefake.ChangingPlaylist)
+	report, err = api.ResyncPlaylists(PlaylistResyncOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if got := resyncResult(t, report, pid); got.Status != RESYNC_CHANGED || len(got.Added) != 1 || got.Added[0].Id != 0 {
+		t.Errorf("dry run: want one not-yet-stored video added, got %+v", got)
+	}
+	if titles := userVideoTitles(t, r, user.Auth0Id); !titles["Planets"] || titles["Rocket launch"] {
+		t.Errorf("dry run must not change the user's videos, got %v", titles)
+	}
+
+	report, err = api.ResyncPlaylists(PlaylistResyncOptions{})
+	if err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	got := resyncResult(t, report, pid)
+	if got.Status != RESYNC_CHANGED || len(got.Added) != 1 || got.Added[0].Title != "Rocket launch" ||
+		len(got.Removed) != 1 || got.Removed[0].Title != "Planets" || len(got.Disabled) != 0 {
+		t.Errorf("changed etag: want +Rocket launch -Planets, got %+v", got)
+	}
+	if report.UsersRefreshed != 1 {
+		t.Errorf("want the subscriber refreshed, got %d", report.UsersRefreshed)
+	}
+	titles := userVideoTitles(t, r, user.Auth0Id)
+	if len(titles) != 2 || !titles["Moon landing"] || !titles["Rocket launch"] {
+		t.Errorf("want the user's videos to follow the playlist, got %v", titles)
+	}
+
+	report, err = api.ResyncPlaylists(PlaylistResyncOptions{})
+	if err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if got := resyncResult(t, report, pid); got.Status != RESYNC_UNCHANGED {
+		t.Errorf("want the new etag stored, got %+v", got)
+	}
+}
+
+// TestResyncPlaylists_DisablesUnplayable checks a listed video YouTube won't
+// embed is disabled, and playlists nobody subscribes to are skipped.
+func TestResyncPlaylists_DisablesUnplayable(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|resyncdisable", "resyncdisable@test.com", "resyncdisableuser")
+	pid := subscribeToFakePlaylist(t, api, user.Id, youtubefake.MixedPlaylist)
+	if _, err := api.syncPlaylist(api.videoProviders[PROVIDER_YOUTUBE], youtubefake.PagedPlaylist); err != nil {
+		t.Fatalf("sync unsubscribed playlist: %v", err)
+	}
+	if _, err := api.DB.Exec("UPDATE playlists SET etag='stale' WHERE id=?", pid); err != nil {
+		t.Fatalf("stale etag: %v", err)
+	}
+
+	report, err := api.ResyncPlaylists(PlaylistResyncOptions{})
+	if err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if len(report.Playlists) != 1 {
+		t.Fatalf("want only the subscribed playlist, got %+v", report.Playlists)
+	}
+	got := resyncResult(t, report, pid)
+	if got.Status != RESYNC_CHANGED || len(got.Added)+len(got.Removed) != 0 || len(got.Disabled) != 1 || got.Disabled[0].Title != "Dinosaur facts" {
+		t.Errorf("want only the non-embeddable video disabled, got %+v", got)
+	}
+	var disabled bool
+	if err := api.DB.QueryRow("SELECT disabled FROM videos WHERE you_tube_id='fkNoEmbed01'").Scan(&disabled); err != nil || !disabled {
+		t.Errorf("want fkNoEmbed01 disabled, got %v (%v)", disabled, err)
+	}
+}
+
+// TestResyncPlaylists_QuotaBudget checks the budget is shared by every
+// playlist in a run and the next run starts with the deferred ones.
+func TestResyncPlaylists_QuotaBudget(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|resyncquota", "resyncquota@test.com", "resyncquotauser")
+	first := subscribeToFakePlaylist(t, api, user.Id, youtubefake.PagedPlaylist)
+	second := subscribeToFakePlaylist(t, api, user.Id, youtubefake.ChangingPlaylist)
+
+	report, err := api.ResyncPlaylists(PlaylistResyncOptions{QuotaBudget: 1})
+	if err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if resyncResult(t, report, first).Status != RESYNC_UNCHANGED || resyncResult(t, report, second).Status != RESYNC_DEFERRED || report.QuotaUnits != 1 {
+		t.Fatalf("budget 1: want the first checked and the second deferred, got %+v", report)
+	}
+
+	report, err = api.ResyncPlaylists(PlaylistResyncOptions{QuotaBudget: 1})
+	if err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if report.Playlists[0].PlaylistId != second || report.Playlists[0].Status != RESYNC_UNCHANGED || report.Count(RESYNC_DEFERRED) != 1 {
+		t.Errorf("next run: want the deferred playlist first, got %+v", report.Playlists)
+	}
+}
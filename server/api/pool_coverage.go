@@ -0,0 +1,264 @@
+// pool_coverage.go: how well the problem pool covers the envelopes in use,
+// and the pre-warm that fills its gaps ahead of demand.
+//
+// selectProblem only notices a thin pool once a user is already waiting on
+// it. ComputePoolCoverage looks at every settings bitmap in use instead, and
+// for each difficulty band up to the bitmap's ceiling (MaxDiffForBitmap)
+// counts the live problems a user targeting that band could be served. Bands
+// under minSelectionPool are thin. PrewarmPool (cmd/prewarm_pool, on a timer)
+// queues generation jobs for the thinnest bands; the queue's workers run them
+// through the same generateProblemsContext admission pipeline as any other
+// job. GET /admin/pool-coverage renders the report. Part of the selection
+// system - documented in docs/selection.md.
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// Band coverage statuses.
+const (
+	COVERAGE_OK    = "ok"
+	COVERAGE_THIN  = "thin"
+	COVERAGE_EMPTY = "empty"
+)
+
+const (
+	// coverageBucket is the difficulty histogram's granularity; a band's
+	// count sums the buckets inside its selection window.
+	coverageBucket = 0.5
+	// DefaultPrewarmJobs is how many bands one pre-warm run queues.
+	DefaultPrewarmJobs = 10
+)
+
+// BandCoverage is one difficulty band of an envelope.
+type BandCoverage struct {
+	Band             int     `json:"band"`
+	TargetDifficulty float64 `json:"target_difficulty"`
+	Problems         int     `json:"problems"` // live problems within problemSelectionEpsilon of the target
+	Users            int     `json:"users"`    // users whose target is in this band
+	Status           string  `json:"status"`
+}
+
+// BitmapCoverage is one settings bitmap in use.
+type BitmapCoverage struct {
+	ProblemTypeBitmap uint64         `json:"problem_type_bitmap"`
+	Users             int            `json:"users"`
+	MaxDifficulty     float64        `json:"max_difficulty"`
+	Bands             []BandCoverage `json:"bands"`
+}
+
+// PoolCoverage is the GET /admin/pool-coverage payload.
+type PoolCoverage struct {
+	ThinThreshold int              `json:"thin_threshold"`
+	Thin          int              `json:"thin"`  // thin bands, not counting empty ones
+	Empty         int              `json:"empty"` // bands with no problems at all
+	Bitmaps       []BitmapCoverage `json:"bitmaps"`
+}
+
+// PrewarmOptions controls a PrewarmPool run.
+type PrewarmOptions struct {
+	MaxJobs int  // bands to queue, thinnest first
+	DryRun  bool // plan only; queue nothing
+}
+
+// PrewarmJob is a band PrewarmPool picked.
+type PrewarmJob struct {
+	ProblemTypeBitmap uint64  `json:"problem_type_bitmap"`
+	Band              int     `json:"band"`
+	TargetDifficulty  float64 `json:"target_difficulty"`
+	Problems          int     `json:"problems"`
+	Users             int     `json:"users"`
+	Queued            bool    `json:"queued"` // false: dry run, or a job for the band was already live
+}
+
+// PrewarmReport is a PrewarmPool run's outcome.
+type PrewarmReport struct {
+	Coverage *PoolCoverage `json:"coverage"`
+	Jobs     []PrewarmJob  `json:"jobs"`
+}
+
+// windowCount sums the histogram buckets (keyed by FLOOR(difficulty /
+// coverageBucket)) inside target's selection window.
+func windowCount(hist map[int]int, target float64) int {
+	lo := int(math.Floor((target - problemSelectionEpsilon) / coverageBucket))
+	hi := int(math.Ceil((target+problemSelectionEpsilon)/coverageBucket)) - 1
+	n := 0
+	for k := lo; k <= hi; k++ {
+		n += hist[k]
+	}
+	return n
+}
+
+// ComputePoolCoverage reports, for every non-zero settings bitmap, the live
+// problems in each band from MinTargetDifficulty to the bitmap's ceiling.
+// Bitmaps are ordered by users, most first.
+func (a *Api) ComputePoolCoverage(ctx context.Context) (*PoolCoverage, error) {
+	rows, err := a.DB.QueryContext(ctx, "SELECT problem_type_bitmap, target_difficulty FROM settings WHERE problem_type_bitmap != 0")
+	if err != nil {
+		return nil, fmt.Errorf("read settings: %w", err)
+	}
+	defer rows.Close()
+	users := map[uint64]map[int]int{} // bitmap -> band -> users
+	for rows.Next() {
+		var bitmap uint64
+		var target float64
+		if err := rows.Scan(&bitmap, &target); err != nil {
+			return nil, fmt.Errorf("scan settings: %w", err)
+		}
+		if users[bitmap] == nil {
+			users[bitmap] = map[int]int{}
+		}
+		users[bitmap][difficultyBand(target)]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	cov := &PoolCoverage{ThinThreshold: minSelectionPool, Bitmaps: []BitmapCoverage{}}
+	for bitmap, byBand := range users {
+		hist, err := a.difficultyHistogram(ctx, bitmap)
+		if err != nil {
+			return nil, err
+		}
+		bc := BitmapCoverage{ProblemTypeBitmap: bitmap, MaxDifficulty: mathcore.MaxDiffForBitmap(bitmap), Bands: []BandCoverage{}}
+		for _, n := range byBand {
+			bc.Users += n
+		}
+		for band := difficultyBand(mathcore.MinTargetDifficulty); band <= difficultyBand(bc.MaxDifficulty); band++ {
+			b := BandCoverage{Band: band, TargetDifficulty: bandTarget(band), Users: byBand[band], Status: COVERAGE_OK}
+			b.Problems = windowCount(hist, b.TargetDifficulty)
+			switch {
+			case b.Problems == 0:
+				b.Status = COVERAGE_EMPTY
+				cov.Empty++
+			case b.Problems < minSelectionPool:
+				b.Status = COVERAGE_THIN
+				cov.Thin++
+			}
+			bc.Bands = append(bc.Bands, b)
+		}
+		cov.Bitmaps = append(cov.Bitmaps, bc)
+	}
+	sort.Slice(cov.Bitmaps, func(i, j int) bool {
+		bi, bj := cov.Bitmaps[i], cov.Bitmaps[j]
+		if bi.Users != bj.Users {
+			return bi.Users > bj.Users
+		}
+		return bi.ProblemTypeBitmap < bj.ProblemTypeBitmap
+	})
+	return cov, nil
+}
+
+// difficultyHistogram counts bitmap's servable problems (the selection
+// subset rule, not disabled) per coverageBucket of difficulty.
+func (a *Api) difficultyHistogram(ctx context.Context, bitmap uint64) (map[int]int, error) {
+	rows, err := a.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT FLOOR(difficulty / %g), COUNT(*)
+		FROM problems
+		WHERE (problem_type_bitmap & ~%d) = 0 AND problem_type_bitmap != 0 AND disabled = 0
+		GROUP BY 1`, coverageBucket, bitmap))
+	if err != nil {
+		return nil, fmt.Errorf("histogram for bitmap %d: %w", bitmap, err)
+	}
+	defer rows.Close()
+	hist := map[int]int{}
+	for rows.Next() {
+		var bucket, n int
+		if err := rows.Scan(&bucket, &n); err != nil {
+			return nil, fmt.Errorf("scan histogram for bitmap %d: %w", bitmap, err)
+		}
+		hist[bucket] = n
+	}
+	return hist, rows.Err()
+}
+
+// planPrewarm picks up to maxJobs thin or empty bands: fewest problems
+// first, then the most users waiting on the band, then the most users on
+// the bitmap.
+func planPrewarm(cov *PoolCoverage, maxJobs int) []PrewarmJob {
+	type candidate struct {
+		job         PrewarmJob
+		bitmapUsers int
+	}
+	var cands []candidate
+	for _, bc := range cov.Bitmaps {
+		for _, b := range bc.Bands {
+			if b.Status == COVERAGE_OK {
+				continue
+			}
+			cands = append(cands, candidate{PrewarmJob{
+				ProblemTypeBitmap: bc.ProblemTypeBitmap,
+				Band:              b.Band,
+				TargetDifficulty:  b.TargetDifficulty,
+				Problems:          b.Problems,
+				Users:             b.Users,
+			}, bc.Users})
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		ci, cj := cands[i], cands[j]
+		if ci.job.Problems != cj.job.Problems {
+			return ci.job.Problems < cj.job.Problems
+		}
+		if ci.job.Users != cj.job.Users {
+			return ci.job.Users > cj.job.Users
+		}
+		return ci.bitmapUsers > cj.bitmapUsers
+	})
+	jobs := []PrewarmJob{}
+	for _, c := range cands {
+		if len(jobs) == maxJobs {
+			break
+		}
+		jobs = append(jobs, c.job)
+	}
+	return jobs
+}
+
+// PrewarmPool queues generation jobs for the thinnest bands of the bitmaps
+// in use. A band whose job is already live just counts another request, so
+// runs never stack jobs. The apiserver's workers pick the jobs up on their
+// next poll.
+func (a *Api) PrewarmPool(ctx context.Context, opts PrewarmOptions) (*PrewarmReport, error) {
+	cov, err := a.ComputePoolCoverage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &PrewarmReport{Coverage: cov, Jobs: planPrewarm(cov, opts.MaxJobs)}
+	if opts.DryRun {
+		return report, nil
+	}
+	for i := range report.Jobs {
+		j := &report.Jobs[i]
+		queued, err := a.insertGenerationJob(j.ProblemTypeBitmap, j.Band, nil)
+		if err != nil {
+			return report, fmt.Errorf("queue bitmap %d band %d: %w", j.ProblemTypeBitmap, j.Band, err)
+		}
+		j.Queued = queued
+	}
+	return report, nil
+}
+
+// adminPoolCoverage handles GET /admin/pool-coverage.
+func (a *Api) adminPoolCoverage(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	cov, err := a.ComputePoolCoverage(c.Request.Context())
+	if err != nil {
+		glog.Errorf("%s ComputePoolCoverage: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not compute pool coverage"))
+		return
+	}
+	c.JSON(http.StatusOK, cov)
+}
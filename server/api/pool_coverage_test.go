@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+func TestWindowCount(t *testing.T) {
+	// Buckets are half-units: 5 is [2.5, 3), 8 is [4, 4.5).
+	hist := map[int]int{2: 100, 5: 1, 6: 2, 8: 4, 9: 8}
+	for target, want := range map[float64]int{3: 7, 4: 15, 7: 0} {
+		if got := windowCount(hist, target); got != want {
+			t.Errorf("windowCount(%v) = %d, want %d", target, got, want)
+		}
+	}
+}
+
+// TestPlanPrewarm: empty bands before thin ones, then bands with users
+// waiting, capped at maxJobs.
+func TestPlanPrewarm(t *testing.T) {
+	cov := &PoolCoverage{Bitmaps: []BitmapCoverage{
+		{ProblemTypeBitmap: 1, Users: 5, Bands: []BandCoverage{
+			{Band: 3, Problems: 150, Status: COVERAGE_OK},
+			{Band: 4, Problems: 40, Users: 2, Status: COVERAGE_THIN},
+			{Band: 5, Problems: 0, Status: COVERAGE_EMPTY},
+		}},
+		{ProblemTypeBitmap: 3, Users: 1, Bands: []BandCoverage{
+			{Band: 3, Problems: 40, Users: 1, Status: COVERAGE_THIN},
+			{Band: 4, Problems: 0, Users: 1, Status: COVERAGE_EMPTY},
+		}},
+	}}
+	jobs := planPrewarm(cov, 3)
+	want := [][2]uint64{{3, 4}, {1, 5}, {1, 4}}
+	if len(jobs) != len(want) {
+		t.Fatalf("want %d jobs, got %+v", len(want), jobs)
+	}
+	for i, w := range want {
+		if jobs[i].ProblemTypeBitmap != w[0] || uint64(jobs[i].Band) != w[1] {
+			t.Errorf("job %d: want bitmap %d band %d, got %+v", i, w[0], w[1], jobs[i])
+		}
+	}
+}
+
+// TestPoolCoverage checks the per-band counts and statuses over the bitmaps
+// in use, that the pre-warm queues the emptiest bands once, and the admin
+// endpoint.
+func TestPoolCoverage(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	add := uint64(mathcore.ADDITION)
+	addMul := uint64(mathcore.ADDITION | mathcore.MULTIPLICATION)
+	alice := createTestUser(t, r, "auth0|cov-alice", "covalice@test.com", "covalice")
+	bob := createTestUser(t, r, "auth0|cov-bob", "covbob@test.com", "covbob")
+	carol := createTestUser(t, r, "auth0|cov-carol", "covcarol@test.com", "covcarol")
+	for _, q := range []struct {
+		query string
+		args  []interface{}
+	}{
+		// User creation generates a first problem and queues more; start from
+		// a known pool and an empty queue.
+		{"UPDATE problems SET disabled = 1", nil},
+		{"DELETE FROM generation_jobs", nil},
+		{"UPDATE settings SET problem_type_bitmap = ?, target_difficulty = ? WHERE user_id = ?", []interface{}{add, 3.0, alice.Id}},
+		{"UPDATE settings SET problem_type_bitmap = ?, target_difficulty = ? WHERE user_id = ?", []interface{}{add, 5.0, bob.Id}},
+		{"UPDATE settings SET problem_type_bitmap = ?, target_difficulty = ? WHERE user_id = ?", []interface{}{addMul, 3.0, carol.Id}},
+		{"UPDATE users SET role = ? WHERE id = ?", []interface{}{RoleAdmin, carol.Id}},
+	} {
+		if _, err := api.DB.Exec(q.query, q.args...); err != nil {
+			t.Fatalf("%s: %v", q.query, err)
+		}
+	}
+	for i := 0; i < 110; i++ {
+		bitmap, difficulty := add, 3.0
+		if i >= 100 {
+			bitmap, difficulty = uint64(mathcore.MULTIPLICATION), 7.0
+		}
+		if _, err := api.DB.Exec(
+			`INSERT INTO problems (problem_type_bitmap, expression, symbolic_expression, answer, difficulty, disabled, generator, difficulty_version)
+			 VALUES (?, ?, '', '1', ?, 0, 'test', '0.2')`, bitmap, "cov", difficulty); err != nil {
+			t.Fatalf("seed problem %d: %v", i, err)
+		}
+	}
+
+	cov, err := api.ComputePoolCoverage(context.Background())
+	if err != nil {
+		t.Fatalf("ComputePoolCoverage: %v", err)
+	}
+	if len(cov.Bitmaps) != 2 || cov.Bitmaps[0].ProblemTypeBitmap != add || cov.Bitmaps[0].Users != 2 {
+		t.Fatalf("want the addition bitmap (2 users) then addition+multiplication, got %+v", cov.Bitmaps)
+	}
+	// Addition tops out at band 5; multiplication raises the ceiling to 9.
+	want := map[uint64]map[int]string{
+		add:    {3: COVERAGE_OK, 4: COVERAGE_OK, 5: COVERAGE_EMPTY},
+		addMul: {3: COVERAGE_OK, 4: COVERAGE_OK, 5: COVERAGE_EMPTY, 6: COVERAGE_THIN, 7: COVERAGE_THIN, 8: COVERAGE_THIN, 9: COVERAGE_EMPTY},
+	}
+	for _, bc := range cov.Bitmaps {
+		if len(bc.Bands) != len(want[bc.ProblemTypeBitmap]) {
+			t.Errorf("bitmap %d: want %d bands, got %+v", bc.ProblemTypeBitmap, len(want[bc.ProblemTypeBitmap]), bc.Bands)
+			continue
+		}
+		for _, b := range bc.Bands {
+			if b.Status != want[bc.ProblemTypeBitmap][b.Band] {
+				t.Errorf("bitmap %d band %d: want %s, got %+v", bc.ProblemTypeBitmap, b.Band, want[bc.ProblemTypeBitmap][b.Band], b)
+			}
+		}
+	}
+	if cov.Empty != 3 || cov.Thin != 3 {
+		t.Errorf("want 3 empty and 3 thin bands, got %d and %d", cov.Empty, cov.Thin)
+	}
+
+	report, err := api.PrewarmPool(context.Background(), PrewarmOptions{MaxJobs: 2})
+	if err != nil {
+		t.Fatalf("PrewarmPool: %v", err)
+	}
+	if len(report.Jobs) != 2 || report.Jobs[0].ProblemTypeBitmap != add || report.Jobs[0].Band != 5 || !report.Jobs[0].Queued ||
+		report.Jobs[1].ProblemTypeBitmap != addMul || report.Jobs[1].Band != 5 || !report.Jobs[1].Queued {
+		t.Fatalf("want band 5 of both bitmaps queued, bob's first, got %+v", report.Jobs)
+	}
+	q, err := api.listGenerationJobs(JOB_QUEUED, 10)
+	if err != nil {
+		t.Fatalf("listGenerationJobs: %v", err)
+	}
+	if len(q.Jobs) != 2 || q.Jobs[0].RequestedBy != nil {
+		t.Errorf("want 2 queued jobs nobody requested, got %+v", q.Jobs)
+	}
+	report, err = api.PrewarmPool(context.Background(), PrewarmOptions{MaxJobs: 2})
+	if err != nil {
+		t.Fatalf("PrewarmPool again: %v", err)
+	}
+	if report.Jobs[0].Queued || report.Jobs[1].Queued {
+		t.Errorf("second run: want the live jobs reused, got %+v", report.Jobs)
+	}
+
+	if resp := catalogRequest(t, r, carol, "GET", "/admin/pool-coverage", ""); resp.Code != http.StatusOK {
+		t.Errorf("admin coverage: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, alice, "GET", "/admin/pool-coverage", ""); resp.Code != http.StatusForbidden {
+		t.Errorf("student coverage: want 403, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,443 @@
+// problem_import.go: bulk problem import and export.
+//
+// Besides the two generators, problems enter the pool from files: JSON Lines
+// or CSV rows of expression / answer / explanation / symbolic_expression.
+// Every row is stamped exactly as an admin edit is (restampProblem: the
+// admission pipeline, the answer check, the normalized bitmap and computed
+// difficulty) and gets its own accept/reject line in the report. Export
+// writes the pool in the same format, so an exported file imports back
+// unchanged. Used by POST /admin/problems/import, GET /admin/problems/export
+// and cmd/import_problems, cmd/export_problems; documented in
+// docs/problem-generation.md.
+package api
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// File formats for import and export.
+const (
+	PROBLEM_FORMAT_JSONL = "jsonl"
+	PROBLEM_FORMAT_CSV   = "csv"
+)
+
+// Per-row import outcomes.
+const (
+	IMPORT_ACCEPTED  = "accepted"
+	IMPORT_REJECTED  = "rejected"
+	IMPORT_DUPLICATE = "duplicate"
+)
+
+const (
+	// DefaultImportGenerator is the generator column imported problems get
+	// unless the caller names one.
+	DefaultImportGenerator = "import"
+	maxImportRows          = 5000
+	maxImportBodyBytes     = 8 << 20
+	maxImportLineBytes     = 64 << 10
+)
+
+// problemCSVColumns is the CSV header, in export order. Import needs
+// expression and answer; it ignores the columns it recomputes (id, bitmap,
+// difficulty) and any it doesn't know.
+var problemCSVColumns = []string{"id", "expression", "answer", "explanation", "symbolic_expression", "problem_type_bitmap", "difficulty", "generator"}
+
+// ProblemRecord is one row of an import or export file.
+type ProblemRecord struct {
+	Id                 uint32  `json:"id,omitempty"`
+	Expression         string  `json:"expression"`
+	Answer             string  `json:"answer"`
+	Explanation        string  `json:"explanation"`
+	SymbolicExpression string  `json:"symbolic_expression"`
+	ProblemTypeBitmap  uint64  `json:"problem_type_bitmap,omitempty"`
+	Difficulty         float64 `json:"difficulty,omitempty"`
+	Generator          string  `json:"generator,omitempty"`
+	// Line is the row's line in the file, for the report; ParseError is set
+	// when the row couldn't be read at all.
+	Line       int    `json:"-"`
+	ParseError string `json:"-"`
+}
+
+// ProblemImportRow is one row of an import report.
+type ProblemImportRow struct {
+	Line       int     `json:"line"`
+	Expression string  `json:"expression"`
+	Status     string  `json:"status"`
+	Reason     string  `json:"reason,omitempty"`
+	Id         uint32  `json:"id,omitempty"`
+	Bitmap     uint64  `json:"problem_type_bitmap,omitempty"`
+	Difficulty float64 `json:"difficulty,omitempty"`
+}
+
+// ProblemImportReport is the outcome of ImportProblems.
+type ProblemImportReport struct {
+	DryRun     bool               `json:"dry_run"`
+	Generator  string             `json:"generator"`
+	Accepted   int                `json:"accepted"`
+	Rejected   int                `json:"rejected"`
+	Duplicates int                `json:"duplicates"`
+	Rows       []ProblemImportRow `json:"rows"`
+}
+
+// ProblemImportOptions configures ImportProblems.
+type ProblemImportOptions struct {
+	Generator string // "" means DefaultImportGenerator
+	DryRun    bool   // stamp and report, but insert nothing
+}
+
+// ProblemExportFilter selects the problems ExportProblems writes. Bitmap, when
+// nonzero, keeps problems whose bitmap is a subset of it - the problems
+// playable under that topic envelope, as selection reads it. Retired problems
+// are never exported; disabled ones only with IncludeDisabled.
+type ProblemExportFilter struct {
+	Bitmap          uint64
+	Generator       string
+	MinDifficulty   float64
+	MaxDifficulty   float64 // 0 means no upper bound
+	IncludeDisabled bool
+}
+
+// ProblemFormatFromPath picks a format from a file name's extension: .csv is
+// CSV, anything else JSON Lines.
+func ProblemFormatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return PROBLEM_FORMAT_CSV
+	}
+	return PROBLEM_FORMAT_JSONL
+}
+
+// ParseProblemRecords reads an import file. A row that can't be read is
+// returned with ParseError set rather than failing the file; only a missing
+// or unusable CSV header, an unknown format or too many rows is an error.
+func ParseProblemRecords(r io.Reader, format string) ([]ProblemRecord, error) {
+	switch format {
+	case PROBLEM_FORMAT_JSONL:
+		return parseProblemJSONL(r)
+	case PROBLEM_FORMAT_CSV:
+		return parseProblemCSV(r)
+	}
+	return nil, fmt.Errorf("unknown format %q (want %s or %s)", format, PROBLEM_FORMAT_JSONL, PROBLEM_FORMAT_CSV)
+}
+
+func parseProblemJSONL(r io.Reader) ([]ProblemRecord, error) {
+	var recs []ProblemRecord
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 4096), maxImportLineBytes)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if len(recs) == maxImportRows {
+			return nil, fmt.Errorf("more than %d rows", maxImportRows)
+		}
+		var rec ProblemRecord
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			rec = ProblemRecord{ParseError: fmt.Sprintf("invalid JSON: %v", err)}
+		}
+		rec.Line = line
+		recs = append(recs, rec)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", line+1, err)
+	}
+	return recs, nil
+}
+
+func parseProblemCSV(r io.Reader) ([]ProblemRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read CSV header: %w", err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"expression", "answer"} {
+		if _, ok := col[required]; !ok {
+			return nil, fmt.Errorf("CSV header has no %q column", required)
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	var recs []ProblemRecord
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if len(recs) == maxImportRows {
+			return nil, fmt.Errorf("more than %d rows", maxImportRows)
+		}
+		if err != nil {
+			pe, ok := err.(*csv.ParseError)
+			if !ok {
+				return nil, err
+			}
+			recs = append(recs, ProblemRecord{Line: pe.StartLine, ParseError: pe.Err.Error()})
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		recs = append(recs, ProblemRecord{
+			Line:               line,
+			Expression:         field(row, "expression"),
+			Answer:             field(row, "answer"),
+			Explanation:        field(row, "explanation"),
+			SymbolicExpression: field(row, "symbolic_expression"),
+		})
+	}
+	return recs, nil
+}
+
+// ImportProblems stamps each record and inserts the ones that pass. A row
+// whose stamped expression is already in the pool, or earlier in the file,
+// is a duplicate and left alone.
+func (a *Api) ImportProblems(recs []ProblemRecord, opts ProblemImportOptions) (*ProblemImportReport, error) {
+	if opts.Generator == "" {
+		opts.Generator = DefaultImportGenerator
+	}
+	report := &ProblemImportReport{DryRun: opts.DryRun, Generator: opts.Generator, Rows: []ProblemImportRow{}}
+	seen := map[uint32]bool{}
+	for _, rec := range recs {
+		row := ProblemImportRow{Line: rec.Line, Expression: rec.Expression}
+		switch stamped, reason := stampImportRecord(rec); {
+		case reason != "":
+			row.Status, row.Reason = IMPORT_REJECTED, reason
+		default:
+			row.Id, row.Bitmap, row.Difficulty = stamped.Id, stamped.ProblemTypeBitmap, stamped.Difficulty
+			row.Expression = stamped.Expression
+			dup := seen[stamped.Id]
+			if !dup {
+				var one int
+				err := a.DB.QueryRow("SELECT 1 FROM problems WHERE id = ? OR expression = ? LIMIT 1", stamped.Id, stamped.Expression).Scan(&one)
+				if err != nil && err != sql.ErrNoRows {
+					return nil, fmt.Errorf("line %d: check duplicate: %w", rec.Line, err)
+				}
+				dup = err == nil
+			}
+			seen[stamped.Id] = true
+			if dup {
+				row.Status, row.Reason = IMPORT_DUPLICATE, "expression already in the pool"
+				break
+			}
+			stamped.Generator = opts.Generator
+			if !opts.DryRun {
+				if _, _, err := a.problemManager.Create(stamped); err != nil {
+					return nil, fmt.Errorf("line %d: insert: %w", rec.Line, err)
+				}
+				a.candidates.put(stamped)
+			}
+			row.Status = IMPORT_ACCEPTED
+		}
+		switch row.Status {
+		case IMPORT_ACCEPTED:
+			report.Accepted++
+		case IMPORT_REJECTED:
+			report.Rejected++
+		case IMPORT_DUPLICATE:
+			report.Duplicates++
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	return report, nil
+}
+
+// stampImportRecord turns a record into a problem ready to insert, or returns
+// why it can't be one. The id is the stamped expression's hash, as the
+// generators assign it.
+func stampImportRecord(rec ProblemRecord) (*Problem, string) {
+	if rec.ParseError != "" {
+		return nil, rec.ParseError
+	}
+	p := &Problem{
+		Expression:         rec.Expression,
+		Answer:             strings.TrimSpace(rec.Answer),
+		Explanation:        strings.TrimSpace(rec.Explanation),
+		SymbolicExpression: strings.TrimSpace(rec.SymbolicExpression),
+	}
+	if err := restampProblem(p, 0); err != nil {
+		return nil, err.Error()
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.Expression))
+	p.Id = h.Sum32()
+	return p, ""
+}
+
+// ExportProblems writes the problems the filter selects to w, ordered by id,
+// and returns how many it wrote.
+func (a *Api) ExportProblems(w io.Writer, format string, f ProblemExportFilter) (int, error) {
+	if format != PROBLEM_FORMAT_JSONL && format != PROBLEM_FORMAT_CSV {
+		return 0, fmt.Errorf("unknown format %q (want %s or %s)", format, PROBLEM_FORMAT_JSONL, PROBLEM_FORMAT_CSV)
+	}
+	where := []string{"NOT EXISTS (SELECT 1 FROM retired_problems r WHERE r.problem_id = p.id)"}
+	var args []interface{}
+	if !f.IncludeDisabled {
+		where = append(where, "p.disabled = 0")
+	}
+	if f.Bitmap != 0 {
+		where = append(where, "p.problem_type_bitmap & ~? = 0")
+		args = append(args, f.Bitmap)
+	}
+	if f.Generator != "" {
+		where = append(where, "p.generator = ?")
+		args = append(args, f.Generator)
+	}
+	if f.MinDifficulty > 0 {
+		where = append(where, "p.difficulty >= ?")
+		args = append(args, f.MinDifficulty)
+	}
+	if f.MaxDifficulty > 0 {
+		where = append(where, "p.difficulty <= ?")
+		args = append(args, f.MaxDifficulty)
+	}
+	rows, err := a.DB.Query(`
+		SELECT p.id, p.expression, p.answer, COALESCE(p.explanation, ''), p.symbolic_expression,
+		  p.problem_type_bitmap, p.difficulty, p.generator
+		FROM problems p
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY p.id`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("query problems: %w", err)
+	}
+	defer rows.Close()
+
+	var cw *csv.Writer
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if format == PROBLEM_FORMAT_CSV {
+		cw = csv.NewWriter(w)
+		if err := cw.Write(problemCSVColumns); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
+	for rows.Next() {
+		var rec ProblemRecord
+		if err := rows.Scan(&rec.Id, &rec.Expression, &rec.Answer, &rec.Explanation, &rec.SymbolicExpression,
+			&rec.ProblemTypeBitmap, &rec.Difficulty, &rec.Generator); err != nil {
+			return n, fmt.Errorf("scan problem: %w", err)
+		}
+		if cw != nil {
+			err = cw.Write([]string{
+				strconv.FormatUint(uint64(rec.Id), 10), rec.Expression, rec.Answer, rec.Explanation, rec.SymbolicExpression,
+				strconv.FormatUint(rec.ProblemTypeBitmap, 10), strconv.FormatFloat(rec.Difficulty, 'f', -1, 64), rec.Generator,
+			})
+		} else {
+			err = enc.Encode(rec)
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if cw != nil {
+		cw.Flush()
+		return n, cw.Error()
+	}
+	return n, nil
+}
+
+// adminImportProblems handles POST /admin/problems/import. The body is the
+// file; format (jsonl|csv, default jsonl), generator and dry_run are query
+// parameters. The response is the per-row report.
+func (a *Api) adminImportProblems(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	format := c.DefaultQuery("format", PROBLEM_FORMAT_JSONL)
+	generator := strings.TrimSpace(c.Query("generator"))
+	if len(generator) > 64 {
+		c.JSON(http.StatusBadRequest, common.GetError("generator must be at most 64 characters"))
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	recs, err := ParseProblemRecords(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBodyBytes), format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	report, err := a.ImportProblems(recs, ProblemImportOptions{Generator: generator, DryRun: dryRun})
+	if err != nil {
+		glog.Errorf("%s ImportProblems: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not import problems"))
+		return
+	}
+	glog.Infof("%s problem import: admin=%d generator=%q dry_run=%v accepted=%d rejected=%d duplicates=%d",
+		logPrefix, admin.Id, report.Generator, dryRun, report.Accepted, report.Rejected, report.Duplicates)
+	if !dryRun && report.Accepted > 0 {
+		a.audit(logPrefix, admin.Id, AUDIT_PROBLEM_IMPORT, AUDIT_TARGET_PROBLEM, "", nil, gin.H{
+			"generator": report.Generator, "format": format,
+			"accepted": report.Accepted, "rejected": report.Rejected, "duplicates": report.Duplicates,
+		})
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// adminExportProblems handles GET /admin/problems/export with format,
+// bitmap, generator, min_difficulty, max_difficulty and include_disabled.
+func (a *Api) adminExportProblems(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	format := c.DefaultQuery("format", PROBLEM_FORMAT_JSONL)
+	if format != PROBLEM_FORMAT_JSONL && format != PROBLEM_FORMAT_CSV {
+		c.JSON(http.StatusBadRequest, common.GetError("format must be jsonl or csv"))
+		return
+	}
+	var f ProblemExportFilter
+	var err error
+	if v := c.Query("bitmap"); v != "" {
+		if f.Bitmap, err = strconv.ParseUint(v, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, common.GetError("bitmap must be an unsigned integer"))
+			return
+		}
+	}
+	for name, dst := range map[string]*float64{"min_difficulty": &f.MinDifficulty, "max_difficulty": &f.MaxDifficulty} {
+		if v := c.Query(name); v != "" {
+			if *dst, err = strconv.ParseFloat(v, 64); err != nil || *dst < 0 {
+				c.JSON(http.StatusBadRequest, common.GetError(name+" must be a non-negative number"))
+				return
+			}
+		}
+	}
+	f.Generator = c.Query("generator")
+	f.IncludeDisabled, _ = strconv.ParseBool(c.DefaultQuery("include_disabled", "false"))
+
+	contentType := "application/x-ndjson"
+	if format == PROBLEM_FORMAT_CSV {
+		contentType = "text/csv; charset=utf-8"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="problems.%s"`, format))
+	c.Status(http.StatusOK)
+	// The header is already sent, so a failure part-way can only be logged.
+	if n, err := a.ExportProblems(c.Writer, format, f); err != nil {
+		glog.Errorf("%s ExportProblems after %d rows: %v", logPrefix, n, err)
+	}
+}
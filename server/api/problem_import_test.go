@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestParseProblemRecords(t *testing.T) {
+	jsonl := `{"expression": "2 + 3", "answer": "5", "explanation": "Add."}
+
+not json
+{"expression": "7 - 2", "answer": "5", "id": 12, "difficulty": 3.5}
+`
+	recs, err := ParseProblemRecords(strings.NewReader(jsonl), PROBLEM_FORMAT_JSONL)
+	if err != nil {
+		t.Fatalf("jsonl: %v", err)
+	}
+	if len(recs) != 3 || recs[0].Explanation != "Add." || recs[1].Line != 3 || recs[1].ParseError == "" ||
+		recs[2].Line != 4 || recs[2].Expression != "7 - 2" {
+		t.Errorf("jsonl: want 3 rows with the bad one kept by line, got %+v", recs)
+	}
+
+	csvText := "Answer,expression,extra\n5,2 + 3,x\n\"unterminated,1\n"
+	recs, err = ParseProblemRecords(strings.NewReader(csvText), PROBLEM_FORMAT_CSV)
+	if err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	if len(recs) != 2 || recs[0].Expression != "2 + 3" || recs[0].Answer != "5" || recs[0].Line != 2 ||
+		recs[1].ParseError == "" || recs[1].Line != 3 {
+		t.Errorf("csv: want columns mapped by header and the bad row kept, got %+v", recs)
+	}
+
+	if _, err := ParseProblemRecords(strings.NewReader("expression,explanation\n1 + 1,x\n"), PROBLEM_FORMAT_CSV); err == nil {
+		t.Errorf("csv without an answer column: want an error")
+	}
+	if _, err := ParseProblemRecords(strings.NewReader(""), "xml"); err == nil {
+		t.Errorf("unknown format: want an error")
+	}
+	if ProblemFormatFromPath("sheet.CSV") != PROBLEM_FORMAT_CSV || ProblemFormatFromPath("-") != PROBLEM_FORMAT_JSONL {
+		t.Errorf("ProblemFormatFromPath: want csv by extension, jsonl otherwise")
+	}
+}
+
+// TestProblemImport_RoundTrip checks each imported row is accepted, rejected
+// or a duplicate, and that an export of the pool imports back as duplicates.
+func TestProblemImport_RoundTrip(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	admin := createTestUser(t, r, "auth0|import-admin", "importadmin@test.com", "importadmin")
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE id=?", RoleAdmin, admin.Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+	user := createTestUser(t, r, "auth0|import-user", "importuser@test.com", "importuser")
+
+	file := strings.Join([]string{
+		`{"expression": "2 + 3", "answer": "5"}`,
+		`{"expression": "6 * 7", "answer": "42", "explanation": "Six sevens."}`,
+		`{"expression": "2 + 3", "answer": "5"}`,
+		`{"expression": "9 - 4", "answer": "6"}`,
+		`{"expression": "9 -", "answer": "9"}`,
+	}, "\n")
+	if resp := catalogRequest(t, r, user, "POST", "/admin/problems/import", file); resp.Code != http.StatusForbidden {
+		t.Errorf("student importing: want 403, got %d", resp.Code)
+	}
+	resp := catalogRequest(t, r, admin, "POST", "/admin/problems/import?generator=worksheet", file)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("import: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	var report ProblemImportReport
+	if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	statuses := []string{}
+	for _, row := range report.Rows {
+		statuses = append(statuses, row.Status)
+	}
+	if strings.Join(statuses, ",") != "accepted,accepted,duplicate,rejected,rejected" ||
+		report.Accepted != 2 || report.Rejected != 2 || report.Duplicates != 1 || report.Rows[3].Reason == "" {
+		t.Fatalf("want accepted x2, a duplicate and two rejections, got %+v", report)
+	}
+	p, _, _, err := api.problemManager.Get(report.Rows[1].Id)
+	if err != nil || p.Generator != "worksheet" || p.Difficulty == 0 || p.ProblemTypeBitmap == 0 || p.Explanation != "Six sevens." {
+		t.Errorf("want the stamped problem stored under its generator, got %+v (%v)", p, err)
+	}
+
+	var buf bytes.Buffer
+	if n, err := api.ExportProblems(&buf, PROBLEM_FORMAT_CSV, ProblemExportFilter{Generator: "worksheet"}); err != nil || n != 2 {
+		t.Fatalf("export: want 2 rows, got %d (%v)", n, err)
+	}
+	if n, err := api.ExportProblems(&bytes.Buffer{}, PROBLEM_FORMAT_JSONL, ProblemExportFilter{Generator: "worksheet", MaxDifficulty: 0.01}); err != nil || n != 0 {
+		t.Errorf("export under a difficulty cap below every row: want 0 rows, got %d (%v)", n, err)
+	}
+	recs, err := ParseProblemRecords(&buf, PROBLEM_FORMAT_CSV)
+	if err != nil {
+		t.Fatalf("parse export: %v", err)
+	}
+	again, err := api.ImportProblems(recs, ProblemImportOptions{DryRun: true})
+	if err != nil || again.Duplicates != 2 || again.Accepted != 0 || again.Rejected != 0 {
+		t.Errorf("re-import of the export: want 2 duplicates, got %+v (%v)", again, err)
+	}
+
+	resp = catalogRequest(t, r, admin, "GET", "/admin/problems/export?generator=worksheet", "")
+	if resp.Code != http.StatusOK || strings.Count(resp.Body.String(), "\n") != 2 {
+		t.Errorf("export endpoint: want 2 JSON lines, got %d %q", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, admin, "POST", "/admin/problems/import?format=xml", file); resp.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: want 400, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,729 @@
+// problem_review.go: the admin workbench for flagged problems.
+//
+// A BAD_PROBLEM_USER or BAD_PROBLEM_SYSTEM event disables its problem at once
+// (processEvent) and records a problem_flags row: who flagged it, the source
+// and their explanation. The workbench lists the disabled problems that carry
+// flags, most-flagged first, with each one's generator and that generator's
+// pool counts, and how the problem fares in today's admission pipeline
+// (mathcore.AdmitExpression and the answer check). An admin then re-enables
+// it, edits it (restamped through the same pipeline) or retires it for good,
+// and can disable a live problem without waiting for a flag. Every action is
+// an audit_log row (audit.go) with the problem before and after and the
+// admin's note; problem_review_log is a view of those rows. Registered under
+// /api/v1/admin behind RequireAdmin; documented in
+// docs/problem-generation.md.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// Flag sources, as stored in problem_flags.source.
+const (
+	FLAG_SOURCE_USER   = "user"
+	FLAG_SOURCE_SYSTEM = "system"
+)
+
+// Review actions, as problem_review_log.action shows them.
+const (
+	REVIEW_ENABLE  = "enable"
+	REVIEW_DISABLE = "disable"
+	REVIEW_EDIT    = "edit"
+	REVIEW_RETIRE  = "retire"
+)
+
+// reviewAuditActions maps a review action to its audit_log action (audit.go).
+var reviewAuditActions = map[string]string{
+	REVIEW_ENABLE:  AUDIT_PROBLEM_ENABLE,
+	REVIEW_DISABLE: AUDIT_PROBLEM_DISABLE,
+	REVIEW_EDIT:    AUDIT_PROBLEM_EDIT,
+	REVIEW_RETIRE:  AUDIT_PROBLEM_RETIRE,
+}
+
+const (
+	maxFlagExplanation   = 512
+	maxReviewNote        = 512
+	defaultReviewPage    = 50
+	maxReviewPage        = 200
+	reviewRecentFlagsCap = 5
+)
+
+// ProblemFlag is one report against a problem.
+type ProblemFlag struct {
+	UserId      uint32    `json:"user_id"`
+	Username    string    `json:"username"`
+	Source      string    `json:"source"`
+	Explanation string    `json:"explanation"`
+	FlaggedAt   time.Time `json:"flagged_at"`
+}
+
+// GeneratorPoolStats is how a generator version's problems stand in the pool.
+type GeneratorPoolStats struct {
+	Total    int `json:"total"`
+	Live     int `json:"live"`
+	Disabled int `json:"disabled"`
+	Retired  int `json:"retired"`
+	Flagged  int `json:"flagged"`
+}
+
+// AdmissionCheck is a stored problem re-run through the admission pipeline.
+// RejectStage is "" when the expression is still admitted; AnswerError is
+// set when a symbolic problem's answer no longer checks out.
+type AdmissionCheck struct {
+	RejectStage string `json:"reject_stage"`
+	RejectWhy   string `json:"reject_why"`
+	Bitmap      uint64 `json:"bitmap"`
+	AnswerError string `json:"answer_error"`
+}
+
+// FlaggedProblem is one row of the review queue.
+type FlaggedProblem struct {
+	Problem
+	FlagCount       int                `json:"flag_count"`
+	UserFlagCount   int                `json:"user_flag_count"`
+	SystemFlagCount int                `json:"system_flag_count"`
+	LastFlaggedAt   time.Time          `json:"last_flagged_at"`
+	RecentFlags     []ProblemFlag      `json:"recent_flags"`
+	GeneratorStats  GeneratorPoolStats `json:"generator_stats"`
+	Admission       AdmissionCheck     `json:"admission"`
+}
+
+// ProblemReviewEntry is one audit-log row.
+type ProblemReviewEntry struct {
+	AdminId   uint32          `json:"admin_id"`
+	Action    string          `json:"action"`
+	Note      string          `json:"note"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ProblemReviewDetail is GET /admin/problem-review/:id.
+type ProblemReviewDetail struct {
+	FlaggedProblem
+	Retired bool                 `json:"retired"`
+	Flags   []ProblemFlag        `json:"flags"`
+	Log     []ProblemReviewEntry `json:"log"`
+}
+
+// recordProblemFlag stores a BAD_PROBLEM_* report. Failures are logged, not
+// returned: the flag is for review, and the problem is disabled either way.
+func (a *Api) recordProblemFlag(logPrefix string, userID, problemID uint32, eventType, rawValue string) {
+	source := FLAG_SOURCE_SYSTEM
+	if eventType == BAD_PROBLEM_USER {
+		source = FLAG_SOURCE_USER
+	}
+	var v struct {
+		Explanation string `json:"explanation"`
+	}
+	_ = json.Unmarshal([]byte(rawValue), &v)
+	explanation := v.Explanation
+	if len(explanation) > maxFlagExplanation {
+		explanation = explanation[:maxFlagExplanation]
+	}
+	if _, err := a.DB.Exec("INSERT INTO problem_flags (problem_id, user_id, source, explanation) VALUES (?, ?, ?, ?)",
+		problemID, userID, source, explanation); err != nil {
+		glog.Warningf("%s recordProblemFlag problem=%d: %v", logPrefix, problemID, err)
+	}
+}
+
+// checkAdmission re-runs a stored problem through the admission pipeline.
+// WORD problems' answers are checked against their symbolic_expression, when
+// they have one.
+func checkAdmission(p *Problem) AdmissionCheck {
+	adm := mathcore.AdmitExpression(p.Expression)
+	if adm.RejectStage != "" {
+		return AdmissionCheck{RejectStage: adm.RejectStage, RejectWhy: adm.RejectWhy}
+	}
+	out := AdmissionCheck{Bitmap: mathcore.NormalizeProblemBitmap(adm.Bitmap)}
+	toks := adm.Tokens
+	if adm.Bitmap&uint64(mathcore.WORD) != 0 {
+		if p.SymbolicExpression == "" {
+			return out
+		}
+		sym := mathcore.AdmitExpression(p.SymbolicExpression)
+		if sym.RejectStage != "" {
+			out.AnswerError = fmt.Sprintf("symbolic_expression %s: %s", sym.RejectStage, sym.RejectWhy)
+			return out
+		}
+		toks = sym.Tokens
+	}
+	if err := mathcore.VerifyAnswerSymbolic(toks, p.Answer); err != nil {
+		out.AnswerError = err.Error()
+	}
+	return out
+}
+
+// generatorPoolStats counts each named generator's problems.
+func (a *Api) generatorPoolStats(generators []string) (map[string]GeneratorPoolStats, error) {
+	out := map[string]GeneratorPoolStats{}
+	if len(generators) == 0 {
+		return out, nil
+	}
+	args := make([]interface{}, len(generators))
+	for i, g := range generators {
+		args[i] = g
+	}
+	rows, err := a.DB.Query(`
+		SELECT p.generator, COUNT(*), SUM(p.disabled = 0), SUM(p.disabled = 1), COUNT(r.problem_id),
+		  SUM(EXISTS (SELECT 1 FROM problem_flags f WHERE f.problem_id = p.id))
+		FROM problems p
+		LEFT JOIN retired_problems r ON r.problem_id = p.id
+		WHERE p.generator IN (?`+strings.Repeat(", ?", len(generators)-1)+`)
+		GROUP BY p.generator`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query generator stats: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var g string
+		var s GeneratorPoolStats
+		if err := rows.Scan(&g, &s.Total, &s.Live, &s.Disabled, &s.Retired, &s.Flagged); err != nil {
+			return nil, fmt.Errorf("scan generator stats: %w", err)
+		}
+		out[g] = s
+	}
+	return out, rows.Err()
+}
+
+// problemFlags returns a problem's flags, newest first, at most limit (0 for
+// all).
+func (a *Api) problemFlags(problemID uint32, limit int) ([]ProblemFlag, error) {
+	query := `
+		SELECT f.user_id, COALESCE(u.username, ''), f.source, f.explanation, f.flagged_at
+		FROM problem_flags f
+		LEFT JOIN users u ON u.id = f.user_id
+		WHERE f.problem_id = ?
+		ORDER BY f.flagged_at DESC, f.id DESC`
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := a.DB.Query(query, problemID)
+	if err != nil {
+		return nil, fmt.Errorf("query problem_flags: %w", err)
+	}
+	defer rows.Close()
+	flags := []ProblemFlag{}
+	for rows.Next() {
+		var f ProblemFlag
+		if err := rows.Scan(&f.UserId, &f.Username, &f.Source, &f.Explanation, &f.FlaggedAt); err != nil {
+			return nil, fmt.Errorf("scan problem_flags: %w", err)
+		}
+		flags = append(flags, f)
+	}
+	return flags, rows.Err()
+}
+
+// listFlaggedProblems returns a page of the review queue: disabled, unretired
+// problems with at least one flag, most-flagged first. A parent's typed
+// assignment problem (AssignmentGenerator) is private, not a pool problem
+// waiting for review, so it never appears.
+func (a *Api) listFlaggedProblems(source, generator string, limit, offset int) ([]FlaggedProblem, error) {
+	where := "p.disabled = 1 AND r.problem_id IS NULL AND p.generator <> ?"
+	args := []interface{}{AssignmentGenerator}
+	if generator != "" {
+		where += " AND p.generator = ?"
+		args = append(args, generator)
+	}
+	having := ""
+	switch source {
+	case FLAG_SOURCE_USER:
+		having = "HAVING user_flags > 0"
+	case FLAG_SOURCE_SYSTEM:
+		having = "HAVING system_flags > 0"
+	}
+	args = append(args, limit, offset)
+	rows, err := a.DB.Query(`
+		SELECT p.id, p.problem_type_bitmap, p.expression, p.answer, COALESCE(p.explanation, ''),
+		  p.symbolic_expression, p.difficulty, p.disabled, p.generator, p.difficulty_version,
+		  COUNT(*), SUM(f.source = '`+FLAG_SOURCE_USER+`') AS user_flags,
+		  SUM(f.source = '`+FLAG_SOURCE_SYSTEM+`') AS system_flags, MAX(f.flagged_at)
+		FROM problems p
+		INNER JOIN problem_flags f ON f.problem_id = p.id
+		LEFT JOIN retired_problems r ON r.problem_id = p.id
+		WHERE `+where+`
+		GROUP BY p.id
+		`+having+`
+		ORDER BY COUNT(*) DESC, MAX(f.flagged_at) DESC, p.id
+		LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query flagged problems: %w", err)
+	}
+	defer rows.Close()
+	list := []FlaggedProblem{}
+	for rows.Next() {
+		var fp FlaggedProblem
+		if err := rows.Scan(&fp.Id, &fp.ProblemTypeBitmap, &fp.Expression, &fp.Answer, &fp.Explanation,
+			&fp.SymbolicExpression, &fp.Difficulty, &fp.Disabled, &fp.Generator, &fp.DifficultyVersion,
+			&fp.FlagCount, &fp.UserFlagCount, &fp.SystemFlagCount, &fp.LastFlaggedAt); err != nil {
+			return nil, fmt.Errorf("scan flagged problem: %w", err)
+		}
+		list = append(list, fp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if err := a.fillFlaggedProblems(list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// fillFlaggedProblems adds the recent flags, generator stats and admission
+// check to each queue row.
+func (a *Api) fillFlaggedProblems(list []FlaggedProblem) error {
+	var generators []string
+	seen := map[string]bool{}
+	for i := range list {
+		if g := list[i].Generator; !seen[g] {
+			seen[g] = true
+			generators = append(generators, g)
+		}
+	}
+	stats, err := a.generatorPoolStats(generators)
+	if err != nil {
+		return err
+	}
+	for i := range list {
+		flags, err := a.problemFlags(list[i].Id, reviewRecentFlagsCap)
+		if err != nil {
+			return err
+		}
+		list[i].RecentFlags = flags
+		list[i].GeneratorStats = stats[list[i].Generator]
+		list[i].Admission = checkAdmission(&list[i].Problem)
+	}
+	return nil
+}
+
+// adminListFlaggedProblems handles GET /admin/problem-review with optional
+// source (user|system), generator, limit and offset.
+func (a *Api) adminListFlaggedProblems(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	source := c.Query("source")
+	if source != "" && source != FLAG_SOURCE_USER && source != FLAG_SOURCE_SYSTEM {
+		c.JSON(http.StatusBadRequest, common.GetError("source must be user or system"))
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultReviewPage)))
+	if err != nil || limit < 1 || limit > maxReviewPage {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("limit must be 1 to %d", maxReviewPage)))
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, common.GetError("offset must be a non-negative integer"))
+		return
+	}
+	list, err := a.listFlaggedProblems(source, c.Query("generator"), limit, offset)
+	if err != nil {
+		glog.Errorf("%s listFlaggedProblems: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list flagged problems"))
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// bindReviewProblem loads the problem named by the :id path parameter,
+// answering 400 or 404 itself when it can't.
+func (a *Api) bindReviewProblem(logPrefix string, c *gin.Context) (*Problem, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid problem id"))
+		return nil, false
+	}
+	problem, status, msg, err := a.problemManager.Get(uint32(id))
+	if err != nil {
+		if status != http.StatusNotFound {
+			glog.Errorf("%s get problem %d: %v", logPrefix, id, err)
+		}
+		c.JSON(status, common.GetError(msg))
+		return nil, false
+	}
+	return problem, true
+}
+
+// problemRetired reports whether a problem has been retired.
+func (a *Api) problemRetired(problemID uint32) (bool, error) {
+	var one int
+	err := a.DB.QueryRow("SELECT 1 FROM retired_problems WHERE problem_id=?", problemID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// adminGetProblemReview handles GET /admin/problem-review/:id: the problem
+// with every flag and its audit trail.
+func (a *Api) adminGetProblemReview(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	problem, ok := a.bindReviewProblem(logPrefix, c)
+	if !ok {
+		return
+	}
+	detail, err := a.problemReviewDetail(problem)
+	if err != nil {
+		glog.Errorf("%s problemReviewDetail: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not load problem review"))
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
+
+func (a *Api) problemReviewDetail(problem *Problem) (*ProblemReviewDetail, error) {
+	d := &ProblemReviewDetail{FlaggedProblem: FlaggedProblem{Problem: *problem}}
+	flags, err := a.problemFlags(problem.Id, 0)
+	if err != nil {
+		return nil, err
+	}
+	d.Flags = flags
+	for i, f := range flags {
+		if i < reviewRecentFlagsCap {
+			d.RecentFlags = append(d.RecentFlags, f)
+		}
+		if f.Source == FLAG_SOURCE_USER {
+			d.UserFlagCount++
+		} else {
+			d.SystemFlagCount++
+		}
+		if f.FlaggedAt.After(d.LastFlaggedAt) {
+			d.LastFlaggedAt = f.FlaggedAt
+		}
+	}
+	d.FlagCount = len(flags)
+	stats, err := a.generatorPoolStats([]string{problem.Generator})
+	if err != nil {
+		return nil, err
+	}
+	d.GeneratorStats = stats[problem.Generator]
+	d.Admission = checkAdmission(problem)
+	if d.Retired, err = a.problemRetired(problem.Id); err != nil {
+		return nil, err
+	}
+	rows, err := a.DB.Query(`
+		SELECT admin_id, action, note, before_json, after_json, created_at
+		FROM problem_review_log WHERE problem_id = ? ORDER BY created_at, id`, problem.Id)
+	if err != nil {
+		return nil, fmt.Errorf("query problem_review_log: %w", err)
+	}
+	defer rows.Close()
+	d.Log = []ProblemReviewEntry{}
+	for rows.Next() {
+		var e ProblemReviewEntry
+		var before, after string
+		if err := rows.Scan(&e.AdminId, &e.Action, &e.Note, &before, &after, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan problem_review_log: %w", err)
+		}
+		e.Before, e.After = json.RawMessage(before), json.RawMessage(after)
+		d.Log = append(d.Log, e)
+	}
+	return d, rows.Err()
+}
+
+// bindReviewNote reads the optional {"note"} every review action accepts.
+func bindReviewNote(c *gin.Context, dst interface{}) bool {
+	if c.Request.ContentLength == 0 {
+		return true
+	}
+	if err := c.ShouldBindJSON(dst); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return false
+	}
+	return true
+}
+
+// adminEnableProblem handles POST /admin/problem-review/:id/enable {note}.
+func (a *Api) adminEnableProblem(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	problem, ok := a.bindReviewProblem(logPrefix, c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	if !bindReviewNote(c, &body) {
+		return
+	}
+	if len(body.Note) > maxReviewNote {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("note must be at most %d characters", maxReviewNote)))
+		return
+	}
+	if problem.Generator == AssignmentGenerator {
+		c.JSON(http.StatusConflict, common.GetError("Problem is private to an assignment"))
+		return
+	}
+	if retired, err := a.problemRetired(problem.Id); err != nil {
+		glog.Errorf("%s problemRetired: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not enable problem"))
+		return
+	} else if retired {
+		c.JSON(http.StatusConflict, common.GetError("Problem is retired"))
+		return
+	}
+	before := *problem
+	problem.Disabled = false
+	a.saveReviewedProblem(logPrefix, c, admin.Id, REVIEW_ENABLE, body.Note, &before, problem)
+}
+
+// adminDisableProblem handles POST /admin/problem-review/:id/disable {note}:
+// takes a live problem out of selection, as a flag would, without one.
+func (a *Api) adminDisableProblem(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	problem, ok := a.bindReviewProblem(logPrefix, c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	if !bindReviewNote(c, &body) {
+		return
+	}
+	if len(body.Note) > maxReviewNote {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("note must be at most %d characters", maxReviewNote)))
+		return
+	}
+	if problem.Generator == AssignmentGenerator {
+		c.JSON(http.StatusConflict, common.GetError("Problem is private to an assignment"))
+		return
+	}
+	// The flag and its audit row change together, under a lock on the
+	// problem, so concurrent disables can't both audit the live problem.
+	tx, err := a.DB.Begin()
+	if err != nil {
+		glog.Errorf("%s begin: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not disable problem"))
+		return
+	}
+	defer tx.Rollback()
+	var disabled bool
+	if err := tx.QueryRow("SELECT disabled FROM problems WHERE id=? FOR UPDATE", problem.Id).Scan(&disabled); err != nil {
+		glog.Errorf("%s select problems.disabled: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not disable problem"))
+		return
+	}
+	if disabled {
+		c.JSON(http.StatusConflict, common.GetError("Problem is already disabled"))
+		return
+	}
+	if _, err := tx.Exec("UPDATE problems SET disabled=1 WHERE id=?", problem.Id); err != nil {
+		glog.Errorf("%s update problems.disabled: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not disable problem"))
+		return
+	}
+	before := *problem
+	before.Disabled = false
+	problem.Disabled = true
+	if err := recordAuditNote(tx, admin.Id, AUDIT_PROBLEM_DISABLE, AUDIT_TARGET_PROBLEM,
+		strconv.FormatUint(uint64(problem.Id), 10), body.Note, &before, problem); err != nil {
+		glog.Errorf("%s audit problem disable: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not disable problem"))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		glog.Errorf("%s commit: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not disable problem"))
+		return
+	}
+	a.candidates.put(problem)
+	a.answerReviewed(logPrefix, c, admin.Id, REVIEW_DISABLE, problem)
+}
+
+// adminRetireProblem handles POST /admin/problem-review/:id/retire {note}. The
+// problem stays disabled and can no longer be enabled or edited.
+func (a *Api) adminRetireProblem(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	problem, ok := a.bindReviewProblem(logPrefix, c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Note string `json:"note"`
+	}
+	if !bindReviewNote(c, &body) {
+		return
+	}
+	if len(body.Note) > maxReviewNote {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("note must be at most %d characters", maxReviewNote)))
+		return
+	}
+	res, err := a.DB.Exec("INSERT IGNORE INTO retired_problems (problem_id, retired_by) VALUES (?, ?)", problem.Id, admin.Id)
+	if err != nil {
+		glog.Errorf("%s insert retired_problems: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not retire problem"))
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		c.JSON(http.StatusConflict, common.GetError("Problem is already retired"))
+		return
+	}
+	before := *problem
+	problem.Disabled = true
+	a.saveReviewedProblem(logPrefix, c, admin.Id, REVIEW_RETIRE, body.Note, &before, problem)
+}
+
+// adminEditProblem handles POST /admin/problem-review/:id/edit with
+// {expression, answer, explanation, symbolic_expression, note}; omitted
+// fields keep their value. The result is restamped through the admission
+// pipeline: an expression it rejects, or an answer that doesn't check out,
+// is a 400 naming the stage. The problem keeps its id and its disabled state.
+func (a *Api) adminEditProblem(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	problem, ok := a.bindReviewProblem(logPrefix, c)
+	if !ok {
+		return
+	}
+	var body struct {
+		Expression         *string `json:"expression"`
+		Answer             *string `json:"answer"`
+		Explanation        *string `json:"explanation"`
+		SymbolicExpression *string `json:"symbolic_expression"`
+		Note               string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	if len(body.Note) > maxReviewNote {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("note must be at most %d characters", maxReviewNote)))
+		return
+	}
+	if problem.Generator == AssignmentGenerator {
+		c.JSON(http.StatusConflict, common.GetError("Problem is private to an assignment"))
+		return
+	}
+	if retired, err := a.problemRetired(problem.Id); err != nil {
+		glog.Errorf("%s problemRetired: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not edit problem"))
+		return
+	} else if retired {
+		c.JSON(http.StatusConflict, common.GetError("Problem is retired"))
+		return
+	}
+	edited := *problem
+	if body.Expression != nil {
+		edited.Expression = *body.Expression
+	}
+	if body.Answer != nil {
+		edited.Answer = strings.TrimSpace(*body.Answer)
+	}
+	if body.Explanation != nil {
+		edited.Explanation = *body.Explanation
+	}
+	if body.SymbolicExpression != nil {
+		edited.SymbolicExpression = strings.TrimSpace(*body.SymbolicExpression)
+	}
+	if err := restampProblem(&edited, problem.ProblemTypeBitmap); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	if edited.Expression != problem.Expression {
+		// Generation dedups by expression hash; an edit must not create a
+		// second copy of a problem already in the pool.
+		h := fnv.New32a()
+		h.Write([]byte(edited.Expression))
+		var other uint32
+		err := a.DB.QueryRow("SELECT id FROM problems WHERE (id = ? OR expression = ?) AND id <> ? LIMIT 1",
+			h.Sum32(), edited.Expression, problem.Id).Scan(&other)
+		if err == nil {
+			c.JSON(http.StatusConflict, common.GetError(fmt.Sprintf("Problem %d already has that expression", other)))
+			return
+		} else if err != sql.ErrNoRows {
+			glog.Errorf("%s edit collision check: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not edit problem"))
+			return
+		}
+	}
+	a.saveReviewedProblem(logPrefix, c, admin.Id, REVIEW_EDIT, body.Note, problem, &edited)
+}
+
+// restampProblem runs an edited problem through the admission pipeline, as
+// generation does, and recomputes its bitmap and difficulty. A WORD problem
+// is checked against its symbolic_expression, which it must have; as the
+// validator isn't consulted, it keeps the topic bits it had (oldBitmap).
+func restampProblem(p *Problem, oldBitmap uint64) error {
+	adm := mathcore.AdmitExpression(p.Expression)
+	if adm.RejectStage != "" {
+		return fmt.Errorf("expression rejected at %s: %s", adm.RejectStage, adm.RejectWhy)
+	}
+	if p.Answer == "" {
+		return fmt.Errorf("answer is required")
+	}
+	bitmap := adm.Bitmap
+	symbolic := ""
+	if bitmap&uint64(mathcore.WORD) == 0 {
+		if err := mathcore.VerifyAnswerSymbolic(adm.Tokens, p.Answer); err != nil {
+			return fmt.Errorf("answer check failed: %v", err)
+		}
+	} else {
+		if p.SymbolicExpression == "" {
+			return fmt.Errorf("a word problem needs a symbolic_expression to check its answer")
+		}
+		sym := mathcore.AdmitExpression(p.SymbolicExpression)
+		if sym.RejectStage != "" {
+			return fmt.Errorf("symbolic_expression rejected at %s: %s", sym.RejectStage, sym.RejectWhy)
+		}
+		if err := mathcore.VerifyAnswerSymbolic(sym.Tokens, p.Answer); err != nil {
+			return fmt.Errorf("answer check against symbolic_expression failed: %v", err)
+		}
+		symbolic = sym.Expr
+		topics := uint64(mathcore.FeaturesToProblemType(mathcore.ValidatorFeatureNames))
+		bitmap |= sym.Bitmap | (oldBitmap & topics)
+	}
+	p.Expression = adm.Expr
+	p.SymbolicExpression = symbolic
+	p.Explanation = RewriteLetterInProse(p.Explanation, adm.RewroteLetter)
+	p.ProblemTypeBitmap = mathcore.NormalizeProblemBitmap(bitmap)
+	p.Difficulty = mathcore.ComputeProblemDifficulty(adm.Expr, symbolic)
+	p.DifficultyVersion = mathcore.DifficultyVersion
+	return nil
+}
+
+// saveReviewedProblem writes a reviewed problem and its audit row, then
+// answers with the updated review detail.
+func (a *Api) saveReviewedProblem(logPrefix string, c *gin.Context, adminID uint32, action, note string, before, after *Problem) {
+	status, msg, err := a.problemManager.Update(after)
+	if err != nil {
+		glog.Errorf("%s update problem %d: %v", logPrefix, after.Id, err)
+		c.JSON(status, common.GetError(msg))
+		return
+	}
+	a.candidates.put(after)
+	if err := recordAuditNote(a.DB, adminID, reviewAuditActions[action], AUDIT_TARGET_PROBLEM,
+		strconv.FormatUint(uint64(after.Id), 10), note, before, after); err != nil {
+		glog.Errorf("%s audit %s problem=%d: %v", logPrefix, action, after.Id, err)
+	}
+	a.answerReviewed(logPrefix, c, adminID, action, after)
+}
+
+// answerReviewed logs a review action and answers with the problem's updated
+// review detail.
+func (a *Api) answerReviewed(logPrefix string, c *gin.Context, adminID uint32, action string, problem *Problem) {
+	glog.Infof("%s problem review: admin=%d %s problem=%d", logPrefix, adminID, action, problem.Id)
+	detail, err := a.problemReviewDetail(problem)
+	if err != nil {
+		glog.Errorf("%s problemReviewDetail: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not load problem review"))
+		return
+	}
+	c.JSON(http.StatusOK, detail)
+}
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+func TestRestampProblem(t *testing.T) {
+	p := Problem{Expression: "2 + 3", Answer: "5", DifficultyVersion: "old"}
+	if err := restampProblem(&p, 0); err != nil {
+		t.Fatalf("valid edit: %v", err)
+	}
+	if p.ProblemTypeBitmap == 0 || p.Difficulty == 0 || p.DifficultyVersion != mathcore.DifficultyVersion {
+		t.Errorf("want bitmap and difficulty recomputed, got %+v", p)
+	}
+	bad := []Problem{
+		{Expression: "2 + 3", Answer: "6"},
+		{Expression: "2 + 3", Answer: ""},
+		{Expression: "2 +", Answer: "2"},
+	}
+	for _, b := range bad {
+		if err := restampProblem(&b, 0); err == nil {
+			t.Errorf("%+v: want an error", b)
+		}
+	}
+}
+
+func fetchFlaggedProblems(t *testing.T, r http.Handler, admin *User, query string) []FlaggedProblem {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/admin/problem-review?%stest_auth0_id=%s", query, admin.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("list flagged: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	var list []FlaggedProblem
+	if err := json.Unmarshal(resp.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode flagged: %v", err)
+	}
+	return list
+}
+
+// TestProblemReview_Workflow checks a flagged problem reaches the review
+// queue with its reporter, and that enable, edit and retire each act on it
+// and leave an audit row.
+func TestProblemReview_Workflow(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	admin := createTestUser(t, r, "auth0|review-admin", "reviewadmin@test.com", "reviewadmin")
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE id=?", RoleAdmin, admin.Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+	user := createTestUser(t, r, "auth0|review-user", "reviewuser@test.com", "reviewuser")
+	for _, p := range []Problem{
+		{Id: 9101, Expression: "2 + 3", Answer: "5", Generator: "review-test"},
+		{Id: 9102, Expression: "4 + 4", Answer: "8", Generator: "review-test"},
+	} {
+		p := p
+		if _, _, err := api.problemManager.Create(&p); err != nil {
+			t.Fatalf("create problem: %v", err)
+		}
+	}
+	var gs Gamestate
+	fetchGamestate(t, r, user, &gs)
+
+	reportEvent(t, r, user, BAD_PROBLEM_USER, `{"problem_id": 9101, "explanation": "answer looks wrong"}`)
+	reportEvent(t, r, user, BAD_PROBLEM_SYSTEM, `{"problem_id": 9101}`)
+	reportEvent(t, r, user, BAD_PROBLEM_USER, `{"problem_id": 9102}`)
+
+	if resp := catalogRequest(t, r, user, "GET", "/admin/problem-review", ""); resp.Code != http.StatusForbidden {
+		t.Errorf("student listing: want 403, got %d", resp.Code)
+	}
+	list := fetchFlaggedProblems(t, r, admin, "")
+	if len(list) != 2 || list[0].Id != 9101 || list[0].FlagCount != 2 || list[0].UserFlagCount != 1 ||
+		list[0].SystemFlagCount != 1 {
+		t.Fatalf("want both problems queued, most-flagged first, got %+v", list)
+	}
+	first := list[0]
+	if first.GeneratorStats.Total != 2 || first.GeneratorStats.Flagged != 2 || first.Admission.RejectStage != "" {
+		t.Errorf("want generator stats and a clean admission check, got %+v %+v", first.GeneratorStats, first.Admission)
+	}
+	found := false
+	for _, f := range first.RecentFlags {
+		if f.Source == FLAG_SOURCE_USER && f.Username == "reviewuser" && f.Explanation == "answer looks wrong" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("want the user's flag and explanation, got %+v", first.RecentFlags)
+	}
+	if list := fetchFlaggedProblems(t, r, admin, "source=system&"); len(list) != 1 || list[0].Id != 9101 {
+		t.Errorf("source=system: want only 9101, got %+v", list)
+	}
+
+	path := func(id uint32, action string) string { return fmt.Sprintf("/admin/problem-review/%d/%s", id, action) }
+	if resp := catalogRequest(t, r, admin, "POST", path(9101, "edit"), `{"answer": "6"}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("wrong answer: want 400, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", path(9101, "edit"), `{"expression": "4 + 4", "answer": "8"}`); resp.Code != http.StatusConflict {
+		t.Errorf("duplicate expression: want 409, got %d", resp.Code)
+	}
+	resp := catalogRequest(t, r, admin, "POST", path(9101, "edit"), `{"expression": "2 + 4", "answer": "6", "note": "typo"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("edit: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, admin, "POST", path(9101, "enable"), ""); resp.Code != http.StatusOK {
+		t.Fatalf("enable: want 200, got %d", resp.Code)
+	}
+	p, _, _, err := api.problemManager.Get(9101)
+	if err != nil || p.Disabled || p.Expression != "2 + 4" || p.Answer != "6" || p.DifficultyVersion != mathcore.DifficultyVersion {
+		t.Errorf("want 9101 edited and live, got %+v (%v)", p, err)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", path(9101, "disable"), `{"note": "again"}`); resp.Code != http.StatusOK {
+		t.Fatalf("disable: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	if resp := catalogRequest(t, r, admin, "POST", path(9101, "disable"), ""); resp.Code != http.StatusConflict {
+		t.Errorf("disable twice: want 409, got %d", resp.Code)
+	}
+	if p, _, _, err := api.problemManager.Get(9101); err != nil || !p.Disabled {
+		t.Errorf("want 9101 disabled, got %+v (%v)", p, err)
+	}
+	disabled := 0
+	if err := api.DB.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE action = ? AND target_id = '9101' AND note = 'again'`,
+		AUDIT_PROBLEM_DISABLE).Scan(&disabled); err != nil || disabled != 1 {
+		t.Errorf("want one problem_disable audit row, got %d (%v)", disabled, err)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", path(9101, "enable"), ""); resp.Code != http.StatusOK {
+		t.Fatalf("re-enable: want 200, got %d", resp.Code)
+	}
+
+	if resp := catalogRequest(t, r, admin, "POST", path(9102, "retire"), `{"note": "unsalvageable"}`); resp.Code != http.StatusOK {
+		t.Fatalf("retire: want 200, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", path(9102, "retire"), ""); resp.Code != http.StatusConflict {
+		t.Errorf("retire twice: want 409, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "POST", path(9102, "enable"), ""); resp.Code != http.StatusConflict {
+		t.Errorf("enable retired: want 409, got %d", resp.Code)
+	}
+	if list := fetchFlaggedProblems(t, r, admin, ""); len(list) != 0 {
+		t.Errorf("want the queue empty once reviewed, got %+v", list)
+	}
+
+	resp = catalogRequest(t, r, admin, "GET", "/admin/problem-review/9101", "")
+	var detail ProblemReviewDetail
+	if err := json.Unmarshal(resp.Body.Bytes(), &detail); err != nil || len(detail.Flags) != 2 || len(detail.Log) != 4 ||
+		detail.Log[0].Action != REVIEW_EDIT || detail.Log[0].Note != "typo" || detail.Log[1].Action != REVIEW_ENABLE ||
+		detail.Log[2].Action != REVIEW_DISABLE || detail.Log[2].Note != "again" {
+		t.Fatalf("want both flags and the edit, enable, disable and enable logged, got %+v (%v)", detail, err)
+	}
+	var before Problem
+	if err := json.Unmarshal(detail.Log[0].Before, &before); err != nil || before.Expression != "2 + 3" {
+		t.Errorf("want the pre-edit problem in the log, got %s", detail.Log[0].Before)
+	}
+	if resp := catalogRequest(t, r, admin, "GET", "/admin/problem-review/999999", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("unknown problem: want 404, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,287 @@
+// problem_search.go: the admin problem search and browse API.
+//
+// GET /admin/problems filters the pool by bitmap (subset_of / superset_of),
+// difficulty range, generator, difficulty_version, disabled state and an
+// expression substring, and pages through it by id with an opaque cursor.
+// Each row carries its live DifficultyBreakdown (recomputed now, so a row
+// stamped under an older DifficultyVersion shows where the formula has moved)
+// and its usage: times served from SELECTED_PROBLEM events, and attempts and
+// first-try solves from the statistics cache (statistics_topic_attempts), so
+// usage covers only users whose cache has been built. Registered under
+// /api/v1/admin behind RequireAdmin; documented in docs/problem-generation.md.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+const (
+	defaultProblemSearchPage = 50
+	maxProblemSearchPage     = 200
+	maxProblemSearchText     = 128
+)
+
+// ProblemSearchQuery is a parsed GET /admin/problems request. Zero values
+// don't filter; Disabled is nil for either state.
+type ProblemSearchQuery struct {
+	SubsetOf          uint64
+	SupersetOf        uint64
+	MinDifficulty     float64
+	MaxDifficulty     float64
+	Generator         string
+	DifficultyVersion string
+	Disabled          *bool
+	Text              string
+	AfterId           uint32
+	Limit             int
+}
+
+// ProblemUsage is how a problem has fared with children.
+type ProblemUsage struct {
+	TimesServed     int64    `json:"times_served"`
+	Attempts        int64    `json:"attempts"`
+	FirstTryCorrect int64    `json:"first_try_correct"`
+	FirstTryRate    *float64 `json:"first_try_rate"` // nil until attempted
+}
+
+// ProblemSearchRow is one search result.
+type ProblemSearchRow struct {
+	Problem
+	Retired   bool                         `json:"retired"`
+	Bits      []string                     `json:"bits"`
+	Breakdown mathcore.DifficultyBreakdown `json:"breakdown"`
+	Usage     ProblemUsage                 `json:"usage"`
+}
+
+// ProblemSearchResult is a page of results. NextCursor is "" on the last page.
+type ProblemSearchResult struct {
+	Problems   []ProblemSearchRow `json:"problems"`
+	NextCursor string             `json:"next_cursor"`
+}
+
+// parseProblemSearchQuery reads a search from query parameters, returning an
+// error whose text is the 400 body.
+func parseProblemSearchQuery(v url.Values) (ProblemSearchQuery, error) {
+	q := ProblemSearchQuery{Limit: defaultProblemSearchPage}
+	var err error
+	for name, dst := range map[string]*uint64{"subset_of": &q.SubsetOf, "superset_of": &q.SupersetOf} {
+		if s := v.Get(name); s != "" {
+			if *dst, err = strconv.ParseUint(s, 10, 64); err != nil {
+				return q, fmt.Errorf("%s must be an unsigned integer", name)
+			}
+		}
+	}
+	for name, dst := range map[string]*float64{"min_difficulty": &q.MinDifficulty, "max_difficulty": &q.MaxDifficulty} {
+		if s := v.Get(name); s != "" {
+			if *dst, err = strconv.ParseFloat(s, 64); err != nil || *dst < 0 {
+				return q, fmt.Errorf("%s must be a non-negative number", name)
+			}
+		}
+	}
+	if q.MaxDifficulty > 0 && q.MinDifficulty > q.MaxDifficulty {
+		return q, fmt.Errorf("min_difficulty must not exceed max_difficulty")
+	}
+	if s := v.Get("disabled"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("disabled must be true or false")
+		}
+		q.Disabled = &b
+	}
+	q.Generator = v.Get("generator")
+	q.DifficultyVersion = v.Get("difficulty_version")
+	q.Text = strings.TrimSpace(v.Get("q"))
+	if len(q.Text) > maxProblemSearchText {
+		return q, fmt.Errorf("q must be at most %d characters", maxProblemSearchText)
+	}
+	if s := v.Get("cursor"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return q, fmt.Errorf("invalid cursor")
+		}
+		q.AfterId = uint32(id)
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 || q.Limit > maxProblemSearchPage {
+			return q, fmt.Errorf("limit must be 1 to %d", maxProblemSearchPage)
+		}
+	}
+	return q, nil
+}
+
+// escapeLike escapes s for use inside a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// searchProblems returns one page of problems matching q, ordered by id.
+func (a *Api) searchProblems(q ProblemSearchQuery) (*ProblemSearchResult, error) {
+	where := []string{"p.id > ?"}
+	args := []interface{}{q.AfterId}
+	if q.SubsetOf != 0 {
+		where = append(where, "p.problem_type_bitmap & ~? = 0")
+		args = append(args, q.SubsetOf)
+	}
+	if q.SupersetOf != 0 {
+		where = append(where, "p.problem_type_bitmap & ? = ?")
+		args = append(args, q.SupersetOf, q.SupersetOf)
+	}
+	if q.MinDifficulty > 0 {
+		where = append(where, "p.difficulty >= ?")
+		args = append(args, q.MinDifficulty)
+	}
+	if q.MaxDifficulty > 0 {
+		where = append(where, "p.difficulty <= ?")
+		args = append(args, q.MaxDifficulty)
+	}
+	if q.Generator != "" {
+		where = append(where, "p.generator = ?")
+		args = append(args, q.Generator)
+	}
+	if q.DifficultyVersion != "" {
+		where = append(where, "p.difficulty_version = ?")
+		args = append(args, q.DifficultyVersion)
+	}
+	if q.Disabled != nil {
+		where = append(where, "p.disabled = ?")
+		args = append(args, *q.Disabled)
+	}
+	if q.Text != "" {
+		where = append(where, "p.expression LIKE ?")
+		args = append(args, "%"+escapeLike(q.Text)+"%")
+	}
+	args = append(args, q.Limit+1)
+	rows, err := a.DB.Query(`
+		SELECT p.id, p.problem_type_bitmap, p.expression, p.answer, COALESCE(p.explanation, ''),
+		  p.symbolic_expression, p.difficulty, p.disabled, p.generator, p.difficulty_version,
+		  r.problem_id IS NOT NULL
+		FROM problems p
+		LEFT JOIN retired_problems r ON r.problem_id = p.id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY p.id
+		LIMIT ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query problems: %w", err)
+	}
+	defer rows.Close()
+	res := &ProblemSearchResult{Problems: []ProblemSearchRow{}}
+	for rows.Next() {
+		var row ProblemSearchRow
+		if err := rows.Scan(&row.Id, &row.ProblemTypeBitmap, &row.Expression, &row.Answer, &row.Explanation,
+			&row.SymbolicExpression, &row.Difficulty, &row.Disabled, &row.Generator, &row.DifficultyVersion,
+			&row.Retired); err != nil {
+			return nil, fmt.Errorf("scan problem: %w", err)
+		}
+		res.Problems = append(res.Problems, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	if len(res.Problems) > q.Limit {
+		res.Problems = res.Problems[:q.Limit]
+		res.NextCursor = strconv.FormatUint(uint64(res.Problems[q.Limit-1].Id), 10)
+	}
+
+	usage, err := a.problemUsage(res.Problems)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res.Problems {
+		p := &res.Problems[i]
+		p.Bits = mathcore.ProblemTypeToFeatures(mathcore.ProblemType(p.ProblemTypeBitmap))
+		sort.Strings(p.Bits)
+		p.Breakdown = mathcore.ComputeDifficultyBreakdownFor(p.Expression, p.SymbolicExpression)
+		p.Usage = usage[p.Id]
+	}
+	return res, nil
+}
+
+// problemUsage joins times served and cached attempts onto a page of problems.
+func (a *Api) problemUsage(page []ProblemSearchRow) (map[uint32]ProblemUsage, error) {
+	usage := map[uint32]ProblemUsage{}
+	if len(page) == 0 {
+		return usage, nil
+	}
+	placeholders := make([]string, len(page))
+	served := []interface{}{SELECTED_PROBLEM}
+	ids := make([]interface{}, len(page))
+	for i, p := range page {
+		placeholders[i] = "?"
+		served = append(served, strconv.FormatUint(uint64(p.Id), 10))
+		ids[i] = p.Id
+	}
+	in := "(" + strings.Join(placeholders, ", ") + ")"
+
+	rows, err := a.DB.Query("SELECT value, COUNT(*) FROM events WHERE event_type = ? AND value IN "+in+" GROUP BY value", served...)
+	if err != nil {
+		return nil, fmt.Errorf("count served: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		var n int64
+		if err := rows.Scan(&value, &n); err != nil {
+			return nil, fmt.Errorf("scan served: %w", err)
+		}
+		id, _ := strconv.ParseUint(value, 10, 32)
+		u := usage[uint32(id)]
+		u.TimesServed = n
+		usage[uint32(id)] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = a.DB.Query(`
+		SELECT problem_id, COUNT(*), COALESCE(SUM(solved = 1 AND answers = 1), 0)
+		FROM statistics_topic_attempts WHERE problem_id IN `+in+` GROUP BY problem_id`, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("count attempts: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint32
+		var attempts, firstTry int64
+		if err := rows.Scan(&id, &attempts, &firstTry); err != nil {
+			return nil, fmt.Errorf("scan attempts: %w", err)
+		}
+		u := usage[id]
+		u.Attempts, u.FirstTryCorrect = attempts, firstTry
+		if attempts > 0 {
+			rate := float64(firstTry) / float64(attempts)
+			u.FirstTryRate = &rate
+		}
+		usage[id] = u
+	}
+	return usage, rows.Err()
+}
+
+// adminSearchProblems handles GET /admin/problems.
+func (a *Api) adminSearchProblems(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	q, err := parseProblemSearchQuery(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	res, err := a.searchProblems(q)
+	if err != nil {
+		glog.Errorf("%s searchProblems: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not search problems"))
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
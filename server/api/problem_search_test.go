@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestParseProblemSearchQuery(t *testing.T) {
+	v, _ := url.ParseQuery("subset_of=7&superset_of=1&min_difficulty=2&max_difficulty=5.5&disabled=false&q=+3+&cursor=42&limit=10")
+	q, err := parseProblemSearchQuery(v)
+	if err != nil {
+		t.Fatalf("valid query: %v", err)
+	}
+	if q.SubsetOf != 7 || q.SupersetOf != 1 || q.MinDifficulty != 2 || q.MaxDifficulty != 5.5 ||
+		q.Disabled == nil || *q.Disabled || q.Text != "3" || q.AfterId != 42 || q.Limit != 10 {
+		t.Errorf("want every filter parsed, got %+v", q)
+	}
+	if q, _ := parseProblemSearchQuery(url.Values{}); q.Limit != defaultProblemSearchPage || q.Disabled != nil {
+		t.Errorf("empty query: want the default page and no disabled filter, got %+v", q)
+	}
+	for _, bad := range []string{"subset_of=-1", "min_difficulty=x", "min_difficulty=6&max_difficulty=5", "disabled=maybe", "cursor=abc", "limit=0", "limit=201"} {
+		v, _ := url.ParseQuery(bad)
+		if _, err := parseProblemSearchQuery(v); err == nil {
+			t.Errorf("%s: want an error", bad)
+		}
+	}
+	if got := escapeLike(`50%_\`); got != `50\%\_\\` {
+		t.Errorf("escapeLike: got %q", got)
+	}
+}
+
+func searchProblemsAs(t *testing.T, r http.Handler, user *User, query string) ProblemSearchResult {
+	t.Helper()
+	resp := catalogRequest(t, r, user, "GET", "/admin/problems?"+query, "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("search %q: want 200, got %d %s", query, resp.Code, resp.Body.String())
+	}
+	var res ProblemSearchResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode search: %v", err)
+	}
+	return res
+}
+
+func searchIds(res ProblemSearchResult) []uint32 {
+	ids := []uint32{}
+	for _, p := range res.Problems {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+// TestProblemSearch checks the filters, cursor paging and the usage joined
+// onto each row.
+func TestProblemSearch(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	admin := createTestUser(t, r, "auth0|search-admin", "searchadmin@test.com", "searchadmin")
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE id=?", RoleAdmin, admin.Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+	for _, p := range []Problem{
+		{Id: 9201, ProblemTypeBitmap: 1, Expression: "2 + 3", Answer: "5", Difficulty: 3, Generator: "search-test", DifficultyVersion: "v1"},
+		{Id: 9202, ProblemTypeBitmap: 3, Expression: "7 - 2 + 3", Answer: "8", Difficulty: 4, Generator: "search-test", DifficultyVersion: "v1"},
+		{Id: 9203, ProblemTypeBitmap: 2, Expression: "9 - 4", Answer: "5", Difficulty: 6, Generator: "search-test", DifficultyVersion: "v2"},
+	} {
+		p := p
+		if _, _, err := api.problemManager.Create(&p); err != nil {
+			t.Fatalf("create problem: %v", err)
+		}
+	}
+	if _, err := api.DB.Exec("UPDATE problems SET disabled = 1 WHERE id = 9203"); err != nil {
+		t.Fatalf("disable: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := api.DB.Exec("INSERT INTO events (user_id, event_type, value) VALUES (?, ?, '9201')", admin.Id, SELECTED_PROBLEM); err != nil {
+			t.Fatalf("insert event: %v", err)
+		}
+	}
+	for i, answers := range []int{1, 1, 2, 1} {
+		if _, err := api.DB.Exec(`INSERT INTO statistics_topic_attempts (user_id, end_event_id, ended_at, problem_id, answers, solved)
+			VALUES (?, ?, NOW(), 9201, ?, 1)`, admin.Id, i+1, answers); err != nil {
+			t.Fatalf("insert attempt: %v", err)
+		}
+	}
+
+	if resp := catalogRequest(t, r, createTestUser(t, r, "auth0|search-user", "searchuser@test.com", "searchuser"),
+		"GET", "/admin/problems", ""); resp.Code != http.StatusForbidden {
+		t.Errorf("student searching: want 403, got %d", resp.Code)
+	}
+
+	cases := map[string]string{
+		"generator=search-test":                          "[9201 9202 9203]",
+		"generator=search-test&subset_of=1":              "[9201]",
+		"generator=search-test&superset_of=2":            "[9202 9203]",
+		"generator=search-test&min_difficulty=3.5":       "[9202 9203]",
+		"generator=search-test&disabled=false":           "[9201 9202]",
+		"generator=search-test&difficulty_version=v2":    "[9203]",
+		"generator=search-test&q=%2B+3":                  "[9201 9202]",
+		"generator=search-test&q=%25":                    "[]",
+		"generator=search-test&max_difficulty=3&q=2+%2B": "[9201]",
+	}
+	for query, want := range cases {
+		if got := searchIds(searchProblemsAs(t, r, admin, query)); fmt.Sprint(got) != want {
+			t.Errorf("%s: want %s, got %v", query, want, got)
+		}
+	}
+
+	page := searchProblemsAs(t, r, admin, "generator=search-test&limit=2")
+	if fmt.Sprint(searchIds(page)) != "[9201 9202]" || page.NextCursor == "" {
+		t.Fatalf("first page: want two rows and a cursor, got %+v", page)
+	}
+	next := searchProblemsAs(t, r, admin, "generator=search-test&limit=2&cursor="+page.NextCursor)
+	if fmt.Sprint(searchIds(next)) != "[9203]" || next.NextCursor != "" {
+		t.Errorf("last page: want the third row and no cursor, got %+v", next)
+	}
+
+	row := page.Problems[0]
+	if row.Usage.TimesServed != 3 || row.Usage.Attempts != 4 || row.Usage.FirstTryCorrect != 3 ||
+		row.Usage.FirstTryRate == nil || *row.Usage.FirstTryRate != 0.75 {
+		t.Errorf("want 3 served, 3 of 4 first try, got %+v", row.Usage)
+	}
+	if row.Breakdown.Scaled == 0 || len(row.Bits) != 1 {
+		t.Errorf("want a live breakdown and the bit names, got %+v %v", row.Breakdown, row.Bits)
+	}
+	if page.Problems[1].Usage.FirstTryRate != nil {
+		t.Errorf("unattempted problem: want no first-try rate, got %+v", page.Problems[1].Usage)
+	}
+}
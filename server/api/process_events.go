@@ -37,6 +37,65 @@ const (
 	maxTarget = 20
 )
 
+// validateEventValue checks an event's value against the rules processEvent
+// enforces before acting on it, returning an error whose text is the 400 body.
+// settings supplies the envelope ceiling for SET_TARGET_DIFFICULTY. Event types
+// without a value rule return nil.
+func validateEventValue(eventType, value string, settings *Settings) error {
+	switch eventType {
+	case SET_TARGET_DIFFICULTY:
+		// Upper bound is the bitmap-derived ceiling: a target above the
+		// hardest problem the envelope can express lands in an empty band
+		// (see MaxDiffForBitmap).
+		ceiling := mathcore.MaxDiffForBitmap(settings.ProblemTypeBitmap)
+		val, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil || val < mathcore.MinTargetDifficulty || val > ceiling {
+			return fmt.Errorf("Invalid target_difficulty: %s (must be %.0f-%.1f for the current problem types)", value, mathcore.MinTargetDifficulty, ceiling)
+		}
+	case SET_TARGET_WORK_PERCENTAGE:
+		val, parseErr := strconv.ParseUint(value, 10, 8)
+		if parseErr != nil || val < 1 || val > 100 {
+			return fmt.Errorf("Invalid target_work_percentage: %s (must be 1-100)", value)
+		}
+	case SET_PROBLEM_TYPE_BITMAP:
+		// Only shape validity is checked here (nonzero, defined bits). The
+		// dependency rules (core-op required, LARGE=>MEDIUM,
+		// MISMATCHED=>FRACTIONS, PEMDAS=>CHAINED) are enforced by the
+		// settings UI's validateBitmap; an API client bypassing
+		// them gets an incoherent-but-harmless envelope (the ceiling and
+		// subset selection both degrade gracefully).
+		val, parseErr := strconv.ParseUint(value, 10, 64)
+		if parseErr != nil || val == 0 || mathcore.ProblemType(val)&^mathcore.ALL_PROBLEM_TYPES != 0 {
+			return fmt.Errorf("Invalid problem_type_bitmap: %s (must be 1-%d)", value, uint64(mathcore.ALL_PROBLEM_TYPES))
+		}
+	case SET_GAMESTATE_TARGET:
+		val, parseErr := strconv.ParseUint(value, 10, 32)
+		if parseErr != nil || val < 5 || val > 20 {
+			return fmt.Errorf("Invalid gamestate_target: %s (must be 5-20)", value)
+		}
+	case SELECTED_PROBLEM:
+		if value != "" {
+			val, parseErr := strconv.ParseUint(value, 10, 32)
+			if parseErr != nil || val == 0 {
+				return fmt.Errorf("Invalid problem_id: %s", value)
+			}
+		}
+	case WORKING_ON_PROBLEM:
+		val, parseErr := strconv.ParseInt(value, 10, 64)
+		if parseErr != nil || val < 0 || val > 3600000 {
+			return fmt.Errorf("Invalid working_on_problem duration: %s (must be 0-3600000ms)", value)
+		}
+	case WATCHING_VIDEO:
+		// Float because the player reports fractional milliseconds; NaN and
+		// Inf parse but would poison the reward cycle's watched total.
+		val, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil || math.IsNaN(val) || math.IsInf(val, 0) || val < 0 || val > 3600000 {
+			return fmt.Errorf("Invalid watching_video duration: %s (must be 0-3600000ms)", value)
+		}
+	}
+	return nil
+}
+
 // recentProblemHistorySize and the other recency-related sizes live in
 // generate_problems.go alongside the rest of the selection-funnel constants.
 
@@ -44,6 +103,14 @@ const (
 // Use this for LOGGED_IN, WORKING_ON_PROBLEM, WATCHING_VIDEO, SET_TARGET_WORK_PERCENTAGE.
 func (a *Api) processRecordOnlyEvents(logPrefix string, c *gin.Context, events []*Event) error {
 	user := GetUserFromContext(c)
+	// None of these types' rules read settings, so none is loaded.
+	for _, event := range events {
+		if err := validateEventValue(event.EventType, event.Value, nil); err != nil {
+			glog.Errorf("%s %s", logPrefix, err)
+			c.JSON(http.StatusBadRequest, err.Error())
+			return err
+		}
+	}
 	if err := a.createEventsBatch(user.Id, events); err != nil {
 		glog.Errorf("%s createEventsBatch: %v", logPrefix, err)
 		c.JSON(http.StatusInternalServerError, common.GetError("Couldn't add events to database"))
@@ -103,6 +170,8 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 	changed_gamestate := false
 	changed_settings := false
 	select_new_problem := false
+	// ProblemTypeBitmap of a problem solved by this event, for the achievements engine
+	var solvedProblemBitmap uint64
 
 	// The main event to be processed as well as any side-effect events we add in this function
 	events := []*Event{event}
@@ -125,71 +194,50 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 		})
 	}
 
+	// Reject malformed values before any side effect. The same rules gate the
+	// replay engine (replay.go), so a logged event is always one processEvent
+	// would have accepted.
+	if err := validateEventValue(event.EventType, event.Value, settings); err != nil {
+		glog.Errorf("%s %s", logPrefix, err)
+		c.JSON(http.StatusBadRequest, err.Error())
+		return err
+	}
+
+	// A WATCHING_VIDEO report past the cycle's cutoff (plus grace) ends the
+	// video as if the player had sent DONE_WATCHING_VIDEO, which is logged.
+	videoCutoff := false
+	if event.EventType == WATCHING_VIDEO && gamestate.Solved >= gamestate.Target {
+		cycle, err := a.currentRewardCycle(user.Id, settings)
+		if err != nil {
+			glog.Errorf("%s currentRewardCycle: %v (not enforcing the video cutoff)", logPrefix, err)
+		} else if watched, err := strconv.ParseFloat(event.Value, 64); err != nil {
+			// Unreachable past validateEventValue; don't count a bad report.
+			glog.Errorf("%s watching_video value %q: %v (not enforcing the video cutoff)", logPrefix, event.Value, err)
+		} else if cycle.withWatched(watched).overBudget() {
+			glog.Infof("%s video %d past its %ds cutoff, ending it", logPrefix, gamestate.VideoId, cycle.budgetSeconds)
+			videoCutoff = true
+			events = append(events, &Event{
+				EventType: DONE_WATCHING_VIDEO,
+				Value:     strconv.FormatUint(uint64(gamestate.VideoId), 10),
+			})
+		}
+	}
+
 	if event.EventType == LOGGED_IN {
 		// no-op
 	} else if event.EventType == SET_TARGET_DIFFICULTY {
-		// Upper bound is the bitmap-derived ceiling: a target above the
-		// hardest problem the envelope can express lands in an empty band
-		// (see MaxDiffForBitmap).
-		ceiling := mathcore.MaxDiffForBitmap(settings.ProblemTypeBitmap)
-		val, parseErr := strconv.ParseFloat(event.Value, 64)
-		if parseErr != nil || val < mathcore.MinTargetDifficulty || val > ceiling {
-			msg := fmt.Sprintf("Invalid target_difficulty: %s (must be %.0f-%.1f for the current problem types)", event.Value, mathcore.MinTargetDifficulty, ceiling)
-			glog.Errorf("%s %s", logPrefix, msg)
-			c.JSON(http.StatusBadRequest, msg)
-			return errors.New(msg)
-		}
 		select_new_problem = true
 	} else if event.EventType == SET_TARGET_WORK_PERCENTAGE {
-		val, parseErr := strconv.ParseUint(event.Value, 10, 8)
-		if parseErr != nil || val < 1 || val > 100 {
-			msg := fmt.Sprintf("Invalid target_work_percentage: %s (must be 1-100)", event.Value)
-			glog.Errorf("%s %s", logPrefix, msg)
-			c.JSON(http.StatusBadRequest, msg)
-			return errors.New(msg)
-		}
+		// no-op beyond validation
 	} else if event.EventType == SET_PROBLEM_TYPE_BITMAP {
-		// Only shape validity is checked here (nonzero, defined bits). The
-		// dependency rules (core-op required, LARGE=>MEDIUM,
-		// MISMATCHED=>FRACTIONS, PEMDAS=>CHAINED) are enforced by the
-		// settings UI's validateBitmap; an API client bypassing
-		// them gets an incoherent-but-harmless envelope (the ceiling and
-		// subset selection both degrade gracefully).
-		val, parseErr := strconv.ParseUint(event.Value, 10, 64)
-		if parseErr != nil || val == 0 || mathcore.ProblemType(val)&^mathcore.ALL_PROBLEM_TYPES != 0 {
-			msg := fmt.Sprintf("Invalid problem_type_bitmap: %s (must be 1-%d)", event.Value, uint64(mathcore.ALL_PROBLEM_TYPES))
-			glog.Errorf("%s %s", logPrefix, msg)
-			c.JSON(http.StatusBadRequest, msg)
-			return errors.New(msg)
-		}
 		select_new_problem = true
-		a.generateProblemsBackground(logPrefix, settings)
+		a.enqueueGeneration(logPrefix, settings)
 	} else if event.EventType == SET_GAMESTATE_TARGET {
-		val, parseErr := strconv.ParseUint(event.Value, 10, 32)
-		if parseErr != nil || val < 5 || val > 20 {
-			msg := fmt.Sprintf("Invalid gamestate_target: %s (must be 5-20)", event.Value)
-			glog.Errorf("%s %s", logPrefix, msg)
-			c.JSON(http.StatusBadRequest, msg)
-			return errors.New(msg)
-		}
+		// no-op beyond validation
 	} else if event.EventType == SELECTED_PROBLEM {
-		if event.Value != "" {
-			val, parseErr := strconv.ParseUint(event.Value, 10, 32)
-			if parseErr != nil || val == 0 {
-				msg := fmt.Sprintf("Invalid problem_id: %s", event.Value)
-				glog.Errorf("%s %s", logPrefix, msg)
-				c.JSON(http.StatusBadRequest, msg)
-				return errors.New(msg)
-			}
-		}
+		// no-op beyond validation
 	} else if event.EventType == WORKING_ON_PROBLEM {
-		val, parseErr := strconv.ParseInt(event.Value, 10, 64)
-		if parseErr != nil || val < 0 || val > 3600000 {
-			msg := fmt.Sprintf("Invalid working_on_problem duration: %s (must be 0-3600000ms)", event.Value)
-			glog.Errorf("%s %s", logPrefix, msg)
-			c.JSON(http.StatusBadRequest, msg)
-			return errors.New(msg)
-		}
+		// no-op beyond validation
 	} else if event.EventType == ANSWERED_PROBLEM {
 		// Get Problem
 		problem, status, msg, err := a.problemManager.Get(gamestate.ProblemId)
@@ -201,6 +249,7 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 			glog.Infof("%s %s", logPrefix, msg)
 			// Add to spaced repetition review queue
 			a.addToReviewQueue(logPrefix, user.Id, gamestate.ProblemId)
+			a.recordAssignmentAnswer(logPrefix, user.Id, gamestate.ProblemId, false)
 		} else { // Answer was correct
 			events = append(events, &Event{
 				EventType: SOLVED_PROBLEM,
@@ -208,10 +257,26 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 			})
 			// Advance spaced repetition if this was a review problem
 			a.advanceReviewQueue(logPrefix, user.Id, gamestate.ProblemId)
+			a.recordAssignmentAnswer(logPrefix, user.Id, gamestate.ProblemId, true)
+			solvedProblemBitmap = problem.ProblemTypeBitmap
 			// Update counts
 			gamestate.Solved += 1
 			// Select a new problem
 			select_new_problem = true
+			// The cycle's last problem: re-pick the reward to fit the time
+			// its work earned (video_budget.go).
+			if gamestate.Solved == gamestate.Target {
+				cycle, err := a.currentRewardCycle(user.Id, settings)
+				if err != nil {
+					glog.Errorf("%s currentRewardCycle: %v (keeping video %d)", logPrefix, err, gamestate.VideoId)
+				} else {
+					videoId, err := a.selectVideo(logPrefix, c, user.Id, map[uint32]bool{cycle.lastVideoId: true}, cycle.budgetSeconds)
+					if err != nil {
+						return err
+					}
+					gamestate.VideoId = videoId
+				}
+			}
 		}
 	} else if event.EventType == ERROR_PLAYING_VIDEO {
 		// Get the current video
@@ -228,15 +293,21 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 			return err
 		}
 		// Set a new reward video
-		videoId, err := a.selectVideo(logPrefix, c, user.Id, map[uint32]bool{gamestate.VideoId: true})
+		videoId, err := a.selectVideo(logPrefix, c, user.Id, map[uint32]bool{gamestate.VideoId: true}, a.rewardBudgetIfDue(logPrefix, gamestate, settings))
 		if err != nil {
 			return err
 		}
 		gamestate.VideoId = videoId
 		changed_gamestate = true
-	} else if event.EventType == WATCHING_VIDEO {
-		// TODO: validate duration
-	} else if event.EventType == DONE_WATCHING_VIDEO {
+	} else if event.EventType == WATCHING_VIDEO && !videoCutoff {
+		// no-op beyond validation
+	} else if event.EventType == DONE_WATCHING_VIDEO && gamestate.Solved < gamestate.Target {
+		// The cycle isn't finished, so there is no video to be done with: most
+		// often the player's own DONE_WATCHING_VIDEO arriving after a
+		// WATCHING_VIDEO cutoff already ended the video and reset Solved. The
+		// event is logged, but the adjuster and the re-pick don't run again.
+		glog.Infof("%s done_watching_video with %v < %v solved, ignoring", logPrefix, gamestate.Solved, gamestate.Target)
+	} else if event.EventType == DONE_WATCHING_VIDEO || videoCutoff {
 		// TODO: validate videoID
 
 		// Difficulty adjustment limits
@@ -255,6 +326,13 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 		// permanently (generation cannot fill an unreachable band). See
 		// MaxDiffForBitmap in difficulty.go.
 		maxDiff := mathcore.MaxDiffForBitmap(settings.ProblemTypeBitmap)
+		// A classroom's difficulty floor (classrooms.go) raises minDiff, never
+		// past the ceiling. The policy is cached per student.
+		if policy, err := a.cachedClassroomPolicy(user.Id); err != nil {
+			glog.Errorf("%s cachedClassroomPolicy: %v", logPrefix, err)
+		} else if policy != nil {
+			minDiff = math.Max(minDiff, math.Min(policy.floor, maxDiff))
+		}
 		// End difficulty adjustment limits
 
 		// Defensive: clamp any previously-runaway difficulty back into range.
@@ -278,10 +356,6 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 			})
 		}
 
-		if gamestate.Solved < gamestate.Target {
-			msg := fmt.Sprintf("Done watching video, but there's an inconsistency in problems solved: %v < %v", gamestate.Solved, gamestate.Target)
-			glog.Errorf("%s %s", logPrefix, msg)
-		}
 		// Calculate work % for the "recent past" of the user.
 		query := `SELECT work/total FROM
                                   (SELECT
@@ -347,15 +421,24 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 		gamestate.Solved = 0
 		changed_gamestate = true
 
-		// Set a new reward video
-		videoId, err := a.selectVideo(logPrefix, c, user.Id, map[uint32]bool{gamestate.VideoId: true})
+		// Rotate the finished video to the back of the line.
+		a.recordRecentlyWatched(logPrefix, user.Id, gamestate.VideoId)
+
+		// Set a new reward video. The next cycle's work is unknown yet, so
+		// this pick ignores duration; the cycle's last solve re-picks to fit.
+		videoId, err := a.selectVideo(logPrefix, c, user.Id, map[uint32]bool{gamestate.VideoId: true}, 0)
 		if err != nil {
 			return err
 		}
 		gamestate.VideoId = videoId
 		changed_gamestate = true
+
+		// Past a screen-time limit the next cycle is prepared but not
+		// started: helpGetPlayData answers with the SessionOver instead of
+		// the next problem until the limit clears.
 	} else if event.EventType == BAD_PROBLEM_SYSTEM || event.EventType == BAD_PROBLEM_USER {
-		// Disable the reported problem, falling back to gamestate.ProblemId.
+		// Disable the reported problem, falling back to gamestate.ProblemId,
+		// and keep the report for the review workbench (problem_review.go).
 		badID := parseBadProblemID(event.Value)
 		if badID == 0 {
 			badID = gamestate.ProblemId
@@ -365,11 +448,14 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 			return err
 		}
 		glog.Infof("%s Disabling problem: %v", logPrefix, problem)
+		a.recordProblemFlag(logPrefix, user.Id, problem.Id, event.EventType, event.Value)
 		problem.Disabled = true
 		status, msg, err = a.problemManager.Update(problem)
 		if HandleMngrResp(logPrefix, c, status, msg, err, problem) != nil {
 			return err
 		}
+		a.candidates.put(problem)
+		a.skipReportedAssignmentItem(logPrefix, user.Id, problem.Id)
 		// Only re-select if the disabled problem is the current one.
 		if badID == gamestate.ProblemId {
 			select_new_problem = true
@@ -415,6 +501,9 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 			recordRecentlyShown(logPrefix, a.DB, e.UserId, e.Value)
 		}
 	}
+	// Fold the committed batch into the achievement counters. Also derived and
+	// best-effort; earned achievements are logged as their own events.
+	a.evaluateAchievements(logPrefix, user.Id, events, solvedProblemBitmap)
 
 	// Write the updated settings
 	if changed_settings {
@@ -449,7 +538,7 @@ func (a *Api) processEvent(logPrefix string, c *gin.Context, event *Event, write
 
 	// Write the Play data to the response body
 	if writeCtx {
-		a.helpGetPlayData(logPrefix, c, gamestate)
+		a.helpGetPlayData(logPrefix, c, gamestate, settings)
 	}
 
 	return nil
@@ -527,14 +616,18 @@ func (a *Api) loadGamestateAndSettings(userID uint32) (*Gamestate, *Settings, er
 	err := a.DB.QueryRow(`
 		SELECT
 		  g.user_id, g.problem_id, g.video_id, g.solved, g.target,
-		  s.problem_type_bitmap, s.target_difficulty, s.target_work_percentage
+		  s.problem_type_bitmap, s.target_difficulty, s.target_work_percentage, s.digest_opt_in,
+		  s.daily_limit_minutes, s.daily_video_limit_minutes, s.quiet_hours_start, s.quiet_hours_end, s.timezone,
+		  s.topic_weights
 		FROM gamestates g
 		JOIN settings s ON s.user_id = g.user_id
 		WHERE g.user_id = ?`,
 		userID,
 	).Scan(
 		&gs.UserId, &gs.ProblemId, &gs.VideoId, &gs.Solved, &gs.Target,
-		&s.ProblemTypeBitmap, &s.TargetDifficulty, &s.TargetWorkPercentage,
+		&s.ProblemTypeBitmap, &s.TargetDifficulty, &s.TargetWorkPercentage, &s.DigestOptIn,
+		&s.DailyLimitMinutes, &s.DailyVideoLimitMinutes, &s.QuietHoursStart, &s.QuietHoursEnd, &s.Timezone,
+		&s.TopicWeights,
 	)
 	if err != nil {
 		return nil, nil, err
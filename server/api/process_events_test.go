@@ -156,6 +156,26 @@ func TestProcessEvents_WatchingVideo_Accepted(t *testing.T) {
 	}
 }
 
+func TestValidateEventValue_WatchingVideo(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		ok    bool
+	}{
+		{"0", true},
+		{"1000.25", true},
+		{"3600000", true},
+		{"-1", false},
+		{"3600000.5", false},
+		{"NaN", false},
+		{"+Inf", false},
+		{"banana", false},
+	} {
+		if err := validateEventValue(WATCHING_VIDEO, tc.value, nil); (err == nil) != tc.ok {
+			t.Errorf("watching_video %q: want ok=%v, got %v", tc.value, tc.ok, err)
+		}
+	}
+}
+
 func TestProcessEvents_AnsweredProblem_WrongAnswer_DoesNotIncrementSolved(t *testing.T) {
 	c, err := common.ReadConfig("../../test_conf.json")
 	if err != nil {
@@ -0,0 +1,329 @@
+// Package api: event-log replay.
+//
+// The events table is the source of truth for a user's settings and gamestate;
+// the settings and gamestates rows are the folded result. replayEvents re-folds
+// the state-bearing events from scratch, applying the same validation
+// processEvent does (validateEventValue), so an operator can see what a user's
+// rows looked like at any instant and restore them after a bad write.
+//
+// Replay is pure: it reads events and computes, nothing else. No problem is
+// generated or selected, no review queue is touched, and no events are written.
+// RestoreUserState is the only writer, and it appends the SET_* events that
+// reproduce the restored values so that a later replay to "now" converges on
+// the restored rows instead of undoing them.
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// replayEventTypes are the events that change settings or gamestate columns.
+// Everything else in the log is either record-only or derived from these.
+var replayEventTypes = []string{
+	SET_PROBLEM_TYPE_BITMAP,
+	SET_TARGET_DIFFICULTY,
+	SET_TARGET_WORK_PERCENTAGE,
+	SET_GAMESTATE_TARGET,
+	SELECTED_PROBLEM,
+	SOLVED_PROBLEM,
+	DONE_WATCHING_VIDEO,
+}
+
+// ReplayFieldDiff is one column whose replayed value differs from the row.
+type ReplayFieldDiff struct {
+	Table    string `json:"table"`
+	Field    string `json:"field"`
+	Current  string `json:"current"`
+	Replayed string `json:"replayed"`
+}
+
+// ReplaySkippedEvent is a logged event the fold refused, with the reason.
+type ReplaySkippedEvent struct {
+	EventId   uint32 `json:"event_id"`
+	EventType string `json:"event_type"`
+	Value     string `json:"value"`
+	Reason    string `json:"reason"`
+}
+
+// ReplayResult is the state a user's event log folds to as of AsOf, and how it
+// differs from the current rows.
+type ReplayResult struct {
+	UserId        uint32               `json:"user_id"`
+	AsOf          time.Time            `json:"as_of"`
+	EventsApplied int                  `json:"events_applied"`
+	EventsSkipped []ReplaySkippedEvent `json:"events_skipped"`
+	Settings      Settings             `json:"settings"`
+	Gamestate     Gamestate            `json:"gamestate"`
+	Diffs         []ReplayFieldDiff    `json:"diffs"`
+}
+
+// applyReplayEvent folds one event into settings and gamestate using
+// processEvent's rules. It returns an error (and leaves state untouched) for a
+// value processEvent would have rejected.
+func applyReplayEvent(e *Event, settings *Settings, gamestate *Gamestate) error {
+	if err := validateEventValue(e.EventType, e.Value, settings); err != nil {
+		return err
+	}
+	switch e.EventType {
+	case SET_PROBLEM_TYPE_BITMAP:
+		v, _ := strconv.ParseUint(e.Value, 10, 64)
+		settings.ProblemTypeBitmap = v
+	case SET_TARGET_DIFFICULTY:
+		v, _ := strconv.ParseFloat(e.Value, 64)
+		settings.TargetDifficulty = v
+	case SET_TARGET_WORK_PERCENTAGE:
+		v, _ := strconv.ParseUint(e.Value, 10, 8)
+		settings.TargetWorkPercentage = uint8(v)
+	case SET_GAMESTATE_TARGET:
+		v, _ := strconv.ParseUint(e.Value, 10, 32)
+		gamestate.Target = uint32(v)
+	case SELECTED_PROBLEM:
+		if e.Value != "" {
+			v, _ := strconv.ParseUint(e.Value, 10, 32)
+			gamestate.ProblemId = uint32(v)
+		}
+	case SOLVED_PROBLEM:
+		gamestate.Solved++
+	case DONE_WATCHING_VIDEO:
+		// processEvent ignores a DONE_WATCHING_VIDEO mid-cycle.
+		if gamestate.Solved >= gamestate.Target {
+			gamestate.Solved = 0
+		}
+	}
+	return nil
+}
+
+// replayEvents folds events (in id order) from zero state. The returned
+// settings and gamestate carry userID; VideoId, DigestOptIn, the session
+// limits and the topic weights are left zero because they are not logged.
+func replayEvents(userID uint32, events []*Event) (Settings, Gamestate, int, []ReplaySkippedEvent) {
+	settings := Settings{UserId: userID}
+	gamestate := Gamestate{UserId: userID}
+	applied := 0
+	skipped := []ReplaySkippedEvent{}
+	for _, e := range events {
+		if err := applyReplayEvent(e, &settings, &gamestate); err != nil {
+			skipped = append(skipped, ReplaySkippedEvent{
+				EventId:   e.Id,
+				EventType: e.EventType,
+				Value:     e.Value,
+				Reason:    err.Error(),
+			})
+			continue
+		}
+		applied++
+	}
+	return settings, gamestate, applied, skipped
+}
+
+// diffReplayState lists the columns where the replayed state differs from the
+// current rows. VideoId is not compared (it is not reconstructible).
+func diffReplayState(curSettings *Settings, curGamestate *Gamestate, settings *Settings, gamestate *Gamestate) []ReplayFieldDiff {
+	diffs := []ReplayFieldDiff{}
+	add := func(table, field, cur, replayed string) {
+		if cur != replayed {
+			diffs = append(diffs, ReplayFieldDiff{Table: table, Field: field, Current: cur, Replayed: replayed})
+		}
+	}
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	add("settings", "problem_type_bitmap", u(curSettings.ProblemTypeBitmap), u(settings.ProblemTypeBitmap))
+	add("settings", "target_difficulty", f(curSettings.TargetDifficulty), f(settings.TargetDifficulty))
+	add("settings", "target_work_percentage", u(uint64(curSettings.TargetWorkPercentage)), u(uint64(settings.TargetWorkPercentage)))
+	add("gamestates", "problem_id", u(uint64(curGamestate.ProblemId)), u(uint64(gamestate.ProblemId)))
+	add("gamestates", "solved", u(uint64(curGamestate.Solved)), u(uint64(gamestate.Solved)))
+	add("gamestates", "target", u(uint64(curGamestate.Target)), u(uint64(gamestate.Target)))
+	return diffs
+}
+
+// ReplayUserState folds the user's event log up to and including asOf and
+// diffs the result against the current rows. It writes nothing.
+func (a *Api) ReplayUserState(userID uint32, asOf time.Time) (*ReplayResult, error) {
+	curGamestate, curSettings, err := a.loadGamestateAndSettings(userID)
+	if err != nil {
+		return nil, fmt.Errorf("loading current state: %w", err)
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(replayEventTypes)), ", ")
+	args := []interface{}{userID, asOf.UTC()}
+	for _, t := range replayEventTypes {
+		args = append(args, t)
+	}
+	rows, err := a.DB.Query(
+		"SELECT id, timestamp, event_type, value FROM events WHERE user_id = ? AND timestamp <= ? AND event_type IN ("+placeholders+") ORDER BY id",
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("loading events: %w", err)
+	}
+	defer rows.Close()
+	events := []*Event{}
+	for rows.Next() {
+		e := &Event{UserId: userID}
+		if err := rows.Scan(&e.Id, &e.Timestamp, &e.EventType, &e.Value); err != nil {
+			return nil, fmt.Errorf("scanning events: %w", err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scanning events: %w", err)
+	}
+	if len(events) == 0 {
+		return nil, errNoReplayEvents
+	}
+
+	settings, gamestate, applied, skipped := replayEvents(userID, events)
+	// Not event-sourced: keep the current values so a restore leaves them be.
+	gamestate.VideoId = curGamestate.VideoId
+	settings.DigestOptIn = curSettings.DigestOptIn
+	settings.DailyLimitMinutes = curSettings.DailyLimitMinutes
+	settings.DailyVideoLimitMinutes = curSettings.DailyVideoLimitMinutes
+	settings.QuietHoursStart = curSettings.QuietHoursStart
+	settings.QuietHoursEnd = curSettings.QuietHoursEnd
+	settings.Timezone = curSettings.Timezone
+	settings.TopicWeights = curSettings.TopicWeights
+	return &ReplayResult{
+		UserId:        userID,
+		AsOf:          asOf.UTC(),
+		EventsApplied: applied,
+		EventsSkipped: skipped,
+		Settings:      settings,
+		Gamestate:     gamestate,
+		Diffs:         diffReplayState(curSettings, curGamestate, &settings, &gamestate),
+	}, nil
+}
+
+// errNoReplayEvents means the user has no state-bearing events at or before
+// asOf (e.g. asOf predates account creation), so there is nothing to restore.
+var errNoReplayEvents = errors.New("no events at or before as_of")
+
+// RestoreUserState replays to asOf and, when anything differs, writes the
+// replayed settings and gamestate rows and appends the events that set them.
+func (a *Api) RestoreUserState(logPrefix string, userID uint32, asOf time.Time) (*ReplayResult, error) {
+	result, err := a.ReplayUserState(userID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Diffs) == 0 {
+		return result, nil
+	}
+
+	if _, _, err := a.settingsManager.Update(&result.Settings); err != nil {
+		return nil, fmt.Errorf("writing settings: %w", err)
+	}
+	if _, _, err := a.gamestateManager.Update(&result.Gamestate); err != nil {
+		return nil, fmt.Errorf("writing gamestate: %w", err)
+	}
+
+	// Re-log the restored values (in processEvent's field order) so the log
+	// folds to the restored rows. Solved has no SET_ event; it resets to the
+	// replayed count on the next DONE_WATCHING_VIDEO regardless.
+	events := []*Event{}
+	for _, d := range result.Diffs {
+		switch d.Field {
+		case "problem_type_bitmap":
+			events = append(events, &Event{EventType: SET_PROBLEM_TYPE_BITMAP, Value: d.Replayed})
+		case "target_difficulty":
+			events = append(events, &Event{EventType: SET_TARGET_DIFFICULTY, Value: strconv.FormatFloat(result.Settings.TargetDifficulty, 'E', -1, 64)})
+		case "target_work_percentage":
+			events = append(events, &Event{EventType: SET_TARGET_WORK_PERCENTAGE, Value: d.Replayed})
+		case "target":
+			events = append(events, &Event{EventType: SET_GAMESTATE_TARGET, Value: d.Replayed})
+		case "problem_id":
+			if result.Gamestate.ProblemId != 0 {
+				events = append(events, &Event{EventType: SELECTED_PROBLEM, Value: d.Replayed})
+			}
+		}
+	}
+	if err := a.createEventsBatch(userID, events); err != nil {
+		return nil, fmt.Errorf("logging restore events: %w", err)
+	}
+	if result.Gamestate.ProblemId != 0 {
+		recordRecentlyShown(logPrefix, a.DB, userID, strconv.FormatUint(uint64(result.Gamestate.ProblemId), 10))
+	}
+	glog.Infof("%s restored user=%d to %s (%d fields)", logPrefix, userID, result.AsOf.Format(time.RFC3339), len(result.Diffs))
+	return result, nil
+}
+
+// parseReplayRequest reads the :user_id path param and an optional RFC3339
+// as_of (query or form); an empty as_of means now.
+func parseReplayRequest(c *gin.Context) (uint32, time.Time, error) {
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil || userID == 0 {
+		return 0, time.Time{}, fmt.Errorf("Invalid user_id: %s", c.Param("user_id"))
+	}
+	raw := c.Query("as_of")
+	if raw == "" {
+		raw = c.PostForm("as_of")
+	}
+	if raw == "" {
+		return uint32(userID), time.Now().UTC(), nil
+	}
+	asOf, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("Invalid as_of: %s (must be RFC3339)", raw)
+	}
+	return uint32(userID), asOf, nil
+}
+
+func replayErrorStatus(err error) int {
+	if errors.Is(err, errNoReplayEvents) || errors.Is(err, sql.ErrNoRows) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// adminReplayUserState is the dry run: the state the log folds to as of as_of
+// and its diff against the current rows.
+func (a *Api) adminReplayUserState(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	userID, asOf, err := parseReplayRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	result, err := a.ReplayUserState(userID, asOf)
+	if err != nil {
+		glog.Errorf("%s replay user=%d: %v", logPrefix, userID, err)
+		c.JSON(replayErrorStatus(err), common.GetError(err.Error()))
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// adminRestoreUserState applies the replayed state to the user's rows.
+func (a *Api) adminRestoreUserState(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	admin := GetUserFromContext(c)
+	userID, asOf, err := parseReplayRequest(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError(err.Error()))
+		return
+	}
+	result, err := a.RestoreUserState(logPrefix, userID, asOf)
+	if err != nil {
+		glog.Errorf("%s restore user=%d: %v", logPrefix, userID, err)
+		c.JSON(replayErrorStatus(err), common.GetError(err.Error()))
+		return
+	}
+	if len(result.Diffs) > 0 {
+		before, after := gin.H{}, gin.H{"as_of": asOf}
+		for _, d := range result.Diffs {
+			before[d.Table+"."+d.Field] = d.Current
+			after[d.Table+"."+d.Field] = d.Replayed
+		}
+		a.audit(logPrefix, admin.Id, AUDIT_USER_RESTORE, AUDIT_TARGET_USER, strconv.FormatUint(uint64(userID), 10), before, after)
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// signupEvents mirrors what customCreateOrUpdateUser logs for a new user.
+func signupEvents() []*Event {
+	return []*Event{
+		{Id: 1, EventType: SET_PROBLEM_TYPE_BITMAP, Value: "1"},
+		{Id: 2, EventType: SET_TARGET_DIFFICULTY, Value: "3E+00"},
+		{Id: 3, EventType: SET_TARGET_WORK_PERCENTAGE, Value: "70"},
+		{Id: 4, EventType: SET_GAMESTATE_TARGET, Value: "10"},
+		{Id: 5, EventType: SELECTED_PROBLEM, Value: "77"},
+	}
+}
+
+func TestReplayEvents_Signup(t *testing.T) {
+	settings, gamestate, applied, skipped := replayEvents(9, signupEvents())
+	if applied != 5 || len(skipped) != 0 {
+		t.Fatalf("want 5 applied, 0 skipped; got %d, %v", applied, skipped)
+	}
+	want := Settings{UserId: 9, ProblemTypeBitmap: 1, TargetDifficulty: 3, TargetWorkPercentage: 70}
+	if settings != want {
+		t.Errorf("settings: want %+v, got %+v", want, settings)
+	}
+	if gamestate.UserId != 9 || gamestate.ProblemId != 77 || gamestate.Target != 10 || gamestate.Solved != 0 {
+		t.Errorf("gamestate: got %+v", gamestate)
+	}
+}
+
+func TestReplayEvents_SolvedCountsResetOnVideo(t *testing.T) {
+	events := append(signupEvents(), &Event{Id: 6, EventType: SET_GAMESTATE_TARGET, Value: "5"})
+	for id := uint32(7); id < 12; id++ {
+		events = append(events, &Event{Id: id, EventType: SOLVED_PROBLEM, Value: "77"})
+	}
+	events = append(events,
+		&Event{Id: 12, EventType: DONE_WATCHING_VIDEO, Value: "3"},
+		&Event{Id: 13, EventType: SOLVED_PROBLEM, Value: "79"},
+		// Mid-cycle, as when the player's DONE follows a server cutoff.
+		&Event{Id: 14, EventType: DONE_WATCHING_VIDEO, Value: "3"},
+	)
+	_, gamestate, _, _ := replayEvents(9, events)
+	if gamestate.Solved != 1 {
+		t.Errorf("solved: want 1 (reset by the cycle's DONE_WATCHING_VIDEO only), got %d", gamestate.Solved)
+	}
+}
+
+// TestReplayEvents_SkipsInvalid verifies the fold rejects what processEvent
+// would have rejected, including a difficulty above the ceiling of the bitmap
+// in effect at that point in the log.
+func TestReplayEvents_SkipsInvalid(t *testing.T) {
+	tooHard := fmt.Sprintf("%g", mathcore.MaxDiffForBitmap(1)+1)
+	events := append(signupEvents(),
+		&Event{Id: 6, EventType: SET_TARGET_WORK_PERCENTAGE, Value: "0"},
+		&Event{Id: 7, EventType: SET_GAMESTATE_TARGET, Value: "banana"},
+		&Event{Id: 8, EventType: SET_TARGET_DIFFICULTY, Value: tooHard},
+		&Event{Id: 9, EventType: SELECTED_PROBLEM, Value: ""},
+	)
+	settings, gamestate, applied, skipped := replayEvents(9, events)
+	if applied != 6 {
+		t.Errorf("applied: want 6, got %d", applied)
+	}
+	if len(skipped) != 3 || skipped[0].EventId != 6 || skipped[1].EventId != 7 || skipped[2].EventId != 8 {
+		t.Fatalf("skipped: want events 6,7,8; got %+v", skipped)
+	}
+	if settings.TargetWorkPercentage != 70 || settings.TargetDifficulty != 3 || gamestate.Target != 10 {
+		t.Errorf("invalid events changed state: %+v %+v", settings, gamestate)
+	}
+	if gamestate.ProblemId != 77 {
+		t.Errorf("empty SELECTED_PROBLEM should keep problem 77, got %d", gamestate.ProblemId)
+	}
+}
+
+func TestDiffReplayState(t *testing.T) {
+	cur := Settings{ProblemTypeBitmap: 1, TargetDifficulty: 3, TargetWorkPercentage: 40}
+	curGs := Gamestate{ProblemId: 5, Solved: 2, Target: 10, VideoId: 8}
+	replayed := Settings{ProblemTypeBitmap: 1, TargetDifficulty: 3.5, TargetWorkPercentage: 40}
+	replayedGs := Gamestate{ProblemId: 5, Solved: 2, Target: 10, VideoId: 99}
+	diffs := diffReplayState(&cur, &curGs, &replayed, &replayedGs)
+	if len(diffs) != 1 {
+		t.Fatalf("want 1 diff (video_id is not compared), got %+v", diffs)
+	}
+	want := ReplayFieldDiff{Table: "settings", Field: "target_difficulty", Current: "3", Replayed: "3.5"}
+	if diffs[0] != want {
+		t.Errorf("want %+v, got %+v", want, diffs[0])
+	}
+}
+
+// TestAdminRestoreUserState corrupts a settings row behind the event log's
+// back, then checks the dry run reports it and restore repairs it.
+func TestAdminRestoreUserState(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+
+	admin := createTestUser(t, r, "auth0id|replay-admin", "ra@test.com", "replayadmin")
+	if _, err := api.DB.Exec("UPDATE users SET role=? WHERE auth0_id=?", RoleAdmin, admin.Auth0Id); err != nil {
+		t.Fatalf("promote to admin: %v", err)
+	}
+	user := createTestUser(t, r, "auth0id|replay-user", "ru@test.com", "replayuser")
+	if _, err := api.DB.Exec("UPDATE settings SET target_work_percentage=5 WHERE user_id=?", user.Id); err != nil {
+		t.Fatalf("corrupt settings: %v", err)
+	}
+
+	call := func(method, action string) ReplayResult {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, fmt.Sprintf("/api/v1/admin/users/%d/%s?test_auth0_id=%s", user.Id, action, admin.Auth0Id), nil)
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected 200, got %d: %s", method, action, resp.Code, resp.Body.Bytes())
+		}
+		var result ReplayResult
+		if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+			t.Fatalf("unmarshal %s: %v", action, err)
+		}
+		return result
+	}
+
+	dry := call("GET", "replay")
+	if len(dry.Diffs) != 1 || dry.Diffs[0].Field != "target_work_percentage" || dry.Diffs[0].Replayed != "70" {
+		t.Fatalf("dry run: want target_work_percentage 5 -> 70, got %+v", dry.Diffs)
+	}
+	var stored uint8
+	if err := api.DB.QueryRow("SELECT target_work_percentage FROM settings WHERE user_id=?", user.Id).Scan(&stored); err != nil || stored != 5 {
+		t.Fatalf("dry run must not write: got %d (%v)", stored, err)
+	}
+
+	call("POST", "restore")
+	if err := api.DB.QueryRow("SELECT target_work_percentage FROM settings WHERE user_id=?", user.Id).Scan(&stored); err != nil || stored != 70 {
+		t.Fatalf("restore: want 70, got %d (%v)", stored, err)
+	}
+	if after := call("GET", "replay"); len(after.Diffs) != 0 {
+		t.Errorf("after restore: want no diffs, got %+v", after.Diffs)
+	}
+}
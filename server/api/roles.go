@@ -1,12 +1,17 @@
-// Package api: server-side authorization roles.
+// Package api: server-side authorization roles and the permissions they grant.
 //
 // The role lives on the users row (the DB is the source of truth) and defaults
-// to RoleStudent. There is no seeding machinery: the single operator is
-// promoted manually with `UPDATE users SET role='admin' WHERE auth0_id='...'`.
-// Privileged surfaces gate on RequireAdmin.
+// to RoleStudent. Each role grants a fixed set of permissions (rolePermissions);
+// routes gate on a permission, not on a role name. RequireAdmin (the /admin
+// group) and RequireTeacher (the /teacher group) are the two group gates, and
+// the admin routes that change something add the narrower permission with
+// RequirePermission. Roles are changed through POST /admin/users/:user_id/role
+// (audit.go records each change); the first operator is still promoted by hand
+// with `UPDATE users SET role='admin' WHERE auth0_id='...'`.
 package api
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -16,32 +21,94 @@ import (
 
 const (
 	RoleStudent = "student"
+	RoleTeacher = "teacher"
 	RoleAdmin   = "admin"
 )
 
-// RequireAdmin aborts the request with 403 unless the authenticated user has
-// the admin role. It reads the user loaded by UserMiddleware (which resolves
-// the validated token's identity to a users row), so it must be registered
-// after UserMiddleware.
-func (a *Api) RequireAdmin() gin.HandlerFunc {
+// Permission is one thing a role may do. The values are stable strings: they
+// appear in 403 messages and in docs/accounts.md.
+type Permission string
+
+const (
+	PermAdminAccess    Permission = "admin.access"          // read the operator surfaces
+	PermManageRoles    Permission = "roles.manage"          // grant and revoke roles
+	PermManageProblems Permission = "problems.manage"       // enable, edit, retire, import
+	PermManageVideos   Permission = "videos.manage"         // disable videos, curate the catalog
+	PermCalibrate      Permission = "calibration.recompute" // rebuild the calibration report
+	PermRestoreUsers   Permission = "users.restore"         // overwrite a user's state from replay
+	PermTeach          Permission = "classrooms.teach"      // own classrooms (classrooms.go)
+)
+
+// rolePermissions is the whole permission model. The roles don't nest: an
+// admin is not a teacher, so an operator who wants a classroom uses a teacher
+// account. A role missing from the map (a hand-edited row) has no permissions.
+var rolePermissions = map[string][]Permission{
+	RoleStudent: nil,
+	RoleTeacher: {PermTeach},
+	RoleAdmin: {PermAdminAccess, PermManageRoles, PermManageProblems, PermManageVideos,
+		PermCalibrate, PermRestoreUsers},
+}
+
+// validRole reports whether role is one rolePermissions knows.
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether role grants perm.
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePermission aborts the request with 403 and msg unless the
+// authenticated user's role grants perm. It reads the user loaded by
+// UserMiddleware (which resolves the validated token's identity to a users
+// row), so it must be registered after UserMiddleware.
+func requirePermission(perm Permission, msg string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := GetUserFromContextLenient(c)
-		if user == nil || user.Role != RoleAdmin {
-			c.AbortWithStatusJSON(http.StatusForbidden, common.GetError("Admin access required."))
+		if user == nil || !HasPermission(user.Role, perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, common.GetError(msg))
 			return
 		}
 		c.Next()
 	}
 }
 
+// RequirePermission aborts the request with 403 unless the authenticated
+// user's role grants perm.
+func (a *Api) RequirePermission(perm Permission) gin.HandlerFunc {
+	return requirePermission(perm, fmt.Sprintf("Permission required: %s", perm))
+}
+
+// RequireAdmin aborts the request with 403 unless the authenticated user may
+// use the operator surfaces (PermAdminAccess). Must follow UserMiddleware.
+func (a *Api) RequireAdmin() gin.HandlerFunc {
+	return requirePermission(PermAdminAccess, "Admin access required.")
+}
+
+// RequireTeacher aborts the request with 403 unless the authenticated user may
+// own classrooms (PermTeach). Like RequireAdmin it must follow UserMiddleware.
+// It admits the caller to the teacher surface only: which classrooms they may
+// touch is decided per request by requireOwnClassroom.
+func (a *Api) RequireTeacher() gin.HandlerFunc {
+	return requirePermission(PermTeach, "Teacher access required.")
+}
+
 // adminWhoami confirms admin access works end to end and gives the
 // /api/v1/admin group a first inhabitant for operator-only surfaces (e.g. a
 // future admin-only calibration page) to register alongside.
 func (a *Api) adminWhoami(c *gin.Context) {
 	user := GetUserFromContext(c)
 	c.JSON(http.StatusOK, gin.H{
-		"auth0_id": user.Auth0Id,
-		"id":       user.Id,
-		"role":     user.Role,
+		"auth0_id":    user.Auth0Id,
+		"id":          user.Id,
+		"role":        user.Role,
+		"permissions": rolePermissions[user.Role],
 	})
 }
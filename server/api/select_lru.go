@@ -22,12 +22,20 @@ func (a *Api) pickWithRecencyBias(logPrefix string, userID uint32, candidates []
 		glog.Errorf("%s lastShownAt: %v (falling back to uniform random)", logPrefix, err)
 		return local[rand.Intn(len(local))]
 	}
-	sort.SliceStable(local, recencyLess(local, lastShown))
-	topN := int(float64(len(local)) * lruTopFrac)
+	top := recencyTopSlice(local, lastShown)
+	return top[rand.Intn(len(top))]
+}
+
+// recencyTopSlice sorts ids least-recently-shown first, in place, and returns
+// the top lruTopFrac of them (at least one) - the slice the pick is uniform
+// over.
+func recencyTopSlice(ids []uint32, lastShown map[uint32]time.Time) []uint32 {
+	sort.SliceStable(ids, recencyLess(ids, lastShown))
+	topN := int(float64(len(ids)) * lruTopFrac)
 	if topN < 1 {
 		topN = 1
 	}
-	return local[rand.Intn(topN)]
+	return ids[:topN]
 }
 
 // recencyLess orders never-shown first, then oldest-shown to most-recent.
@@ -0,0 +1,262 @@
+// selection_explain.go: GET /admin/users/:user_id/selection, a dry run of
+// selectProblem's decision path for one user.
+//
+// It answers "why does my kid keep getting these?" without reading glog: the
+// open assignment item, the review queue check, the candidate tiers by
+// generator rank, the recency exclusions, the topic interleaving, the
+// least-recently-shown slice the pick is uniform over, and which fallback
+// would fire. Nothing is written: no problem is served, generated or queued,
+// and no metric moves. It follows the decision path selectProblem takes on
+// the next problem after an answer (process_events.go), so a change to
+// selectProblem's stages belongs here too. Part of the selection system -
+// documented in docs/selection.md.
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// Where an explained selection would get its problem.
+const (
+	SELECT_FROM_ASSIGNMENT = "assignment"
+	SELECT_FROM_REVIEW     = "review"
+	SELECT_FROM_POOL       = "pool"
+	SELECT_FROM_HEURISTIC  = "heuristic"
+	SELECT_FROM_LLM        = "llm"
+)
+
+// AssignmentExplain is the assignment stage, ahead of spaced repetition.
+type AssignmentExplain struct {
+	Open         int    `json:"open"`          // the user's uncompleted assignments
+	AssignmentId uint32 `json:"assignment_id"` // the assignment the next item is from, or 0
+	Position     int    `json:"position"`
+	ProblemId    uint32 `json:"problem_id"` // the item selection would serve, or 0
+	Skips        int    `json:"skips"`      // unavailable items selection would skip first
+}
+
+// ReviewExplain is the spaced-repetition stage.
+type ReviewExplain struct {
+	Due       int    `json:"due"`        // the user's due review_queue rows
+	ProblemId uint32 `json:"problem_id"` // the due review selection would serve, or 0
+	Used      bool   `json:"used"`
+	Reason    string `json:"reason"`
+}
+
+// TierExplain is one generator rank's share of the satisfying set.
+type TierExplain struct {
+	Rank       int      `json:"rank"`
+	Generators []string `json:"generators"` // the generatorRank entries at this rank; empty for unranked
+	Problems   int      `json:"problems"`   // in the envelope and window, not recently shown
+	Excluded   int      `json:"excluded"`   // in the envelope and window, but recently shown
+	Served     bool     `json:"served"`     // the tier selection picks from
+}
+
+// TopicExplain is one enabled topic's standing in the interleaving stage.
+type TopicExplain struct {
+	Topic       string  `json:"topic"`
+	Weight      float64 `json:"weight"`
+	Recent      int     `json:"recent"`       // of the last topicHistorySize shown problems
+	Since       int     `json:"since"`        // problems shown since it last appeared
+	Candidates  int     `json:"candidates"`   // pool problems carrying it
+	Overdue     bool    `json:"overdue"`      // the pick must carry it
+	FocusChance float64 `json:"focus_chance"` // the odds a biased pick narrows to it
+}
+
+// RecencyCandidate is a problem in the slice the pick is uniform over.
+type RecencyCandidate struct {
+	ProblemId   uint32     `json:"problem_id"`
+	LastShownAt *time.Time `json:"last_shown_at"` // nil: never shown, or trimmed from the cache
+}
+
+// SelectionExplain is the GET /admin/users/:user_id/selection payload.
+type SelectionExplain struct {
+	UserId            uint32             `json:"user_id"`
+	ProblemTypeBitmap uint64             `json:"problem_type_bitmap"`
+	TargetDifficulty  float64            `json:"target_difficulty"`
+	DifficultyMin     float64            `json:"difficulty_min"`
+	DifficultyMax     float64            `json:"difficulty_max"`
+	Assignment        AssignmentExplain  `json:"assignment"`
+	Review            ReviewExplain      `json:"review"`
+	RecentExclusions  []uint32           `json:"recent_exclusions"`
+	Tiers             []TierExplain      `json:"tiers"` // highest rank first
+	PoolSize          int                `json:"pool_size"`
+	Topics            []TopicExplain     `json:"topics"`
+	TopicsForced      bool               `json:"topics_forced"` // overdue topics narrowed the pool; top_slice is over what's left
+	TopSlice          []RecencyCandidate `json:"top_slice"`
+	WouldQueue        bool               `json:"would_queue"` // the pool is under minSelectionPool
+	LiveJob           *GenerationJob     `json:"live_job"`    // the job a queue request would join
+	Source            string             `json:"source"`
+}
+
+// explainSelection walks selectProblem's stages for settings, reading only.
+func (a *Api) explainSelection(logPrefix string, settings *Settings) (*SelectionExplain, error) {
+	ex := &SelectionExplain{
+		UserId:            settings.UserId,
+		ProblemTypeBitmap: settings.ProblemTypeBitmap,
+		TargetDifficulty:  settings.TargetDifficulty,
+		DifficultyMin:     settings.TargetDifficulty - problemSelectionEpsilon,
+		DifficultyMax:     settings.TargetDifficulty + problemSelectionEpsilon,
+		Tiers:             []TierExplain{},
+		Topics:            []TopicExplain{},
+		TopSlice:          []RecencyCandidate{},
+	}
+
+	// Assignments. Their items preempt every other stage.
+	if err := a.DB.QueryRow("SELECT COUNT(*) FROM assignments WHERE user_id = ? AND completed_at IS NULL",
+		settings.UserId).Scan(&ex.Assignment.Open); err != nil {
+		return nil, fmt.Errorf("count open assignments: %w", err)
+	}
+	item, skip, err := a.peekAssignmentItem(settings.UserId)
+	if err != nil {
+		return nil, err
+	}
+	ex.Assignment.Skips = len(skip)
+	if item != nil {
+		ex.Assignment.AssignmentId, ex.Assignment.Position, ex.Assignment.ProblemId = item.assignmentID, item.position, item.problemID
+	}
+
+	// [0] Spaced repetition.
+	if err := a.DB.QueryRow("SELECT COUNT(*) FROM review_queue WHERE user_id = ? AND next_review_at <= NOW()",
+		settings.UserId).Scan(&ex.Review.Due); err != nil {
+		return nil, fmt.Errorf("count due reviews: %w", err)
+	}
+	ex.Review.ProblemId = a.getDueReviewProblem(logPrefix, settings)
+	switch {
+	case ex.Review.Due == 0:
+		ex.Review.Reason = "no reviews due"
+	case ex.Review.ProblemId == 0:
+		ex.Review.Reason = "due reviews are disabled, outside the envelope, or above target + epsilon"
+	default:
+		p, status, _, err := a.problemManager.Get(ex.Review.ProblemId)
+		if err != nil || status != http.StatusOK || p.Disabled {
+			ex.Review.Reason = "the earliest due review is no longer servable"
+		} else {
+			ex.Review.Used = true
+			ex.Review.Reason = "the earliest due review preempts selection"
+		}
+	}
+
+	// [1] The satisfying set, by tier.
+	ex.RecentExclusions = loadRecentProblemIds(logPrefix, a.DB, settings.UserId)
+	var pool []uint32
+	var tiers map[int32]*candidateTier
+	if err := a.readCandidates(func(s *candidateSet) {
+		pool = s.lookup(settings.ProblemTypeBitmap, ex.DifficultyMin, ex.DifficultyMax, ex.RecentExclusions)
+		tiers = s.tiers(settings.ProblemTypeBitmap, ex.DifficultyMin, ex.DifficultyMax, ex.RecentExclusions)
+	}); err != nil {
+		return nil, err
+	}
+	servedRank, served := int32(0), false
+	for rank, t := range tiers {
+		if t.problems > 0 && (!served || rank > servedRank) {
+			servedRank, served = rank, true
+		}
+	}
+	names := map[int][]string{}
+	for g, r := range generatorRank {
+		names[r] = append(names[r], g)
+	}
+	for rank, t := range tiers {
+		gens := append([]string{}, names[int(rank)]...)
+		sort.Strings(gens)
+		ex.Tiers = append(ex.Tiers, TierExplain{
+			Rank:       int(rank),
+			Generators: gens,
+			Problems:   t.problems,
+			Excluded:   t.excluded,
+			Served:     served && rank == servedRank,
+		})
+	}
+	sort.Slice(ex.Tiers, func(i, j int) bool { return ex.Tiers[i].Rank > ex.Tiers[j].Rank })
+	ex.PoolSize = len(pool)
+
+	ex.WouldQueue = ex.PoolSize < minSelectionPool
+	live, err := a.liveGenerationJob(settings.ProblemTypeBitmap, difficultyBand(settings.TargetDifficulty))
+	if err != nil {
+		return nil, err
+	}
+	ex.LiveJob = live
+
+	// Topic interleaving. A forced narrowing is deterministic, so the top
+	// slice below follows it; a biased one is a draw, so only its odds show.
+	plan, bitmaps, err := a.planTopicsFor(logPrefix, settings, pool)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range plan.stats {
+		ex.Topics = append(ex.Topics, TopicExplain{
+			Topic:       mathcore.ProblemTypeToFeatures(st.bit)[0],
+			Weight:      st.weight,
+			Recent:      st.recent,
+			Since:       st.since,
+			Candidates:  st.candidates,
+			Overdue:     plan.overdue&st.bit != 0,
+			FocusChance: plan.chances[st.bit],
+		})
+	}
+	if plan.overdue != 0 {
+		pool, _ = plan.narrow(pool, bitmaps, 0)
+		ex.TopicsForced = true
+	}
+
+	if len(pool) > 0 {
+		lastShown, err := a.lastShownAt(settings.UserId, pool)
+		if err != nil {
+			return nil, fmt.Errorf("lastShownAt: %w", err)
+		}
+		for _, id := range recencyTopSlice(pool, lastShown) {
+			rc := RecencyCandidate{ProblemId: id}
+			if ts, ok := lastShown[id]; ok {
+				rc.LastShownAt = &ts
+			}
+			ex.TopSlice = append(ex.TopSlice, rc)
+		}
+	}
+
+	// [2]/[3] The fallbacks, as selectProblem orders them.
+	switch {
+	case ex.Assignment.ProblemId != 0:
+		ex.Source = SELECT_FROM_ASSIGNMENT
+	case ex.Review.Used:
+		ex.Source = SELECT_FROM_REVIEW
+	case len(pool) > 0:
+		ex.Source = SELECT_FROM_POOL
+	case mathcore.ProblemType(settings.ProblemTypeBitmap)&^mathcore.WORD != 0:
+		ex.Source = SELECT_FROM_HEURISTIC
+	default:
+		ex.Source = SELECT_FROM_LLM
+	}
+	return ex, nil
+}
+
+// adminExplainSelection handles GET /admin/users/:user_id/selection.
+func (a *Api) adminExplainSelection(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 32)
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("Invalid user_id: %s", c.Param("user_id"))))
+		return
+	}
+	settings, status, msg, err := a.settingsManager.Get(uint32(userID))
+	if err != nil {
+		c.JSON(status, common.GetError(msg))
+		return
+	}
+	ex, err := a.explainSelection(logPrefix, settings)
+	if err != nil {
+		glog.Errorf("%s explainSelection user=%d: %v", logPrefix, userID, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not explain selection"))
+		return
+	}
+	c.JSON(http.StatusOK, ex)
+}
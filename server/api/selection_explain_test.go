@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+// TestExplainSelection checks the explanation's review, tier, exclusion,
+// top-slice and fallback fields, and that explaining writes nothing.
+func TestExplainSelection(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	alice := createTestUser(t, r, "auth0|explain-alice", "explainalice@test.com", "explainalice")
+	bob := createTestUser(t, r, "auth0|explain-bob", "explainbob@test.com", "explainbob")
+	admin := createTestUser(t, r, "auth0|explain-admin", "explainadmin@test.com", "explainadmin")
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := api.DB.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	// User creation served and queued problems; start from a known pool.
+	exec("UPDATE problems SET disabled = 1")
+	exec("DELETE FROM generation_jobs")
+	exec("DELETE FROM recently_shown_problems")
+	exec("UPDATE users SET role = ? WHERE id = ?", RoleAdmin, admin.Id)
+	exec("UPDATE settings SET problem_type_bitmap = ?, target_difficulty = 3 WHERE user_id = ?", uint64(mathcore.ADDITION), alice.Id)
+	exec("UPDATE settings SET problem_type_bitmap = ?, target_difficulty = 3 WHERE user_id = ?", uint64(mathcore.WORD), bob.Id)
+	seed := func(id uint32, bitmap mathcore.ProblemType, generator string) {
+		exec(`INSERT INTO problems (id, problem_type_bitmap, expression, symbolic_expression, answer, difficulty, disabled, generator, difficulty_version)
+			 VALUES (?, ?, ?, '', '1', 3, 0, ?, '0.2')`, id, uint64(bitmap), fmt.Sprintf("explain %d", id), generator)
+	}
+	for id := uint32(9601); id <= 9605; id++ {
+		seed(id, mathcore.ADDITION, "llm_0.5")
+	}
+	for id := uint32(9611); id <= 9620; id++ {
+		seed(id, mathcore.ADDITION, "heuristic_1.0")
+	}
+	seed(9630, mathcore.MULTIPLICATION, "llm_0.5")
+	exec("INSERT INTO recently_shown_problems (user_id, problem_id, shown_at) VALUES (?, 9601, NOW()), (?, 9602, NOW())", alice.Id, alice.Id)
+	// Due, but multiplication is outside alice's envelope.
+	exec("INSERT INTO review_queue (user_id, problem_id, next_review_at) VALUES (?, 9630, NOW() - INTERVAL 1 HOUR)", alice.Id)
+	if err := api.LoadCandidateIndex(); err != nil {
+		t.Fatalf("LoadCandidateIndex: %v", err)
+	}
+
+	explain := func(user *User) *SelectionExplain {
+		t.Helper()
+		resp := catalogRequest(t, r, admin, "GET", fmt.Sprintf("/admin/users/%d/selection", user.Id), "")
+		if resp.Code != http.StatusOK {
+			t.Fatalf("explain user %d: want 200, got %d %s", user.Id, resp.Code, resp.Body.String())
+		}
+		var ex SelectionExplain
+		if err := json.Unmarshal(resp.Body.Bytes(), &ex); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return &ex
+	}
+
+	ex := explain(alice)
+	if ex.Review.Due != 1 || ex.Review.ProblemId != 0 || ex.Review.Used {
+		t.Errorf("review: want one due review filtered out by the envelope, got %+v", ex.Review)
+	}
+	if len(ex.RecentExclusions) != 2 {
+		t.Errorf("want the 2 recently shown problems excluded, got %v", ex.RecentExclusions)
+	}
+	if len(ex.Tiers) != 2 ||
+		ex.Tiers[0].Rank != generatorRank["llm_0.5"] || ex.Tiers[0].Problems != 3 || ex.Tiers[0].Excluded != 2 || !ex.Tiers[0].Served ||
+		ex.Tiers[1].Rank != generatorRank["heuristic_1.0"] || ex.Tiers[1].Problems != 10 || ex.Tiers[1].Served {
+		t.Errorf("tiers: want llm_0.5 (3 left, 2 excluded, served) over heuristic_1.0 (10), got %+v", ex.Tiers)
+	}
+	if ex.PoolSize != 3 || len(ex.TopSlice) != 1 || ex.TopSlice[0].LastShownAt != nil {
+		t.Errorf("want a pool of 3 and a never-shown top slice of 1, got %d %+v", ex.PoolSize, ex.TopSlice)
+	}
+	if !ex.WouldQueue || ex.LiveJob != nil || ex.Source != SELECT_FROM_POOL {
+		t.Errorf("want a thin pool served from the pool with no live job, got queue=%v job=%+v source=%s", ex.WouldQueue, ex.LiveJob, ex.Source)
+	}
+
+	// WORD-only with an empty pool: the LLM block is the fallback.
+	if ex := explain(bob); ex.PoolSize != 0 || len(ex.TopSlice) != 0 || ex.Source != SELECT_FROM_LLM || ex.Review.Reason != "no reviews due" {
+		t.Errorf("word-only: want the LLM fallback, got %+v", ex)
+	}
+
+	var jobs, shown int
+	if err := api.DB.QueryRow("SELECT (SELECT COUNT(*) FROM generation_jobs), (SELECT COUNT(*) FROM recently_shown_problems)").Scan(&jobs, &shown); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if jobs != 0 || shown != 2 {
+		t.Errorf("explaining must not write: got %d jobs, %d recently shown rows", jobs, shown)
+	}
+
+	if resp := catalogRequest(t, r, alice, "GET", fmt.Sprintf("/admin/users/%d/selection", alice.Id), ""); resp.Code != http.StatusForbidden {
+		t.Errorf("student: want 403, got %d", resp.Code)
+	}
+	if resp := catalogRequest(t, r, admin, "GET", "/admin/users/999999/selection", ""); resp.Code != http.StatusNotFound {
+		t.Errorf("unknown user: want 404, got %d", resp.Code)
+	}
+}
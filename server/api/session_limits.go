@@ -0,0 +1,157 @@
+// session_limits.go: daily screen-time caps and quiet hours.
+//
+// TargetWorkPercentage balances math against video within a session; these
+// settings bound the session itself. A parent sets, on the PIN-gated settings
+// page, a daily cap on total minutes (math + video), a daily cap on video
+// minutes, and a quiet-hours window, all evaluated in the user's timezone.
+// Once any applies, helpGetPlayData answers with a SessionOver instead of a
+// problem and video. Documented in docs/gameplay.md.
+package api
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // user timezones must resolve even on a host without zoneinfo
+
+	"github.com/golang/glog"
+)
+
+// SessionOver reasons.
+const (
+	SESSION_OVER_DAILY_LIMIT = "daily_limit"
+	SESSION_OVER_VIDEO_LIMIT = "video_limit"
+	SESSION_OVER_QUIET_HOURS = "quiet_hours"
+)
+
+const minutesPerDay = 24 * 60
+
+// SessionOver tells the client play is closed and when it reopens.
+type SessionOver struct {
+	Reason       string    `json:"reason"`
+	Until        time.Time `json:"until"`
+	TotalMinutes int64     `json:"total_minutes"`
+	VideoMinutes int64     `json:"video_minutes"`
+}
+
+// hasSessionLimits reports whether any limit is configured, so the common
+// no-limits case costs no usage query.
+func hasSessionLimits(s *Settings) bool {
+	return s.DailyLimitMinutes > 0 || s.DailyVideoLimitMinutes > 0 || s.QuietHoursStart != s.QuietHoursEnd
+}
+
+// validateSessionLimits checks the limit fields of a settings write. An empty
+// timezone means UTC.
+func validateSessionLimits(s *Settings) error {
+	if s.DailyLimitMinutes > minutesPerDay {
+		return fmt.Errorf("Invalid daily_limit_minutes: %d (must be 0-%d)", s.DailyLimitMinutes, minutesPerDay)
+	}
+	if s.DailyVideoLimitMinutes > minutesPerDay {
+		return fmt.Errorf("Invalid daily_video_limit_minutes: %d (must be 0-%d)", s.DailyVideoLimitMinutes, minutesPerDay)
+	}
+	if s.QuietHoursStart >= minutesPerDay || s.QuietHoursEnd >= minutesPerDay {
+		return fmt.Errorf("Invalid quiet hours: %d-%d (minutes after midnight, must be 0-%d)", s.QuietHoursStart, s.QuietHoursEnd, minutesPerDay-1)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("Invalid timezone: %s", s.Timezone)
+	}
+	return nil
+}
+
+// settingsLocation is the user's timezone, falling back to UTC for a name
+// LoadLocation rejects (validateSessionLimits refuses those on write, but a row
+// written directly may still hold one).
+func settingsLocation(s *Settings) *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// localDayStart is midnight of now's day in loc.
+func localDayStart(now time.Time, loc *time.Location) time.Time {
+	l := now.In(loc)
+	return time.Date(l.Year(), l.Month(), l.Day(), 0, 0, 0, 0, loc)
+}
+
+// quietHoursUntil returns when the quiet-hours window containing now ends, or
+// the zero time when now is outside it. The window is [start, end) in minutes
+// after local midnight and wraps midnight when start > end (e.g. 20:00-07:00).
+func quietHoursUntil(now time.Time, loc *time.Location, start, end uint32) time.Time {
+	if start == end {
+		return time.Time{}
+	}
+	l := now.In(loc)
+	minute := uint32(l.Hour()*60 + l.Minute())
+	dayStart := localDayStart(now, loc)
+	switch {
+	case start < end && minute >= start && minute < end:
+		return dayStart.Add(time.Duration(end) * time.Minute)
+	case start > end && minute >= start:
+		return dayStart.AddDate(0, 0, 1).Add(time.Duration(end) * time.Minute)
+	case start > end && minute < end:
+		return dayStart.Add(time.Duration(end) * time.Minute)
+	}
+	return time.Time{}
+}
+
+// evaluateSessionLimits decides whether play is over given today's usage
+// (milliseconds since local midnight). Quiet hours win over the caps, since
+// they say when play reopens soonest; the caps reopen at the next local
+// midnight. Returns nil when play may continue.
+func evaluateSessionLimits(s *Settings, workMs, videoMs int64, now time.Time) *SessionOver {
+	loc := settingsLocation(s)
+	totalMin := (workMs + videoMs) / 60000
+	videoMin := videoMs / 60000
+	over := &SessionOver{TotalMinutes: totalMin, VideoMinutes: videoMin}
+	if until := quietHoursUntil(now, loc, s.QuietHoursStart, s.QuietHoursEnd); !until.IsZero() {
+		over.Reason, over.Until = SESSION_OVER_QUIET_HOURS, until
+		return over
+	}
+	tomorrow := localDayStart(now, loc).AddDate(0, 0, 1)
+	if s.DailyLimitMinutes > 0 && totalMin >= int64(s.DailyLimitMinutes) {
+		over.Reason, over.Until = SESSION_OVER_DAILY_LIMIT, tomorrow
+		return over
+	}
+	if s.DailyVideoLimitMinutes > 0 && videoMin >= int64(s.DailyVideoLimitMinutes) {
+		over.Reason, over.Until = SESSION_OVER_VIDEO_LIMIT, tomorrow
+		return over
+	}
+	return nil
+}
+
+// usageSince sums the user's WORKING_ON_PROBLEM and WATCHING_VIDEO
+// milliseconds logged at or after since. Negative values count as zero, as in
+// the statistics cache.
+func (a *Api) usageSince(userID uint32, since time.Time) (workMs, videoMs int64, err error) {
+	err = a.DB.QueryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN event_type = ? THEN GREATEST(CAST(value AS SIGNED), 0) END), 0),
+			COALESCE(SUM(CASE WHEN event_type = ? THEN GREATEST(CAST(value AS SIGNED), 0) END), 0)
+		FROM events
+		WHERE user_id = ? AND event_type IN (?, ?) AND timestamp >= ?`,
+		WORKING_ON_PROBLEM, WATCHING_VIDEO,
+		userID, WORKING_ON_PROBLEM, WATCHING_VIDEO, since.UTC(),
+	).Scan(&workMs, &videoMs)
+	return workMs, videoMs, err
+}
+
+// checkSessionLimits returns the user's SessionOver, or nil while play may
+// continue. It fails open: on a lookup error it logs and returns nil, so a
+// database hiccup never locks a child out mid-problem.
+func (a *Api) checkSessionLimits(logPrefix string, userID uint32, settings *Settings, now time.Time) *SessionOver {
+	if !hasSessionLimits(settings) {
+		return nil
+	}
+	workMs, videoMs, err := a.usageSince(userID, localDayStart(now, settingsLocation(settings)))
+	if err != nil {
+		glog.Errorf("%s session limits: usage lookup: %v", logPrefix, err)
+		return nil
+	}
+	over := evaluateSessionLimits(settings, workMs, videoMs, now)
+	if over != nil {
+		glog.Infof("%s session over: %s until %s (%d total min, %d video min)",
+			logPrefix, over.Reason, over.Until.Format(time.RFC3339), over.TotalMinutes, over.VideoMinutes)
+	}
+	return over
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestQuietHoursUntil(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("load location: %v", err)
+	}
+	at := func(h, m int) time.Time { return time.Date(2026, 10, 14, h, m, 0, 0, loc) }
+	cases := []struct {
+		name       string
+		now        time.Time
+		start, end uint32
+		want       time.Time
+	}{
+		{"no window", at(21, 0), 0, 0, time.Time{}},
+		{"same-day window, inside", at(13, 30), 12 * 60, 14 * 60, at(14, 0)},
+		{"same-day window, at end", at(14, 0), 12 * 60, 14 * 60, time.Time{}},
+		{"overnight, evening", at(21, 0), 20 * 60, 7 * 60, time.Date(2026, 10, 15, 7, 0, 0, 0, loc)},
+		{"overnight, early morning", at(6, 59), 20 * 60, 7 * 60, at(7, 0)},
+		{"overnight, daytime", at(12, 0), 20 * 60, 7 * 60, time.Time{}},
+	}
+	for _, c := range cases {
+		got := quietHoursUntil(c.now, loc, c.start, c.end)
+		if !got.Equal(c.want) {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestEvaluateSessionLimits(t *testing.T) {
+	s := &Settings{DailyLimitMinutes: 60, DailyVideoLimitMinutes: 20, Timezone: "America/New_York"}
+	// 2026-10-14 23:30 UTC is 19:30 in New York; the caps reopen at New
+	// York midnight, 04:00 UTC.
+	now := time.Date(2026, 10, 14, 23, 30, 0, 0, time.UTC)
+	midnight := time.Date(2026, 10, 15, 4, 0, 0, 0, time.UTC)
+
+	if over := evaluateSessionLimits(s, 30*60000, 10*60000, now); over != nil {
+		t.Errorf("under both caps: want nil, got %+v", over)
+	}
+	over := evaluateSessionLimits(s, 30*60000, 20*60000, now)
+	if over == nil || over.Reason != SESSION_OVER_VIDEO_LIMIT || !over.Until.Equal(midnight) {
+		t.Errorf("video cap: want video_limit until %v, got %+v", midnight, over)
+	}
+	over = evaluateSessionLimits(s, 50*60000, 10*60000, now)
+	if over == nil || over.Reason != SESSION_OVER_DAILY_LIMIT || over.TotalMinutes != 60 {
+		t.Errorf("total cap: want daily_limit at 60 min, got %+v", over)
+	}
+
+	// Quiet hours apply even with no usage.
+	s.QuietHoursStart, s.QuietHoursEnd = 19*60, 7*60
+	over = evaluateSessionLimits(s, 0, 0, now)
+	if over == nil || over.Reason != SESSION_OVER_QUIET_HOURS || !over.Until.Equal(time.Date(2026, 10, 15, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("quiet hours: want quiet_hours until 07:00 New York, got %+v", over)
+	}
+}
+
+func TestValidateSessionLimits(t *testing.T) {
+	ok := []Settings{
+		{},
+		{DailyLimitMinutes: 1440, DailyVideoLimitMinutes: 30, QuietHoursStart: 1200, QuietHoursEnd: 420, Timezone: "Europe/London"},
+	}
+	for _, s := range ok {
+		if err := validateSessionLimits(&s); err != nil {
+			t.Errorf("%+v: want valid, got %v", s, err)
+		}
+	}
+	bad := []Settings{
+		{DailyLimitMinutes: 1441},
+		{DailyVideoLimitMinutes: 5000},
+		{QuietHoursStart: 1440},
+		{Timezone: "Mars/Olympus_Mons"},
+	}
+	for _, s := range bad {
+		if err := validateSessionLimits(&s); err == nil {
+			t.Errorf("%+v: want an error", s)
+		}
+	}
+}
+
+// TestPlay_SessionOverAtDailyLimit checks /play closes once today's logged
+// minutes reach the cap, and reopens when the cap is lifted.
+func TestPlay_SessionOverAtDailyLimit(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|session-limits", "limits@test.com", "limitsuser")
+	insertVideosAndUserHasVideo(t, api, user.Id, 2)
+
+	if _, err := api.DB.Exec("UPDATE settings SET daily_limit_minutes = 2 WHERE user_id = ?", user.Id); err != nil {
+		t.Fatalf("set limit: %v", err)
+	}
+	if _, err := api.DB.Exec(
+		"INSERT INTO events (user_id, event_type, value) VALUES (?, ?, ?), (?, ?, ?)",
+		user.Id, WORKING_ON_PROBLEM, "90000",
+		user.Id, WATCHING_VIDEO, "30000",
+	); err != nil {
+		t.Fatalf("insert events: %v", err)
+	}
+
+	getPlay := func() PlayData {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/play/%d?test_auth0_id=%s", user.Id, user.Auth0Id), nil)
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("play: want 200, got %d %s", resp.Code, resp.Body.String())
+		}
+		var pd PlayData
+		if err := json.Unmarshal(resp.Body.Bytes(), &pd); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return pd
+	}
+
+	pd := getPlay()
+	if pd.SessionOver == nil || pd.SessionOver.Reason != SESSION_OVER_DAILY_LIMIT || pd.Problem != nil {
+		t.Fatalf("want session over with no problem, got %+v", pd)
+	}
+
+	if _, err := api.DB.Exec("UPDATE settings SET daily_limit_minutes = 0 WHERE user_id = ?", user.Id); err != nil {
+		t.Fatalf("clear limit: %v", err)
+	}
+	pd = getPlay()
+	if pd.SessionOver != nil || pd.Problem == nil {
+		t.Errorf("limit cleared: want a problem, got %+v", pd)
+	}
+}
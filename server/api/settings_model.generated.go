@@ -14,7 +14,14 @@ const (
         user_id BIGINT UNSIGNED PRIMARY KEY,
 	problem_type_bitmap BIGINT UNSIGNED NOT NULL,
 	target_difficulty DOUBLE NOT NULL,
-	target_work_percentage INT(3) NOT NULL
+	target_work_percentage INT(3) NOT NULL,
+	digest_opt_in TINYINT NOT NULL DEFAULT 0,
+	daily_limit_minutes INT UNSIGNED NOT NULL DEFAULT 0,
+	daily_video_limit_minutes INT UNSIGNED NOT NULL DEFAULT 0,
+	quiet_hours_start INT UNSIGNED NOT NULL DEFAULT 0,
+	quiet_hours_end INT UNSIGNED NOT NULL DEFAULT 0,
+	timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+	topic_weights VARCHAR(1024) NOT NULL DEFAULT ''
     ) DEFAULT CHARSET=utf8mb4 ;`
 
 	createSettingsSQL = `INSERT INTO settings (user_id, problem_type_bitmap, target_difficulty, target_work_percentage) VALUES (?, ?, ?, ?);`
@@ -25,20 +32,27 @@ const (
 
 	listSettingsSQL = `SELECT * FROM settings WHERE user_id=?;`
 
-	updateSettingsSQL = `UPDATE settings SET problem_type_bitmap=?, target_difficulty=?, target_work_percentage=? WHERE user_id=?;`
+	updateSettingsSQL = `UPDATE settings SET problem_type_bitmap=?, target_difficulty=?, target_work_percentage=?, digest_opt_in=?, daily_limit_minutes=?, daily_video_limit_minutes=?, quiet_hours_start=?, quiet_hours_end=?, timezone=?, topic_weights=? WHERE user_id=?;`
 
 	deleteSettingsSQL = `DELETE FROM settings WHERE user_id=?;`
 )
 
 type Settings struct {
-	UserId               uint32  `json:"user_id" uri:"user_id"`
-	ProblemTypeBitmap    uint64  `json:"problem_type_bitmap" uri:"problem_type_bitmap" form:"problem_type_bitmap"`
-	TargetDifficulty     float64 `json:"target_difficulty" uri:"target_difficulty" form:"target_difficulty"`
-	TargetWorkPercentage uint8   `json:"target_work_percentage" uri:"target_work_percentage" form:"target_work_percentage"`
+	UserId                 uint32  `json:"user_id" uri:"user_id"`
+	ProblemTypeBitmap      uint64  `json:"problem_type_bitmap" uri:"problem_type_bitmap" form:"problem_type_bitmap"`
+	TargetDifficulty       float64 `json:"target_difficulty" uri:"target_difficulty" form:"target_difficulty"`
+	TargetWorkPercentage   uint8   `json:"target_work_percentage" uri:"target_work_percentage" form:"target_work_percentage"`
+	DigestOptIn            bool    `json:"digest_opt_in" uri:"digest_opt_in" form:"digest_opt_in"`
+	DailyLimitMinutes      uint32  `json:"daily_limit_minutes" uri:"daily_limit_minutes" form:"daily_limit_minutes"`
+	DailyVideoLimitMinutes uint32  `json:"daily_video_limit_minutes" uri:"daily_video_limit_minutes" form:"daily_video_limit_minutes"`
+	QuietHoursStart        uint32  `json:"quiet_hours_start" uri:"quiet_hours_start" form:"quiet_hours_start"`
+	QuietHoursEnd          uint32  `json:"quiet_hours_end" uri:"quiet_hours_end" form:"quiet_hours_end"`
+	Timezone               string  `json:"timezone" uri:"timezone" form:"timezone"`
+	TopicWeights           string  `json:"topic_weights" uri:"topic_weights" form:"topic_weights"`
 }
 
 func (model Settings) String() string {
-	return fmt.Sprintf("UserId: %v, ProblemTypeBitmap: %v, TargetDifficulty: %v, TargetWorkPercentage: %v", model.UserId, model.ProblemTypeBitmap, model.TargetDifficulty, model.TargetWorkPercentage)
+	return fmt.Sprintf("UserId: %v, ProblemTypeBitmap: %v, TargetDifficulty: %v, TargetWorkPercentage: %v, DigestOptIn: %v, DailyLimitMinutes: %v, DailyVideoLimitMinutes: %v, QuietHoursStart: %v, QuietHoursEnd: %v, Timezone: %v, TopicWeights: %v", model.UserId, model.ProblemTypeBitmap, model.TargetDifficulty, model.TargetWorkPercentage, model.DigestOptIn, model.DailyLimitMinutes, model.DailyVideoLimitMinutes, model.QuietHoursStart, model.QuietHoursEnd, model.Timezone, model.TopicWeights)
 }
 
 type SettingsManager struct {
@@ -62,7 +76,7 @@ func (m *SettingsManager) Create(model *Settings) (int, string, error) {
 
 func (m *SettingsManager) Get(user_id uint32) (*Settings, int, string, error) {
 	model := &Settings{}
-	err := m.DB.QueryRow(getSettingsSQL, user_id).Scan(&model.UserId, &model.ProblemTypeBitmap, &model.TargetDifficulty, &model.TargetWorkPercentage)
+	err := m.DB.QueryRow(getSettingsSQL, user_id).Scan(&model.UserId, &model.ProblemTypeBitmap, &model.TargetDifficulty, &model.TargetWorkPercentage, &model.DigestOptIn, &model.DailyLimitMinutes, &model.DailyVideoLimitMinutes, &model.QuietHoursStart, &model.QuietHoursEnd, &model.Timezone, &model.TopicWeights)
 	if err == sql.ErrNoRows {
 		msg := "Couldn't find a settings with that user_id"
 		return nil, http.StatusNotFound, msg, err
@@ -84,7 +98,7 @@ func (m *SettingsManager) List(user_id uint32) (*[]Settings, int, string, error)
 	}
 	for rows.Next() {
 		model := Settings{}
-		err = rows.Scan(&model.UserId, &model.ProblemTypeBitmap, &model.TargetDifficulty, &model.TargetWorkPercentage)
+		err = rows.Scan(&model.UserId, &model.ProblemTypeBitmap, &model.TargetDifficulty, &model.TargetWorkPercentage, &model.DigestOptIn, &model.DailyLimitMinutes, &model.DailyVideoLimitMinutes, &model.QuietHoursStart, &model.QuietHoursEnd, &model.Timezone, &model.TopicWeights)
 		if err != nil {
 			msg := "Couldn't scan row from database"
 			return nil, http.StatusInternalServerError, msg, err
@@ -111,7 +125,7 @@ func (m *SettingsManager) CustomList(sql string) (*[]Settings, int, string, erro
 	}
 	for rows.Next() {
 		model := Settings{}
-		err = rows.Scan(&model.UserId, &model.ProblemTypeBitmap, &model.TargetDifficulty, &model.TargetWorkPercentage)
+		err = rows.Scan(&model.UserId, &model.ProblemTypeBitmap, &model.TargetDifficulty, &model.TargetWorkPercentage, &model.DigestOptIn, &model.DailyLimitMinutes, &model.DailyVideoLimitMinutes, &model.QuietHoursStart, &model.QuietHoursEnd, &model.Timezone, &model.TopicWeights)
 		if err != nil {
 			msg := "Couldn't scan row from database"
 			return nil, http.StatusInternalServerError, msg, err
@@ -169,7 +183,7 @@ func (m *SettingsManager) Update(model *Settings) (int, string, error) {
 		return status, msg, err
 	}
 	// Update
-	_, err = m.DB.Exec(updateSettingsSQL, model.ProblemTypeBitmap, model.TargetDifficulty, model.TargetWorkPercentage, model.UserId)
+	_, err = m.DB.Exec(updateSettingsSQL, model.ProblemTypeBitmap, model.TargetDifficulty, model.TargetWorkPercentage, model.DigestOptIn, model.DailyLimitMinutes, model.DailyVideoLimitMinutes, model.QuietHoursStart, model.QuietHoursEnd, model.Timezone, model.TopicWeights, model.UserId)
 	if err != nil {
 		msg := "Couldn't update settings in database"
 		return http.StatusInternalServerError, msg, err
@@ -91,7 +91,7 @@ func (a *Api) getDueReviewProblem(logPrefix string, settings *Settings) uint32 {
 	// JOINs review_queue (small, per-user) against the indexed problems
 	// table by primary key; the filters drop rows that no longer fit the
 	// user's current topic/difficulty settings. The subset clause matches
-	// the default selection SQL (see getSatisfyingProblemIds).
+	// the default selection's candidate index (see getSatisfyingProblemIds).
 	sql := `
 		SELECT rq.problem_id
 		FROM review_queue rq
@@ -14,18 +14,22 @@ import (
 
 // StatisticsResponse is the JSON response for GET /api/v1/statistics/:user_id
 type StatisticsResponse struct {
-	TotalProblemsSolved int64        `json:"total_problems_solved"`
-	TotalWorkMinutes    int64        `json:"total_work_minutes"`
-	TotalVideoMinutes   int64        `json:"total_video_minutes"`
-	StatsByMonth        []MonthStats `json:"stats_by_month"`
+	TotalProblemsSolved int64 `json:"total_problems_solved"`
+	TotalWorkMinutes    int64 `json:"total_work_minutes"`
+	TotalVideoMinutes   int64 `json:"total_video_minutes"`
+	// TotalAchievementsEarned counts ACHIEVEMENT_EARNED events (achievements.go).
+	TotalAchievementsEarned int64        `json:"total_achievements_earned"`
+	StatsByMonth            []MonthStats `json:"stats_by_month"`
+	Topics                  []TopicStats `json:"topics"`
 }
 
 // MonthStats holds the same top-level stats for a single month (YYYY-MM).
 type MonthStats struct {
-	Month               string `json:"month"`
-	TotalProblemsSolved int64  `json:"total_problems_solved"`
-	TotalWorkMinutes    int64  `json:"total_work_minutes"`
-	TotalVideoMinutes   int64  `json:"total_video_minutes"`
+	Month                   string `json:"month"`
+	TotalProblemsSolved     int64  `json:"total_problems_solved"`
+	TotalWorkMinutes        int64  `json:"total_work_minutes"`
+	TotalVideoMinutes       int64  `json:"total_video_minutes"`
+	TotalAchievementsEarned int64  `json:"total_achievements_earned"`
 }
 
 // UpdateStatisticsForUser updates the statistics cache (statistics_totals, statistics_monthly,
@@ -124,29 +128,31 @@ func (a *Api) fetchProgressEventsAfter(userID uint32, afterID uint64) ([]progres
 
 func (a *Api) fullProgressBackfill(logPrefix string, userID uint32) error {
 	const msPerMinute = 60000
-	var totalProblems, totalWorkMinutes, totalVideoMinutes int64
+	var totalProblems, totalWorkMinutes, totalVideoMinutes, totalAchievements int64
 	err := a.DB.QueryRow(`
 		SELECT
 			COUNT(CASE WHEN event_type = ? THEN 1 END),
 			(GREATEST(COALESCE(SUM(CASE WHEN event_type = ? THEN CAST(value AS SIGNED) END), 0), 0)) DIV ?,
-			(GREATEST(COALESCE(SUM(CASE WHEN event_type = ? THEN CAST(value AS SIGNED) END), 0), 0)) DIV ?
+			(GREATEST(COALESCE(SUM(CASE WHEN event_type = ? THEN CAST(value AS SIGNED) END), 0), 0)) DIV ?,
+			COUNT(CASE WHEN event_type = ? THEN 1 END)
 		FROM events
-		WHERE user_id = ? AND event_type IN (?, ?, ?)`,
-		SOLVED_PROBLEM, WORKING_ON_PROBLEM, msPerMinute, WATCHING_VIDEO, msPerMinute,
-		userID, SOLVED_PROBLEM, WORKING_ON_PROBLEM, WATCHING_VIDEO,
-	).Scan(&totalProblems, &totalWorkMinutes, &totalVideoMinutes)
+		WHERE user_id = ? AND event_type IN (?, ?, ?, ?)`,
+		SOLVED_PROBLEM, WORKING_ON_PROBLEM, msPerMinute, WATCHING_VIDEO, msPerMinute, ACHIEVEMENT_EARNED,
+		userID, SOLVED_PROBLEM, WORKING_ON_PROBLEM, WATCHING_VIDEO, ACHIEVEMENT_EARNED,
+	).Scan(&totalProblems, &totalWorkMinutes, &totalVideoMinutes, &totalAchievements)
 	if err != nil {
 		return err
 	}
 
 	_, err = a.DB.Exec(`
-		INSERT INTO statistics_totals (user_id, total_problems_solved, total_work_minutes, total_video_minutes)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO statistics_totals (user_id, total_problems_solved, total_work_minutes, total_video_minutes, total_achievements_earned)
+		VALUES (?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 			total_problems_solved = VALUES(total_problems_solved),
 			total_work_minutes = VALUES(total_work_minutes),
-			total_video_minutes = VALUES(total_video_minutes)`,
-		userID, totalProblems, totalWorkMinutes, totalVideoMinutes,
+			total_video_minutes = VALUES(total_video_minutes),
+			total_achievements_earned = VALUES(total_achievements_earned)`,
+		userID, totalProblems, totalWorkMinutes, totalVideoMinutes, totalAchievements,
 	)
 	if err != nil {
 		return err
@@ -157,12 +163,13 @@ func (a *Api) fullProgressBackfill(logPrefix string, userID uint32) error {
 			DATE_FORMAT(timestamp, '%Y-%m') AS month,
 			COUNT(CASE WHEN event_type = ? THEN 1 END),
 			(GREATEST(COALESCE(SUM(CASE WHEN event_type = ? THEN CAST(value AS SIGNED) END), 0), 0)) DIV ?,
-			(GREATEST(COALESCE(SUM(CASE WHEN event_type = ? THEN CAST(value AS SIGNED) END), 0), 0)) DIV ?
+			(GREATEST(COALESCE(SUM(CASE WHEN event_type = ? THEN CAST(value AS SIGNED) END), 0), 0)) DIV ?,
+			COUNT(CASE WHEN event_type = ? THEN 1 END)
 		FROM events
-		WHERE user_id = ? AND event_type IN (?, ?, ?)
+		WHERE user_id = ? AND event_type IN (?, ?, ?, ?)
 		GROUP BY DATE_FORMAT(timestamp, '%Y-%m')`,
-		SOLVED_PROBLEM, WORKING_ON_PROBLEM, msPerMinute, WATCHING_VIDEO, msPerMinute,
-		userID, SOLVED_PROBLEM, WORKING_ON_PROBLEM, WATCHING_VIDEO,
+		SOLVED_PROBLEM, WORKING_ON_PROBLEM, msPerMinute, WATCHING_VIDEO, msPerMinute, ACHIEVEMENT_EARNED,
+		userID, SOLVED_PROBLEM, WORKING_ON_PROBLEM, WATCHING_VIDEO, ACHIEVEMENT_EARNED,
 	)
 	if err != nil {
 		return err
@@ -171,18 +178,19 @@ func (a *Api) fullProgressBackfill(logPrefix string, userID uint32) error {
 	for monthRows.Next() {
 		var month string
 		var solved int64
-		var workMin, videoMin int64
-		if err := monthRows.Scan(&month, &solved, &workMin, &videoMin); err != nil {
+		var workMin, videoMin, achievements int64
+		if err := monthRows.Scan(&month, &solved, &workMin, &videoMin, &achievements); err != nil {
 			return err
 		}
 		_, err = a.DB.Exec(`
-			INSERT INTO statistics_monthly (user_id, month, total_problems_solved, total_work_minutes, total_video_minutes)
-			VALUES (?, ?, ?, ?, ?)
+			INSERT INTO statistics_monthly (user_id, month, total_problems_solved, total_work_minutes, total_video_minutes, total_achievements_earned)
+			VALUES (?, ?, ?, ?, ?, ?)
 			ON DUPLICATE KEY UPDATE
 				total_problems_solved = VALUES(total_problems_solved),
 				total_work_minutes = VALUES(total_work_minutes),
-				total_video_minutes = VALUES(total_video_minutes)`,
-			userID, month, solved, workMin, videoMin,
+				total_video_minutes = VALUES(total_video_minutes),
+				total_achievements_earned = VALUES(total_achievements_earned)`,
+			userID, month, solved, workMin, videoMin, achievements,
 		)
 		if err != nil {
 			return err
@@ -197,12 +205,15 @@ func (a *Api) fullProgressBackfill(logPrefix string, userID uint32) error {
 	if err != nil {
 		return err
 	}
-	_, err = a.DB.Exec(`
-		INSERT INTO statistics_cache_meta (user_id, last_event_id) VALUES (?, ?)
-		ON DUPLICATE KEY UPDATE last_event_id = VALUES(last_event_id)`,
-		userID, maxID,
-	)
-	return err
+	topicEvents, err := a.fetchTopicEventsUpTo(userID, maxID)
+	if err != nil {
+		return err
+	}
+	open, err := a.cacheTopicAttempts(userID, topicOpenAttempt{}, topicEvents)
+	if err != nil {
+		return err
+	}
+	return a.saveProgressCheckpoint(userID, maxID, open)
 }
 
 func (a *Api) mergeProgressEventsIntoCache(logPrefix string, userID uint32, events []progressEventRow) (uint64, error) {
@@ -210,14 +221,15 @@ func (a *Api) mergeProgressEventsIntoCache(logPrefix string, userID uint32, even
 		return 0, nil
 	}
 	const msPerMinute = 60000
-	var totalDelta int64
+	var totalDelta, achievementsDelta int64
 	var workDelta, videoDelta int64
 	// Accumulate per-month totals in milliseconds and divide once at the end, to
 	// match how fullProgressBackfill computes it.
 	monthDeltas := make(map[string]struct {
-		solved  int64
-		workMs  int64
-		videoMs int64
+		solved       int64
+		workMs       int64
+		videoMs      int64
+		achievements int64
 	})
 	for _, e := range events {
 		switch e.eventType {
@@ -233,8 +245,10 @@ func (a *Api) mergeProgressEventsIntoCache(logPrefix string, userID uint32, even
 			if v > 0 {
 				videoDelta += v
 			}
+		case ACHIEVEMENT_EARNED:
+			achievementsDelta++
 		}
-		if e.eventType == SOLVED_PROBLEM || e.eventType == WORKING_ON_PROBLEM || e.eventType == WATCHING_VIDEO {
+		if e.eventType == SOLVED_PROBLEM || e.eventType == WORKING_ON_PROBLEM || e.eventType == WATCHING_VIDEO || e.eventType == ACHIEVEMENT_EARNED {
 			month := e.timestamp.Format("2006-01")
 			d := monthDeltas[month]
 			switch e.eventType {
@@ -250,6 +264,8 @@ func (a *Api) mergeProgressEventsIntoCache(logPrefix string, userID uint32, even
 				if v > 0 {
 					d.videoMs += v
 				}
+			case ACHIEVEMENT_EARNED:
+				d.achievements++
 			}
 			monthDeltas[month] = d
 		}
@@ -258,13 +274,14 @@ func (a *Api) mergeProgressEventsIntoCache(logPrefix string, userID uint32, even
 	videoMinDelta := videoDelta / msPerMinute
 
 	_, err := a.DB.Exec(`
-		INSERT INTO statistics_totals (user_id, total_problems_solved, total_work_minutes, total_video_minutes)
-		VALUES (?, ?, ?, ?)
+		INSERT INTO statistics_totals (user_id, total_problems_solved, total_work_minutes, total_video_minutes, total_achievements_earned)
+		VALUES (?, ?, ?, ?, ?)
 		ON DUPLICATE KEY UPDATE
 			total_problems_solved = total_problems_solved + VALUES(total_problems_solved),
 			total_work_minutes = total_work_minutes + VALUES(total_work_minutes),
-			total_video_minutes = total_video_minutes + VALUES(total_video_minutes)`,
-		userID, totalDelta, workMinDelta, videoMinDelta,
+			total_video_minutes = total_video_minutes + VALUES(total_video_minutes),
+			total_achievements_earned = total_achievements_earned + VALUES(total_achievements_earned)`,
+		userID, totalDelta, workMinDelta, videoMinDelta, achievementsDelta,
 	)
 	if err != nil {
 		return 0, err
@@ -274,13 +291,14 @@ func (a *Api) mergeProgressEventsIntoCache(logPrefix string, userID uint32, even
 		workMin := d.workMs / msPerMinute
 		videoMin := d.videoMs / msPerMinute
 		_, err = a.DB.Exec(`
-			INSERT INTO statistics_monthly (user_id, month, total_problems_solved, total_work_minutes, total_video_minutes)
-			VALUES (?, ?, ?, ?, ?)
+			INSERT INTO statistics_monthly (user_id, month, total_problems_solved, total_work_minutes, total_video_minutes, total_achievements_earned)
+			VALUES (?, ?, ?, ?, ?, ?)
 			ON DUPLICATE KEY UPDATE
 				total_problems_solved = total_problems_solved + VALUES(total_problems_solved),
 				total_work_minutes = total_work_minutes + VALUES(total_work_minutes),
-				total_video_minutes = total_video_minutes + VALUES(total_video_minutes)`,
-			userID, month, d.solved, workMin, videoMin,
+				total_video_minutes = total_video_minutes + VALUES(total_video_minutes),
+				total_achievements_earned = total_achievements_earned + VALUES(total_achievements_earned)`,
+			userID, month, d.solved, workMin, videoMin, d.achievements,
 		)
 		if err != nil {
 			return 0, err
@@ -293,32 +311,35 @@ func (a *Api) mergeProgressEventsIntoCache(logPrefix string, userID uint32, even
 			maxID = e.id
 		}
 	}
-	_, err = a.DB.Exec(`
-		INSERT INTO statistics_cache_meta (user_id, last_event_id) VALUES (?, ?)
-		ON DUPLICATE KEY UPDATE last_event_id = VALUES(last_event_id)`,
-		userID, maxID,
-	)
+	open, err := a.getTopicOpenAttempt(userID)
+	if err != nil {
+		return 0, err
+	}
+	open, err = a.cacheTopicAttempts(userID, open, events)
 	if err != nil {
 		return 0, err
 	}
+	if err := a.saveProgressCheckpoint(userID, maxID, open); err != nil {
+		return 0, err
+	}
 	return maxID, nil
 }
 
 func (a *Api) readStatisticsFromCache(logPrefix string, userID uint32) (StatisticsResponse, error) {
 	var resp StatisticsResponse
 	err := a.DB.QueryRow(`
-		SELECT total_problems_solved, total_work_minutes, total_video_minutes
+		SELECT total_problems_solved, total_work_minutes, total_video_minutes, total_achievements_earned
 		FROM statistics_totals WHERE user_id = ?`, userID,
-	).Scan(&resp.TotalProblemsSolved, &resp.TotalWorkMinutes, &resp.TotalVideoMinutes)
+	).Scan(&resp.TotalProblemsSolved, &resp.TotalWorkMinutes, &resp.TotalVideoMinutes, &resp.TotalAchievementsEarned)
 	if err == sql.ErrNoRows {
-		return StatisticsResponse{StatsByMonth: []MonthStats{}}, nil
+		return StatisticsResponse{StatsByMonth: []MonthStats{}, Topics: []TopicStats{}}, nil
 	}
 	if err != nil {
 		return resp, err
 	}
 
 	rows, err := a.DB.Query(`
-		SELECT month, total_problems_solved, total_work_minutes, total_video_minutes
+		SELECT month, total_problems_solved, total_work_minutes, total_video_minutes, total_achievements_earned
 		FROM statistics_monthly WHERE user_id = ? ORDER BY month DESC`, userID,
 	)
 	if err != nil {
@@ -327,7 +348,7 @@ func (a *Api) readStatisticsFromCache(logPrefix string, userID uint32) (Statisti
 	defer rows.Close()
 	for rows.Next() {
 		var m MonthStats
-		if err := rows.Scan(&m.Month, &m.TotalProblemsSolved, &m.TotalWorkMinutes, &m.TotalVideoMinutes); err != nil {
+		if err := rows.Scan(&m.Month, &m.TotalProblemsSolved, &m.TotalWorkMinutes, &m.TotalVideoMinutes, &m.TotalAchievementsEarned); err != nil {
 			return resp, err
 		}
 		resp.StatsByMonth = append(resp.StatsByMonth, m)
@@ -335,5 +356,9 @@ func (a *Api) readStatisticsFromCache(logPrefix string, userID uint32) (Statisti
 	if err := rows.Err(); err != nil {
 		return resp, err
 	}
+	resp.Topics, err = a.readTopicStats(userID, time.Now())
+	if err != nil {
+		return resp, err
+	}
 	return resp, nil
 }
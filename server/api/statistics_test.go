@@ -7,8 +7,10 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
 )
 
 func TestStatistics_ReturnsTotals(t *testing.T) {
@@ -321,3 +323,102 @@ func TestUpdateStatisticsForUser_IncrementalMonthlySumsThenDivides(t *testing.T)
 		t.Errorf("monthly total_work_minutes: want 1, got %d", monthWork)
 	}
 }
+
+// TestStatistics_TopicAttemptSpansIncrementalMerge checks the per-topic path
+// end to end: an attempt opened before the backfill checkpoint and finished
+// after it is cached once, with its problem's bitmap and difficulty.
+func TestStatistics_TopicAttemptSpansIncrementalMerge(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|topic-stats", "topicstats@test.com", "topicstatsuser")
+
+	fracs := uint64(mathcore.ADDITION | mathcore.FRACTIONS)
+	_, err = api.DB.Exec(
+		"INSERT INTO problems (id, problem_type_bitmap, expression, answer, explanation, symbolic_expression, difficulty, disabled, generator, difficulty_version) VALUES (?,?,?,?,?,?,?,?,?,?)",
+		900001, fracs, `\frac{1}{2} + \frac{1}{2}`, "1", "", "", 6.5, 0, "llm_0.3", "0.2")
+	if err != nil {
+		t.Fatalf("seed problem: %v", err)
+	}
+	_, err = api.DB.Exec(
+		"INSERT INTO events (user_id, event_type, value) VALUES (?, ?, ?), (?, ?, ?), (?, ?, ?)",
+		user.Id, SELECTED_PROBLEM, "900001",
+		user.Id, WORKING_ON_PROBLEM, "3000",
+		user.Id, ANSWERED_PROBLEM, "2",
+	)
+	if err != nil {
+		t.Fatalf("insert events: %v", err)
+	}
+	if err := api.UpdateStatisticsForUser("[test]", user.Id); err != nil {
+		t.Fatalf("backfill UpdateStatisticsForUser: %v", err)
+	}
+
+	_, err = api.DB.Exec(
+		"INSERT INTO events (user_id, event_type, value) VALUES (?, ?, ?), (?, ?, ?), (?, ?, ?)",
+		user.Id, WORKING_ON_PROBLEM, "2000",
+		user.Id, ANSWERED_PROBLEM, "1",
+		user.Id, SOLVED_PROBLEM, "900001",
+	)
+	if err != nil {
+		t.Fatalf("insert events: %v", err)
+	}
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/statistics/%d?test_auth0_id=%s", user.Id, user.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d body %s", http.StatusOK, resp.Code, resp.Body.Bytes())
+	}
+	var pr StatisticsResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &pr); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	var fractions *TopicStats
+	for i := range pr.Topics {
+		if pr.Topics[i].Bit == uint64(mathcore.FRACTIONS) {
+			fractions = &pr.Topics[i]
+		}
+	}
+	if fractions == nil {
+		t.Fatalf("no fractions entry in topics: %+v", pr.Topics)
+	}
+	if fractions.Attempts != 1 || fractions.FirstTryCorrect != 0 {
+		t.Errorf("fractions: want 1 attempt, 0 first-try; got %d, %d", fractions.Attempts, fractions.FirstTryCorrect)
+	}
+	if fractions.MedianSolveMs != 5000 || fractions.MaxDifficultySolved != 6.5 {
+		t.Errorf("fractions: want median 5000ms, max difficulty 6.5; got %d, %v", fractions.MedianSolveMs, fractions.MaxDifficultySolved)
+	}
+	if len(fractions.Daily) != 1 || len(fractions.Weekly) != 1 {
+		t.Errorf("fractions: want one day and one week, got %+v / %+v", fractions.Daily, fractions.Weekly)
+	}
+
+	// Attempts older than every period window count toward the all-time
+	// figures only, which are aggregated in SQL.
+	old := time.Now().AddDate(-1, 0, 0)
+	_, err = api.DB.Exec(`INSERT INTO statistics_topic_attempts
+		(user_id, end_event_id, ended_at, problem_id, problem_type_bitmap, difficulty, answers, solved, solve_ms) VALUES
+		(?, 999999001, ?, 900001, ?, 7, 1, 1, 1000), (?, 999999002, ?, 900001, ?, 8, 2, 1, 9000),
+		(?, 999999003, ?, 900001, ?, 9, 3, 0, 0), (?, 999999004, ?, 900001, ?, 3, 1, 1, 4000)`,
+		user.Id, old, fracs, user.Id, old, fracs, user.Id, old, fracs, user.Id, old, uint64(mathcore.SUBTRACTION))
+	if err != nil {
+		t.Fatalf("insert old attempts: %v", err)
+	}
+	topics, err := api.readTopicStats(user.Id, time.Now())
+	if err != nil {
+		t.Fatalf("readTopicStats: %v", err)
+	}
+	byBit := map[uint64]TopicStats{}
+	for _, s := range topics {
+		byBit[s.Bit] = s
+	}
+	f := byBit[uint64(mathcore.FRACTIONS)]
+	if f.Attempts != 4 || f.FirstTryCorrect != 1 || f.MedianSolveMs != 5000 || f.MaxDifficultySolved != 8 || len(f.Daily) != 1 || len(f.Weekly) != 1 {
+		t.Errorf("fractions with old attempts: want 4 attempts, 1 first-try, median 5000, max 8 and the same periods; got %+v", f)
+	}
+	if s := byBit[uint64(mathcore.SUBTRACTION)]; s.Topic != "subtraction" || s.Attempts != 1 || s.MedianSolveMs != 4000 || len(s.Daily) != 0 || len(s.Weekly) != 0 {
+		t.Errorf("subtraction: want one old attempt and no periods, got %+v", s)
+	}
+}
@@ -0,0 +1,519 @@
+// Package api: per-topic statistics.
+//
+// The progress page breaks the statistics cache down by ProblemType bit
+// ("fractions: 62% first-try, improving"). The unit is an attempt: one
+// presentation of a problem (SELECTED_PROBLEM) that got at least one
+// ANSWERED_PROBLEM, ending at its SOLVED_PROBLEM or at the next selection.
+// foldTopicAttempts turns the event stream into finished attempts; both cache
+// write paths (fullProgressBackfill and mergeProgressEventsIntoCache) run it,
+// and the attempt still open at the checkpoint is carried on
+// statistics_cache_meta so an attempt split across two merges is counted once.
+//
+// Finished attempts are cached one row each in statistics_topic_attempts.
+// Medians don't merge, so the per-bit day/week rollups are computed from those
+// rows at read time (aggregateTopicStats), reading only the rows inside the
+// period windows; the all-time figures are aggregated in SQL
+// (readTopicTotals), so neither grows with a user's history. Days and weeks
+// are UTC; weeks start on Monday.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+const (
+	// topicDailyWindowDays and topicWeeklyWindowWeeks bound the per-bit period
+	// lists in the response. All-time figures cover every cached attempt.
+	topicDailyWindowDays   = 28
+	topicWeeklyWindowWeeks = 12
+	// topicTrendMinAttempts is the attempts each of the last two weeks needs
+	// before a trend is reported; topicTrendDelta is the first-try-rate change
+	// that counts as improving/declining.
+	topicTrendMinAttempts = 5
+	topicTrendDelta       = 0.05
+)
+
+// topicStatsEventTypes are the events foldTopicAttempts reads.
+var topicStatsEventTypes = []string{SELECTED_PROBLEM, ANSWERED_PROBLEM, SOLVED_PROBLEM, WORKING_ON_PROBLEM}
+
+// TopicStats is one ProblemType bit's attempt history.
+type TopicStats struct {
+	Topic               string             `json:"topic"`
+	Bit                 uint64             `json:"bit"`
+	Attempts            int64              `json:"attempts"`
+	FirstTryCorrect     int64              `json:"first_try_correct"`
+	MedianSolveMs       int64              `json:"median_solve_ms"`
+	MaxDifficultySolved float64            `json:"max_difficulty_solved"`
+	Trend               string             `json:"trend"`
+	Daily               []TopicPeriodStats `json:"daily"`
+	Weekly              []TopicPeriodStats `json:"weekly"`
+}
+
+// TopicPeriodStats is a bit's attempts within one day or week (Period is the
+// UTC date the day or Monday-starting week begins on, YYYY-MM-DD).
+type TopicPeriodStats struct {
+	Period              string  `json:"period"`
+	Attempts            int64   `json:"attempts"`
+	FirstTryCorrect     int64   `json:"first_try_correct"`
+	Solved              int64   `json:"solved"`
+	MedianSolveMs       int64   `json:"median_solve_ms"`
+	MaxDifficultySolved float64 `json:"max_difficulty_solved"`
+}
+
+// topicOpenAttempt is the attempt in progress at the end of a fold.
+type topicOpenAttempt struct {
+	problemID uint32
+	answers   int
+	workMs    int64
+}
+
+// topicAttempt is one finished attempt. problemTypeBitmap and difficulty are
+// filled from the problems table after the fold.
+type topicAttempt struct {
+	endEventID        uint64
+	endedAt           time.Time
+	problemID         uint32
+	problemTypeBitmap uint64
+	difficulty        float64
+	answers           int
+	solved            bool
+	solveMs           int64
+}
+
+func (t topicAttempt) firstTry() bool {
+	return t.solved && t.answers == 1
+}
+
+// foldTopicAttempts advances open over events (in id order) and returns the
+// attempts they finished. Events of other types are ignored.
+func foldTopicAttempts(open topicOpenAttempt, events []progressEventRow) (topicOpenAttempt, []topicAttempt) {
+	var done []topicAttempt
+	closeOpen := func(e progressEventRow, solved bool) {
+		if open.problemID != 0 && open.answers > 0 {
+			done = append(done, topicAttempt{
+				endEventID: e.id,
+				endedAt:    e.timestamp,
+				problemID:  open.problemID,
+				answers:    open.answers,
+				solved:     solved,
+				solveMs:    open.workMs,
+			})
+		}
+		open = topicOpenAttempt{}
+	}
+	for _, e := range events {
+		switch e.eventType {
+		case SELECTED_PROBLEM:
+			v, _ := strconv.ParseUint(e.value, 10, 32)
+			// Re-selecting the problem already on screen (e.g. a settings
+			// change that lands on it again) continues the attempt.
+			if uint32(v) == open.problemID {
+				continue
+			}
+			closeOpen(e, false)
+			open.problemID = uint32(v)
+		case ANSWERED_PROBLEM:
+			if open.problemID != 0 {
+				open.answers++
+			}
+		case SOLVED_PROBLEM:
+			v, _ := strconv.ParseUint(e.value, 10, 32)
+			if open.problemID != 0 && uint32(v) == open.problemID {
+				closeOpen(e, true)
+			}
+		case WORKING_ON_PROBLEM:
+			v, _ := parseEventDurationMs(e.value)
+			if open.problemID != 0 && v > 0 {
+				open.workMs += v
+			}
+		}
+	}
+	return open, done
+}
+
+// cacheTopicAttempts folds events from open, stamps the finished attempts with
+// their problems' bitmap and difficulty, and inserts them. It returns the new
+// open attempt for the caller to checkpoint.
+func (a *Api) cacheTopicAttempts(userID uint32, open topicOpenAttempt, events []progressEventRow) (topicOpenAttempt, error) {
+	open, done := foldTopicAttempts(open, events)
+	if len(done) == 0 {
+		return open, nil
+	}
+
+	ids := map[uint32]bool{}
+	for _, t := range done {
+		ids[t.problemID] = true
+	}
+	placeholders := make([]string, 0, len(ids))
+	args := make([]interface{}, 0, len(ids))
+	for id := range ids {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+	rows, err := a.DB.Query(
+		"SELECT id, problem_type_bitmap, difficulty FROM problems WHERE id IN ("+strings.Join(placeholders, ", ")+")",
+		args...,
+	)
+	if err != nil {
+		return open, err
+	}
+	defer rows.Close()
+	type stamp struct {
+		bitmap     uint64
+		difficulty float64
+	}
+	stamps := map[uint32]stamp{}
+	for rows.Next() {
+		var id uint32
+		var s stamp
+		if err := rows.Scan(&id, &s.bitmap, &s.difficulty); err != nil {
+			return open, err
+		}
+		stamps[id] = s
+	}
+	if err := rows.Err(); err != nil {
+		return open, err
+	}
+
+	placeholders = make([]string, len(done))
+	args = make([]interface{}, 0, len(done)*9)
+	for i, t := range done {
+		s := stamps[t.problemID]
+		placeholders[i] = "(?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		args = append(args, userID, t.endEventID, t.endedAt, t.problemID, s.bitmap, s.difficulty, t.answers, t.solved, t.solveMs)
+	}
+	// INSERT IGNORE: the (user_id, end_event_id) key makes a re-fold of an
+	// already-cached range (a backfill after a checkpoint reset) a no-op.
+	_, err = a.DB.Exec(
+		"INSERT IGNORE INTO statistics_topic_attempts "+
+			"(user_id, end_event_id, ended_at, problem_id, problem_type_bitmap, difficulty, answers, solved, solve_ms) VALUES "+
+			strings.Join(placeholders, ", "),
+		args...,
+	)
+	return open, err
+}
+
+// fetchTopicEventsUpTo loads the user's topicStatsEventTypes events with
+// id <= maxID, for the backfill fold.
+func (a *Api) fetchTopicEventsUpTo(userID uint32, maxID uint64) ([]progressEventRow, error) {
+	args := []interface{}{userID, maxID}
+	for _, t := range topicStatsEventTypes {
+		args = append(args, t)
+	}
+	rows, err := a.DB.Query(
+		`SELECT id, event_type, value, timestamp FROM events WHERE user_id = ? AND id <= ? AND event_type IN (?, ?, ?, ?) ORDER BY id`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []progressEventRow
+	for rows.Next() {
+		var r progressEventRow
+		if err := rows.Scan(&r.id, &r.eventType, &r.value, &r.timestamp); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// getTopicOpenAttempt reads the attempt checkpointed with last_event_id.
+func (a *Api) getTopicOpenAttempt(userID uint32) (topicOpenAttempt, error) {
+	var open topicOpenAttempt
+	err := a.DB.QueryRow(
+		`SELECT open_problem_id, open_answers, open_work_ms FROM statistics_cache_meta WHERE user_id = ?`, userID,
+	).Scan(&open.problemID, &open.answers, &open.workMs)
+	if err == sql.ErrNoRows {
+		return topicOpenAttempt{}, nil
+	}
+	return open, err
+}
+
+// saveProgressCheckpoint records the last scanned event id and the attempt
+// open at that point.
+func (a *Api) saveProgressCheckpoint(userID uint32, lastEventID uint64, open topicOpenAttempt) error {
+	_, err := a.DB.Exec(`
+		INSERT INTO statistics_cache_meta (user_id, last_event_id, open_problem_id, open_answers, open_work_ms)
+		VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+			last_event_id = VALUES(last_event_id),
+			open_problem_id = VALUES(open_problem_id),
+			open_answers = VALUES(open_answers),
+			open_work_ms = VALUES(open_work_ms)`,
+		userID, lastEventID, open.problemID, open.answers, open.workMs,
+	)
+	return err
+}
+
+// readTopicStats rolls the user's cached attempts up: the period lists and
+// trend from the attempts inside the windows, the all-time figures from
+// readTopicTotals.
+func (a *Api) readTopicStats(userID uint32, now time.Time) ([]TopicStats, error) {
+	rows, err := a.DB.Query(`
+		SELECT ended_at, problem_type_bitmap, difficulty, answers, solved, solve_ms
+		FROM statistics_topic_attempts WHERE user_id = ? AND ended_at >= ?`, userID, topicWindowStart(now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var attempts []topicAttempt
+	for rows.Next() {
+		var t topicAttempt
+		if err := rows.Scan(&t.endedAt, &t.problemTypeBitmap, &t.difficulty, &t.answers, &t.solved, &t.solveMs); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	totals, err := a.readTopicTotals(userID)
+	if err != nil {
+		return nil, err
+	}
+	return withTopicTotals(aggregateTopicStats(attempts, now), totals), nil
+}
+
+// topicBitsSQL is a derived table with one row per ProblemType bit.
+func topicBitsSQL() string {
+	var rows []string
+	for bit := mathcore.ProblemType(1); bit <= mathcore.ALL_PROBLEM_TYPES; bit <<= 1 {
+		rows = append(rows, fmt.Sprintf("SELECT %d AS topic_bit", uint64(bit)))
+	}
+	return strings.Join(rows, " UNION ALL ")
+}
+
+// readTopicTotals aggregates every cached attempt of the user per bit in SQL,
+// filling only TopicStats' all-time fields. The median is the lower median
+// of the solved attempts' solve_ms, as medianInt64 picks it.
+func (a *Api) readTopicTotals(userID uint32) (map[uint64]TopicStats, error) {
+	rows, err := a.DB.Query(`
+		SELECT topic_bit, COUNT(*), SUM(solved AND answers = 1),
+		  COALESCE(MAX(CASE WHEN solved THEN difficulty END), 0),
+		  COALESCE(MAX(CASE WHEN solved AND solved_rank = (solved_count + 1) DIV 2 THEN solve_ms END), 0)
+		FROM (
+		  SELECT b.topic_bit, t.solved, t.answers, t.difficulty, t.solve_ms,
+		    ROW_NUMBER() OVER (PARTITION BY b.topic_bit, t.solved ORDER BY t.solve_ms) AS solved_rank,
+		    SUM(t.solved) OVER (PARTITION BY b.topic_bit) AS solved_count
+		  FROM statistics_topic_attempts t
+		  JOIN (`+topicBitsSQL()+`) b ON t.problem_type_bitmap & b.topic_bit <> 0
+		  WHERE t.user_id = ?
+		) x
+		GROUP BY topic_bit`, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[uint64]TopicStats{}
+	for rows.Next() {
+		var s TopicStats
+		if err := rows.Scan(&s.Bit, &s.Attempts, &s.FirstTryCorrect, &s.MaxDifficultySolved, &s.MedianSolveMs); err != nil {
+			return nil, err
+		}
+		out[s.Bit] = s
+	}
+	return out, rows.Err()
+}
+
+// withTopicTotals sets the all-time figures of windowed (aggregateTopicStats
+// over the windows' attempts) from totals, adding the bits whose attempts all
+// predate the windows. The result stays in bit order.
+func withTopicTotals(windowed []TopicStats, totals map[uint64]TopicStats) []TopicStats {
+	byBit := map[uint64]TopicStats{}
+	for _, s := range windowed {
+		byBit[s.Bit] = s
+	}
+	for bit, t := range totals {
+		s, ok := byBit[bit]
+		if !ok {
+			s = TopicStats{Topic: topicName(mathcore.ProblemType(bit)), Bit: bit, Daily: []TopicPeriodStats{}, Weekly: []TopicPeriodStats{}}
+		}
+		s.Attempts, s.FirstTryCorrect = t.Attempts, t.FirstTryCorrect
+		s.MedianSolveMs, s.MaxDifficultySolved = t.MedianSolveMs, t.MaxDifficultySolved
+		byBit[bit] = s
+	}
+	out := make([]TopicStats, 0, len(byBit))
+	for _, s := range byBit {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Bit < out[j].Bit })
+	return out
+}
+
+// topicAccumulator gathers one bit's attempts for a period (or all time).
+type topicAccumulator struct {
+	attempts, firstTry, solved int64
+	maxDifficulty              float64
+	solveMs                    []int64
+}
+
+func (acc *topicAccumulator) add(t topicAttempt) {
+	acc.attempts++
+	if t.firstTry() {
+		acc.firstTry++
+	}
+	if t.solved {
+		acc.solved++
+		acc.solveMs = append(acc.solveMs, t.solveMs)
+		if t.difficulty > acc.maxDifficulty {
+			acc.maxDifficulty = t.difficulty
+		}
+	}
+}
+
+func (acc *topicAccumulator) period(label string) TopicPeriodStats {
+	return TopicPeriodStats{
+		Period:              label,
+		Attempts:            acc.attempts,
+		FirstTryCorrect:     acc.firstTry,
+		Solved:              acc.solved,
+		MedianSolveMs:       medianInt64(acc.solveMs),
+		MaxDifficultySolved: acc.maxDifficulty,
+	}
+}
+
+// medianInt64 is the lower median (0 for none), so it is always an observed
+// value.
+func medianInt64(vals []int64) int64 {
+	if len(vals) == 0 {
+		return 0
+	}
+	s := append([]int64(nil), vals...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return s[(len(s)-1)/2]
+}
+
+// topicWeekStart is the UTC Monday on or before t.
+func topicWeekStart(t time.Time) time.Time {
+	d := time.Date(t.UTC().Year(), t.UTC().Month(), t.UTC().Day(), 0, 0, 0, 0, time.UTC)
+	offset := (int(d.Weekday()) + 6) % 7
+	return d.AddDate(0, 0, -offset)
+}
+
+// topicTrend compares the first-try rates of the current and previous week.
+func topicTrend(weekly map[string]*topicAccumulator, now time.Time) string {
+	cur := weekly[topicWeekStart(now).Format("2006-01-02")]
+	prev := weekly[topicWeekStart(now).AddDate(0, 0, -7).Format("2006-01-02")]
+	if cur == nil || prev == nil || cur.attempts < topicTrendMinAttempts || prev.attempts < topicTrendMinAttempts {
+		return ""
+	}
+	delta := float64(cur.firstTry)/float64(cur.attempts) - float64(prev.firstTry)/float64(prev.attempts)
+	switch {
+	case delta >= topicTrendDelta:
+		return "improving"
+	case delta <= -topicTrendDelta:
+		return "declining"
+	}
+	return "steady"
+}
+
+// topicWindows returns the first UTC day of the daily and of the weekly
+// period lists.
+func topicWindows(now time.Time) (dailyFrom, weeklyFrom time.Time) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return today.AddDate(0, 0, -(topicDailyWindowDays - 1)), topicWeekStart(now).AddDate(0, 0, -7*(topicWeeklyWindowWeeks-1))
+}
+
+// topicWindowStart is the earliest attempt end either period list covers.
+func topicWindowStart(now time.Time) time.Time {
+	dailyFrom, weeklyFrom := topicWindows(now)
+	if dailyFrom.Before(weeklyFrom) {
+		return dailyFrom
+	}
+	return weeklyFrom
+}
+
+// topicName is a single bit's feature name.
+func topicName(bit mathcore.ProblemType) string {
+	if names := mathcore.ProblemTypeToFeatures(bit); len(names) == 1 {
+		return names[0]
+	}
+	return ""
+}
+
+// aggregateTopicStats rolls attempts up per ProblemType bit: over all of
+// attempts, per UTC day over the last topicDailyWindowDays, and per week over
+// the last topicWeeklyWindowWeeks. An attempt counts toward every bit its
+// problem has. Bits with no attempts are omitted; periods are newest first.
+func aggregateTopicStats(attempts []topicAttempt, now time.Time) []TopicStats {
+	now = now.UTC()
+	dailyFrom, weeklyFrom := topicWindows(now)
+
+	type bitAcc struct {
+		all    topicAccumulator
+		daily  map[string]*topicAccumulator
+		weekly map[string]*topicAccumulator
+	}
+	byBit := map[mathcore.ProblemType]*bitAcc{}
+	for _, t := range attempts {
+		for bit := mathcore.ProblemType(1); bit != 0 && bit <= mathcore.ALL_PROBLEM_TYPES; bit <<= 1 {
+			if mathcore.ProblemType(t.problemTypeBitmap)&bit == 0 {
+				continue
+			}
+			b := byBit[bit]
+			if b == nil {
+				b = &bitAcc{daily: map[string]*topicAccumulator{}, weekly: map[string]*topicAccumulator{}}
+				byBit[bit] = b
+			}
+			b.all.add(t)
+			if !t.endedAt.Before(dailyFrom) {
+				day := t.endedAt.UTC().Format("2006-01-02")
+				if b.daily[day] == nil {
+					b.daily[day] = &topicAccumulator{}
+				}
+				b.daily[day].add(t)
+			}
+			if !t.endedAt.Before(weeklyFrom) {
+				week := topicWeekStart(t.endedAt).Format("2006-01-02")
+				if b.weekly[week] == nil {
+					b.weekly[week] = &topicAccumulator{}
+				}
+				b.weekly[week].add(t)
+			}
+		}
+	}
+
+	bits := make([]mathcore.ProblemType, 0, len(byBit))
+	for bit := range byBit {
+		bits = append(bits, bit)
+	}
+	sort.Slice(bits, func(i, j int) bool { return bits[i] < bits[j] })
+
+	periods := func(m map[string]*topicAccumulator) []TopicPeriodStats {
+		out := make([]TopicPeriodStats, 0, len(m))
+		for label, acc := range m {
+			out = append(out, acc.period(label))
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Period > out[j].Period })
+		return out
+	}
+
+	out := make([]TopicStats, 0, len(bits))
+	for _, bit := range bits {
+		b := byBit[bit]
+		out = append(out, TopicStats{
+			Topic:               topicName(bit),
+			Bit:                 uint64(bit),
+			Attempts:            b.all.attempts,
+			FirstTryCorrect:     b.all.firstTry,
+			MedianSolveMs:       medianInt64(b.all.solveMs),
+			MaxDifficultySolved: b.all.maxDifficulty,
+			Trend:               topicTrend(b.weekly, now),
+			Daily:               periods(b.daily),
+			Weekly:              periods(b.weekly),
+		})
+	}
+	return out
+}
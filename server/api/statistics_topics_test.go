@@ -0,0 +1,190 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+func topicEv(id uint64, eventType, value string, at time.Time) progressEventRow {
+	return progressEventRow{id: id, eventType: eventType, value: value, timestamp: at}
+}
+
+func TestFoldTopicAttempts_FirstTryAndRetry(t *testing.T) {
+	at := ts(1000)
+	events := []progressEventRow{
+		topicEv(1, SELECTED_PROBLEM, "10", at),
+		topicEv(2, WORKING_ON_PROBLEM, "4000", at),
+		topicEv(3, ANSWERED_PROBLEM, "7", at),
+		topicEv(4, SOLVED_PROBLEM, "10", at),
+		topicEv(5, SELECTED_PROBLEM, "11", at),
+		topicEv(6, WORKING_ON_PROBLEM, "3000", at),
+		topicEv(7, ANSWERED_PROBLEM, "1", at),
+		topicEv(8, WORKING_ON_PROBLEM, "2000", at),
+		topicEv(9, ANSWERED_PROBLEM, "2", at),
+		topicEv(10, SOLVED_PROBLEM, "11", at),
+		topicEv(11, SELECTED_PROBLEM, "12", at),
+	}
+	open, done := foldTopicAttempts(topicOpenAttempt{}, events)
+	if len(done) != 2 {
+		t.Fatalf("want 2 attempts, got %+v", done)
+	}
+	if !done[0].firstTry() || done[0].solveMs != 4000 || done[0].endEventID != 4 {
+		t.Errorf("attempt 1: want first-try in 4000ms ending at event 4, got %+v", done[0])
+	}
+	if done[1].firstTry() || !done[1].solved || done[1].answers != 2 || done[1].solveMs != 5000 {
+		t.Errorf("attempt 2: want solved on the 2nd answer in 5000ms, got %+v", done[1])
+	}
+	if open != (topicOpenAttempt{problemID: 12}) {
+		t.Errorf("open: want problem 12 with nothing yet, got %+v", open)
+	}
+}
+
+// TestFoldTopicAttempts_SplitAcrossMerges verifies an attempt open at one
+// checkpoint finishes correctly in the next merge.
+func TestFoldTopicAttempts_SplitAcrossMerges(t *testing.T) {
+	at := ts(1000)
+	open, done := foldTopicAttempts(topicOpenAttempt{}, []progressEventRow{
+		topicEv(1, SELECTED_PROBLEM, "10", at),
+		topicEv(2, WORKING_ON_PROBLEM, "1000", at),
+		topicEv(3, ANSWERED_PROBLEM, "9", at),
+	})
+	if len(done) != 0 {
+		t.Fatalf("first merge: want 0 finished attempts, got %+v", done)
+	}
+	_, done = foldTopicAttempts(open, []progressEventRow{
+		topicEv(4, WORKING_ON_PROBLEM, "1500", at),
+		topicEv(5, ANSWERED_PROBLEM, "8", at),
+		topicEv(6, SOLVED_PROBLEM, "10", at),
+	})
+	if len(done) != 1 || done[0].answers != 2 || done[0].solveMs != 2500 {
+		t.Errorf("second merge: want one 2-answer attempt in 2500ms, got %+v", done)
+	}
+}
+
+// TestFoldTopicAttempts_Unanswered verifies a problem replaced before any
+// answer is not an attempt, one replaced after a wrong answer is an unsolved
+// attempt, and a re-selection of the same problem continues it.
+func TestFoldTopicAttempts_Unanswered(t *testing.T) {
+	at := ts(1000)
+	_, done := foldTopicAttempts(topicOpenAttempt{}, []progressEventRow{
+		topicEv(1, SELECTED_PROBLEM, "10", at),
+		topicEv(2, SELECTED_PROBLEM, "11", at),
+		topicEv(3, ANSWERED_PROBLEM, "5", at),
+		topicEv(4, SELECTED_PROBLEM, "11", at),
+		topicEv(5, SELECTED_PROBLEM, "12", at),
+	})
+	if len(done) != 1 {
+		t.Fatalf("want 1 attempt, got %+v", done)
+	}
+	if done[0].problemID != 11 || done[0].solved || done[0].endEventID != 5 {
+		t.Errorf("want unsolved attempt on 11 ending at event 5, got %+v", done[0])
+	}
+}
+
+func TestMedianInt64(t *testing.T) {
+	cases := []struct {
+		in   []int64
+		want int64
+	}{
+		{nil, 0},
+		{[]int64{5}, 5},
+		{[]int64{9, 1, 5}, 5},
+		{[]int64{4, 1, 3, 2}, 2},
+	}
+	for _, c := range cases {
+		if got := medianInt64(c.in); got != c.want {
+			t.Errorf("medianInt64(%v): want %d, got %d", c.in, c.want, got)
+		}
+	}
+}
+
+func TestTopicWeekStart(t *testing.T) {
+	// 2026-10-18 is a Sunday; its week starts Monday 2026-10-12.
+	sunday := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+	if got := topicWeekStart(sunday).Format("2006-01-02"); got != "2026-10-12" {
+		t.Errorf("Sunday: want 2026-10-12, got %s", got)
+	}
+	monday := time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)
+	if got := topicWeekStart(monday).Format("2006-01-02"); got != "2026-10-12" {
+		t.Errorf("Monday: want 2026-10-12, got %s", got)
+	}
+}
+
+func TestAggregateTopicStats(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC) // Wednesday
+	thisWeek := now.Add(-time.Hour)
+	lastWeek := now.AddDate(0, 0, -7)
+	fracs := uint64(mathcore.ADDITION | mathcore.FRACTIONS)
+	var attempts []topicAttempt
+	// Last week: 5 fraction attempts, 2 first-try.
+	for i := 0; i < 5; i++ {
+		answers := 2
+		if i < 2 {
+			answers = 1
+		}
+		attempts = append(attempts, topicAttempt{endedAt: lastWeek, problemTypeBitmap: fracs, difficulty: 4, answers: answers, solved: true, solveMs: int64(1000 * (i + 1))})
+	}
+	// This week: 5 fraction attempts, 4 first-try, one harder.
+	for i := 0; i < 5; i++ {
+		answers := 1
+		if i == 0 {
+			answers = 3
+		}
+		attempts = append(attempts, topicAttempt{endedAt: thisWeek, problemTypeBitmap: fracs, difficulty: 4 + float64(i), answers: answers, solved: true, solveMs: 2000})
+	}
+	// An unsolved subtraction attempt well outside both windows.
+	attempts = append(attempts, topicAttempt{endedAt: now.AddDate(-1, 0, 0), problemTypeBitmap: uint64(mathcore.SUBTRACTION), answers: 2})
+
+	got := aggregateTopicStats(attempts, now)
+	if len(got) != 3 {
+		t.Fatalf("want addition, subtraction, fractions; got %+v", got)
+	}
+	if got[0].Topic != "addition" || got[1].Topic != "subtraction" || got[2].Topic != "fractions" {
+		t.Errorf("want bit order addition, subtraction, fractions; got %s, %s, %s", got[0].Topic, got[1].Topic, got[2].Topic)
+	}
+	f := got[2]
+	if f.Attempts != 10 || f.FirstTryCorrect != 6 || f.MaxDifficultySolved != 8 {
+		t.Errorf("fractions all-time: want 10 attempts, 6 first-try, max 8; got %+v", f)
+	}
+	if f.MedianSolveMs != 2000 {
+		t.Errorf("fractions median: want 2000, got %d", f.MedianSolveMs)
+	}
+	if f.Trend != "improving" {
+		t.Errorf("fractions trend: want improving (40%% -> 80%%), got %q", f.Trend)
+	}
+	if len(f.Weekly) != 2 || f.Weekly[0].Period != "2026-10-12" || f.Weekly[0].FirstTryCorrect != 4 {
+		t.Errorf("fractions weekly: want newest-first with 4 first-try this week, got %+v", f.Weekly)
+	}
+	if len(f.Daily) != 2 || f.Daily[0].Period != "2026-10-14" || f.Daily[1].Period != "2026-10-07" {
+		t.Errorf("fractions daily: want 2026-10-14 then 2026-10-07, got %+v", f.Daily)
+	}
+	s := got[1]
+	if s.Attempts != 1 || s.FirstTryCorrect != 0 || len(s.Daily) != 0 || len(s.Weekly) != 0 || s.Trend != "" {
+		t.Errorf("subtraction: want one old unsolved attempt and no periods, got %+v", s)
+	}
+}
+
+func TestWithTopicTotals(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	if got := topicWindowStart(now); !got.Equal(time.Date(2026, 7, 27, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("window start: want the Monday 11 weeks before this one, got %v", got)
+	}
+	fracs := uint64(mathcore.ADDITION | mathcore.FRACTIONS)
+	windowed := aggregateTopicStats([]topicAttempt{{endedAt: now, problemTypeBitmap: fracs, difficulty: 4, answers: 1, solved: true, solveMs: 2000}}, now)
+	got := withTopicTotals(windowed, map[uint64]TopicStats{
+		uint64(mathcore.ADDITION):    {Bit: uint64(mathcore.ADDITION), Attempts: 9, FirstTryCorrect: 3, MedianSolveMs: 1500, MaxDifficultySolved: 6},
+		uint64(mathcore.SUBTRACTION): {Bit: uint64(mathcore.SUBTRACTION), Attempts: 2},
+		uint64(mathcore.FRACTIONS):   {Bit: uint64(mathcore.FRACTIONS), Attempts: 1, FirstTryCorrect: 1, MedianSolveMs: 2000, MaxDifficultySolved: 4},
+	})
+	if len(got) != 3 || got[0].Topic != "addition" || got[1].Topic != "subtraction" || got[2].Topic != "fractions" {
+		t.Fatalf("want addition, subtraction, fractions in bit order; got %+v", got)
+	}
+	if a := got[0]; a.Attempts != 9 || a.FirstTryCorrect != 3 || a.MedianSolveMs != 1500 || a.MaxDifficultySolved != 6 || len(a.Daily) != 1 {
+		t.Errorf("addition: want the totals' all-time figures and the window's periods, got %+v", a)
+	}
+	if s := got[1]; s.Attempts != 2 || s.Daily == nil || len(s.Daily) != 0 || len(s.Weekly) != 0 || s.Trend != "" {
+		t.Errorf("subtraction: want an all-time-only entry with empty periods, got %+v", s)
+	}
+}
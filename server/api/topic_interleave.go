@@ -0,0 +1,290 @@
+// topic_interleave.go: spreads selection across the topics a user enabled.
+//
+// The subset rule lets any problem inside the envelope be served, so an
+// envelope of addition, fractions and word problems can serve addition-only
+// problems for a whole session. Between the candidate lookup and the recency
+// pick, selectProblem narrows the pool by topic: each enabled bit is a topic,
+// and the bitmaps of the user's last topicHistorySize shown problems
+// (recently_shown_problems joined to problems) say how often, and how
+// recently, each was exercised. Topics fall due by deadline: one unseen for
+// topicCoverageWindow-1 problems must be carried by this pick, and k topics
+// due within the next k picks are all overdue, so two topics reaching the
+// window together are served one pick apart rather than colliding. A forced
+// pick carries the longest-unseen overdue topic; otherwise the pick is
+// biased toward the topics furthest below their share of settings'
+// topic_weights. Part of the selection system - documented in
+// docs/selection.md.
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+const (
+	// topicCoverageWindow is N in the coverage guarantee: every servable
+	// enabled topic with a non-zero weight appears at least once in any
+	// topicCoverageWindow consecutive picks from the pool, as long as there
+	// are no more than topicCoverageWindow such topics.
+	topicCoverageWindow = 10
+
+	// topicHistorySize is how many recently shown problems the topic shares
+	// are measured over.
+	topicHistorySize = 2 * topicCoverageWindow
+
+	// maxTopicWeight bounds a topic_weights entry; 0 leaves the topic out
+	// of the bias and the guarantee without disabling it.
+	maxTopicWeight = 10
+)
+
+// parseTopicWeights reads settings.topic_weights, a JSON object of
+// problem-type feature names to weights. Empty means no weights: every
+// enabled topic weighs 1.
+func parseTopicWeights(s string) (map[mathcore.ProblemType]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var byName map[string]float64
+	if err := json.Unmarshal([]byte(s), &byName); err != nil {
+		return nil, fmt.Errorf("Invalid topic_weights: %v", err)
+	}
+	out := make(map[mathcore.ProblemType]float64, len(byName))
+	for name, w := range byName {
+		bit := mathcore.FeaturesToProblemType([]string{name})
+		if bit == 0 {
+			return nil, fmt.Errorf("Invalid topic_weights: unknown topic %q", name)
+		}
+		if math.IsNaN(w) || w < 0 || w > maxTopicWeight {
+			return nil, fmt.Errorf("Invalid topic_weights: %s weight %v (must be 0-%d)", name, w, maxTopicWeight)
+		}
+		out[bit] = w
+	}
+	return out, nil
+}
+
+// validateTopicWeights checks the topic_weights field of a settings write.
+// Weights for topics outside the envelope are kept: they apply once the
+// topic is enabled.
+func validateTopicWeights(s *Settings) error {
+	_, err := parseTopicWeights(s.TopicWeights)
+	return err
+}
+
+// topicStat is one enabled topic's standing at a pick.
+type topicStat struct {
+	bit        mathcore.ProblemType
+	weight     float64
+	recent     int // history problems carrying the topic
+	since      int // problems shown since it last appeared; len(history) if not in it
+	candidates int // pool problems carrying the topic
+}
+
+// topicPlan is how a pick narrows its pool. With overdue bits set the pick
+// is forced to carry first and as many of the others as it can; otherwise
+// chances gives each under-covered topic's probability of being the one the
+// pick is narrowed to.
+type topicPlan struct {
+	stats   []topicStat // the envelope's bits, lowest first
+	overdue mathcore.ProblemType
+	first   mathcore.ProblemType // the overdue topic unseen longest, lowest bit on a tie
+	chances map[mathcore.ProblemType]float64
+}
+
+// planTopics scores the envelope's topics against history (the shown
+// problems' bitmaps, most recent first) and the pool's bitmaps.
+func planTopics(envelope uint64, weights map[mathcore.ProblemType]float64, history, pool []uint64) *topicPlan {
+	plan := &topicPlan{chances: map[mathcore.ProblemType]float64{}}
+	totalWeight := 0.0
+	servable := []topicStat{}
+	for rest := envelope & uint64(mathcore.ALL_PROBLEM_TYPES); rest != 0; rest &= rest - 1 {
+		bit := mathcore.ProblemType(1) << bits.TrailingZeros64(rest)
+		st := topicStat{bit: bit, weight: 1, since: len(history)}
+		if w, ok := weights[bit]; ok {
+			st.weight = w
+		}
+		for i, b := range history {
+			if mathcore.ProblemType(b)&bit != 0 {
+				st.recent++
+				if st.since == len(history) {
+					st.since = i
+				}
+			}
+		}
+		for _, b := range pool {
+			if mathcore.ProblemType(b)&bit != 0 {
+				st.candidates++
+			}
+		}
+		// A topic with no weight or nothing in the pool can't be asked for.
+		if st.weight > 0 && st.candidates > 0 {
+			totalWeight += st.weight
+			servable = append(servable, st)
+		}
+		plan.stats = append(plan.stats, st)
+	}
+
+	// Earliest deadline first. A topic with since s must be carried within
+	// the next topicCoverageWindow-1-s picks. Ranked longest-unseen first,
+	// the topic at rank k is tight when its deadline leaves no slack for
+	// the k ranked ahead of it; that topic and everything ahead are overdue.
+	// A pick serves at least the first of them, so the rest still meet
+	// their deadlines on the picks that follow.
+	sort.SliceStable(servable, func(i, j int) bool { return servable[i].since > servable[j].since })
+	for k, st := range servable {
+		if topicCoverageWindow-1-st.since <= k {
+			for _, ahead := range servable[:k+1] {
+				plan.overdue |= ahead.bit
+			}
+		}
+	}
+	if plan.overdue != 0 {
+		plan.first = servable[0].bit
+	}
+	if plan.overdue != 0 || totalWeight == 0 {
+		return plan
+	}
+
+	// Each servable topic's deficit: its share of the weight less its share
+	// of the history. Problems carry several bits, so the shares needn't
+	// sum to 1; only the topics below their share are chosen from.
+	sum := 0.0
+	for _, st := range plan.stats {
+		if st.weight == 0 || st.candidates == 0 {
+			continue
+		}
+		deficit := st.weight / totalWeight
+		if len(history) > 0 {
+			deficit -= float64(st.recent) / float64(len(history))
+		}
+		if deficit > 0 {
+			plan.chances[st.bit] = deficit
+			sum += deficit
+		}
+	}
+	for bit := range plan.chances {
+		plan.chances[bit] /= sum
+	}
+	return plan
+}
+
+// narrow returns the ids the recency pick should choose from, and how they
+// were chosen. "forced", when topics are overdue, keeps the problems that
+// carry first and, of those, the most overdue topics. "biased", when none
+// are, keeps the problems carrying the topic r (uniform in [0, 1)) lands on
+// in chances. "none", when chances is empty too, keeps all of ids. bitmaps
+// parallels ids.
+func (p *topicPlan) narrow(ids []uint32, bitmaps []uint64, r float64) ([]uint32, string) {
+	if p.overdue != 0 {
+		best := 0
+		for _, b := range bitmaps {
+			if n := bits.OnesCount64(b & uint64(p.overdue)); b&uint64(p.first) != 0 && n > best {
+				best = n
+			}
+		}
+		out := []uint32{}
+		for i, b := range bitmaps {
+			if b&uint64(p.first) != 0 && bits.OnesCount64(b&uint64(p.overdue)) == best {
+				out = append(out, ids[i])
+			}
+		}
+		return out, "forced"
+	}
+	focus := mathcore.ProblemType(0)
+	for _, st := range p.stats { // lowest bit first, so r maps to one topic
+		c, ok := p.chances[st.bit]
+		if !ok {
+			continue
+		}
+		focus = st.bit
+		if r < c {
+			break
+		}
+		r -= c
+	}
+	if focus == 0 {
+		return ids, "none"
+	}
+	out := []uint32{}
+	for i, b := range bitmaps {
+		if mathcore.ProblemType(b)&focus != 0 {
+			out = append(out, ids[i])
+		}
+	}
+	return out, "biased"
+}
+
+// loadRecentBitmaps returns the bitmaps of the user's topicHistorySize most
+// recently shown problems, most recent first. Fail-tolerant like
+// loadRecentProblemIds: on error it logs and returns none, which leaves the
+// pick biased by weight alone.
+func loadRecentBitmaps(logPrefix string, db *sql.DB, userID uint32) []uint64 {
+	out := []uint64{}
+	rows, err := db.Query(
+		`SELECT p.problem_type_bitmap FROM recently_shown_problems r
+		 JOIN problems p ON p.id = r.problem_id
+		 WHERE r.user_id = ? ORDER BY r.shown_at DESC LIMIT ?`,
+		userID, topicHistorySize,
+	)
+	if err != nil {
+		glog.Warningf("%s loadRecentBitmaps user=%d query: %v (continuing without topic history)", logPrefix, userID, err)
+		return out
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b uint64
+		if err := rows.Scan(&b); err != nil {
+			glog.Warningf("%s loadRecentBitmaps user=%d scan: %v", logPrefix, userID, err)
+			return []uint64{}
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		glog.Warningf("%s loadRecentBitmaps user=%d iter: %v", logPrefix, userID, err)
+		return []uint64{}
+	}
+	return out
+}
+
+// planTopicsFor builds the topic plan for a pick from ids, returning the
+// ids' bitmaps alongside. Unparseable weights (written before validation
+// existed, or by hand) are logged and ignored.
+func (a *Api) planTopicsFor(logPrefix string, settings *Settings, ids []uint32) (*topicPlan, []uint64, error) {
+	weights, err := parseTopicWeights(settings.TopicWeights)
+	if err != nil {
+		glog.Warningf("%s user=%d: %v (ignoring)", logPrefix, settings.UserId, err)
+	}
+	var bitmaps []uint64
+	if err := a.readCandidates(func(s *candidateSet) { bitmaps = s.bitmapsOf(ids) }); err != nil {
+		return nil, nil, err
+	}
+	history := loadRecentBitmaps(logPrefix, a.DB, settings.UserId)
+	return planTopics(settings.ProblemTypeBitmap, weights, history, bitmaps), bitmaps, nil
+}
+
+// interleaveTopics narrows a non-empty pool to the problems the topic plan
+// wants next. It never empties the pool: on any failure it returns ids.
+func (a *Api) interleaveTopics(logPrefix string, settings *Settings, ids []uint32) []uint32 {
+	plan, bitmaps, err := a.planTopicsFor(logPrefix, settings, ids)
+	if err != nil {
+		glog.Errorf("%s planTopicsFor: %v (skipping topic interleaving)", logPrefix, err)
+		return ids
+	}
+	out, result := plan.narrow(ids, bitmaps, rand.Float64())
+	if len(out) == 0 {
+		return ids
+	}
+	topicInterleaveTotal.Inc(result)
+	if result != "none" {
+		glog.Infof("%s topics %s: %d of %d candidates", logPrefix, result, len(out), len(ids))
+	}
+	return out
+}
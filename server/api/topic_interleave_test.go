@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/mathcore"
+)
+
+func TestParseTopicWeights(t *testing.T) {
+	w, err := parseTopicWeights(`{"fractions":2,"word":0}`)
+	if err != nil || len(w) != 2 || w[mathcore.FRACTIONS] != 2 || w[mathcore.WORD] != 0 {
+		t.Errorf("want fractions 2 and word 0, got %v %v", w, err)
+	}
+	if w, err := parseTopicWeights(""); err != nil || w != nil {
+		t.Errorf("empty: want no weights, got %v %v", w, err)
+	}
+	for _, bad := range []string{`{"geometry":1}`, `{"addition":-1}`, `{"addition":11}`, `[1]`, `{`} {
+		if _, err := parseTopicWeights(bad); err == nil {
+			t.Errorf("%s: want an error", bad)
+		}
+	}
+}
+
+// TestPlanTopics: a topic unseen for topicCoverageWindow-1 problems forces
+// the pick, as do k topics due within k picks; otherwise the pick leans
+// toward the topics below their share.
+func TestPlanTopics(t *testing.T) {
+	add, frac, word := uint64(mathcore.ADDITION), uint64(mathcore.FRACTIONS), uint64(mathcore.WORD)
+	envelope := add | frac | word
+	ids := []uint32{1, 2, 3, 4, 5}
+	pool := []uint64{add, add, add, add | frac, word}
+
+	addOnly := make([]uint64, topicCoverageWindow-1)
+	for i := range addOnly {
+		addOnly[i] = add
+	}
+	plan := planTopics(envelope, nil, addOnly, pool)
+	if plan.overdue != mathcore.FRACTIONS|mathcore.WORD {
+		t.Fatalf("after %d addition-only problems: want fractions and word overdue, got %d", len(addOnly), plan.overdue)
+	}
+	// Both are unseen equally long; the lower bit goes first.
+	if got, result := plan.narrow(ids, pool, 0.5); result != "forced" || len(got) != 1 || got[0] != 4 {
+		t.Errorf("forced: want fractions [4], got %v %s", got, result)
+	}
+	// Two topics sharing a deadline fall due a problem early, and two
+	// problems early nothing is due yet.
+	if plan := planTopics(envelope, nil, addOnly[1:], pool); plan.overdue != mathcore.FRACTIONS|mathcore.WORD {
+		t.Errorf("two topics due within two picks: want both overdue, got %d", plan.overdue)
+	}
+	if plan := planTopics(envelope, nil, addOnly[2:], pool); plan.overdue != 0 {
+		t.Errorf("want nothing overdue yet, got %d", plan.overdue)
+	}
+	// A zero weight exempts word from the guarantee.
+	if plan := planTopics(envelope, map[mathcore.ProblemType]float64{mathcore.WORD: 0}, addOnly, pool); plan.overdue != mathcore.FRACTIONS {
+		t.Errorf("word weight 0: want only fractions overdue, got %d", plan.overdue)
+	}
+	// Nothing in the pool carries subtraction, so it can't fall due.
+	if plan := planTopics(envelope|uint64(mathcore.SUBTRACTION), nil, addOnly, pool); plan.overdue&mathcore.SUBTRACTION != 0 {
+		t.Errorf("want an unservable topic left out, got %d", plan.overdue)
+	}
+
+	// Addition has 3 of 4 recent problems, over its third: fractions and
+	// word split the odds.
+	history := []uint64{add, add | frac, word, add}
+	plan = planTopics(envelope, nil, history, pool)
+	if plan.overdue != 0 || len(plan.chances) != 2 || plan.chances[mathcore.FRACTIONS] != 0.5 || plan.chances[mathcore.WORD] != 0.5 {
+		t.Fatalf("want fractions and word at 0.5 each, got %+v", plan)
+	}
+	if got, result := plan.narrow(ids, pool, 0.2); result != "biased" || len(got) != 1 || got[0] != 4 {
+		t.Errorf("r=0.2: want fractions [4], got %v %s", got, result)
+	}
+	if got, _ := plan.narrow(ids, pool, 0.7); len(got) != 1 || got[0] != 5 {
+		t.Errorf("r=0.7: want word [5], got %v", got)
+	}
+	// With no history the odds follow the weights.
+	plan = planTopics(envelope, map[mathcore.ProblemType]float64{mathcore.ADDITION: 2, mathcore.WORD: 0}, nil, pool)
+	if math.Abs(plan.chances[mathcore.ADDITION]-2.0/3) > 1e-9 || math.Abs(plan.chances[mathcore.FRACTIONS]-1.0/3) > 1e-9 || plan.chances[mathcore.WORD] != 0 {
+		t.Errorf("no history: want addition 2/3 and fractions 1/3, got %v", plan.chances)
+	}
+}
+
+// TestPlanTopics_OverdueTogether runs picks from a pool where no problem
+// carries both fractions and word, starting from a history where both last
+// appeared on the same problem and their weights keep the bias off them, and
+// checks each still appears in every topicCoverageWindow consecutive picks.
+func TestPlanTopics_OverdueTogether(t *testing.T) {
+	add, frac, word := uint64(mathcore.ADDITION), uint64(mathcore.FRACTIONS), uint64(mathcore.WORD)
+	envelope := add | frac | word
+	weights := map[mathcore.ProblemType]float64{mathcore.ADDITION: 10, mathcore.FRACTIONS: 0.01, mathcore.WORD: 0.01}
+	ids := []uint32{1, 2, 3, 4}
+	pool := []uint64{add, add, add | frac, word}
+
+	// Oldest first; history is most recent first.
+	all := []uint64{frac | word}
+	for i := 0; i < topicCoverageWindow-2; i++ {
+		all = append(all, add)
+	}
+	for pick := 0; pick < 3*topicCoverageWindow; pick++ {
+		history := []uint64{}
+		for i := len(all) - 1; i >= 0 && len(history) < topicHistorySize; i-- {
+			history = append(history, all[i])
+		}
+		got, _ := planTopics(envelope, weights, history, pool).narrow(ids, pool, 0)
+		if len(got) == 0 {
+			t.Fatalf("pick %d: narrowed to nothing", pick)
+		}
+		all = append(all, pool[got[0]-1])
+	}
+	for _, bit := range []uint64{frac, word} {
+		gap := 0
+		for i, b := range all {
+			if b&bit != 0 {
+				gap = 0
+				continue
+			}
+			if gap++; gap >= topicCoverageWindow {
+				t.Fatalf("topic %d missing from the %d picks ending at %d: %v", bit, topicCoverageWindow, i, all)
+			}
+		}
+	}
+}
+
+// TestInterleaveTopics serves from an addition-heavy pool after a run of
+// addition-only problems and checks the overdue topic is served, that the
+// explain endpoint reports it, and that bad weights are refused.
+func TestInterleaveTopics(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|topics", "topics@test.com", "topicsuser")
+	admin := createTestUser(t, r, "auth0|topics-admin", "topicsadmin@test.com", "topicsadmin")
+	add, frac := uint64(mathcore.ADDITION), uint64(mathcore.ADDITION|mathcore.FRACTIONS)
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := api.DB.Exec(query, args...); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+	exec("UPDATE problems SET disabled = 1")
+	exec("DELETE FROM generation_jobs")
+	exec("DELETE FROM recently_shown_problems")
+	exec("UPDATE users SET role = ? WHERE id = ?", RoleAdmin, admin.Id)
+	exec("UPDATE settings SET problem_type_bitmap = ?, target_difficulty = 3 WHERE user_id = ?", add|frac, user.Id)
+	seed := func(id uint32, bitmap uint64) {
+		exec(`INSERT INTO problems (id, problem_type_bitmap, expression, symbolic_expression, answer, difficulty, disabled, generator, difficulty_version)
+			 VALUES (?, ?, ?, '', '1', 3, 0, 'llm_0.5', '0.2')`, id, bitmap, fmt.Sprintf("topics %d", id))
+	}
+	for id := uint32(9701); id <= 9740; id++ {
+		seed(id, add)
+	}
+	seed(9750, frac)
+	seed(9751, frac)
+	for i := 0; i < topicCoverageWindow-1; i++ {
+		exec("INSERT INTO recently_shown_problems (user_id, problem_id, shown_at) VALUES (?, ?, NOW() - INTERVAL ? MINUTE)", user.Id, 9701+i, i)
+	}
+	if err := api.LoadCandidateIndex(); err != nil {
+		t.Fatalf("LoadCandidateIndex: %v", err)
+	}
+
+	settings, _, _, err := api.settingsManager.Get(user.Id)
+	if err != nil {
+		t.Fatalf("settings: %v", err)
+	}
+	ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+	for i := 0; i < 5; i++ {
+		prevIds := loadRecentProblemIds("[test]", api.DB, user.Id)
+		p, err := api.selectProblem("[test]", ctx, settings, &prevIds)
+		if err != nil {
+			t.Fatalf("selectProblem: %v", err)
+		}
+		if p.Id != 9750 && p.Id != 9751 {
+			t.Fatalf("pick %d: want a fractions problem, got %d", i, p.Id)
+		}
+	}
+
+	resp := catalogRequest(t, r, admin, "GET", fmt.Sprintf("/admin/users/%d/selection", user.Id), "")
+	var ex SelectionExplain
+	if err := json.Unmarshal(resp.Body.Bytes(), &ex); err != nil {
+		t.Fatalf("explain: %v %s", err, resp.Body.String())
+	}
+	if !ex.TopicsForced || len(ex.Topics) != 2 || !ex.Topics[1].Overdue || ex.Topics[1].Since != topicCoverageWindow-1 || len(ex.TopSlice) != 1 {
+		t.Errorf("explain: want fractions overdue and the pool narrowed, got forced=%v %+v %+v", ex.TopicsForced, ex.Topics, ex.TopSlice)
+	}
+
+	// The weights are validated on write.
+	body := fmt.Sprintf(`{"problem_type_bitmap":%d,"target_difficulty":3,"target_work_percentage":70,"timezone":"UTC","topic_weights":%q}`, add|frac, `{"geometry":1}`)
+	if resp := catalogRequest(t, r, user, "POST", fmt.Sprintf("/settings/%d", user.Id), body); resp.Code != 400 {
+		t.Errorf("unknown topic: want 400, got %d %s", resp.Code, resp.Body.String())
+	}
+	body = fmt.Sprintf(`{"problem_type_bitmap":%d,"target_difficulty":3,"target_work_percentage":70,"timezone":"UTC","topic_weights":%q}`, add|frac, `{"fractions":0}`)
+	if resp := catalogRequest(t, r, user, "POST", fmt.Sprintf("/settings/%d", user.Id), body); resp.Code != 200 {
+		t.Errorf("valid weights: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+}
@@ -0,0 +1,285 @@
+// video_approvals.go: the parent's review queue for playlist videos.
+//
+// Each user_playlist row carries an approval policy. An 'auto' playlist makes
+// every video in it rewardable, including anything its channel adds later. A
+// 'review' playlist admits only the videos the parent approves: its videos
+// wait in the queue, pending, until approved or rejected, and
+// refreshUserHasVideo leaves the pending and rejected ones out of
+// user_has_video. Decisions live in user_video_approvals, one per (user,
+// video). A video that an 'auto' playlist of the user's also holds is
+// rewardable whatever its decision, so it never waits in the queue.
+// Documented in docs/videos.md.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// Approval policies, as stored in user_playlist.approval_policy.
+const (
+	APPROVAL_POLICY_AUTO   = "auto"
+	APPROVAL_POLICY_REVIEW = "review"
+)
+
+// Approval statuses. Pending is the absence of a user_video_approvals row.
+const (
+	APPROVAL_PENDING  = "pending"
+	APPROVAL_APPROVED = "approved"
+	APPROVAL_REJECTED = "rejected"
+)
+
+// maxApprovalBatch caps the videos one decision may cover.
+const maxApprovalBatch = 500
+
+// rewardableVideoSQL is refreshUserHasVideo's test for a playlist video
+// (pv, via the user's subscription up, with decision ap) entering the pool.
+const rewardableVideoSQL = `(up.approval_policy = '` + APPROVAL_POLICY_AUTO + `' OR ap.status = '` + APPROVAL_APPROVED + `')`
+
+// ApprovalPlaylist is a review playlist holding a queued video.
+type ApprovalPlaylist struct {
+	Id    uint32 `json:"id"`
+	Title string `json:"title"`
+}
+
+// ApprovalVideo is one video in the review queue.
+type ApprovalVideo struct {
+	VideoId         uint32             `json:"video_id"`
+	Title           string             `json:"title"`
+	URL             string             `json:"url"`
+	ThumbnailURL    string             `json:"thumbnailurl"`
+	DurationSeconds uint32             `json:"duration_seconds"`
+	Status          string             `json:"status"`
+	AddedAt         time.Time          `json:"added_at"`
+	Playlists       []ApprovalPlaylist `json:"playlists"`
+}
+
+// validApprovalPolicy reports whether s is an approval policy.
+func validApprovalPolicy(s string) bool {
+	return s == APPROVAL_POLICY_AUTO || s == APPROVAL_POLICY_REVIEW
+}
+
+// listVideoApprovals returns the user's review-playlist videos with the given
+// status, newest membership first.
+func (a *Api) listVideoApprovals(userID uint32, status string) ([]ApprovalVideo, error) {
+	statusCond := "ap.status = ?"
+	args := []interface{}{userID}
+	if status == APPROVAL_PENDING {
+		// Pending videos exclude any an 'auto' playlist already admits.
+		statusCond = `ap.video_id IS NULL AND NOT EXISTS (
+			SELECT 1 FROM user_playlist upa
+			INNER JOIN playlist_video pva ON pva.playlist_id = upa.playlist_id
+			WHERE upa.user_id = up.user_id AND upa.approval_policy = '` + APPROVAL_POLICY_AUTO + `' AND pva.video_id = v.id)`
+	} else {
+		args = append(args, status)
+	}
+	rows, err := a.DB.Query(`
+		SELECT v.id, v.title, v.url, v.thumbnailurl, v.duration_seconds, p.id, p.title, pv.added_at
+		FROM user_playlist up
+		INNER JOIN playlists p ON p.id = up.playlist_id
+		INNER JOIN playlist_video pv ON pv.playlist_id = up.playlist_id
+		INNER JOIN videos v ON v.id = pv.video_id AND v.disabled = 0
+		LEFT JOIN user_video_approvals ap ON ap.user_id = up.user_id AND ap.video_id = v.id
+		WHERE up.user_id = ? AND up.approval_policy = '`+APPROVAL_POLICY_REVIEW+`' AND `+statusCond+`
+		ORDER BY pv.added_at DESC, v.id, p.id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query approvals: %w", err)
+	}
+	defer rows.Close()
+	out := []ApprovalVideo{}
+	index := map[uint32]int{}
+	for rows.Next() {
+		var v ApprovalVideo
+		var pl ApprovalPlaylist
+		if err := rows.Scan(&v.VideoId, &v.Title, &v.URL, &v.ThumbnailURL, &v.DurationSeconds, &pl.Id, &pl.Title, &v.AddedAt); err != nil {
+			return nil, fmt.Errorf("scan approval: %w", err)
+		}
+		// A video in several review playlists is listed once, under its
+		// newest membership.
+		if i, ok := index[v.VideoId]; ok {
+			out[i].Playlists = append(out[i].Playlists, pl)
+			continue
+		}
+		v.Status = status
+		v.URL, v.ThumbnailURL = a.signMediaURL(v.URL), a.signMediaURL(v.ThumbnailURL)
+		v.Playlists = []ApprovalPlaylist{pl}
+		index[v.VideoId] = len(out)
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// customListVideoApprovals handles GET /video-approvals?status=pending (the
+// default), approved or rejected.
+func (a *Api) customListVideoApprovals(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user := GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, common.GetError("unauthorized"))
+		return
+	}
+	status := c.DefaultQuery("status", APPROVAL_PENDING)
+	if status != APPROVAL_PENDING && status != APPROVAL_APPROVED && status != APPROVAL_REJECTED {
+		c.JSON(http.StatusBadRequest, common.GetError("status must be pending, approved or rejected"))
+		return
+	}
+	list, err := a.listVideoApprovals(user.Id, status)
+	if err != nil {
+		glog.Errorf("%s listVideoApprovals: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not list videos"))
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// customDecideVideoApprovals handles POST /video-approvals with
+// {"video_ids": [...], "decision": "approve" | "reject"}. Every video must be
+// in one of the user's playlists. A decision can be changed later.
+func (a *Api) customDecideVideoApprovals(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user := GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, common.GetError("unauthorized"))
+		return
+	}
+	var body struct {
+		VideoIDs []uint32 `json:"video_ids"`
+		Decision string   `json:"decision"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	var status string
+	switch body.Decision {
+	case "approve":
+		status = APPROVAL_APPROVED
+	case "reject":
+		status = APPROVAL_REJECTED
+	default:
+		c.JSON(http.StatusBadRequest, common.GetError("decision must be approve or reject"))
+		return
+	}
+	if len(body.VideoIDs) == 0 || len(body.VideoIDs) > maxApprovalBatch {
+		c.JSON(http.StatusBadRequest, common.GetError(fmt.Sprintf("video_ids must hold 1 to %d ids", maxApprovalBatch)))
+		return
+	}
+	for _, id := range body.VideoIDs {
+		if ok, err := a.userCanSeeVideo(user.Id, id); err != nil {
+			glog.Errorf("%s userCanSeeVideo: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not record decision"))
+			return
+		} else if !ok {
+			c.JSON(http.StatusNotFound, common.GetError(fmt.Sprintf("Video %d is not in your playlists", id)))
+			return
+		}
+	}
+	values := strings.Repeat("(?, ?, ?),", len(body.VideoIDs))
+	args := make([]interface{}, 0, 3*len(body.VideoIDs))
+	for _, id := range body.VideoIDs {
+		args = append(args, user.Id, id, status)
+	}
+	_, err := a.DB.Exec(
+		"INSERT INTO user_video_approvals (user_id, video_id, status) VALUES "+values[:len(values)-1]+
+			" ON DUPLICATE KEY UPDATE status=VALUES(status)", args...)
+	if err != nil {
+		glog.Errorf("%s upsert user_video_approvals: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not record decision"))
+		return
+	}
+	if err := a.refreshUserHasVideo(user.Id); err != nil {
+		glog.Errorf("%s refreshUserHasVideo: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update video list"))
+		return
+	}
+	if status == APPROVAL_REJECTED {
+		if err := a.replaceCurrentVideoIfGone(logPrefix, c, user.Id); err != nil {
+			glog.Errorf("%s replaceCurrentVideoIfGone: %v", logPrefix, err)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"decided": len(body.VideoIDs), "status": status})
+}
+
+// customUpdateUserPlaylist handles POST /playlists/:playlist_id with
+// {"approval_policy": "auto" | "review"}. Switching to review approves the
+// videos the playlist already holds, so only later additions wait for the
+// parent; a decision already made is kept.
+func (a *Api) customUpdateUserPlaylist(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user := GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, common.GetError("unauthorized"))
+		return
+	}
+	var uri struct {
+		PlaylistID uint32 `uri:"playlist_id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid playlist_id"))
+		return
+	}
+	var body struct {
+		ApprovalPolicy string `json:"approval_policy"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || !validApprovalPolicy(body.ApprovalPolicy) {
+		c.JSON(http.StatusBadRequest, common.GetError("approval_policy must be auto or review"))
+		return
+	}
+	var current string
+	err := a.DB.QueryRow("SELECT approval_policy FROM user_playlist WHERE user_id=? AND playlist_id=?", user.Id, uri.PlaylistID).Scan(&current)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, common.GetError("Playlist not in your list"))
+		return
+	} else if err != nil {
+		glog.Errorf("%s get user_playlist: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update playlist"))
+		return
+	}
+	if current != body.ApprovalPolicy {
+		if body.ApprovalPolicy == APPROVAL_POLICY_REVIEW {
+			if _, err := a.DB.Exec(`
+				INSERT IGNORE INTO user_video_approvals (user_id, video_id, status)
+				SELECT ?, video_id, ? FROM playlist_video WHERE playlist_id = ?`,
+				user.Id, APPROVAL_APPROVED, uri.PlaylistID); err != nil {
+				glog.Errorf("%s approve existing videos: %v", logPrefix, err)
+				c.JSON(http.StatusInternalServerError, common.GetError("Could not update playlist"))
+				return
+			}
+		}
+		if _, err := a.DB.Exec("UPDATE user_playlist SET approval_policy=? WHERE user_id=? AND playlist_id=?",
+			body.ApprovalPolicy, user.Id, uri.PlaylistID); err != nil {
+			glog.Errorf("%s update user_playlist: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not update playlist"))
+			return
+		}
+		if err := a.refreshUserHasVideo(user.Id); err != nil {
+			glog.Errorf("%s refreshUserHasVideo: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not update video list"))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"id": uri.PlaylistID, "approval_policy": body.ApprovalPolicy})
+}
+
+// replaceCurrentVideoIfGone re-picks the user's reward video when it has left
+// their pool.
+func (a *Api) replaceCurrentVideoIfGone(logPrefix string, c *gin.Context, userID uint32) error {
+	gamestate, _, _, err := a.gamestateManager.Get(userID)
+	if err != nil {
+		return fmt.Errorf("get gamestate: %w", err)
+	}
+	var one int
+	err = a.DB.QueryRow("SELECT 1 FROM user_has_video WHERE user_id=? AND video_id=?", userID, gamestate.VideoId).Scan(&one)
+	if err != sql.ErrNoRows {
+		return err
+	}
+	return a.replaceCurrentVideo(logPrefix, c, userID, gamestate.VideoId)
+}
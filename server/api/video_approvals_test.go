@@ -0,0 +1,173 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/youtubefake"
+)
+
+func listApprovals(t *testing.T, r http.Handler, user *User, status string) []ApprovalVideo {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/video-approvals?status=%s&test_auth0_id=%s", status, user.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("list %s approvals: want 200, got %d %s", status, resp.Code, resp.Body.String())
+	}
+	var list []ApprovalVideo
+	if err := json.Unmarshal(resp.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode approvals: %v", err)
+	}
+	return list
+}
+
+func postApprovalJSON(t *testing.T, r http.Handler, user *User, path, body string) int {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1%s?test_auth0_id=%s", path, user.Auth0Id), bytes.NewBufferString(body))
+	r.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+func approvalTitles(list []ApprovalVideo) map[string]bool {
+	titles := map[string]bool{}
+	for _, v := range list {
+		titles[v.Title] = true
+	}
+	return titles
+}
+
+// TestVideoApprovals_ReviewPlaylist checks a playlist added for review keeps
+// its videos out of the pool until approved, and that a rejection sticks.
+func TestVideoApprovals_ReviewPlaylist(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	useFakeYouTube(t, api)
+	user := createTestUser(t, r, "auth0|approvals-review", "approvals@test.com", "approvalsuser")
+
+	code := postApprovalJSON(t, r, user, "/playlists",
+		fmt.Sprintf(`{"youtube_playlist_id": %q, "approval_policy": "review"}`, youtubefake.ChangingPlaylist))
+	if code != http.StatusOK {
+		t.Fatalf("add for review: want 200, got %d", code)
+	}
+	if titles := userVideoTitles(t, r, user.Auth0Id); len(titles) != 0 {
+		t.Errorf("review: want an empty pool before approval, got %v", titles)
+	}
+	pending := listApprovals(t, r, user, APPROVAL_PENDING)
+	if titles := approvalTitles(pending); len(pending) != 2 || !titles["Planets"] || !titles["Moon landing"] {
+		t.Fatalf("want both videos pending, got %+v", pending)
+	}
+	if len(pending[0].Playlists) != 1 || pending[0].Status != APPROVAL_PENDING {
+		t.Errorf("want the playlist and status on each entry, got %+v", pending[0])
+	}
+	ids := map[string]uint32{}
+	for _, v := range pending {
+		ids[v.Title] = v.VideoId
+	}
+
+	if code := postApprovalJSON(t, r, user, "/video-approvals",
+		fmt.Sprintf(`{"video_ids": [%d], "decision": "approve"}`, ids["Planets"])); code != http.StatusOK {
+		t.Fatalf("approve: want 200, got %d", code)
+	}
+	if code := postApprovalJSON(t, r, user, "/video-approvals",
+		fmt.Sprintf(`{"video_ids": [%d], "decision": "reject"}`, ids["Moon landing"])); code != http.StatusOK {
+		t.Fatalf("reject: want 200, got %d", code)
+	}
+	if titles := userVideoTitles(t, r, user.Auth0Id); len(titles) != 1 || !titles["Planets"] {
+		t.Errorf("want only the approved video in the pool, got %v", titles)
+	}
+	if list := listApprovals(t, r, user, APPROVAL_PENDING); len(list) != 0 {
+		t.Errorf("want nothing pending, got %+v", list)
+	}
+	if list := listApprovals(t, r, user, APPROVAL_REJECTED); len(list) != 1 || list[0].VideoId != ids["Moon landing"] {
+		t.Errorf("want the rejected video listed, got %+v", list)
+	}
+
+	var playlists []UserPlaylist
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/playlists?test_auth0_id="+user.Auth0Id, nil)
+	r.ServeHTTP(resp, req)
+	if err := json.Unmarshal(resp.Body.Bytes(), &playlists); err != nil || len(playlists) != 1 || playlists[0].ApprovalPolicy != APPROVAL_POLICY_REVIEW {
+		t.Errorf("want the playlist listed as review, got %+v (%v)", playlists, err)
+	}
+
+	if code := postApprovalJSON(t, r, user, "/video-approvals",
+		fmt.Sprintf(`{"video_ids": [%d], "decision": "maybe"}`, ids["Planets"])); code != http.StatusBadRequest {
+		t.Errorf("bad decision: want 400, got %d", code)
+	}
+	if code := postApprovalJSON(t, r, user, "/video-approvals", `{"video_ids": [], "decision": "approve"}`); code != http.StatusBadRequest {
+		t.Errorf("no ids: want 400, got %d", code)
+	}
+	res, err := api.DB.Exec("INSERT INTO videos (title, url, thumbnailurl, you_tube_id) VALUES ('Elsewhere', 'https://example.com/v', '', 'test_approvals_other')")
+	if err != nil {
+		t.Fatalf("insert video: %v", err)
+	}
+	other, _ := res.LastInsertId()
+	if code := postApprovalJSON(t, r, user, "/video-approvals",
+		fmt.Sprintf(`{"video_ids": [%d], "decision": "approve"}`, other)); code != http.StatusNotFound {
+		t.Errorf("someone else's video: want 404, got %d", code)
+	}
+	if code := postApprovalJSON(t, r, user, "/playlists/999999", `{"approval_policy": "auto"}`); code != http.StatusNotFound {
+		t.Errorf("unsubscribed playlist: want 404, got %d", code)
+	}
+	if code := postApprovalJSON(t, r, user, fmt.Sprintf("/playlists/%d", playlists[0].Id), `{"approval_policy": "later"}`); code != http.StatusBadRequest {
+		t.Errorf("bad policy: want 400, got %d", code)
+	}
+}
+
+// TestVideoApprovals_PolicySwitch checks switching to review keeps the
+// playlist's current videos, holds back what the channel adds later, and that
+// switching back to auto admits everything.
+func TestVideoApprovals_PolicySwitch(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	fake := useFakeYouTube(t, api)
+	user := createTestUser(t, r, "auth0|approvals-switch", "approvalswitch@test.com", "approvalswitchuser")
+	pid := subscribeToFakePlaylist(t, api, user.Id, youtubefake.ChangingPlaylist)
+	path := fmt.Sprintf("/playlists/%d", pid)
+
+	if code := postApprovalJSON(t, r, user, path, `{"approval_policy": "review"}`); code != http.StatusOK {
+		t.Fatalf("switch to review: want 200, got %d", code)
+	}
+	if titles := userVideoTitles(t, r, user.Auth0Id); len(titles) != 2 {
+		t.Errorf("switch to review: want the current videos kept, got %v", titles)
+	}
+	if list := listApprovals(t, r, user, APPROVAL_APPROVED); len(list) != 2 {
+		t.Errorf("want the current videos approved, got %+v", list)
+	}
+
+	fake.Advance(youtubefake.ChangingPlaylist)
+	if _, err := api.ResyncPlaylists(PlaylistResyncOptions{}); err != nil {
+		t.Fatalf("resync: %v", err)
+	}
+	if titles := userVideoTitles(t, r, user.Auth0Id); len(titles) != 1 || !titles["Moon landing"] {
+		t.Errorf("after resync: want the new video held back, got %v", titles)
+	}
+	if list := listApprovals(t, r, user, APPROVAL_PENDING); len(list) != 1 || list[0].Title != "Rocket launch" {
+		t.Errorf("after resync: want the new video pending, got %+v", list)
+	}
+
+	if code := postApprovalJSON(t, r, user, path, `{"approval_policy": "auto"}`); code != http.StatusOK {
+		t.Fatalf("switch to auto: want 200, got %d", code)
+	}
+	if titles := userVideoTitles(t, r, user.Auth0Id); len(titles) != 2 || !titles["Rocket launch"] {
+		t.Errorf("switch to auto: want every video admitted, got %v", titles)
+	}
+	if list := listApprovals(t, r, user, APPROVAL_PENDING); len(list) != 0 {
+		t.Errorf("auto playlists queue nothing, got %+v", list)
+	}
+}
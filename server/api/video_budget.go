@@ -0,0 +1,162 @@
+// video_budget.go: reward videos sized to the work that earned them.
+//
+// A cycle is the problems between two DONE_WATCHING_VIDEO events and the
+// video that closes it. Its reward budget is the WORKING_ON_PROBLEM time
+// logged since the last DONE_WATCHING_VIDEO, scaled by TargetWorkPercentage:
+// at 70% work, 7 minutes of math earns 3 minutes of video. When the last
+// problem of a cycle is solved the reward video is re-picked with each
+// candidate weighted by how well its duration fits the budget, and play data
+// carries the budget as the cutoff. The player stops there; a WATCHING_VIDEO
+// report past cutoff + videoCutoffGrace ends the video server-side.
+// Documented in docs/gameplay.md.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// The budget is clamped so a short or work-free cycle still earns a
+	// watchable reward, and a marathon doesn't earn an evening of video.
+	minRewardSeconds = 60
+	maxRewardSeconds = 60 * 60
+	// videoCutoffGrace is how far past the cutoff WATCHING_VIDEO reports may
+	// run before the server ends the video, covering reporting lag and a
+	// player that paused right at the cutoff.
+	videoCutoffGrace = 15
+	// unknownDurationWeight is the selection weight of a video whose
+	// duration the provider didn't report.
+	unknownDurationWeight = 0.5
+)
+
+// rewardBudgetSeconds converts a cycle's work into its video budget.
+func rewardBudgetSeconds(workMs float64, targetWorkPercentage uint8) uint32 {
+	p := float64(targetWorkPercentage)
+	if p <= 0 || p > 100 {
+		p = 100
+	}
+	budget := workMs / 1000 * (100 - p) / p
+	return uint32(math.Max(minRewardSeconds, math.Min(maxRewardSeconds, math.Round(budget))))
+}
+
+// videoFitWeight is a candidate's selection weight for a budget: a video that
+// fills the budget weighs 1, a shorter one less in proportion, and a longer
+// one, which will be cut off, falls off with the square of the overrun.
+func videoFitWeight(durationSeconds, budgetSeconds uint32) float64 {
+	if durationSeconds == 0 || budgetSeconds == 0 {
+		return unknownDurationWeight
+	}
+	ratio := float64(durationSeconds) / float64(budgetSeconds)
+	if ratio <= 1 {
+		return 0.25 + 0.75*ratio
+	}
+	return 1 / (ratio * ratio)
+}
+
+// videoCandidate is a selectable video: its duration (0 = unknown), its
+// preference weight, and its rotation state (video_rotation.go).
+type videoCandidate struct {
+	id              uint32
+	durationSeconds uint32
+	weight          float64
+	pinned          bool
+	// watchedAt is when it last finished playing; zero if never.
+	watchedAt time.Time
+}
+
+// pickVideoForBudget picks a candidate given a uniform random r in [0, 1),
+// weighted by its preference weight times, when budgetSeconds is set,
+// videoFitWeight.
+func pickVideoForBudget(candidates []videoCandidate, budgetSeconds uint32, r float64) uint32 {
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, v := range candidates {
+		weights[i] = v.weight
+		if budgetSeconds > 0 {
+			weights[i] *= videoFitWeight(v.durationSeconds, budgetSeconds)
+		}
+		total += weights[i]
+	}
+	r *= total
+	for i, w := range weights {
+		if r < w {
+			return candidates[i].id
+		}
+		r -= w
+	}
+	return candidates[len(candidates)-1].id
+}
+
+// rewardCycle is the state of the user's current cycle.
+type rewardCycle struct {
+	// budgetSeconds is the video time the cycle's work has earned.
+	budgetSeconds uint32
+	// watchedSeconds is the WATCHING_VIDEO time reported since.
+	watchedSeconds float64
+	// lastVideoId is the video that closed the previous cycle (0 = none), so
+	// the re-pick doesn't repeat it.
+	lastVideoId uint32
+}
+
+// overBudget reports whether the video has run past its cutoff and grace.
+func (r *rewardCycle) overBudget() bool {
+	return r.watchedSeconds > float64(r.budgetSeconds+videoCutoffGrace)
+}
+
+// withWatched counts a WATCHING_VIDEO report (ms) not yet in the events table.
+func (r *rewardCycle) withWatched(ms float64) *rewardCycle {
+	r.watchedSeconds += ms / 1000
+	return r
+}
+
+// currentRewardCycle sums the user's WORKING_ON_PROBLEM and WATCHING_VIDEO
+// time since their last DONE_WATCHING_VIDEO: the work that earned the current
+// reward, and how much of it has been watched.
+func (a *Api) currentRewardCycle(userId uint32, settings *Settings) (*rewardCycle, error) {
+	cycle := &rewardCycle{}
+	var lastDone uint64
+	var lastValue string
+	err := a.DB.QueryRow(
+		"SELECT id, value FROM events WHERE user_id=? AND event_type=? ORDER BY id DESC LIMIT 1",
+		userId, DONE_WATCHING_VIDEO).Scan(&lastDone, &lastValue)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("last done_watching_video: %w", err)
+	}
+	if v, err := strconv.ParseUint(lastValue, 10, 32); err == nil {
+		cycle.lastVideoId = uint32(v)
+	}
+	var workMs, watchMs float64
+	err = a.DB.QueryRow(`
+		SELECT
+		  COALESCE(SUM(CASE WHEN event_type=? THEN value ELSE 0 END), 0),
+		  COALESCE(SUM(CASE WHEN event_type=? THEN value ELSE 0 END), 0)
+		FROM events
+		WHERE user_id=? AND event_type IN (?, ?) AND id > ?`,
+		WORKING_ON_PROBLEM, WATCHING_VIDEO, userId, WORKING_ON_PROBLEM, WATCHING_VIDEO, lastDone).Scan(&workMs, &watchMs)
+	if err != nil {
+		return nil, fmt.Errorf("cycle usage: %w", err)
+	}
+	cycle.budgetSeconds = rewardBudgetSeconds(workMs, settings.TargetWorkPercentage)
+	cycle.watchedSeconds = watchMs / 1000
+	return cycle, nil
+}
+
+// rewardBudgetIfDue is the cycle's budget once its problems are solved, for
+// weighting a video pick; 0 (no fit weighting) before that or on error.
+func (a *Api) rewardBudgetIfDue(logPrefix string, gamestate *Gamestate, settings *Settings) uint32 {
+	if gamestate.Solved < gamestate.Target {
+		return 0
+	}
+	cycle, err := a.currentRewardCycle(gamestate.UserId, settings)
+	if err != nil {
+		glog.Errorf("%s currentRewardCycle: %v (not weighting by fit)", logPrefix, err)
+		return 0
+	}
+	return cycle.budgetSeconds
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestRewardBudgetSeconds(t *testing.T) {
+	cases := []struct {
+		workMs float64
+		pct    uint8
+		want   uint32
+	}{
+		{7 * 60000, 70, 180},      // 7 min of math at 70% earns 3 min
+		{10 * 60000, 50, 600},     // 50% is one-for-one
+		{0, 70, minRewardSeconds}, // a work-free cycle still earns the minimum
+		{600 * 60000, 50, maxRewardSeconds},
+		{5 * 60000, 100, minRewardSeconds},
+		{5 * 60000, 0, minRewardSeconds}, // out of range reads as 100
+	}
+	for _, c := range cases {
+		if got := rewardBudgetSeconds(c.workMs, c.pct); got != c.want {
+			t.Errorf("%vms at %d%%: want %d, got %d", c.workMs, c.pct, c.want, got)
+		}
+	}
+}
+
+func TestPickVideoForBudget(t *testing.T) {
+	if w := videoFitWeight(180, 180); w != 1 {
+		t.Errorf("exact fit: want 1, got %v", w)
+	}
+	if a, b := videoFitWeight(90, 180), videoFitWeight(360, 180); a <= b {
+		t.Errorf("half the budget (%v) should outweigh twice it (%v)", a, b)
+	}
+	if w := videoFitWeight(0, 180); w != unknownDurationWeight {
+		t.Errorf("unknown duration: want %v, got %v", unknownDurationWeight, w)
+	}
+
+	candidates := []videoCandidate{
+		{id: 1, durationSeconds: 3600, weight: 1},
+		{id: 2, durationSeconds: 170, weight: 1},
+		{id: 3, weight: 1},
+	}
+	// Sweep r across [0, 1) and count picks: the close fit dominates and the
+	// hour-long video is rarely chosen.
+	counts := map[uint32]int{}
+	const n = 1000
+	for i := 0; i < n; i++ {
+		counts[pickVideoForBudget(candidates, 180, float64(i)/n)]++
+	}
+	if counts[2] <= counts[3] || counts[3] <= counts[1] {
+		t.Errorf("want picks ordered fit > unknown > too long, got %v", counts)
+	}
+	if counts[1] == 0 {
+		t.Errorf("a long video should still be pickable, got %v", counts)
+	}
+
+	counts = map[uint32]int{}
+	for i := 0; i < 3; i++ {
+		counts[pickVideoForBudget(candidates, 0, float64(i)/3)]++
+	}
+	if len(counts) != 3 {
+		t.Errorf("budget 0, equal weights: want a uniform pick, got %v", counts)
+	}
+}
+
+// TestRewardVideo_Cutoff checks a finished cycle's play data carries the
+// earned cutoff, and that WATCHING_VIDEO reports past it end the video.
+func TestRewardVideo_Cutoff(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|video-budget", "budget@test.com", "budgetuser")
+	videoIDs := insertVideosAndUserHasVideo(t, api, user.Id, 2)
+	for i, d := range []uint32{170, 3600} {
+		if _, err := api.DB.Exec("UPDATE videos SET duration_seconds=? WHERE id=?", d, videoIDs[i]); err != nil {
+			t.Fatalf("set duration: %v", err)
+		}
+	}
+
+	getPlay := func() PlayData {
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/play/%d?test_auth0_id=%s", user.Id, user.Auth0Id), nil)
+		r.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("play: want 200, got %d %s", resp.Code, resp.Body.String())
+		}
+		var pd PlayData
+		if err := json.Unmarshal(resp.Body.Bytes(), &pd); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return pd
+	}
+
+	if pd := getPlay(); pd.VideoCutoffSeconds != 0 {
+		t.Errorf("mid-cycle: want no cutoff, got %d", pd.VideoCutoffSeconds)
+	}
+
+	// 7 minutes of work at the default 70% earns 180 seconds.
+	if _, err := api.DB.Exec("INSERT INTO events (user_id, event_type, value) VALUES (?, ?, ?)", user.Id, WORKING_ON_PROBLEM, "420000"); err != nil {
+		t.Fatalf("insert work: %v", err)
+	}
+	if _, err := api.DB.Exec("UPDATE gamestates SET solved=target WHERE user_id=?", user.Id); err != nil {
+		t.Fatalf("finish cycle: %v", err)
+	}
+	if pd := getPlay(); pd.VideoCutoffSeconds != 180 {
+		t.Fatalf("finished cycle: want a 180s cutoff, got %d", pd.VideoCutoffSeconds)
+	}
+
+	gs := reportEvent(t, r, user, WATCHING_VIDEO, "150000")
+	if gs.Solved < gs.Target {
+		t.Fatalf("150s of 180s: want the video still running, got %+v", gs)
+	}
+	gs = reportEvent(t, r, user, WATCHING_VIDEO, "50000")
+	if gs.Solved != 0 {
+		t.Errorf("200s of 180s: want the cycle reset, got %+v", gs)
+	}
+	var done int
+	if err := api.DB.QueryRow("SELECT COUNT(*) FROM events WHERE user_id=? AND event_type=?", user.Id, DONE_WATCHING_VIDEO).Scan(&done); err != nil || done != 1 {
+		t.Errorf("want one server-logged done_watching_video, got %d (%v)", done, err)
+	}
+	// The player stops at the same report and sends its own done; the cycle
+	// has already ended, so nothing is adjusted or re-picked again.
+	var difficulty float64
+	if err := api.DB.QueryRow("SELECT target_difficulty FROM settings WHERE user_id=?", user.Id).Scan(&difficulty); err != nil {
+		t.Fatalf("read settings: %v", err)
+	}
+	again := reportEvent(t, r, user, DONE_WATCHING_VIDEO, fmt.Sprint(videoIDs[0]))
+	if again.Solved != 0 || again.Target != gs.Target || again.VideoId != gs.VideoId || again.ProblemId != gs.ProblemId {
+		t.Errorf("player's done after the cutoff: want %+v unchanged, got %+v", gs, again)
+	}
+	var after float64
+	if err := api.DB.QueryRow("SELECT target_difficulty FROM settings WHERE user_id=?", user.Id).Scan(&after); err != nil || after != difficulty {
+		t.Errorf("player's done after the cutoff: want difficulty %v, got %v (%v)", difficulty, after, err)
+	}
+	if pd := getPlay(); pd.VideoCutoffSeconds != 0 {
+		t.Errorf("new cycle: want no cutoff, got %d", pd.VideoCutoffSeconds)
+	}
+}
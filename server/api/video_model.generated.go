@@ -16,7 +16,9 @@ const (
 	url VARCHAR(256) NOT NULL,
 	thumbnailurl VARCHAR(256) NOT NULL,
 	you_tube_id VARCHAR(32) NULL UNIQUE,
-	disabled TINYINT NOT NULL DEFAULT 0
+	disabled TINYINT NOT NULL DEFAULT 0,
+	provider VARCHAR(16) NOT NULL DEFAULT 'youtube',
+	duration_seconds INT UNSIGNED NOT NULL DEFAULT 0
     ) DEFAULT CHARSET=utf8mb4 ;`
 
 	createVideoSQL = `INSERT INTO videos (title, url, thumbnailurl, you_tube_id) VALUES (?, ?, ?, ?);`
@@ -27,22 +29,24 @@ const (
 
 	listVideoSQL = `SELECT * FROM videos;`
 
-	updateVideoSQL = `UPDATE videos SET title=?, url=?, thumbnailurl=?, you_tube_id=?, disabled=? WHERE id=?;`
+	updateVideoSQL = `UPDATE videos SET title=?, url=?, thumbnailurl=?, you_tube_id=?, disabled=?, provider=?, duration_seconds=? WHERE id=?;`
 
 	deleteVideoSQL = `DELETE FROM videos WHERE id=?;`
 )
 
 type Video struct {
-	Id           uint32 `json:"id" uri:"id"`
-	Title        string `json:"title" uri:"title" form:"title"`
-	URL          string `json:"url" uri:"url" form:"url"`
-	ThumbnailURL string `json:"thumbnailurl" uri:"thumbnailurl" form:"thumbnailurl"`
-	YouTubeId    string `json:"you_tube_id" uri:"you_tube_id" form:"you_tube_id"`
-	Disabled     bool   `json:"disabled" uri:"disabled" form:"disabled"`
+	Id              uint32 `json:"id" uri:"id"`
+	Title           string `json:"title" uri:"title" form:"title"`
+	URL             string `json:"url" uri:"url" form:"url"`
+	ThumbnailURL    string `json:"thumbnailurl" uri:"thumbnailurl" form:"thumbnailurl"`
+	YouTubeId       string `json:"you_tube_id" uri:"you_tube_id" form:"you_tube_id"`
+	Disabled        bool   `json:"disabled" uri:"disabled" form:"disabled"`
+	Provider        string `json:"provider" uri:"provider" form:"provider"`
+	DurationSeconds uint32 `json:"duration_seconds" uri:"duration_seconds" form:"duration_seconds"`
 }
 
 func (model Video) String() string {
-	return fmt.Sprintf("Id: %v, Title: %v, URL: %v, ThumbnailURL: %v, YouTubeId: %v, Disabled: %v", model.Id, model.Title, model.URL, model.ThumbnailURL, model.YouTubeId, model.Disabled)
+	return fmt.Sprintf("Id: %v, Title: %v, URL: %v, ThumbnailURL: %v, YouTubeId: %v, Disabled: %v, Provider: %v, DurationSeconds: %v", model.Id, model.Title, model.URL, model.ThumbnailURL, model.YouTubeId, model.Disabled, model.Provider, model.DurationSeconds)
 }
 
 type VideoManager struct {
@@ -80,7 +84,7 @@ func (m *VideoManager) Create(model *Video) (int, string, error) {
 
 func (m *VideoManager) Get(id uint32) (*Video, int, string, error) {
 	model := &Video{}
-	err := m.DB.QueryRow(getVideoSQL, id).Scan(&model.Id, &model.Title, &model.URL, &model.ThumbnailURL, &model.YouTubeId, &model.Disabled)
+	err := m.DB.QueryRow(getVideoSQL, id).Scan(&model.Id, &model.Title, &model.URL, &model.ThumbnailURL, &model.YouTubeId, &model.Disabled, &model.Provider, &model.DurationSeconds)
 	if err == sql.ErrNoRows {
 		msg := "Couldn't find a video with that id"
 		return nil, http.StatusNotFound, msg, err
@@ -102,7 +106,7 @@ func (m *VideoManager) List() (*[]Video, int, string, error) {
 	}
 	for rows.Next() {
 		model := Video{}
-		err = rows.Scan(&model.Id, &model.Title, &model.URL, &model.ThumbnailURL, &model.YouTubeId, &model.Disabled)
+		err = rows.Scan(&model.Id, &model.Title, &model.URL, &model.ThumbnailURL, &model.YouTubeId, &model.Disabled, &model.Provider, &model.DurationSeconds)
 		if err != nil {
 			msg := "Couldn't scan row from database"
 			return nil, http.StatusInternalServerError, msg, err
@@ -129,7 +133,7 @@ func (m *VideoManager) CustomList(sql string) (*[]Video, int, string, error) {
 	}
 	for rows.Next() {
 		model := Video{}
-		err = rows.Scan(&model.Id, &model.Title, &model.URL, &model.ThumbnailURL, &model.YouTubeId, &model.Disabled)
+		err = rows.Scan(&model.Id, &model.Title, &model.URL, &model.ThumbnailURL, &model.YouTubeId, &model.Disabled, &model.Provider, &model.DurationSeconds)
 		if err != nil {
 			msg := "Couldn't scan row from database"
 			return nil, http.StatusInternalServerError, msg, err
@@ -187,7 +191,7 @@ func (m *VideoManager) Update(model *Video) (int, string, error) {
 		return status, msg, err
 	}
 	// Update
-	_, err = m.DB.Exec(updateVideoSQL, model.Title, model.URL, model.ThumbnailURL, model.YouTubeId, model.Disabled, model.Id)
+	_, err = m.DB.Exec(updateVideoSQL, model.Title, model.URL, model.ThumbnailURL, model.YouTubeId, model.Disabled, model.Provider, model.DurationSeconds, model.Id)
 	if err != nil {
 		msg := "Couldn't update video in database"
 		return http.StatusInternalServerError, msg, err
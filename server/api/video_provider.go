@@ -0,0 +1,240 @@
+// video_provider.go: the sources reward videos come from.
+//
+// A VideoProvider answers three questions about its own catalog: what a
+// playlist is called, which videos it holds, and whether a video can still be
+// played. YouTube (youtube.go) is one provider; the self-hosted local media
+// library (local_media.go) is another. playlists and videos rows record their
+// provider, and you_tube_id holds the provider's external ID (a YouTube ID for
+// YouTube, a namespaced "local:..." ID for the library). syncPlaylist is the
+// provider-neutral half: it reconciles whatever a provider returns into
+// playlists, videos and playlist_video. Documented in docs/videos.md.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+// Provider names, as stored in the provider column of playlists and videos.
+const (
+	PROVIDER_YOUTUBE = "youtube"
+	PROVIDER_LOCAL   = "local"
+)
+
+// ProviderPlaylist is a provider's playlist metadata.
+type ProviderPlaylist struct {
+	Title        string
+	ThumbnailURL string
+	// Etag changes whenever the playlist's contents do.
+	Etag string
+}
+
+// ProviderVideo is one video in a provider's playlist.
+type ProviderVideo struct {
+	ExternalId   string
+	Title        string
+	URL          string
+	ThumbnailURL string
+	// DurationSeconds is 0 when the provider doesn't know it.
+	DurationSeconds uint32
+}
+
+// Playability is a provider's verdict on whether a video can be played.
+type Playability string
+
+const (
+	VideoPlayable         Playability = "playable"
+	VideoNotPlayable      Playability = "not playable"
+	VideoPlayabilityError Playability = "error"
+)
+
+// VideoProvider is a source of reward videos.
+type VideoProvider interface {
+	// Name is the provider column value for this provider's rows.
+	Name() string
+	// PlaylistMetadata returns the playlist's title, thumbnail and etag.
+	PlaylistMetadata(playlistID string) (*ProviderPlaylist, error)
+	// PlaylistItems returns every video in the playlist.
+	PlaylistItems(playlistID string) ([]ProviderVideo, error)
+	// CheckPlayable reports a verdict for each external video ID. An ID
+	// missing from the result could not be checked.
+	CheckPlayable(videoIDs []string) map[string]Playability
+}
+
+// NewVideoProviders builds the providers a config enables: YouTube always,
+// the local media library when local_media_dir is set.
+func NewVideoProviders(cfg *common.Config) (map[string]VideoProvider, error) {
+	providers := map[string]VideoProvider{}
+	yt := &YouTubeProvider{}
+	if cfg != nil {
+		yt = NewYouTubeProvider(cfg.YouTubeAPIKey, cfg.YouTubeAPIBaseURL, nil)
+	}
+	providers[PROVIDER_YOUTUBE] = yt
+	if cfg != nil && strings.TrimSpace(cfg.LocalMediaDir) != "" {
+		if strings.TrimSpace(cfg.LocalMediaURL) == "" {
+			return nil, fmt.Errorf("local_media_url is required when local_media_dir is set")
+		}
+		providers[PROVIDER_LOCAL] = NewLocalMediaProvider(cfg.LocalMediaDir, cfg.LocalMediaURL, cfg.LocalMediaSigningKey)
+	}
+	return providers, nil
+}
+
+// videoProvider returns the named provider, or an error when it isn't
+// configured on this host.
+func (a *Api) videoProvider(name string) (VideoProvider, error) {
+	p, ok := a.videoProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("video provider %q is not configured", name)
+	}
+	return p, nil
+}
+
+// syncPlaylist fetches a provider's playlist and reconciles it into playlists,
+// videos and playlist_video, returning the internal playlists.id. Video rows
+// are reused by external ID and never deleted.
+func (a *Api) syncPlaylist(provider VideoProvider, playlistID string) (uint32, error) {
+	meta, err := provider.PlaylistMetadata(playlistID)
+	if err != nil {
+		return 0, fmt.Errorf("fetch playlist metadata: %w", err)
+	}
+	var playlistDbID uint32
+	err = a.DB.QueryRow("SELECT id FROM playlists WHERE you_tube_id=?", playlistID).Scan(&playlistDbID)
+	if err == sql.ErrNoRows {
+		pl := &Playlist{
+			YouTubeId:    playlistID,
+			Title:        meta.Title,
+			ThumbnailURL: meta.ThumbnailURL,
+			Etag:         meta.Etag,
+		}
+		status, msg, createErr := a.playlistManager.Create(pl)
+		if createErr != nil {
+			return 0, fmt.Errorf("create playlist: %d %s: %w", status, msg, createErr)
+		}
+		playlistDbID = pl.Id
+		// Create omits DEFAULT columns, so the row starts as youtube.
+		if _, err := a.DB.Exec("UPDATE playlists SET provider=? WHERE id=?", provider.Name(), playlistDbID); err != nil {
+			return 0, fmt.Errorf("set playlist provider: %w", err)
+		}
+	} else if err != nil {
+		return 0, fmt.Errorf("query playlist: %w", err)
+	} else if err := a.updatePlaylistMetadata(playlistDbID, playlistID, provider, meta); err != nil {
+		return 0, err
+	}
+	items, err := provider.PlaylistItems(playlistID)
+	if err != nil {
+		return 0, fmt.Errorf("fetch playlist items: %w", err)
+	}
+	if _, _, err := a.reconcilePlaylistVideos(playlistDbID, provider, items); err != nil {
+		return 0, err
+	}
+	return playlistDbID, nil
+}
+
+// updatePlaylistMetadata writes a provider's title, thumbnail and etag to an
+// existing playlists row.
+func (a *Api) updatePlaylistMetadata(playlistDbID uint32, playlistID string, provider VideoProvider, meta *ProviderPlaylist) error {
+	pl := &Playlist{
+		Id:           playlistDbID,
+		YouTubeId:    playlistID,
+		Title:        meta.Title,
+		ThumbnailURL: meta.ThumbnailURL,
+		Etag:         meta.Etag,
+		Provider:     provider.Name(),
+	}
+	status, msg, err := a.playlistManager.Update(pl)
+	if err != nil {
+		return fmt.Errorf("update playlist: %d %s: %w", status, msg, err)
+	}
+	return nil
+}
+
+// reconcilePlaylistVideos makes playlist_video hold exactly the given items,
+// creating video rows for new external IDs, and returns the videos.id values
+// it added and removed. A video that can't be stored is logged and left out.
+func (a *Api) reconcilePlaylistVideos(playlistDbID uint32, provider VideoProvider, items []ProviderVideo) (added, removed []uint32, err error) {
+	current := map[uint32]bool{}
+	rows, err := a.DB.Query("SELECT video_id FROM playlist_video WHERE playlist_id=?", playlistDbID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query playlist_video: %w", err)
+	}
+	for rows.Next() {
+		var id uint32
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("scan playlist_video: %w", err)
+		}
+		current[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("query playlist_video: %w", err)
+	}
+
+	keep := map[uint32]bool{}
+	for _, item := range items {
+		videoID, err := a.upsertProviderVideo(provider, item)
+		if err != nil {
+			glog.Errorf("%v", err)
+			continue
+		}
+		if keep[videoID] {
+			continue
+		}
+		keep[videoID] = true
+		if current[videoID] {
+			continue
+		}
+		if _, err := a.DB.Exec("INSERT IGNORE INTO playlist_video (playlist_id, video_id) VALUES (?, ?)", playlistDbID, videoID); err != nil {
+			glog.Errorf("insert playlist_video %d %d: %v", playlistDbID, videoID, err)
+			continue
+		}
+		added = append(added, videoID)
+	}
+	for videoID := range current {
+		if keep[videoID] {
+			continue
+		}
+		if _, err := a.DB.Exec("DELETE FROM playlist_video WHERE playlist_id=? AND video_id=?", playlistDbID, videoID); err != nil {
+			return added, removed, fmt.Errorf("delete playlist_video %d %d: %w", playlistDbID, videoID, err)
+		}
+		removed = append(removed, videoID)
+	}
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return added, removed, nil
+}
+
+// upsertProviderVideo returns the videos.id for a provider video, inserting
+// the row the first time its external ID is seen. A known video takes the
+// provider's duration when it reports one.
+func (a *Api) upsertProviderVideo(provider VideoProvider, item ProviderVideo) (uint32, error) {
+	var videoID uint32
+	var duration uint32
+	err := a.DB.QueryRow("SELECT id, duration_seconds FROM videos WHERE you_tube_id=?", item.ExternalId).Scan(&videoID, &duration)
+	if err == nil {
+		if item.DurationSeconds > 0 && item.DurationSeconds != duration {
+			if _, err := a.DB.Exec("UPDATE videos SET duration_seconds=? WHERE id=?", item.DurationSeconds, videoID); err != nil {
+				glog.Errorf("update video duration %s: %v", item.ExternalId, err)
+			}
+		}
+		return videoID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("query video %s: %w", item.ExternalId, err)
+	}
+	result, err := a.DB.Exec("INSERT INTO videos (title, url, thumbnailurl, you_tube_id, provider, duration_seconds) VALUES (?, ?, ?, ?, ?, ?)",
+		item.Title, item.URL, item.ThumbnailURL, item.ExternalId, provider.Name(), item.DurationSeconds)
+	if err != nil {
+		return 0, fmt.Errorf("insert video %s: %w", item.ExternalId, err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("get video insert id %s: %w", item.ExternalId, err)
+	}
+	return uint32(id), nil
+}
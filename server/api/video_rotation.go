@@ -0,0 +1,258 @@
+// video_rotation.go: which reward videos come up next, and how often.
+//
+// selectVideo draws from the user's pool (user_has_video) in two steps. The
+// rotation step sorts candidates least-recently-watched first, by
+// recently_watched_videos, and keeps the first videoLruTopFrac of them, so a
+// video that just played waits for others before it can return, whatever its
+// weight; a pinned video skips the cut. The weighting step
+// (pickVideoForBudget) then weighs the survivors by duration fit and by the
+// user's preferences: the child's rating and favourite, the parent's pin. A
+// blocked video never reaches selectVideo: refreshUserHasVideo keeps it out of
+// the pool. Documented in docs/gameplay.md.
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+const (
+	// videoLruTopFrac is the fraction of the recency-sorted pool a pick is
+	// drawn from, as lruTopFrac is for problems. Video pools are small, so
+	// it is wider.
+	videoLruTopFrac = 0.5
+	// Preference weights multiply a candidate's duration-fit weight.
+	pinnedVideoWeight    = 3.0
+	favouriteVideoWeight = 2.0
+	likedVideoWeight     = 1.5
+	dislikedVideoWeight  = 0.25
+)
+
+// VideoPreference is a user's settings for one video. Rating and Favourite are
+// the child's, Pinned and Blocked the parent's; the settings page is
+// PIN-gated, as for every other parent control.
+type VideoPreference struct {
+	// Rating is -1 (less of this), 0 (no rating) or 1 (more of this).
+	Rating    int8 `json:"rating"`
+	Favourite bool `json:"favourite"`
+	Pinned    bool `json:"pinned"`
+	Blocked   bool `json:"blocked"`
+}
+
+// UserVideo is one row of GET /videos: a video in the user's pool, or one
+// they have blocked, with their preferences and when it last played for them.
+type UserVideo struct {
+	Video
+	VideoPreference
+	LastWatchedAt *time.Time `json:"last_watched_at"`
+}
+
+// videoPreferenceWeight is a candidate's selection weight from its
+// preferences, 1 for none.
+func videoPreferenceWeight(p VideoPreference) float64 {
+	w := 1.0
+	if p.Pinned {
+		w *= pinnedVideoWeight
+	}
+	if p.Favourite {
+		w *= favouriteVideoWeight
+	}
+	switch {
+	case p.Rating > 0:
+		w *= likedVideoWeight
+	case p.Rating < 0:
+		w *= dislikedVideoWeight
+	}
+	return w
+}
+
+// rotateVideoCandidates keeps the least-recently-watched videoLruTopFrac of
+// the candidates (at least one), never-watched first, plus every pinned one.
+func rotateVideoCandidates(candidates []videoCandidate) []videoCandidate {
+	ids := make([]uint32, len(candidates))
+	byID := make(map[uint32]videoCandidate, len(candidates))
+	lastWatched := map[uint32]time.Time{}
+	for i, v := range candidates {
+		ids[i] = v.id
+		byID[v.id] = v
+		if !v.watchedAt.IsZero() {
+			lastWatched[v.id] = v.watchedAt
+		}
+	}
+	sort.SliceStable(ids, recencyLess(ids, lastWatched))
+	topN := int(float64(len(ids)) * videoLruTopFrac)
+	if topN < 1 {
+		topN = 1
+	}
+	out := make([]videoCandidate, 0, len(ids))
+	for i, id := range ids {
+		if v := byID[id]; i < topN || v.pinned {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// recordRecentlyWatched upserts the video's watched_at, like
+// recordRecentlyShown for problems. Failures are logged, never propagated: a
+// stale row only makes the video come up a little early.
+func (a *Api) recordRecentlyWatched(logPrefix string, userID, videoID uint32) {
+	if videoID == 0 || videoID == nullVideoId {
+		return
+	}
+	_, err := a.DB.Exec(
+		`INSERT INTO recently_watched_videos (user_id, video_id, watched_at)
+		 VALUES (?, ?, NOW())
+		 ON DUPLICATE KEY UPDATE watched_at = NOW()`,
+		userID, videoID)
+	if err != nil {
+		glog.Warningf("%s recordRecentlyWatched user=%d video=%d: %v", logPrefix, userID, videoID, err)
+	}
+}
+
+// videoPreference returns the user's preference row for a video, the zero
+// value when there is none.
+func (a *Api) videoPreference(userID, videoID uint32) (VideoPreference, error) {
+	var p VideoPreference
+	err := a.DB.QueryRow(
+		"SELECT rating, favourite, pinned, blocked FROM user_video_preferences WHERE user_id=? AND video_id=?",
+		userID, videoID).Scan(&p.Rating, &p.Favourite, &p.Pinned, &p.Blocked)
+	if err == sql.ErrNoRows {
+		return p, nil
+	}
+	return p, err
+}
+
+// userCanSeeVideo reports whether a video is in the user's pool or one of
+// their playlists, so a blocked video can still be unblocked.
+func (a *Api) userCanSeeVideo(userID, videoID uint32) (bool, error) {
+	var one int
+	err := a.DB.QueryRow(`
+		SELECT 1 FROM user_has_video WHERE user_id=? AND video_id=?
+		UNION
+		SELECT 1 FROM user_playlist up
+		INNER JOIN playlist_video pv ON pv.playlist_id = up.playlist_id
+		WHERE up.user_id=? AND pv.video_id=?
+		LIMIT 1`, userID, videoID, userID, videoID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// customUpdateVideoPreference handles POST /videos/:id/preferences. Fields
+// left out of the body keep their stored value. Blocking or unblocking
+// rebuilds the pool, and a blocked current reward video is replaced.
+func (a *Api) customUpdateVideoPreference(c *gin.Context) {
+	logPrefix := common.GetLogPrefix(c)
+	user := GetUserFromContext(c)
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, common.GetError("unauthorized"))
+		return
+	}
+	var uri struct {
+		VideoID uint32 `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid video id"))
+		return
+	}
+	var body struct {
+		Rating    *int8 `json:"rating"`
+		Favourite *bool `json:"favourite"`
+		Pinned    *bool `json:"pinned"`
+		Blocked   *bool `json:"blocked"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetError("Invalid request body"))
+		return
+	}
+	if ok, err := a.userCanSeeVideo(user.Id, uri.VideoID); err != nil {
+		glog.Errorf("%s userCanSeeVideo: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+		return
+	} else if !ok {
+		c.JSON(http.StatusNotFound, common.GetError("Video not in your list"))
+		return
+	}
+	pref, err := a.videoPreference(user.Id, uri.VideoID)
+	if err != nil {
+		glog.Errorf("%s videoPreference: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+		return
+	}
+	wasBlocked := pref.Blocked
+	if body.Rating != nil {
+		pref.Rating = *body.Rating
+	}
+	if body.Favourite != nil {
+		pref.Favourite = *body.Favourite
+	}
+	if body.Pinned != nil {
+		pref.Pinned = *body.Pinned
+	}
+	if body.Blocked != nil {
+		pref.Blocked = *body.Blocked
+	}
+	if pref.Rating < -1 || pref.Rating > 1 {
+		c.JSON(http.StatusBadRequest, common.GetError("rating must be -1, 0 or 1"))
+		return
+	}
+	if pref.Pinned && pref.Blocked {
+		c.JSON(http.StatusBadRequest, common.GetError("A video can't be both pinned and blocked"))
+		return
+	}
+	_, err = a.DB.Exec(`
+		INSERT INTO user_video_preferences (user_id, video_id, rating, favourite, pinned, blocked)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE rating=VALUES(rating), favourite=VALUES(favourite), pinned=VALUES(pinned), blocked=VALUES(blocked)`,
+		user.Id, uri.VideoID, pref.Rating, pref.Favourite, pref.Pinned, pref.Blocked)
+	if err != nil {
+		glog.Errorf("%s upsert user_video_preferences: %v", logPrefix, err)
+		c.JSON(http.StatusInternalServerError, common.GetError("Could not update video"))
+		return
+	}
+	if pref.Blocked != wasBlocked {
+		if err := a.refreshUserHasVideo(user.Id); err != nil {
+			glog.Errorf("%s refreshUserHasVideo: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not update video list"))
+			return
+		}
+	}
+	if pref.Blocked {
+		if err := a.replaceCurrentVideo(logPrefix, c, user.Id, uri.VideoID); err != nil {
+			glog.Errorf("%s replaceCurrentVideo: %v", logPrefix, err)
+			c.JSON(http.StatusInternalServerError, common.GetError("Could not replace the current video"))
+			return
+		}
+	}
+	c.JSON(http.StatusOK, pref)
+}
+
+// replaceCurrentVideo re-picks the user's reward video when it is videoID.
+func (a *Api) replaceCurrentVideo(logPrefix string, c *gin.Context, userID, videoID uint32) error {
+	gamestate, _, _, err := a.gamestateManager.Get(userID)
+	if err != nil {
+		return fmt.Errorf("get gamestate: %w", err)
+	}
+	if gamestate.VideoId != videoID {
+		return nil
+	}
+	newID, err := a.selectVideo(logPrefix, c, userID, map[uint32]bool{videoID: true}, 0)
+	if err != nil {
+		return err
+	}
+	gamestate.VideoId = newID
+	if _, _, err := a.gamestateManager.Update(gamestate); err != nil {
+		return fmt.Errorf("update gamestate: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"garydmenezes.com/mathgame/server/common"
+)
+
+func TestVideoPreferenceWeight(t *testing.T) {
+	cases := []struct {
+		pref VideoPreference
+		want float64
+	}{
+		{VideoPreference{}, 1},
+		{VideoPreference{Rating: 1}, likedVideoWeight},
+		{VideoPreference{Rating: -1}, dislikedVideoWeight},
+		{VideoPreference{Favourite: true}, favouriteVideoWeight},
+		{VideoPreference{Pinned: true, Favourite: true}, pinnedVideoWeight * favouriteVideoWeight},
+	}
+	for _, c := range cases {
+		if got := videoPreferenceWeight(c.pref); got != c.want {
+			t.Errorf("%+v: want %v, got %v", c.pref, c.want, got)
+		}
+	}
+}
+
+func TestRotateVideoCandidates(t *testing.T) {
+	now := time.Now()
+	candidates := []videoCandidate{
+		{id: 1, watchedAt: now.Add(-time.Minute)},
+		{id: 2, watchedAt: now.Add(-time.Hour)},
+		{id: 3},
+		{id: 4, watchedAt: now.Add(-2 * time.Minute), pinned: true},
+		{id: 5, watchedAt: now.Add(-24 * time.Hour)},
+	}
+	var got []uint32
+	for _, v := range rotateVideoCandidates(candidates) {
+		got = append(got, v.id)
+	}
+	// Never-watched 3 and the oldest, 5, make the top half; pinned 4 skips
+	// the cut; 2 and 1 wait.
+	if fmt.Sprint(got) != "[3 5 4]" {
+		t.Errorf("want [3 5 4], got %v", got)
+	}
+	if got := rotateVideoCandidates(candidates[:1]); len(got) != 1 {
+		t.Errorf("one candidate: want it kept, got %v", got)
+	}
+}
+
+func postVideoPreference(t *testing.T, r http.Handler, user *User, videoID uint32, body string) (int, VideoPreference) {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/videos/%d/preferences?test_auth0_id=%s", videoID, user.Auth0Id), bytes.NewBufferString(body))
+	r.ServeHTTP(resp, req)
+	var pref VideoPreference
+	if resp.Code == http.StatusOK {
+		if err := json.Unmarshal(resp.Body.Bytes(), &pref); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+	}
+	return resp.Code, pref
+}
+
+func userVideos(t *testing.T, r http.Handler, user *User) map[uint32]UserVideo {
+	t.Helper()
+	resp := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/videos?test_auth0_id=%s", user.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	var list []UserVideo
+	if err := json.Unmarshal(resp.Body.Bytes(), &list); err != nil {
+		t.Fatalf("decode videos: %v (%s)", err, resp.Body.String())
+	}
+	out := map[uint32]UserVideo{}
+	for _, v := range list {
+		out[v.Id] = v
+	}
+	return out
+}
+
+// TestVideoPreferences_BlockAndRate checks a blocked video leaves the pool but
+// stays listed, survives a pool rebuild, and can be unblocked.
+func TestVideoPreferences_BlockAndRate(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|video-prefs", "prefs@test.com", "prefsuser")
+	ids := insertVideosAndUserHasVideo(t, api, user.Id, 3)
+	pid := insertPlaylistWithVideos(t, api, "PLvideoPrefs", ids)
+	if _, err := api.DB.Exec("INSERT INTO user_playlist (user_id, playlist_id) VALUES (?, ?)", user.Id, pid); err != nil {
+		t.Fatalf("insert user_playlist: %v", err)
+	}
+
+	if code, pref := postVideoPreference(t, r, user, ids[0], `{"blocked": true}`); code != http.StatusOK || !pref.Blocked {
+		t.Fatalf("block: want 200 and blocked, got %d %+v", code, pref)
+	}
+	if n, _ := api.countEnabledVideosForUser(user.Id); n != 2 {
+		t.Errorf("blocked: want 2 videos in the pool, got %d", n)
+	}
+	if err := api.refreshUserHasVideo(user.Id); err != nil {
+		t.Fatalf("refreshUserHasVideo: %v", err)
+	}
+	videos := userVideos(t, r, user)
+	if len(videos) != 3 || !videos[ids[0]].Blocked {
+		t.Errorf("list: want all 3 with the blocked one flagged, got %+v", videos)
+	}
+	for i := 0; i < 10; i++ {
+		if got, _ := api.selectVideo("test", nil, user.Id, map[uint32]bool{}, 0); got == ids[0] {
+			t.Fatalf("selectVideo picked the blocked video")
+		}
+	}
+
+	if code, _ := postVideoPreference(t, r, user, ids[0], `{"pinned": true}`); code != http.StatusBadRequest {
+		t.Errorf("pin while blocked: want 400, got %d", code)
+	}
+	if code, _ := postVideoPreference(t, r, user, ids[1], `{"rating": 2}`); code != http.StatusBadRequest {
+		t.Errorf("rating 2: want 400, got %d", code)
+	}
+	res, err := api.DB.Exec("INSERT INTO videos (title, url, thumbnailurl, you_tube_id) VALUES ('Elsewhere', 'https://example.com/v', '', 'test_prefs_other')")
+	if err != nil {
+		t.Fatalf("insert video: %v", err)
+	}
+	other, _ := res.LastInsertId()
+	if code, _ := postVideoPreference(t, r, user, uint32(other), `{"favourite": true}`); code != http.StatusNotFound {
+		t.Errorf("someone else's video: want 404, got %d", code)
+	}
+
+	code, pref := postVideoPreference(t, r, user, ids[1], `{"rating": 1, "favourite": true}`)
+	if code != http.StatusOK || pref.Rating != 1 || !pref.Favourite {
+		t.Errorf("rate: want rating 1 and favourite, got %d %+v", code, pref)
+	}
+	if code, pref := postVideoPreference(t, r, user, ids[1], `{"rating": 0}`); code != http.StatusOK || !pref.Favourite {
+		t.Errorf("partial update: want favourite kept, got %d %+v", code, pref)
+	}
+
+	if code, _ := postVideoPreference(t, r, user, ids[0], `{"blocked": false}`); code != http.StatusOK {
+		t.Fatalf("unblock: want 200, got %d", code)
+	}
+	if n, _ := api.countEnabledVideosForUser(user.Id); n != 3 {
+		t.Errorf("unblocked: want 3 videos in the pool, got %d", n)
+	}
+}
+
+// TestSelectVideo_Rotation checks recently watched videos wait their turn,
+// that a pinned one skips the wait, and that finishing a video records it.
+func TestSelectVideo_Rotation(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	api, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|video-rotation", "rotation@test.com", "rotationuser")
+	ids := insertVideosAndUserHasVideo(t, api, user.Id, 4)
+	if _, err := api.DB.Exec(
+		"INSERT INTO recently_watched_videos (user_id, video_id, watched_at) VALUES (?, ?, NOW()), (?, ?, NOW() - INTERVAL 1 HOUR)",
+		user.Id, ids[0], user.Id, ids[1]); err != nil {
+		t.Fatalf("insert watch history: %v", err)
+	}
+
+	pick := func(n int) map[uint32]int {
+		counts := map[uint32]int{}
+		for i := 0; i < n; i++ {
+			id, err := api.selectVideo("test", nil, user.Id, map[uint32]bool{}, 0)
+			if err != nil {
+				t.Fatalf("selectVideo: %v", err)
+			}
+			counts[id]++
+		}
+		return counts
+	}
+	if counts := pick(30); counts[ids[0]]+counts[ids[1]] > 0 {
+		t.Errorf("want only the never-watched half picked, got %v", counts)
+	}
+
+	if code, _ := postVideoPreference(t, r, user, ids[0], `{"pinned": true}`); code != http.StatusOK {
+		t.Fatalf("pin: want 200, got %d", code)
+	}
+	if counts := pick(50); counts[ids[0]] == 0 || counts[ids[1]] > 0 {
+		t.Errorf("pinned: want the pinned video back in rotation, the other still waiting, got %v", counts)
+	}
+
+	var gs Gamestate
+	fetchGamestate(t, r, user, &gs)
+	if _, err := api.DB.Exec("UPDATE gamestates SET solved=target WHERE user_id=?", user.Id); err != nil {
+		t.Fatalf("finish cycle: %v", err)
+	}
+	reportEvent(t, r, user, DONE_WATCHING_VIDEO, fmt.Sprint(gs.VideoId))
+	var watched int
+	if err := api.DB.QueryRow(
+		"SELECT COUNT(*) FROM recently_watched_videos WHERE user_id=? AND video_id=? AND watched_at > NOW() - INTERVAL 1 MINUTE",
+		user.Id, gs.VideoId).Scan(&watched); err != nil || watched != 1 {
+		t.Errorf("done_watching_video: want video %d recorded as just watched, got %d (%v)", gs.VideoId, watched, err)
+	}
+}
@@ -1,17 +1,33 @@
+// youtube.go: the YouTube Data API v3 video provider.
+//
+// YouTubeProvider's base URLs and http.Client are injectable, so tests and the
+// apiserver's -fake_youtube dev mode can point it at the recorded-fixture
+// fake in server/youtubefake instead of googleapis.
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 )
 
+const (
+	youTubeAPIHost       = "https://www.googleapis.com/youtube/v3"
+	youTubeOembedURL     = "https://www.youtube.com/oembed"
+	youTubeWatchURL      = "https://www.youtube.com/watch?v="
+	youTubeStatusBatch   = 50
+	youTubeClientTimeout = 15 * time.Second
+)
+
 type YouTubePlaylistResponse struct {
 	Items []struct {
 		Snippet struct {
@@ -49,51 +65,123 @@ type YouTubePlaylistItemsResponse struct {
 	NextPageToken string `json:"nextPageToken"`
 }
 
-func (a *Api) fetchPlaylistMetadata(playlistID string) (thumbURL, etag, title string, err error) {
-	apiURL := fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlists?part=snippet&id=%s&key=%s",
-		url.QueryEscape(playlistID), url.QueryEscape(a.YouTubeAPIKey))
-	resp, err := http.Get(apiURL)
+// YouTubeVideosResponse is the videos response: part=status for
+// CheckPlayable, part=contentDetails for PlaylistItems' durations.
+type YouTubeVideosResponse struct {
+	Items []struct {
+		Id             string `json:"id"`
+		ContentDetails struct {
+			// Duration is ISO 8601, e.g. PT4M13S.
+			Duration string `json:"duration"`
+		} `json:"contentDetails"`
+		Status struct {
+			Embeddable      bool   `json:"embeddable"`
+			PrivacyStatus   string `json:"privacyStatus"`
+			UploadStatus    string `json:"uploadStatus"`
+			RejectionReason string `json:"rejectionReason"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// YouTubeProvider is the VideoProvider for YouTube playlists. Playlist and
+// video IDs are YouTube's own. Zero-valued fields fall back to the real
+// endpoints and a client with youTubeClientTimeout.
+type YouTubeProvider struct {
+	APIKey string
+	// BaseURL replaces youTubeAPIHost, e.g. with a youtubefake server's
+	// URL + "/youtube/v3".
+	BaseURL   string
+	OembedURL string
+	Client    *http.Client
+
+	// units counts Data API requests, each of which costs one quota unit
+	// (oembed is free). Read with QuotaUnits.
+	units int64
+}
+
+func NewYouTubeProvider(apiKey, baseURL string, client *http.Client) *YouTubeProvider {
+	return &YouTubeProvider{APIKey: apiKey, BaseURL: strings.TrimRight(baseURL, "/"), Client: client}
+}
+
+func (y *YouTubeProvider) apiHost() string {
+	if y.BaseURL != "" {
+		return y.BaseURL
+	}
+	return youTubeAPIHost
+}
+
+func (y *YouTubeProvider) oembedURL() string {
+	if y.OembedURL != "" {
+		return y.OembedURL
+	}
+	return youTubeOembedURL
+}
+
+// QuotaUnits is the Data API quota this provider has spent since it was built.
+func (y *YouTubeProvider) QuotaUnits() int64 {
+	return atomic.LoadInt64(&y.units)
+}
+
+// apiGet issues a Data API request, charging it to the quota count.
+func (y *YouTubeProvider) apiGet(client *http.Client, apiURL string) (*http.Response, error) {
+	atomic.AddInt64(&y.units, 1)
+	return client.Get(apiURL)
+}
+
+func (y *YouTubeProvider) httpClient() *http.Client {
+	if y.Client != nil {
+		return y.Client
+	}
+	return &http.Client{Timeout: youTubeClientTimeout}
+}
+
+// isUnavailablePlaylistItem reports a private or deleted video's placeholder
+// entry: playlistItems still lists it, under a fixed title and with no
+// thumbnails, but it can never play.
+func isUnavailablePlaylistItem(title, thumbURL string) bool {
+	return thumbURL == "" && (title == "Private video" || title == "Deleted video")
+}
+
+func (y *YouTubeProvider) Name() string { return PROVIDER_YOUTUBE }
+
+func (y *YouTubeProvider) PlaylistMetadata(playlistID string) (*ProviderPlaylist, error) {
+	apiURL := fmt.Sprintf("%s/playlists?part=snippet&id=%s&key=%s",
+		y.apiHost(), url.QueryEscape(playlistID), url.QueryEscape(y.APIKey))
+	resp, err := y.apiGet(y.httpClient(), apiURL)
 	if err != nil {
-		return "", "", "", fmt.Errorf("failed to fetch playlist: %w", err)
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", "", "", fmt.Errorf("YouTube API error: %d %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("YouTube API error: %d %s", resp.StatusCode, string(body))
 	}
 	var data YouTubePlaylistResponse
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return "", "", "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 	if len(data.Items) == 0 {
-		return "", "", "", fmt.Errorf("playlist not found")
+		return nil, fmt.Errorf("playlist not found")
 	}
 	snippet := data.Items[0].Snippet
-	thumbURL = snippet.Thumbnails.Medium.URL
+	thumbURL := snippet.Thumbnails.Medium.URL
 	if thumbURL == "" {
 		thumbURL = snippet.Thumbnails.Default.URL
 	}
-	return thumbURL, data.Items[0].Etag, snippet.Title, nil
+	return &ProviderPlaylist{Title: snippet.Title, ThumbnailURL: thumbURL, Etag: data.Items[0].Etag}, nil
 }
 
-func (a *Api) fetchPlaylistItems(playlistID string) ([]struct {
-	VideoID      string
-	Title        string
-	ThumbnailURL string
-}, error) {
-	var allItems []struct {
-		VideoID      string
-		Title        string
-		ThumbnailURL string
-	}
+func (y *YouTubeProvider) PlaylistItems(playlistID string) ([]ProviderVideo, error) {
+	client := y.httpClient()
+	var allItems []ProviderVideo
 	pageToken := ""
 	for {
-		apiURL := fmt.Sprintf("https://www.googleapis.com/youtube/v3/playlistItems?part=snippet&playlistId=%s&maxResults=50&key=%s",
-			url.QueryEscape(playlistID), url.QueryEscape(a.YouTubeAPIKey))
+		apiURL := fmt.Sprintf("%s/playlistItems?part=snippet&playlistId=%s&maxResults=50&key=%s",
+			y.apiHost(), url.QueryEscape(playlistID), url.QueryEscape(y.APIKey))
 		if pageToken != "" {
 			apiURL += "&pageToken=" + url.QueryEscape(pageToken)
 		}
-		resp, err := http.Get(apiURL)
+		resp, err := y.apiGet(client, apiURL)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch playlist items: %w", err)
 		}
@@ -113,13 +201,14 @@ func (a *Api) fetchPlaylistItems(playlistID string) ([]struct {
 			if thumbURL == "" {
 				thumbURL = item.Snippet.Thumbnails.Default.URL
 			}
-			allItems = append(allItems, struct {
-				VideoID      string
-				Title        string
-				ThumbnailURL string
-			}{
-				VideoID:      item.Snippet.ResourceID.VideoID,
+			if isUnavailablePlaylistItem(item.Snippet.Title, thumbURL) {
+				continue
+			}
+			videoID := item.Snippet.ResourceID.VideoID
+			allItems = append(allItems, ProviderVideo{
+				ExternalId:   videoID,
 				Title:        item.Snippet.Title,
+				URL:          youTubeWatchURL + videoID,
 				ThumbnailURL: thumbURL,
 			})
 		}
@@ -128,78 +217,157 @@ func (a *Api) fetchPlaylistItems(playlistID string) ([]struct {
 		}
 		pageToken = data.NextPageToken
 	}
+	y.fillDurations(client, allItems)
 	return allItems, nil
 }
 
-func (a *Api) syncPlaylistFromYouTube(playlistID string) (uint32, error) {
-	thumbURL, etag, title, err := a.fetchPlaylistMetadata(playlistID)
-	if err != nil {
-		return 0, fmt.Errorf("fetch playlist metadata: %w", err)
-	}
-	var playlistDbID uint32
-	err = a.DB.QueryRow("SELECT id FROM playlists WHERE you_tube_id=?", playlistID).Scan(&playlistDbID)
-	if err == sql.ErrNoRows {
-		pl := &Playlist{
-			YouTubeId:    playlistID,
-			Title:        title,
-			ThumbnailURL: thumbURL,
-			Etag:         etag,
-		}
-		status, msg, createErr := a.playlistManager.Create(pl)
-		if createErr != nil {
-			return 0, fmt.Errorf("create playlist: %d %s: %w", status, msg, createErr)
-		}
-		playlistDbID = pl.Id
-	} else if err != nil {
-		return 0, fmt.Errorf("query playlist: %w", err)
-	} else {
-		pl := &Playlist{
-			Id:           playlistDbID,
-			YouTubeId:    playlistID,
-			Title:        title,
-			ThumbnailURL: thumbURL,
-			Etag:         etag,
-		}
-		status, msg, updateErr := a.playlistManager.Update(pl)
-		if updateErr != nil {
-			return 0, fmt.Errorf("update playlist: %d %s: %w", status, msg, updateErr)
-		}
-	}
-	items, err := a.fetchPlaylistItems(playlistID)
-	if err != nil {
-		return 0, fmt.Errorf("fetch playlist items: %w", err)
+// fillDurations sets each item's duration from the videos endpoint, 50 IDs a
+// call. Durations are best-effort: a failed batch leaves its items unknown.
+func (y *YouTubeProvider) fillDurations(client *http.Client, items []ProviderVideo) {
+	for i := 0; i < len(items); i += youTubeStatusBatch {
+		end := i + youTubeStatusBatch
+		if end > len(items) {
+			end = len(items)
+		}
+		ids := make([]string, 0, end-i)
+		for _, item := range items[i:end] {
+			ids = append(ids, item.ExternalId)
+		}
+		data, err := y.fetchVideos(client, "contentDetails", ids)
+		if err != nil {
+			glog.Errorf("YouTube video durations: %v", err)
+			continue
+		}
+		durations := make(map[string]uint32, len(data.Items))
+		for _, v := range data.Items {
+			if d, err := parseISO8601Duration(v.ContentDetails.Duration); err == nil {
+				durations[v.Id] = d
+			}
+		}
+		for j := i; j < end; j++ {
+			items[j].DurationSeconds = durations[items[j].ExternalId]
+		}
 	}
-	_, err = a.DB.Exec("DELETE FROM playlist_video WHERE playlist_id=?", playlistDbID)
-	if err != nil {
-		return 0, fmt.Errorf("clear playlist_video: %w", err)
-	}
-	for _, item := range items {
-		var videoID uint32
-		qerr := a.DB.QueryRow("SELECT id FROM videos WHERE you_tube_id=?", item.VideoID).Scan(&videoID)
-		if qerr == sql.ErrNoRows {
-			videoURL := "https://www.youtube.com/watch?v=" + item.VideoID
-			result, inerr := a.DB.Exec("INSERT INTO videos (title, url, thumbnailurl, you_tube_id) VALUES (?, ?, ?, ?)",
-				item.Title, videoURL, item.ThumbnailURL, item.VideoID)
-			if inerr != nil {
-				glog.Errorf("insert video %s: %v", item.VideoID, inerr)
-				continue
+}
+
+// parseISO8601Duration parses the durations YouTube reports, P[nD]T[nH][nM][nS],
+// into seconds.
+func parseISO8601Duration(s string) (uint32, error) {
+	rest, ok := strings.CutPrefix(s, "P")
+	if !ok || rest == "" || strings.HasSuffix(rest, "T") {
+		return 0, fmt.Errorf("bad duration %q", s)
+	}
+	var total uint64
+	inTime := false
+	num := ""
+	for _, r := range rest {
+		switch {
+		case r >= '0' && r <= '9':
+			num += string(r)
+		case r == 'T' && !inTime && num == "":
+			inTime = true
+		default:
+			n, err := strconv.ParseUint(num, 10, 32)
+			if err != nil {
+				return 0, fmt.Errorf("bad duration %q", s)
 			}
-			id, inerr := result.LastInsertId()
-			if inerr != nil {
-				glog.Errorf("get video insert id %s: %v", item.VideoID, inerr)
-				continue
+			num = ""
+			switch {
+			case r == 'W' && !inTime:
+				total += n * 7 * 24 * 3600
+			case r == 'D' && !inTime:
+				total += n * 24 * 3600
+			case r == 'H' && inTime:
+				total += n * 3600
+			case r == 'M' && inTime:
+				total += n * 60
+			case r == 'S' && inTime:
+				total += n
+			default:
+				return 0, fmt.Errorf("bad duration %q", s)
 			}
-			videoID = uint32(id)
-		} else if qerr != nil {
-			glog.Errorf("query video %s: %v", item.VideoID, qerr)
-			continue
 		}
-		_, err = a.DB.Exec("INSERT INTO playlist_video (playlist_id, video_id) VALUES (?, ?)", playlistDbID, videoID)
+	}
+	if num != "" || total > math.MaxUint32 {
+		return 0, fmt.Errorf("bad duration %q", s)
+	}
+	return uint32(total), nil
+}
+
+// CheckPlayable asks the videos endpoint for each video's status: playable
+// means public and embeddable, and an ID YouTube doesn't return is not
+// playable. Without an API key it falls back to oembed, which only tells
+// whether the video exists and allows embedding.
+func (y *YouTubeProvider) CheckPlayable(videoIDs []string) map[string]Playability {
+	client := y.httpClient()
+	out := make(map[string]Playability)
+	if strings.TrimSpace(y.APIKey) == "" {
+		y.checkViaOembed(client, videoIDs, out)
+		return out
+	}
+	for i := 0; i < len(videoIDs); i += youTubeStatusBatch {
+		end := i + youTubeStatusBatch
+		if end > len(videoIDs) {
+			end = len(videoIDs)
+		}
+		batch := videoIDs[i:end]
+		data, err := y.fetchVideos(client, "status", batch)
 		if err != nil {
-			if !strings.Contains(err.Error(), "Duplicate entry") {
-				glog.Errorf("insert playlist_video %d %d: %v", playlistDbID, videoID, err)
+			glog.Errorf("YouTube video status: %v", err)
+			for _, id := range batch {
+				out[id] = VideoPlayabilityError
+			}
+			continue
+		}
+		for _, id := range batch {
+			out[id] = VideoNotPlayable
+		}
+		for _, item := range data.Items {
+			if item.Status.Embeddable && item.Status.PrivacyStatus == "public" {
+				out[item.Id] = VideoPlayable
 			}
 		}
 	}
-	return playlistDbID, nil
+	return out
+}
+
+func (y *YouTubeProvider) fetchVideos(client *http.Client, part string, ids []string) (*YouTubeVideosResponse, error) {
+	reqURL := fmt.Sprintf("%s/videos?part=%s&id=%s&key=%s",
+		y.apiHost(), part, url.QueryEscape(strings.Join(ids, ",")), url.QueryEscape(y.APIKey))
+	resp, err := y.apiGet(client, reqURL)
+	if err != nil {
+		return nil, fmt.Errorf("request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("YouTube API error: %d %s", resp.StatusCode, string(body))
+	}
+	var data YouTubeVideosResponse
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	return &data, nil
+}
+
+func (y *YouTubeProvider) checkViaOembed(client *http.Client, videoIDs []string, out map[string]Playability) {
+	for _, id := range videoIDs {
+		reqURL := y.oembedURL() + "?url=" + url.QueryEscape(youTubeWatchURL+id)
+		resp, err := client.Get(reqURL)
+		if err != nil {
+			glog.Errorf("oembed %s: %v", id, err)
+			out[id] = VideoPlayabilityError
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			out[id] = VideoPlayable
+		} else {
+			out[id] = VideoNotPlayable
+		}
+	}
 }
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"garydmenezes.com/mathgame/server/common"
+	"garydmenezes.com/mathgame/server/youtubefake"
+)
+
+// useFakeYouTube points the API's YouTube provider at a fresh recorded-fixture
+// fake, so no test reaches googleapis. setupTestAPI calls it; a test that
+// needs to advance revisions or count quota calls it again for a handle.
+func useFakeYouTube(t *testing.T, api *Api) *youtubefake.Server {
+	t.Helper()
+	fake := youtubefake.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	api.videoProviders[PROVIDER_YOUTUBE] = newFakeYouTubeProvider(srv, "test-key")
+	return fake
+}
+
+func newFakeYouTubeProvider(srv *httptest.Server, apiKey string) *YouTubeProvider {
+	y := NewYouTubeProvider(apiKey, srv.URL+youtubefake.APIPath, srv.Client())
+	y.OembedURL = srv.URL + youtubefake.OembedPath
+	return y
+}
+
+func startFakeYouTube(t *testing.T) (*youtubefake.Server, *httptest.Server) {
+	t.Helper()
+	fake := youtubefake.New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, srv
+}
+
+func TestYouTubeProvider_Pagination(t *testing.T) {
+	fake, srv := startFakeYouTube(t)
+	y := newFakeYouTubeProvider(srv, "test-key")
+
+	meta, err := y.PlaylistMetadata(youtubefake.PagedPlaylist)
+	if err != nil || meta.Title != "Fake paged playlist" || meta.Etag != "etag-paged-1" {
+		t.Fatalf("metadata: got %+v (%v)", meta, err)
+	}
+	items, err := y.PlaylistItems(youtubefake.PagedPlaylist)
+	if err != nil {
+		t.Fatalf("items: %v", err)
+	}
+	if len(items) != 3 || items[2].ExternalId != "fkPaged0003" {
+		t.Fatalf("want 3 videos across both pages, got %+v", items)
+	}
+	if items[0].URL != youTubeWatchURL+"fkPaged0001" || items[0].ThumbnailURL == "" {
+		t.Errorf("item: want a watch URL and a thumbnail, got %+v", items[0])
+	}
+	if items[0].DurationSeconds != 200 || items[2].DurationSeconds != 3723 {
+		t.Errorf("durations: want 200s and 3723s from contentDetails, got %d and %d", items[0].DurationSeconds, items[2].DurationSeconds)
+	}
+	if fake.Units() != 4 || y.QuotaUnits() != 4 {
+		t.Errorf("want 4 quota units (metadata + 2 pages + durations), got %d charged and %d counted", fake.Units(), y.QuotaUnits())
+	}
+}
+
+func TestParseISO8601Duration(t *testing.T) {
+	for in, want := range map[string]uint32{
+		"PT45S":    45,
+		"PT3M20S":  200,
+		"PT12M":    720,
+		"PT1H2M3S": 3723,
+		"P1DT1S":   86401,
+		"P0D":      0,
+		"PT1H":     3600,
+		"P1W":      604800,
+	} {
+		if got, err := parseISO8601Duration(in); err != nil || got != want {
+			t.Errorf("%s: want %d, got %d (%v)", in, want, got, err)
+		}
+	}
+	for _, in := range []string{"", "3M", "PT", "PTXS", "PT5X"} {
+		if _, err := parseISO8601Duration(in); err == nil {
+			t.Errorf("%q: want an error", in)
+		}
+	}
+}
+
+func TestYouTubeProvider_UnavailableVideos(t *testing.T) {
+	_, srv := startFakeYouTube(t)
+	y := newFakeYouTubeProvider(srv, "test-key")
+
+	items, err := y.PlaylistItems(youtubefake.MixedPlaylist)
+	if err != nil {
+		t.Fatalf("items: %v", err)
+	}
+	var ids []string
+	for _, it := range items {
+		ids = append(ids, it.ExternalId)
+	}
+	if strings.Join(ids, ",") != "fkPublic001,fkNoEmbed01" {
+		t.Errorf("want the private and deleted placeholders skipped, got %v", ids)
+	}
+
+	all := []string{"fkPublic001", "fkPrivate01", "fkDeleted01", "fkNoEmbed01"}
+	want := map[string]Playability{
+		"fkPublic001": VideoPlayable,
+		"fkPrivate01": VideoNotPlayable,
+		"fkDeleted01": VideoNotPlayable,
+		"fkNoEmbed01": VideoNotPlayable,
+	}
+	for name, key := range map[string]string{"status API": "test-key", "oembed fallback": ""} {
+		y.APIKey = key
+		got := y.CheckPlayable(all)
+		for id, w := range want {
+			if got[id] != w {
+				t.Errorf("%s: %s want %q, got %q", name, id, w, got[id])
+			}
+		}
+	}
+}
+
+func TestYouTubeProvider_QuotaAndErrors(t *testing.T) {
+	fake, srv := startFakeYouTube(t)
+	y := newFakeYouTubeProvider(srv, "test-key")
+
+	if _, err := y.PlaylistMetadata(youtubefake.QuotaPlaylist); err == nil || !strings.Contains(err.Error(), "quotaExceeded") {
+		t.Errorf("recorded quota error: want quotaExceeded surfaced, got %v", err)
+	}
+	if _, err := y.PlaylistMetadata("PLdoesNotExist"); err == nil || err.Error() != "playlist not found" {
+		t.Errorf("unknown playlist: want playlist not found, got %v", err)
+	}
+	if _, err := y.PlaylistItems("PLdoesNotExist"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("unknown playlist items: want a 404, got %v", err)
+	}
+
+	fake.QuotaLimit = fake.Units() + 1
+	if _, err := y.PlaylistItems(youtubefake.PagedPlaylist); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("quota spent mid-pagination: want the whole fetch to fail, got %v", err)
+	}
+	if got := y.CheckPlayable([]string{"fkPaged0001"}); got["fkPaged0001"] != VideoPlayabilityError {
+		t.Errorf("status call over quota: want error, got %v", got)
+	}
+}
+
+func TestYouTubeProvider_EtagChange(t *testing.T) {
+	fake, srv := startFakeYouTube(t)
+	y := newFakeYouTubeProvider(srv, "test-key")
+
+	before, err := y.PlaylistMetadata(youtubefake.ChangingPlaylist)
+	if err != nil {
+		t.Fatalf("metadata: %v", err)
+	}
+	if !fake.Advance(youtubefake.ChangingPlaylist) {
+		t.Fatalf("want a second revision")
+	}
+	after, err := y.PlaylistMetadata(youtubefake.ChangingPlaylist)
+	if err != nil || after.Etag == before.Etag {
+		t.Fatalf("want the etag to change, got %q -> %q (%v)", before.Etag, after.Etag, err)
+	}
+	items, err := y.PlaylistItems(youtubefake.ChangingPlaylist)
+	if err != nil || len(items) != 2 || items[1].ExternalId != "fkEtag00003" {
+		t.Errorf("revision 2 items: got %+v (%v)", items, err)
+	}
+	if fake.Advance(youtubefake.ChangingPlaylist) {
+		t.Errorf("want no third revision")
+	}
+}
+
+// TestAddPlaylist_ByURLFromFake adds a YouTube playlist by URL through the
+// API, against the fake, and checks the user gets its videos.
+func TestAddPlaylist_ByURLFromFake(t *testing.T) {
+	c, err := common.ReadConfig("../../test_conf.json")
+	if err != nil {
+		t.Fatalf("Couldn't read config: %v", err)
+	}
+	_, r, cleanup := setupTestAPI(t, c)
+	defer cleanup()
+	user := createTestUser(t, r, "auth0|fakeyoutube", "fakeyoutube@test.com", "fakeyoutubeuser")
+
+	post := func(body map[string]string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		resp := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/api/v1/playlists?test_auth0_id=%s", user.Auth0Id), bytes.NewBuffer(b))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := post(map[string]string{"playlist_url": "https://www.youtube.com/playlist?list=" + youtubefake.PagedPlaylist})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("add: want 200, got %d %s", resp.Code, resp.Body.String())
+	}
+	resp = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/api/v1/videos?test_auth0_id=%s", user.Auth0Id), nil)
+	r.ServeHTTP(resp, req)
+	var videos []Video
+	if err := json.Unmarshal(resp.Body.Bytes(), &videos); err != nil || len(videos) != 3 {
+		t.Fatalf("want the playlist's 3 videos, got %s (%v)", resp.Body.String(), err)
+	}
+
+	if resp := post(map[string]string{"youtube_playlist_id": youtubefake.QuotaPlaylist}); resp.Code != http.StatusBadRequest {
+		t.Errorf("quota error: want 400, got %d", resp.Code)
+	}
+}
@@ -28,13 +28,45 @@ type Config struct {
 	// empty on dev hosts, where nothing serves TLS.
 	TLSCertFile string `json:"tls_cert_file"`
 	TLSKeyFile  string `json:"tls_key_file"`
+	// Outgoing mail for the weekly parent digest (send_weekly_digest).
+	// Optional: hosts that don't send mail leave them empty, and the job
+	// can run against the local capture transport instead.
+	SMTPHost   string `json:"smtp_host"`
+	SMTPPort   string `json:"smtp_port"`
+	SMTPUser   string `json:"smtp_user"`
+	SMTPPass   string `json:"smtp_pass"`
+	DigestFrom string `json:"digest_from"`
+	// Self-hosted reward videos (the local media library provider): the
+	// directory to scan, the URL the browser fetches its files from, and
+	// the key its signed URLs are checked with. Optional: hosts without a
+	// library leave them empty, and an empty key is random per process.
+	LocalMediaDir        string `json:"local_media_dir"`
+	LocalMediaURL        string `json:"local_media_url"`
+	LocalMediaSigningKey string `json:"local_media_signing_key"`
+	// Replaces the YouTube Data API host (https://www.googleapis.com/youtube/v3).
+	// Optional: set only to point at a fake, as the apiserver's -fake_youtube
+	// dev mode does.
+	YouTubeAPIBaseURL string `json:"youtube_api_base_url"`
+	// Bearer token a Prometheus scraper sends to read /metrics. Optional:
+	// empty serves /metrics to loopback callers only.
+	MetricsToken string `json:"metrics_token"`
 }
 
 // optionalConfigFields may legitimately be empty (set only on hosts that
 // need them); Validate skips these.
 var optionalConfigFields = map[string]bool{
-	"tls_cert_file": true,
-	"tls_key_file":  true,
+	"tls_cert_file":           true,
+	"tls_key_file":            true,
+	"smtp_host":               true,
+	"smtp_port":               true,
+	"smtp_user":               true,
+	"smtp_pass":               true,
+	"digest_from":             true,
+	"local_media_dir":         true,
+	"local_media_url":         true,
+	"local_media_signing_key": true,
+	"youtube_api_base_url":    true,
+	"metrics_token":           true,
 }
 
 func ReadConfig(path string) (*Config, error) {
@@ -52,7 +52,15 @@ Do not wrap the JSON in markdown or any other JSON markers.
 	MAX_QUANTITY = 20
 )
 
+// GenerateProblem is GenerateProblemContext without a deadline.
 func GenerateProblem(opts *Options) ([]Problem, error) {
+	return GenerateProblemContext(context.Background(), opts)
+}
+
+// GenerateProblemContext asks the LLM for opts.NumProblems problems. ctx
+// bounds the OpenAI call, retries included, so a caller shutting down can
+// abandon a batch.
+func GenerateProblemContext(ctx context.Context, opts *Options) ([]Problem, error) {
 	opts.NumProblems = common.Min(opts.NumProblems, MAX_QUANTITY)
 
 	c, err := common.ReadConfig("conf.json")
@@ -99,7 +107,8 @@ func GenerateProblem(opts *Options) ([]Problem, error) {
 
 	client := openai.NewClient(c.OpenAiApiKey)
 	resp, err := chatCompletionWithRetry(
-		context.Background(),
+		ctx,
+		"generate",
 		client,
 		openai.ChatCompletionRequest{
 			Model: model,
@@ -8,6 +8,8 @@ import (
 
 	"github.com/golang/glog"
 	openai "github.com/sashabaranov/go-openai"
+
+	"garydmenezes.com/mathgame/server/metrics"
 )
 
 const (
@@ -16,9 +18,22 @@ const (
 	backoffFactor    = 2
 )
 
+// OpenAI call metrics, labelled by call ("generate" or "validate"). Each
+// attempt is timed separately, so a retried call shows up as several
+// observations plus its retries.
+var (
+	openAIRequestSeconds = metrics.NewHistogramVec("mathgame_openai_request_duration_seconds",
+		"OpenAI chat completion attempts by call and outcome (ok, retryable, error).",
+		[]float64{.5, 1, 2.5, 5, 10, 20, 40, 80}, "call", "outcome")
+	openAIRetries = metrics.NewCounterVec("mathgame_openai_retries_total",
+		"OpenAI chat completion attempts retried after a transient error.", "call")
+)
+
 // chatCompletionWithRetry retries CreateChatCompletion with exponential backoff on transient errors.
+// call names the caller in the OpenAI metrics.
 func chatCompletionWithRetry(
 	ctx context.Context,
+	call string,
 	client *openai.Client,
 	req openai.ChatCompletionRequest,
 ) (openai.ChatCompletionResponse, error) {
@@ -28,16 +43,22 @@ func chatCompletionWithRetry(
 		backoff = initialBackoff
 	)
 	for attempt := 1; attempt <= maxRetryAttempts; attempt++ {
+		start := time.Now()
 		resp, err = client.CreateChatCompletion(ctx, req)
+		elapsed := time.Since(start).Seconds()
 		if err == nil {
+			openAIRequestSeconds.Observe(elapsed, call, "ok")
 			return resp, nil
 		}
 		if !isRetryableOpenAIError(err) {
+			openAIRequestSeconds.Observe(elapsed, call, "error")
 			return resp, err
 		}
+		openAIRequestSeconds.Observe(elapsed, call, "retryable")
 		if attempt == maxRetryAttempts {
 			break
 		}
+		openAIRetries.Inc(call)
 		glog.Warningf("OpenAI transient error (attempt %d/%d, sleeping %s): %v",
 			attempt, maxRetryAttempts, backoff, err)
 		select {
@@ -51,8 +72,9 @@ func chatCompletionWithRetry(
 }
 
 // isRetryableOpenAIError returns true for 408/429/5xx and unknown (likely network) errors.
+// A cancelled or expired context is final: the caller has given up.
 func isRetryableOpenAIError(err error) bool {
-	if err == nil {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 		return false
 	}
 	var apiErr *openai.APIError
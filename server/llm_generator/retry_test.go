@@ -1,7 +1,9 @@
 package llm_generator
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"testing"
 
 	openai "github.com/sashabaranov/go-openai"
@@ -25,6 +27,8 @@ func TestIsRetryableOpenAIError(t *testing.T) {
 		{"req 502", &openai.RequestError{HTTPStatusCode: 502}, true},
 		{"req 400", &openai.RequestError{HTTPStatusCode: 400}, false},
 		{"network error", errors.New("dial tcp: timeout"), true},
+		{"canceled", fmt.Errorf("post: %w", context.Canceled), false},
+		{"deadline", context.DeadlineExceeded, false},
 	}
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -55,12 +55,22 @@ var ErrFormMismatch = errors.New("FORM_MISMATCH")
 // featureNames is the closed list the validator may use on line 3 (the api
 // side owns it). Returns the observed features on success.
 func ValidateWordProblem(p *Problem, constraints string, featureNames []string) ([]string, error) {
-	return ValidateWordProblemWithModel(p, constraints, featureNames, openai.GPT5)
+	return ValidateWordProblemContext(context.Background(), p, constraints, featureNames)
+}
+
+// ValidateWordProblemContext is ValidateWordProblem with ctx bounding the
+// OpenAI call.
+func ValidateWordProblemContext(ctx context.Context, p *Problem, constraints string, featureNames []string) ([]string, error) {
+	return validateWordProblem(ctx, p, constraints, featureNames, openai.GPT5)
 }
 
 // ValidateWordProblemWithModel is ValidateWordProblem with an explicit
 // model, for bulk tools that trade per-call accuracy for cost.
 func ValidateWordProblemWithModel(p *Problem, constraints string, featureNames []string, model string) ([]string, error) {
+	return validateWordProblem(context.Background(), p, constraints, featureNames, model)
+}
+
+func validateWordProblem(ctx context.Context, p *Problem, constraints string, featureNames []string, model string) ([]string, error) {
 	if strings.ContainsAny(p.Answer, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") {
 		msg := fmt.Sprintf("Answer contained text: %v\n", p)
 		glog.Info(msg)
@@ -87,7 +97,8 @@ func ValidateWordProblemWithModel(p *Problem, constraints string, featureNames [
 
 	client := openai.NewClient(c.OpenAiApiKey)
 	resp, err := chatCompletionWithRetry(
-		context.Background(),
+		ctx,
+		"validate",
 		client,
 		openai.ChatCompletionRequest{
 			Model: model,
@@ -0,0 +1,305 @@
+// Package metrics is a small in-process metrics registry that renders the
+// Prometheus text exposition format (version 0.0.4). It has counters, gauges
+// and histograms, each a vector over a fixed set of label names. Packages
+// declare their metrics as package-level vars (NewCounterVec and friends
+// register them with Default); the apiserver serves Default at /metrics.
+// See docs/ops-runbook.md for the metric inventory.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are latency buckets in seconds, from 5ms to 10s.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// family is one registered metric: its header and its series.
+type family interface {
+	header() (name, help, kind string)
+	write(w io.Writer) error
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+// Default is the registry the New*Vec constructors register with.
+var Default = NewRegistry()
+
+func (r *Registry) register(f family) {
+	name, _, _ := f.header()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteText renders every family in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	for _, f := range families {
+		name, help, kind := f.header()
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind); err != nil {
+			return err
+		}
+		if err := f.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vec is the label bookkeeping shared by every metric kind.
+type vec struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	keys   map[string][]string // series key -> label values
+}
+
+func newVec(name, help string, labels []string) vec {
+	return vec{name: name, help: help, labels: labels, keys: map[string][]string{}}
+}
+
+// key checks the label values and returns the series key.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, ok := v.keys[k]; !ok {
+		v.keys[k] = append([]string(nil), values...)
+	}
+	return k
+}
+
+// sortedKeys returns the series keys in a stable order.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.keys))
+	for k := range v.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelText renders {a="x",b="y"} plus any extra pair (a histogram's le).
+func (v *vec) labelText(values []string, extraName, extraValue string) string {
+	if len(values) == 0 && extraName == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(values)+1)
+	for i, l := range v.labels {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", l, escapeLabel(values[i])))
+	}
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// CounterVec is a set of monotonically increasing counters.
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounterVec registers a counter with Default.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, labels), values: map[string]float64{}}
+	Default.register(c)
+	return c
+}
+
+// Add adds delta, which must not be negative, to the labelled counter.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("metrics: counter " + c.name + " decreased")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(labelValues)] += delta
+}
+
+// Inc adds one to the labelled counter.
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+// Value returns the labelled counter's value.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[strings.Join(labelValues, "\xff")]
+}
+
+func (c *CounterVec) header() (string, string, string) { return c.name, c.help, "counter" }
+
+func (c *CounterVec) write(w io.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range c.sortedKeys() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelText(c.keys[k], "", ""), formatFloat(c.values[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GaugeVec is a set of values that go up and down.
+type GaugeVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewGaugeVec registers a gauge with Default.
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, labels), values: map[string]float64{}}
+	Default.register(g)
+	return g
+}
+
+// Set sets the labelled gauge.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] = value
+}
+
+// Add adds delta (possibly negative) to the labelled gauge.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(labelValues)] += delta
+}
+
+// Value returns the labelled gauge's value.
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[strings.Join(labelValues, "\xff")]
+}
+
+func (g *GaugeVec) header() (string, string, string) { return g.name, g.help, "gauge" }
+
+func (g *GaugeVec) write(w io.Writer) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, k := range g.sortedKeys() {
+		if _, err := fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelText(g.keys[k], "", ""), formatFloat(g.values[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HistogramVec is a set of histograms sharing bucket upper bounds.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram with Default. buckets are the upper
+// bounds, ascending; the +Inf bucket is implied.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: " + name + " buckets are not ascending")
+	}
+	h := &HistogramVec{vec: newVec(name, help, labels), buckets: buckets, series: map[string]*histogram{}}
+	Default.register(h)
+	return h
+}
+
+// Observe records one value in the labelled histogram.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	k := h.key(labelValues)
+	s := h.series[k]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+// Count returns how many values the labelled histogram has recorded.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s := h.series[strings.Join(labelValues, "\xff")]; s != nil {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) header() (string, string, string) { return h.name, h.help, "histogram" }
+
+func (h *HistogramVec) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range h.sortedKeys() {
+		values, s := h.keys[k], h.series[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(values, "le", formatFloat(le)), cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.name, h.labelText(values, "le", "+Inf"), s.count,
+			h.name, h.labelText(values, "", ""), formatFloat(s.sum),
+			h.name, h.labelText(values, "", ""), s.count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
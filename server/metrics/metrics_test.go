@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	saved := Default
+	Default = NewRegistry()
+	defer func() { Default = saved }()
+
+	c := NewCounterVec("test_requests_total", "Requests.", "route")
+	c.Inc("/b")
+	c.Add(2, `/a"x`)
+	g := NewGaugeVec("test_queue_depth", "Queue depth.")
+	g.Set(4)
+	g.Add(-1)
+	h := NewHistogramVec("test_latency_seconds", "Latency.", []float64{0.1, 1}, "call")
+	h.Observe(0.05, "gen")
+	h.Observe(0.5, "gen")
+	h.Observe(3, "gen")
+
+	var buf bytes.Buffer
+	if err := Default.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText: %v", err)
+	}
+	want := `# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"x"} 2
+test_requests_total{route="/b"} 1
+# HELP test_queue_depth Queue depth.
+# TYPE test_queue_depth gauge
+test_queue_depth 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{call="gen",le="0.1"} 1
+test_latency_seconds_bucket{call="gen",le="1"} 2
+test_latency_seconds_bucket{call="gen",le="+Inf"} 3
+test_latency_seconds_sum{call="gen"} 3.55
+test_latency_seconds_count{call="gen"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("exposition mismatch:\n got:\n%s\nwant:\n%s", got, want)
+	}
+	if c.Value("/b") != 1 || g.Value() != 3 || h.Count("gen") != 3 || h.Count("other") != 0 {
+		t.Errorf("accessors: got %v %v %v", c.Value("/b"), g.Value(), h.Count("gen"))
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	saved := Default
+	Default = NewRegistry()
+	defer func() { Default = saved }()
+
+	c := NewCounterVec("test_dup_total", "Dup.", "a")
+	for name, f := range map[string]func(){
+		"duplicate name":    func() { NewCounterVec("test_dup_total", "Dup.") },
+		"wrong label count": func() { c.Inc() },
+		"negative counter":  func() { c.Add(-1, "x") },
+		"unsorted buckets":  func() { NewHistogramVec("test_h", "H.", []float64{1, 0.5}) },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.HasPrefix(r.(string), "metrics: ") {
+					t.Errorf("%s: want a metrics panic, got %v", name, r)
+				}
+			}()
+			f()
+		}()
+	}
+}
@@ -0,0 +1,248 @@
+// Package youtubefake is an offline stand-in for the slice of the YouTube Data
+// API v3 (and oembed) that api.YouTubeProvider calls. It serves recorded
+// responses from fixture files, so playlist code paths can be exercised
+// without network access or quota: by tests (httptest.NewServer(New())) and
+// by the apiserver's -fake_youtube dev mode.
+//
+// Fixtures live under fixtures/ (embedded) or any directory passed to
+// NewFromDir, in the layout cmd/record_youtube_fixtures writes:
+//
+//	playlists/<playlistId>.json                 playlists?part=snippet&id=...
+//	playlistItems/<playlistId>.json             playlistItems, first page
+//	playlistItems/<playlistId>.<pageToken>.json playlistItems, later pages
+//	videos/<videoId>.json                       one item of videos?part=status,contentDetails
+//
+// Playlist and playlistItems files hold one recorded response,
+// {"status": <code>, "body": <JSON>}; a videos file holds just the item, and
+// a video with no file is reported the way YouTube reports a deleted one (by
+// leaving it out). A playlist can have later revisions, <playlistId>@2.json
+// and so on, which Advance switches to: that is how an etag change is
+// replayed.
+package youtubefake
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+//go:embed fixtures
+var embedded embed.FS
+
+// APIPath is the path prefix the fake serves the Data API under; point
+// YouTubeProvider.BaseURL at <server URL> + APIPath.
+const APIPath = "/youtube/v3"
+
+// OembedPath is where the fake serves oembed.
+const OembedPath = "/oembed"
+
+// quotaExceededBody is YouTube's 403 body once the daily quota is spent.
+const quotaExceededBody = `{"error": {"code": 403, "message": "The request cannot be completed because you have exceeded your quota.", "errors": [{"message": "The request cannot be completed because you have exceeded your quota.", "domain": "youtube.quota", "reason": "quotaExceeded"}]}}`
+
+type recorded struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// Server is the fake. Its zero value is not usable; build one with New or
+// NewFromDir.
+type Server struct {
+	fixtures fs.FS
+
+	mu        sync.Mutex
+	revisions map[string]int
+	units     int
+	// QuotaLimit, when > 0, fails every request past that many units with
+	// quotaExceeded, as YouTube does once a project's daily quota is spent.
+	QuotaLimit int
+}
+
+// New serves the fixtures embedded in this package.
+func New() *Server {
+	sub, err := fs.Sub(embedded, "fixtures")
+	if err != nil {
+		panic(err) // the embed directive guarantees the directory
+	}
+	return newServer(sub)
+}
+
+// NewFromDir serves fixtures from a directory, e.g. freshly recorded ones.
+func NewFromDir(dir string) *Server {
+	return newServer(os.DirFS(dir))
+}
+
+func newServer(fixtures fs.FS) *Server {
+	return &Server{fixtures: fixtures, revisions: map[string]int{}}
+}
+
+// Units is the quota spent so far: one unit per Data API request, which is
+// what every list call the provider makes costs.
+func (s *Server) Units() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.units
+}
+
+// Advance moves a playlist to its next recorded revision, reporting false
+// when there is none.
+func (s *Server) Advance(playlistID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.revision(playlistID) + 1
+	if _, err := fs.Stat(s.fixtures, fmt.Sprintf("playlists/%s@%d.json", playlistID, next)); err != nil {
+		return false
+	}
+	s.revisions[playlistID] = next
+	return true
+}
+
+// revision is the playlist's current revision; callers hold mu.
+func (s *Server) revision(playlistID string) int {
+	if rev, ok := s.revisions[playlistID]; ok {
+		return rev
+	}
+	return 1
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	switch r.URL.Path {
+	case APIPath + "/playlists":
+		s.serveAPI(w, q, func() { s.serveRecorded(w, "playlists", q.Get("id"), "", `{"items": []}`) })
+	case APIPath + "/playlistItems":
+		s.serveAPI(w, q, func() { s.serveRecorded(w, "playlistItems", q.Get("playlistId"), q.Get("pageToken"), "") })
+	case APIPath + "/videos":
+		s.serveAPI(w, q, func() { s.serveVideos(w, q.Get("id")) })
+	case OembedPath:
+		s.serveOembed(w, q.Get("url"))
+	case "/_fake/advance":
+		// Dev-mode control: POST /_fake/advance?playlist=<id>.
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.Advance(q.Get("playlist")) {
+			http.Error(w, "no later revision", http.StatusNotFound)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveAPI charges a quota unit and checks the key before serving, as the
+// Data API does.
+func (s *Server) serveAPI(w http.ResponseWriter, q url.Values, serve func()) {
+	s.mu.Lock()
+	s.units++
+	over := s.QuotaLimit > 0 && s.units > s.QuotaLimit
+	s.mu.Unlock()
+	if over {
+		writeJSON(w, http.StatusForbidden, []byte(quotaExceededBody))
+		return
+	}
+	if q.Get("key") == "" {
+		writeJSON(w, http.StatusForbidden, []byte(`{"error": {"code": 403, "message": "The request is missing a valid API key.", "errors": [{"reason": "forbidden"}]}}`))
+		return
+	}
+	serve()
+}
+
+// serveRecorded replays playlists / playlistItems fixtures for the
+// playlist's current revision. notFound is the body for a playlist with no
+// fixture; empty means YouTube's 404 playlistNotFound.
+func (s *Server) serveRecorded(w http.ResponseWriter, endpoint, playlistID, pageToken, notFound string) {
+	s.mu.Lock()
+	rev := s.revision(playlistID)
+	s.mu.Unlock()
+	name := playlistID
+	if rev > 1 {
+		name = fmt.Sprintf("%s@%d", playlistID, rev)
+	}
+	if pageToken != "" {
+		name += "." + pageToken
+	}
+	raw, err := fs.ReadFile(s.fixtures, endpoint+"/"+name+".json")
+	if err != nil {
+		if notFound != "" {
+			writeJSON(w, http.StatusOK, []byte(notFound))
+			return
+		}
+		writeJSON(w, http.StatusNotFound, []byte(`{"error": {"code": 404, "message": "The playlist identified with the request's playlistId parameter cannot be found.", "errors": [{"domain": "youtube.playlistItem", "reason": "playlistNotFound"}]}}`))
+		return
+	}
+	var rec recorded
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		http.Error(w, fmt.Sprintf("bad fixture %s/%s: %v", endpoint, name, err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, rec.Status, rec.Body)
+}
+
+// serveVideos assembles a videos response from per-video fixtures, leaving
+// out IDs with none. Every part recorded is served whatever part is asked for.
+func (s *Server) serveVideos(w http.ResponseWriter, ids string) {
+	items := []json.RawMessage{}
+	for _, id := range strings.Split(ids, ",") {
+		if raw, err := fs.ReadFile(s.fixtures, "videos/"+id+".json"); err == nil {
+			items = append(items, raw)
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"kind": "youtube#videoListResponse", "items": items})
+	writeJSON(w, http.StatusOK, body)
+}
+
+// serveOembed answers 200 for a public, embeddable video, 401 for one that
+// exists but can't be embedded, and 404 otherwise.
+func (s *Server) serveOembed(w http.ResponseWriter, watchURL string) {
+	u, err := url.Parse(watchURL)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	raw, err := fs.ReadFile(s.fixtures, "videos/"+u.Query().Get("v")+".json")
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	var item struct {
+		Status struct {
+			Embeddable    bool   `json:"embeddable"`
+			PrivacyStatus string `json:"privacyStatus"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(raw, &item); err != nil || item.Status.PrivacyStatus == "private" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if !item.Status.Embeddable {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, []byte(`{"type": "video", "provider_name": "YouTube"}`))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body []byte) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// Playlists in the embedded fixtures.
+const (
+	// PagedPlaylist has three videos over two pages.
+	PagedPlaylist = "PLfakePaged000000000000000000000001"
+	// MixedPlaylist has one playable video plus a private, a deleted and a
+	// non-embeddable one.
+	MixedPlaylist = "PLfakeMixed000000000000000000000001"
+	// ChangingPlaylist has a second revision with a new etag, one video
+	// removed and one added.
+	ChangingPlaylist = "PLfakeEtag0000000000000000000000001"
+	// QuotaPlaylist's metadata call was recorded as quotaExceeded.
+	QuotaPlaylist = "PLfakeQuota000000000000000000000001"
+)
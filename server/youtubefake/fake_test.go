@@ -0,0 +1,61 @@
+package youtubefake
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, srv *httptest.Server, path string) (int, string) {
+	t.Helper()
+	resp, err := srv.Client().Get(srv.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestServer_ReplaysFixtures(t *testing.T) {
+	fake := New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	code, body := get(t, srv, APIPath+"/playlistItems?part=snippet&playlistId="+PagedPlaylist+"&key=k")
+	if code != http.StatusOK || !strings.Contains(body, `"nextPageToken": "EAAaBlBUOkNBSQ"`) {
+		t.Errorf("first page: got %d %s", code, body)
+	}
+	code, body = get(t, srv, APIPath+"/videos?part=status&id=fkPublic001,fkDeleted01&key=k")
+	if code != http.StatusOK || !strings.Contains(body, "fkPublic001") || strings.Contains(body, "fkDeleted01") {
+		t.Errorf("videos: want the deleted video left out, got %d %s", code, body)
+	}
+	if code, _ := get(t, srv, APIPath+"/playlists?part=snippet&id="+PagedPlaylist); code != http.StatusForbidden {
+		t.Errorf("missing key: want 403, got %d", code)
+	}
+	if fake.Units() != 3 {
+		t.Errorf("want 3 units charged, got %d", fake.Units())
+	}
+}
+
+func TestServer_AdvanceAndQuota(t *testing.T) {
+	fake := New()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	resp, err := srv.Client().Post(srv.URL+"/_fake/advance?playlist="+ChangingPlaylist, "", nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("advance: got %v (%v)", resp, err)
+	}
+	resp.Body.Close()
+	if _, body := get(t, srv, APIPath+"/playlists?part=snippet&id="+ChangingPlaylist+"&key=k"); !strings.Contains(body, "etag-changing-2") {
+		t.Errorf("want revision 2 served, got %s", body)
+	}
+
+	fake.QuotaLimit = fake.Units()
+	if code, body := get(t, srv, APIPath+"/playlists?part=snippet&id="+PagedPlaylist+"&key=k"); code != http.StatusForbidden || !strings.Contains(body, "quotaExceeded") {
+		t.Errorf("over quota: want 403 quotaExceeded, got %d %s", code, body)
+	}
+}
@@ -0,0 +1,266 @@
+import React, { useCallback, useEffect, useState } from "react";
+
+import {
+  maxDiffForBitmap,
+  MIN_TARGET_DIFFICULTY,
+} from "./bitmap_validation.js";
+
+// Assignments: a parent's "do these problems", served ahead of normal
+// selection until every item is solved or skipped (docs/gameplay.md
+// "Assignments"). The settings page creates and deletes them; the companion
+// shows the completion report.
+
+const authHeaders = (token) => ({
+  Accept: "application/json",
+  "Content-Type": "application/json",
+  Authorization: "Bearer " + token,
+});
+
+// "expression = answer" per line; the last "=" splits, so an equation
+// like "x + 3 = 5 = 2" keeps its own.
+const parseExpressionLines = (text) =>
+  text
+    .split("\n")
+    .map((line) => line.trim())
+    .filter((line) => line !== "")
+    .map((line) => {
+      const i = line.lastIndexOf("=");
+      return i < 0
+        ? { expression: line, answer: "" }
+        : {
+            expression: line.slice(0, i).trim(),
+            answer: line.slice(i + 1).trim(),
+          };
+    });
+
+const formatDue = (a) =>
+  a.due_at ? "due " + new Date(a.due_at).toLocaleDateString() : "no due date";
+
+const useAssignments = (token, apiUrl, userId, refreshKey) => {
+  const [assignments, setAssignments] = useState([]);
+
+  const fetchAssignments = useCallback(async () => {
+    if (token == null || apiUrl == null || userId == null) return;
+    try {
+      const req = await fetch(apiUrl + "/assignments/" + userId, {
+        method: "GET",
+        headers: authHeaders(token),
+      });
+      if (req.ok) {
+        const json = await req.json();
+        setAssignments(Array.isArray(json) ? json : []);
+      }
+    } catch (e) {
+      console.log(e.message);
+    }
+  }, [token, apiUrl, userId]);
+
+  useEffect(() => {
+    fetchAssignments();
+  }, [fetchAssignments, refreshKey]);
+
+  return [assignments, fetchAssignments];
+};
+
+// AssignmentsSettingsView creates an assignment from the enabled topics (a
+// practice set drawn from the pool) or from typed problems, and lists the
+// existing ones with their progress.
+const AssignmentsSettingsView = ({ token, apiUrl, user, settings, bitmap }) => {
+  const [assignments, refresh] = useAssignments(token, apiUrl, user.id);
+  const [title, setTitle] = useState("");
+  const [mode, setMode] = useState("spec");
+  const [count, setCount] = useState(10);
+  const [difficulty, setDifficulty] = useState(settings.target_difficulty);
+  const [lines, setLines] = useState("");
+  const [due, setDue] = useState("");
+  const [error, setError] = useState(null);
+  const [busy, setBusy] = useState(false);
+  const ceiling = maxDiffForBitmap(bitmap);
+
+  const create = async () => {
+    const body = { title: title.trim() };
+    if (due) {
+      // End of the chosen day, local time.
+      body.due_at = new Date(due + "T23:59:59").toISOString();
+    }
+    if (mode === "spec") {
+      body.spec = {
+        problem_type_bitmap: bitmap,
+        difficulty: Math.min(difficulty, ceiling),
+        count: parseInt(count, 10) || 0,
+      };
+    } else {
+      body.expressions = parseExpressionLines(lines);
+    }
+    setBusy(true);
+    setError(null);
+    try {
+      const req = await fetch(apiUrl + "/assignments/" + user.id, {
+        method: "POST",
+        headers: authHeaders(token),
+        body: JSON.stringify(body),
+      });
+      const json = await req.json();
+      if (!req.ok) {
+        setError(json.error || "Could not create the assignment.");
+        return;
+      }
+      setTitle("");
+      setLines("");
+      setDue("");
+      refresh();
+    } catch (e) {
+      console.log(e.message);
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const remove = async (id) => {
+    try {
+      const req = await fetch(apiUrl + "/assignments/" + user.id + "/" + id, {
+        method: "DELETE",
+        headers: authHeaders(token),
+      });
+      if (req.ok) refresh();
+    } catch (e) {
+      console.log(e.message);
+    }
+  };
+
+  return (
+    <div id="assignment-settings" className="settings-form">
+      <h4>Assignments:</h4>
+      <p className="settings-hint">
+        Assigned problems come before everything else until they are done.
+      </p>
+      <label>
+        Title{" "}
+        <input
+          type="text"
+          maxLength="128"
+          value={title}
+          onChange={(e) => setTitle(e.target.value)}
+        />
+      </label>
+      <label>
+        <input
+          type="radio"
+          checked={mode === "spec"}
+          onChange={() => setMode("spec")}
+        />{" "}
+        Practice set from the topics above
+      </label>
+      <label>
+        <input
+          type="radio"
+          checked={mode === "expressions"}
+          onChange={() => setMode("expressions")}
+        />{" "}
+        My own problems
+      </label>
+      {mode === "spec" ? (
+        <>
+          <label>
+            Problems{" "}
+            <input
+              type="number"
+              min="1"
+              max="50"
+              value={count}
+              onChange={(e) => setCount(e.target.value)}
+            />
+          </label>
+          <label>
+            Difficulty{" "}
+            <input
+              type="range"
+              min={MIN_TARGET_DIFFICULTY}
+              max={ceiling.toFixed(1)}
+              step="0.1"
+              value={Math.min(difficulty, ceiling)}
+              onChange={(e) => setDifficulty(parseFloat(e.target.value))}
+            />
+          </label>
+        </>
+      ) : (
+        <label>
+          One per line, problem = answer
+          <textarea
+            rows="5"
+            placeholder={"12 + 7 = 19\n3/4 + 1/4 = 1"}
+            value={lines}
+            onChange={(e) => setLines(e.target.value)}
+          />
+        </label>
+      )}
+      <label>
+        Due (optional){" "}
+        <input
+          type="date"
+          value={due}
+          onChange={(e) => setDue(e.target.value)}
+        />
+      </label>
+      <button type="button" disabled={busy || !title.trim()} onClick={create}>
+        Assign
+      </button>
+      {error && <p className="assignment-error">{error}</p>}
+      {assignments.length > 0 && (
+        <ul id="assignment-list">
+          {assignments.map((a) => (
+            <li key={a.id} className={"assignment-" + a.status}>
+              <span className="assignment-title">{a.title}</span>{" "}
+              <span className="assignment-progress">
+                {a.solved}/{a.total} solved &middot; {formatDue(a)} &middot;{" "}
+                {a.status}
+              </span>{" "}
+              <button type="button" onClick={() => remove(a.id)}>
+                Delete
+              </button>
+            </li>
+          ))}
+        </ul>
+      )}
+    </div>
+  );
+};
+
+// AssignmentReportView is the companion's completion report: each recent
+// assignment with its counts and how every item went.
+const AssignmentReportView = ({ token, apiUrl, studentId, refreshKey }) => {
+  const [assignments] = useAssignments(token, apiUrl, studentId, refreshKey);
+
+  if (assignments.length === 0) return null;
+  return (
+    <div id="assignment-report">
+      <h4>Assignments</h4>
+      {assignments.map((a) => (
+        <div key={a.id} className={"assignment-" + a.status}>
+          <div className="assignment-title">
+            {a.title} &middot; {formatDue(a)} &middot; {a.status}
+            {a.late && " (late)"}
+          </div>
+          <div className="assignment-progress">
+            {a.solved} of {a.total} solved, {a.first_try_correct} on the first
+            try
+            {a.skipped > 0 && ", " + a.skipped + " skipped"}
+          </div>
+          <ul>
+            {a.items.map((it) => (
+              <li key={it.position} className={"assignment-item-" + it.status}>
+                {it.expression} &middot; {it.status}
+                {it.attempts > 0 &&
+                  " · " +
+                    it.attempts +
+                    (it.attempts === 1 ? " attempt" : " attempts")}
+              </li>
+            ))}
+          </ul>
+        </div>
+      ))}
+    </div>
+  );
+};
+
+export { AssignmentsSettingsView, AssignmentReportView, parseExpressionLines };
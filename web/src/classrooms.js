@@ -0,0 +1,119 @@
+import React, { useCallback, useEffect, useState } from "react";
+
+// Classrooms: a family joins a teacher's classroom with its invite code and
+// the class envelope and difficulty floor then override these settings
+// (docs/accounts.md "Classrooms"). Teachers manage classrooms through the
+// /api/v1/teacher API; there is no teacher page yet.
+
+const authHeaders = (token) => ({
+  Accept: "application/json",
+  "Content-Type": "application/json",
+  Authorization: "Bearer " + token,
+});
+
+const ClassroomSettingsView = ({ token, apiUrl, user }) => {
+  const [membership, setMembership] = useState(null);
+  const [code, setCode] = useState("");
+  const [error, setError] = useState(null);
+  const [busy, setBusy] = useState(false);
+
+  const fetchMembership = useCallback(async () => {
+    if (token == null || apiUrl == null || user == null) return;
+    try {
+      const req = await fetch(apiUrl + "/classrooms/" + user.id, {
+        method: "GET",
+        headers: authHeaders(token),
+      });
+      if (req.ok) setMembership(await req.json());
+    } catch (e) {
+      console.log(e.message);
+    }
+  }, [token, apiUrl, user]);
+
+  useEffect(() => {
+    fetchMembership();
+  }, [fetchMembership]);
+
+  const join = async () => {
+    setBusy(true);
+    setError(null);
+    try {
+      const req = await fetch(apiUrl + "/classrooms/" + user.id + "/join", {
+        method: "POST",
+        headers: authHeaders(token),
+        body: JSON.stringify({ invite_code: code.trim() }),
+      });
+      const json = await req.json();
+      if (!req.ok) {
+        setError(json.error || "Could not join the classroom.");
+        return;
+      }
+      setCode("");
+      // The class settings were just written server-side; reload to show them.
+      window.location.reload();
+    } catch (e) {
+      console.log(e.message);
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  const leave = async () => {
+    try {
+      const req = await fetch(apiUrl + "/classrooms/" + user.id, {
+        method: "DELETE",
+        headers: authHeaders(token),
+      });
+      if (req.ok) setMembership(null);
+    } catch (e) {
+      console.log(e.message);
+    }
+  };
+
+  return (
+    <div id="classroom-settings" className="settings-form">
+      <h4>Classroom:</h4>
+      {membership ? (
+        <>
+          <p>
+            In <b>{membership.name}</b> (teacher: {membership.teacher}).
+          </p>
+          {(membership.problem_type_bitmap !== 0 ||
+            membership.difficulty_floor !== 0) && (
+            <p className="settings-hint">
+              The class sets
+              {membership.problem_type_bitmap !== 0 && " the topics"}
+              {membership.problem_type_bitmap !== 0 &&
+                membership.difficulty_floor !== 0 &&
+                " and"}
+              {membership.difficulty_floor !== 0 &&
+                " a minimum difficulty of " + membership.difficulty_floor}
+              ; changes here that go against it are overridden.
+            </p>
+          )}
+          <button type="button" onClick={leave}>
+            Leave classroom
+          </button>
+        </>
+      ) : (
+        <>
+          <label>
+            Invite code{" "}
+            <input
+              type="text"
+              maxLength="16"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+            />
+          </label>
+          <button type="button" disabled={busy || !code.trim()} onClick={join}>
+            Join
+          </button>
+          {error && <p className="assignment-error">{error}</p>}
+        </>
+      )}
+    </div>
+  );
+};
+
+export { ClassroomSettingsView };
@@ -8,6 +8,7 @@ import { PreprocessExpression } from "./problem.js";
 import { ProblemCompanionView } from "./problem_companion.js";
 import { VideoCompanionView } from "./video_companion.js";
 import { RequirePin } from "./pin.js";
+import { AssignmentReportView } from "./assignments.js";
 
 class RefresherSingleton {
   constructor(getGamestate, getEvents, interval) {
@@ -69,6 +70,8 @@ const CompanionView = ({ token, apiUrl, user }) => {
 
   const [answer, setAnswer] = useState(null);
   const [attempts, setAttempts] = useState(null);
+  const [achievements, setAchievements] = useState([]);
+  const [achievementTitles, setAchievementTitles] = useState({});
   const { student_id } = useParams();
   const interval = 30000;
 
@@ -167,6 +170,13 @@ const CompanionView = ({ token, apiUrl, user }) => {
       );
       const json = await req.json();
 
+      // Achievements earned within the window, newest first
+      setAchievements(
+        json
+          .filter((e) => e.event_type === "achievement_earned")
+          .reverse()
+      );
+
       // Clean up, sort, and store events
       var attempts = [];
       var attempts_buffer = [];
@@ -192,10 +202,45 @@ const CompanionView = ({ token, apiUrl, user }) => {
     }
   }, [token, apiUrl, student_id, gamestate]);
 
+  const getAchievementTitles = useCallback(async () => {
+    try {
+      if (token == null || apiUrl == null || student_id == null) {
+        return;
+      }
+      const reqParams = {
+        method: "GET",
+        headers: {
+          Accept: "application/json",
+          "Content-Type": "application/json",
+          Authorization: "Bearer " + token,
+        },
+      };
+      const req = await fetch(
+        apiUrl + "/achievements/" + student_id,
+        reqParams
+      );
+      if (!req.ok) {
+        return;
+      }
+      const json = await req.json();
+      var titles = {};
+      json.forEach((a) => {
+        titles[a.key] = a.title;
+      });
+      setAchievementTitles(titles);
+    } catch (e) {
+      console.log(e.message);
+    }
+  }, [token, apiUrl, student_id]);
+
   useEffect(() => {
     getGamestate();
   }, [getGamestate]);
 
+  useEffect(() => {
+    getAchievementTitles();
+  }, [getAchievementTitles]);
+
   useEffect(() => {
     getProblem();
   }, [getProblem]);
@@ -218,16 +263,37 @@ const CompanionView = ({ token, apiUrl, user }) => {
 
   new RefresherSingleton(getGamestate, getEvents, interval);
 
+  // The report refetches whenever the mirror moves to another problem.
+  const report = (
+    <AssignmentReportView
+      token={token}
+      apiUrl={apiUrl}
+      studentId={student_id}
+      refreshKey={gamestate.problem_id}
+    />
+  );
   if (gamestate.solved >= gamestate.target) {
-    return <VideoCompanionView video={video} />;
+    return (
+      <>
+        <VideoCompanionView video={video} />
+        {report}
+      </>
+    );
   }
   return (
-    <ProblemCompanionView
-      gamestate={gamestate}
-      latex={latex}
-      answer={answer}
-      attempts={attempts}
-    />
+    <>
+      <ProblemCompanionView
+        gamestate={gamestate}
+        latex={latex}
+        answer={answer}
+        attempts={attempts}
+        achievements={achievements.map((e) => ({
+          timestamp: e.timestamp,
+          title: achievementTitles[e.value] || e.value,
+        }))}
+      />
+      {report}
+    </>
   );
 };
 
@@ -89,11 +89,40 @@ class EventReporterSingleton {
   }
 }
 
+const SESSION_OVER_MESSAGES = {
+  daily_limit: "That's all the screen time for today.",
+  video_limit: "That's all the videos for today.",
+  quiet_hours: "It's quiet time.",
+};
+
+const SessionOverView = ({ sessionOver }) => {
+  const until = new Date(sessionOver.until);
+  return (
+    <div id="session-over">
+      <h2>
+        {SESSION_OVER_MESSAGES[sessionOver.reason] || "Play is over for now."}
+      </h2>
+      <p>
+        See you{" "}
+        {until.toLocaleString([], {
+          weekday: "long",
+          hour: "numeric",
+          minute: "2-digit",
+        })}
+        !
+      </p>
+    </div>
+  );
+};
+
 const PlayView = ({ token, apiUrl, user, postEvent, interval }) => {
   const [gamestate, setGamestate] = useState(null);
   const [problem, setProblem] = useState(null);
   const [latex, setLatex] = useState(null);
   const [video, setVideo] = useState(null);
+  const [videoCutoff, setVideoCutoff] = useState(0);
+  const [videoPreference, setVideoPreference] = useState(null);
+  const [sessionOver, setSessionOver] = useState(null);
   const [showReportModal, setShowReportModal] = useState(false);
   const [reportPin, setReportPin] = useState("");
   const [reportExplanation, setReportExplanation] = useState("");
@@ -138,6 +167,9 @@ const PlayView = ({ token, apiUrl, user, postEvent, interval }) => {
         setGamestate(json["gamestate"]);
         setProblem(json["problem"]);
         setVideo(json["video"]);
+        setVideoCutoff(json["video_cutoff_seconds"] || 0);
+        setVideoPreference(json["video_preference"] || null);
+        setSessionOver(json["session_over"] || null);
       } catch (e) {
         console.log(e.message);
       }
@@ -179,16 +211,56 @@ const PlayView = ({ token, apiUrl, user, postEvent, interval }) => {
   const eventReporter = new EventReporterSingleton(
     async (event_type, value) => {
       let json = await postEvent(event_type, value);
+      // Any event response can close the session (a limit reached mid-video).
+      if (json && json.session_over) {
+        setSessionOver(json.session_over);
+        return;
+      }
       if (event_type == "answered_problem" && json && json.gamestate) {
         setGamestate(json["gamestate"]);
         setProblem(json["problem"]);
         setVideo(json["video"]);
+        setVideoCutoff(json["video_cutoff_seconds"] || 0);
+        setVideoPreference(json["video_preference"] || null);
+      }
+      // The server ends a video that runs past its cutoff; move on.
+      if (
+        event_type == "watching_video" &&
+        json &&
+        json.gamestate &&
+        json.gamestate.solved < json.gamestate.target
+      ) {
+        window.location.pathname = "play";
       }
     },
     interval
   );
   eventReporter.clear();
 
+  // The child's rating of the reward video steers which videos come up.
+  const rateVideo = async (patch) => {
+    try {
+      const req = await fetch(apiUrl + "/videos/" + video.id + "/preferences", {
+        method: "POST",
+        headers: {
+          Accept: "application/json",
+          "Content-Type": "application/json",
+          Authorization: "Bearer " + token,
+        },
+        body: JSON.stringify(patch),
+      });
+      if (req.ok) {
+        setVideoPreference(await req.json());
+      }
+    } catch (e) {
+      console.log(e.message);
+    }
+  };
+
+  if (sessionOver) {
+    return <SessionOverView sessionOver={sessionOver} />;
+  }
+
   if (!gamestate || !problem) {
     return <div className="content-loading"></div>;
   }
@@ -208,6 +280,9 @@ const PlayView = ({ token, apiUrl, user, postEvent, interval }) => {
       return (
         <VideoView
           video={video}
+          cutoffSeconds={videoCutoff}
+          preference={videoPreference}
+          onRate={rateVideo}
           eventReporter={eventReporter}
           interval={interval}
         />
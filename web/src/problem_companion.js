@@ -24,7 +24,13 @@ const AttemptTime = ({ timestamp }) => {
   return <span className="attempt-time">({diff} second ago)</span>;
 };
 
-const ProblemCompanionView = ({ gamestate, latex, answer, attempts }) => {
+const ProblemCompanionView = ({
+  gamestate,
+  latex,
+  answer,
+  attempts,
+  achievements,
+}) => {
   if (
     gamestate == null ||
     latex == null ||
@@ -52,6 +58,16 @@ const ProblemCompanionView = ({ gamestate, latex, answer, attempts }) => {
           </div>
         ))}
       </div>
+      {achievements && achievements.length > 0 && (
+        <div id="problem-achievements">
+          <div id="problem-attempts-header">achievements</div>
+          {achievements.map((a) => (
+            <div key={a.timestamp + a.title} className="problem-attempt">
+              {a.title} <AttemptTime timestamp={a.timestamp} />
+            </div>
+          ))}
+        </div>
+      )}
     </div>
   );
 };
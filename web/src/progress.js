@@ -25,8 +25,19 @@ const formatMs = (ms) => {
   return `${s}s`;
 };
 
+// Format a topic name ("mismatched_denominators") for display
+const formatTopic = (topic) =>
+  topic
+    ? topic.charAt(0).toUpperCase() + topic.slice(1).replace(/_/g, " ")
+    : "—";
+
+// First-try accuracy as a whole percentage, or "—" with no attempts
+const formatFirstTry = (firstTry, attempts) =>
+  attempts > 0 ? `${Math.round((100 * firstTry) / attempts)}%` : "—";
+
 const ProgressView = ({ token, apiUrl, user }) => {
   const [data, setData] = useState(null);
+  const [achievements, setAchievements] = useState([]);
   const [loading, setLoading] = useState(true);
   const [error, setError] = useState(null);
 
@@ -54,6 +65,15 @@ const ProgressView = ({ token, apiUrl, user }) => {
         }
         const json = await res.json();
         setData(json);
+        // Achievements are a nice-to-have on this page; a failure leaves
+        // the section hidden rather than failing the page.
+        const achRes = await fetch(
+          apiUrl + "/achievements/" + user.id,
+          reqParams
+        );
+        if (achRes.ok) {
+          setAchievements(await achRes.json());
+        }
       } catch (e) {
         setError(e.message || "Could not load statistics");
         setData(null);
@@ -114,8 +134,42 @@ const ProgressView = ({ token, apiUrl, user }) => {
           <span className="progress-summary-value">{workPct}%</span>
           <span className="progress-summary-label">Time on math</span>
         </div>
+        <div className="progress-summary-item">
+          <span className="progress-summary-value">
+            {data.total_achievements_earned ?? 0}
+          </span>
+          <span className="progress-summary-label">Achievements</span>
+        </div>
       </section>
 
+      {achievements.length > 0 && (
+        <section className="progress-by-month">
+          <h2>Achievements</h2>
+          <table className="progress-by-month-table">
+            <thead>
+              <tr>
+                <th>Achievement</th>
+                <th>Goal</th>
+                <th>Progress</th>
+              </tr>
+            </thead>
+            <tbody>
+              {achievements.map((a) => (
+                <tr key={a.key}>
+                  <td>{a.title}</td>
+                  <td>{a.description}</td>
+                  <td>
+                    {a.earned
+                      ? `Earned ${new Date(a.earned_at).toLocaleDateString()}`
+                      : `${a.progress} / ${a.target}`}
+                  </td>
+                </tr>
+              ))}
+            </tbody>
+          </table>
+        </section>
+      )}
+
       {Array.isArray(data.stats_by_month) && data.stats_by_month.length > 0 && (
         <section className="progress-by-month">
           <h2>By month</h2>
@@ -152,6 +206,58 @@ const ProgressView = ({ token, apiUrl, user }) => {
           </table>
         </section>
       )}
+
+      {Array.isArray(data.topics) && data.topics.length > 0 && (
+        <section className="progress-by-month">
+          <h2>By topic</h2>
+          <table className="progress-by-month-table">
+            <thead>
+              <tr>
+                <th>Topic</th>
+                <th>Attempts</th>
+                <th>First try</th>
+                <th>Latest week</th>
+                <th>Typical time</th>
+                <th>Hardest solved</th>
+              </tr>
+            </thead>
+            <tbody>
+              {data.topics.map((row) => {
+                // weekly is newest first: the most recent week with attempts.
+                const week =
+                  Array.isArray(row.weekly) && row.weekly.length > 0
+                    ? row.weekly[0]
+                    : null;
+                return (
+                  <tr key={row.bit}>
+                    <td>{formatTopic(row.topic)}</td>
+                    <td>{row.attempts ?? 0}</td>
+                    <td>
+                      {formatFirstTry(row.first_try_correct, row.attempts)}
+                      {row.trend ? ` (${row.trend})` : ""}
+                    </td>
+                    <td>
+                      {week
+                        ? formatFirstTry(week.first_try_correct, week.attempts)
+                        : "—"}
+                    </td>
+                    <td>
+                      {row.median_solve_ms > 0
+                        ? formatMs(row.median_solve_ms)
+                        : "—"}
+                    </td>
+                    <td>
+                      {row.max_difficulty_solved > 0
+                        ? row.max_difficulty_solved.toFixed(1)
+                        : "—"}
+                    </td>
+                  </tr>
+                );
+              })}
+            </tbody>
+          </table>
+        </section>
+      )}
     </div>
   );
 };
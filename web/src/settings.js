@@ -7,6 +7,8 @@ import {
   MIN_TARGET_DIFFICULTY,
 } from "./bitmap_validation.js";
 import { RequirePin } from "./pin.js";
+import { AssignmentsSettingsView } from "./assignments.js";
+import { ClassroomSettingsView } from "./classrooms.js";
 import "./settings.scss";
 
 const postSettings = async function (token, apiUrl, model) {
@@ -229,11 +231,38 @@ const ProblemTypesSettingsView = ({
 // Add public YouTube playlist links to show as "Recommended playlists" (UI only).
 const RECOMMENDED_PLAYLISTS = [];
 
-const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
-  const [myPlaylists, setMyPlaylists] = useState([]);
+// catalogMeta summarizes a catalog playlist's curation, e.g.
+// " · ages 4–8 · space, science".
+function catalogMeta(catalog) {
+  if (!catalog) return "";
+  const parts = [];
+  const { min_age: min, max_age: max } = catalog;
+  if (min != null && max != null) parts.push("ages " + min + "–" + max);
+  else if (min != null) parts.push("ages " + min + "+");
+  else if (max != null) parts.push("up to age " + max);
+  if (catalog.topics && catalog.topics.length > 0)
+    parts.push(catalog.topics.join(", "));
+  if (catalog.description) parts.push(catalog.description);
+  return parts.map((p) => " · " + p).join("");
+}
+
+const PlaylistsSettingsView = ({
+  token,
+  apiUrl,
+  user,
+  onPlaylistsChange,
+  refreshKey,
+}) => {
+  // GET /playlists returns the user's playlists, then the catalog ones they
+  // haven't subscribed to (subscribed: false).
+  const [allPlaylists, setAllPlaylists] = useState([]);
+  const myPlaylists = allPlaylists.filter((p) => p.subscribed);
+  const catalogPlaylists = allPlaylists.filter((p) => !p.subscribed);
   const [playlistInput, setPlaylistInput] = useState("");
   const [playlistError, setPlaylistError] = useState(null);
   const [addingPlaylist, setAddingPlaylist] = useState(false);
+  // Folders of the self-hosted media library; empty when the server has none.
+  const [localFolders, setLocalFolders] = useState([]);
 
   const authHeaders = () => ({
     Accept: "application/json",
@@ -250,7 +279,7 @@ const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
       });
       if (req.ok) {
         const json = await req.json();
-        setMyPlaylists(Array.isArray(json) ? json : []);
+        setAllPlaylists(Array.isArray(json) ? json : []);
       }
     } catch (e) {
       console.log(e.message);
@@ -259,17 +288,35 @@ const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
 
   useEffect(() => {
     fetchMyPlaylists();
-  }, [fetchMyPlaylists]);
+  }, [fetchMyPlaylists, refreshKey]);
+
+  useEffect(() => {
+    if (token == null || apiUrl == null || user == null) return;
+    fetch(apiUrl + "/local-media", { method: "GET", headers: authHeaders() })
+      .then((req) => (req.ok ? req.json() : []))
+      .then((json) => setLocalFolders(Array.isArray(json) ? json : []))
+      .catch((e) => console.log(e.message));
+  }, [token, apiUrl, user]);
 
   const handleAddPlaylistByUrl = async (e) => {
-    setPlaylistError(null);
     const urlOrId = playlistInput.trim();
     if (!urlOrId) return;
+    addPlaylist(
+      urlOrId.startsWith("http")
+        ? { playlist_url: urlOrId }
+        : { youtube_playlist_id: urlOrId },
+      () => setPlaylistInput("")
+    );
+  };
+
+  const handleAddLocalFolder = (folder) => {
+    addPlaylist({ provider: "local", external_playlist_id: folder.id });
+  };
+
+  const addPlaylist = async (body, onAdded) => {
+    setPlaylistError(null);
     setAddingPlaylist(true);
     try {
-      const body = urlOrId.startsWith("http")
-        ? { playlist_url: urlOrId }
-        : { youtube_playlist_id: urlOrId };
       const req = await fetch(apiUrl + "/playlists", {
         method: "POST",
         headers: authHeaders(),
@@ -277,7 +324,7 @@ const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
       });
       const data = req.ok ? await req.json().catch(() => ({})) : null;
       if (req.ok) {
-        setPlaylistInput("");
+        if (onAdded) onAdded();
         fetchMyPlaylists();
         if (onPlaylistsChange) onPlaylistsChange();
       } else {
@@ -293,6 +340,24 @@ const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
     }
   };
 
+  // A "review" playlist holds new videos back until they are approved in
+  // VideoApprovalsView; switching to it keeps the videos already there.
+  const handleSetPolicy = async (playlistId, policy) => {
+    try {
+      const req = await fetch(apiUrl + "/playlists/" + playlistId, {
+        method: "POST",
+        headers: authHeaders(),
+        body: JSON.stringify({ approval_policy: policy }),
+      });
+      if (req.ok) {
+        fetchMyPlaylists();
+        if (onPlaylistsChange) onPlaylistsChange();
+      }
+    } catch (e) {
+      console.log(e.message);
+    }
+  };
+
   const handleRemovePlaylist = async (playlistId) => {
     try {
       const req = await fetch(apiUrl + "/playlists/" + playlistId, {
@@ -313,8 +378,8 @@ const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
       <div className="settings-form" id="playlists-settings">
         <h4>Your playlists</h4>
         <p className="settings-hint">
-          Add YouTube playlists; reward videos will be chosen from the union of
-          all your playlists.
+          Add YouTube playlists or media library folders; reward videos will be
+          chosen from the union of all your playlists.
         </p>
         {playlistError && (
           <p className="error playlist-error">{playlistError}</p>
@@ -353,17 +418,39 @@ const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
                     : "none",
                 }}
               />
-              <a
-                href={
-                  "https://www.youtube.com/playlist?list=" +
-                  (p.you_tube_id || "")
-                }
-                target="_blank"
-                rel="noopener noreferrer"
-                className="playlist-title"
-              >
-                {p.title || p.you_tube_id || "Playlist " + p.id}
-              </a>
+              {p.provider === "local" ? (
+                <span className="playlist-title">
+                  {p.title || "Playlist " + p.id}
+                </span>
+              ) : (
+                <a
+                  href={
+                    "https://www.youtube.com/playlist?list=" +
+                    (p.you_tube_id || "")
+                  }
+                  target="_blank"
+                  rel="noopener noreferrer"
+                  className="playlist-title"
+                >
+                  {p.title || p.you_tube_id || "Playlist " + p.id}
+                </a>
+              )}
+              <label className="playlist-review">
+                <input
+                  type="checkbox"
+                  checked={p.approval_policy === "review"}
+                  onChange={(e) =>
+                    handleSetPolicy(p.id, e.target.checked ? "review" : "auto")
+                  }
+                />
+                Review new videos
+                {p.pending_count > 0 && (
+                  <span className="playlist-pending">
+                    {" "}
+                    ({p.pending_count} waiting)
+                  </span>
+                )}
+              </label>
               <span
                 className="playlist-remove"
                 onClick={() => handleRemovePlaylist(p.id)}
@@ -373,6 +460,64 @@ const PlaylistsSettingsView = ({ token, apiUrl, user, onPlaylistsChange }) => {
             </li>
           ))}
         </ul>
+        {catalogPlaylists.length > 0 && (
+          <div className="curated-section">
+            <h4>Catalog</h4>
+            <p className="settings-hint">
+              Playlists picked for children, shared by every family.
+            </p>
+            <ul id="catalog-playlist-list">
+              {catalogPlaylists.map((p) => (
+                <li key={p.id} className="recommended-playlist-item">
+                  <span className="recommended-link">
+                    {p.title || "Playlist " + p.id}
+                    <span className="catalog-meta">
+                      {catalogMeta(p.catalog)}
+                    </span>
+                  </span>
+                  <button
+                    type="button"
+                    className="add-recommended"
+                    onClick={() => addPlaylist({ playlist_id: p.id })}
+                    disabled={addingPlaylist}
+                  >
+                    Subscribe
+                  </button>
+                </li>
+              ))}
+            </ul>
+          </div>
+        )}
+        {localFolders.length > 0 && (
+          <div className="curated-section">
+            <h4>Media library</h4>
+            <p className="settings-hint">
+              Folders of videos stored on this server, ad-free.
+            </p>
+            <ul id="local-media-list">
+              {localFolders.map((f) => (
+                <li key={f.id} className="recommended-playlist-item">
+                  <span className="recommended-link">
+                    {f.title} ({f.video_count})
+                  </span>
+                  <button
+                    type="button"
+                    className="add-recommended"
+                    onClick={() => handleAddLocalFolder(f)}
+                    disabled={
+                      addingPlaylist ||
+                      myPlaylists.some((p) => p.you_tube_id === f.id)
+                    }
+                  >
+                    {myPlaylists.some((p) => p.you_tube_id === f.id)
+                      ? "Added"
+                      : "Add folder"}
+                  </button>
+                </li>
+              ))}
+            </ul>
+          </div>
+        )}
         {RECOMMENDED_PLAYLISTS.length > 0 && (
           <div className="curated-section">
             <h4>Recommended playlists</h4>
@@ -508,6 +653,203 @@ const TargetWorkPercentageSettingsView = ({
   );
 };
 
+const DigestSettingsView = ({ token, apiUrl, user, settings }) => {
+  const [optIn, setOptIn] = useState(!!settings.digest_opt_in);
+
+  const handleChange = (e) => {
+    const val = e.target.checked;
+    setOptIn(val);
+    settings.digest_opt_in = val;
+    postSettings(token, apiUrl, settings);
+  };
+
+  return (
+    <div id="digest-settings" className="settings-form">
+      <h4>Weekly email:</h4>
+      <label>
+        <input type="checkbox" checked={optIn} onChange={handleChange} />{" "}
+        Send me a weekly progress summary
+      </label>
+      <p className="settings-hint">
+        Sent Monday mornings to {user.email || "your account email"}.
+      </p>
+    </div>
+  );
+};
+
+// Minutes after midnight <-> "HH:MM" for <input type="time">.
+const minutesToTime = (m) =>
+  String(Math.floor(m / 60)).padStart(2, "0") +
+  ":" +
+  String(m % 60).padStart(2, "0");
+const timeToMinutes = (t) => {
+  const [h, m] = (t || "00:00").split(":").map((x) => parseInt(x, 10) || 0);
+  return h * 60 + m;
+};
+
+const ScreenTimeSettingsView = ({ token, apiUrl, user, settings }) => {
+  const [dailyLimit, setDailyLimit] = useState(
+    settings.daily_limit_minutes ?? 0
+  );
+  const [videoLimit, setVideoLimit] = useState(
+    settings.daily_video_limit_minutes ?? 0
+  );
+  const [quietStart, setQuietStart] = useState(
+    minutesToTime(settings.quiet_hours_start ?? 0)
+  );
+  const [quietEnd, setQuietEnd] = useState(
+    minutesToTime(settings.quiet_hours_end ?? 0)
+  );
+  const [timezone, setTimezone] = useState(settings.timezone || "UTC");
+  const deviceTimezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+
+  const clampMinutes = (v) =>
+    Math.max(0, Math.min(1440, parseInt(v, 10) || 0));
+
+  const handleSubmit = (overrides) => {
+    settings.daily_limit_minutes = clampMinutes(dailyLimit);
+    settings.daily_video_limit_minutes = clampMinutes(videoLimit);
+    settings.quiet_hours_start = timeToMinutes(quietStart);
+    settings.quiet_hours_end = timeToMinutes(quietEnd);
+    settings.timezone = timezone;
+    Object.assign(settings, overrides);
+    postSettings(token, apiUrl, settings);
+  };
+
+  return (
+    <div id="screen-time-settings" className="settings-form">
+      <h4>Screen time:</h4>
+      <label>
+        Daily limit (minutes, 0 = none){" "}
+        <input
+          type="number"
+          min="0"
+          max="1440"
+          value={dailyLimit}
+          onChange={(e) => setDailyLimit(e.target.value)}
+          onBlur={() => handleSubmit()}
+        />
+      </label>
+      <label>
+        Daily video limit (minutes, 0 = none){" "}
+        <input
+          type="number"
+          min="0"
+          max="1440"
+          value={videoLimit}
+          onChange={(e) => setVideoLimit(e.target.value)}
+          onBlur={() => handleSubmit()}
+        />
+      </label>
+      <label>
+        Quiet hours from{" "}
+        <input
+          type="time"
+          value={quietStart}
+          onChange={(e) => setQuietStart(e.target.value)}
+          onBlur={() => handleSubmit()}
+        />{" "}
+        to{" "}
+        <input
+          type="time"
+          value={quietEnd}
+          onChange={(e) => setQuietEnd(e.target.value)}
+          onBlur={() => handleSubmit()}
+        />
+      </label>
+      <p className="settings-hint">
+        Times are in {timezone}.{" "}
+        {deviceTimezone && deviceTimezone !== timezone && (
+          <button
+            type="button"
+            onClick={() => {
+              setTimezone(deviceTimezone);
+              handleSubmit({ timezone: deviceTimezone });
+            }}
+          >
+            Use {deviceTimezone}
+          </button>
+        )}{" "}
+        Same start and end means no quiet hours.
+      </p>
+    </div>
+  );
+};
+
+// Server feature name ("fractions") for each ProblemTypes bit.
+const TOPIC_NAMES = Object.fromEntries(
+  Object.entries(ProblemTypes).map(([k, bit]) => [bit, k.toLowerCase()])
+);
+
+const parseTopicWeights = (s) => {
+  try {
+    return s ? JSON.parse(s) : {};
+  } catch (e) {
+    return {};
+  }
+};
+
+// Per-topic weights for selection's topic interleaving (docs/selection.md):
+// one 0-10 input per enabled topic, 1 by default. Only non-default weights
+// are saved; weights for disabled topics are kept for when they return.
+const TopicWeightsSettingsView = ({
+  token,
+  apiUrl,
+  user,
+  settings,
+  bitmap,
+}) => {
+  const [weights, setWeights] = useState(
+    parseTopicWeights(settings.topic_weights)
+  );
+  const topics = PROBLEM_TYPE_GROUPS.flatMap((g) => g.entries).filter(
+    (e) => (bitmap & e.bit) !== 0
+  );
+
+  const handleChange = (name, value) => {
+    const w = Math.max(0, Math.min(10, parseFloat(value)));
+    const next = { ...weights };
+    if (Number.isNaN(w) || w === 1) {
+      delete next[name];
+    } else {
+      next[name] = w;
+    }
+    setWeights(next);
+    settings.topic_weights =
+      Object.keys(next).length > 0 ? JSON.stringify(next) : "";
+    postSettings(token, apiUrl, settings);
+  };
+
+  if (topics.length < 2) {
+    return null;
+  }
+  return (
+    <div id="topic-weights-settings" className="settings-form">
+      <h4>Topic mix:</h4>
+      <p className="settings-hint">
+        Every topic comes up at least once every 10 problems. Raise a weight
+        for more of a topic; 0 stops it being pushed.
+      </p>
+      {topics.map((e) => {
+        const name = TOPIC_NAMES[e.bit];
+        return (
+          <label key={name}>
+            {e.label}{" "}
+            <input
+              type="number"
+              min="0"
+              max="10"
+              step="0.5"
+              defaultValue={weights[name] ?? 1}
+              onBlur={(ev) => handleChange(name, ev.target.value)}
+            />
+          </label>
+        );
+      })}
+    </div>
+  );
+};
+
 function videoPlayUrl(video) {
   if (video.url) return video.url;
   if (video.you_tube_id)
@@ -525,7 +867,37 @@ const VideosSettingsView = ({
   const [error, setError] = useState(true);
   const [videos, setVideos] = useState([]);
 
-  const getEnabledVideoCount = (list) => list.filter((v) => !v.disabled).length;
+  const getEnabledVideoCount = (list) =>
+    list.filter((v) => !v.disabled && !v.blocked).length;
+
+  // Pin keeps a video in the rotation; block takes it out of the rewards
+  // without removing its playlist. Both are parent controls, so they live
+  // behind the settings PIN.
+  const setPreference = async (video, patch) => {
+    try {
+      const req = await fetch(apiUrl + "/videos/" + video.id + "/preferences", {
+        method: "POST",
+        headers: {
+          Accept: "application/json",
+          "Content-Type": "application/json",
+          Authorization: "Bearer " + token,
+        },
+        body: JSON.stringify(patch),
+      });
+      if (req.ok) {
+        const pref = await req.json();
+        const list = videos.map((v) =>
+          v.id === video.id ? { ...v, ...pref } : v
+        );
+        setVideos(list);
+        const numEnabled = getEnabledVideoCount(list);
+        setError(numEnabled < 3);
+        if (errCallback) errCallback(numEnabled < 3);
+      }
+    } catch (e) {
+      console.log(e.message);
+    }
+  };
 
   useEffect(() => {
     const getVideos = async () => {
@@ -563,8 +935,8 @@ const VideosSettingsView = ({
         </h4>
         <p className="settings-hint">
           These are the reward videos (union of the playlists you added above).
-          To add or remove videos, manage the playlists on YouTube or remove a
-          playlist above.
+          Pin a video to keep it in the rotation, or block one to stop it
+          playing without removing its playlist. &#9829; marks a favourite.
         </p>
         <ul id="video-list">
           <li id="video-list-header">
@@ -572,7 +944,10 @@ const VideosSettingsView = ({
             <span className="video-title">TITLE</span>
           </li>
           {videos.map((video, i) => (
-            <li key={video.id} className={video.disabled ? "disabled" : ""}>
+            <li
+              key={video.id}
+              className={video.disabled || video.blocked ? "disabled" : ""}
+            >
               <span className="video-number">{i + 1}</span>
               <span
                 className="video-thumbnail"
@@ -595,7 +970,28 @@ const VideosSettingsView = ({
                   )}
                 </a>
               </span>
-              <span className="video-title">{video.title}</span>
+              <span className="video-title">
+                {video.favourite && (
+                  <span className="video-favourite">&#9829; </span>
+                )}
+                {video.title}
+              </span>
+              <button
+                className={"video-pin" + (video.pinned ? " active" : "")}
+                disabled={video.blocked}
+                onClick={() => setPreference(video, { pinned: !video.pinned })}
+              >
+                {video.pinned ? "Pinned" : "Pin"}
+              </button>
+              <button
+                className={"video-block" + (video.blocked ? " active" : "")}
+                disabled={video.pinned}
+                onClick={() =>
+                  setPreference(video, { blocked: !video.blocked })
+                }
+              >
+                {video.blocked ? "Unblock" : "Block"}
+              </button>
             </li>
           ))}
         </ul>
@@ -604,6 +1000,130 @@ const VideosSettingsView = ({
   );
 };
 
+// VideoApprovalsView is the queue of videos from "review" playlists waiting
+// for a decision. Only approved ones become rewards; a rejected one stays out
+// even if the playlist keeps it. Hidden when nothing is waiting.
+const VideoApprovalsView = ({
+  token,
+  apiUrl,
+  user,
+  onDecided,
+  refreshKey,
+}) => {
+  const [pending, setPending] = useState([]);
+  const [busy, setBusy] = useState(false);
+
+  const authHeaders = () => ({
+    Accept: "application/json",
+    "Content-Type": "application/json",
+    Authorization: "Bearer " + token,
+  });
+
+  const fetchPending = useCallback(async () => {
+    if (token == null || apiUrl == null || user == null) return;
+    try {
+      const req = await fetch(apiUrl + "/video-approvals", {
+        method: "GET",
+        headers: authHeaders(),
+      });
+      if (req.ok) {
+        const json = await req.json();
+        setPending(Array.isArray(json) ? json : []);
+      }
+    } catch (e) {
+      console.log(e.message);
+    }
+  }, [token, apiUrl, user]);
+
+  useEffect(() => {
+    fetchPending();
+  }, [fetchPending, refreshKey]);
+
+  const decide = async (videoIds, decision) => {
+    setBusy(true);
+    try {
+      const req = await fetch(apiUrl + "/video-approvals", {
+        method: "POST",
+        headers: authHeaders(),
+        body: JSON.stringify({ video_ids: videoIds, decision }),
+      });
+      if (req.ok) {
+        setPending((list) =>
+          list.filter((v) => !videoIds.includes(v.video_id))
+        );
+        if (onDecided) onDecided();
+      }
+    } catch (e) {
+      console.log(e.message);
+    } finally {
+      setBusy(false);
+    }
+  };
+
+  if (pending.length === 0) return null;
+  return (
+    <div className="settings-form" id="video-approvals">
+      <h4>Videos waiting for review ({pending.length})</h4>
+      <p className="settings-hint">
+        New videos from playlists you review. Approve one to add it to the
+        rewards; rejected videos stay out.
+      </p>
+      <button
+        type="button"
+        className="approve-all"
+        disabled={busy}
+        onClick={() => decide(pending.map((v) => v.video_id), "approve")}
+      >
+        Approve all
+      </button>
+      <ul id="approval-list">
+        {pending.map((video) => (
+          <li key={video.video_id}>
+            <span
+              className="video-thumbnail"
+              style={{
+                backgroundImage: video.thumbnailurl
+                  ? `url(${video.thumbnailurl})`
+                  : "none",
+              }}
+            >
+              <a
+                className="video-play"
+                href={videoPlayUrl(video)}
+                target="_blank"
+                rel="noopener noreferrer"
+              >
+                <span>&#9654;</span>
+              </a>
+            </span>
+            <span className="video-title">
+              {video.title}
+              <span className="video-playlists">
+                {" "}
+                &middot; {video.playlists.map((p) => p.title).join(", ")}
+              </span>
+            </span>
+            <button
+              className="video-approve"
+              disabled={busy}
+              onClick={() => decide([video.video_id], "approve")}
+            >
+              Approve
+            </button>
+            <button
+              className="video-reject"
+              disabled={busy}
+              onClick={() => decide([video.video_id], "reject")}
+            >
+              Reject
+            </button>
+          </li>
+        ))}
+      </ul>
+    </div>
+  );
+};
+
 const SettingsView = ({ token, apiUrl, user, settings }) => {
   const [videosRefreshKey, setVideosRefreshKey] = useState(0);
   const [bitmap, setBitmap] = useState(settings.problem_type_bitmap);
@@ -634,6 +1154,30 @@ const SettingsView = ({ token, apiUrl, user, settings }) => {
         />
       </div>
 
+      <div className="tab-content">
+        <TopicWeightsSettingsView
+          token={token}
+          apiUrl={apiUrl}
+          user={user}
+          settings={settings}
+          bitmap={bitmap}
+        />
+      </div>
+
+      <div className="tab-content">
+        <ClassroomSettingsView token={token} apiUrl={apiUrl} user={user} />
+      </div>
+
+      <div className="tab-content">
+        <AssignmentsSettingsView
+          token={token}
+          apiUrl={apiUrl}
+          user={user}
+          settings={settings}
+          bitmap={bitmap}
+        />
+      </div>
+
       <div className="tab-content">
         <TargetWorkPercentageSettingsView
           token={token}
@@ -643,12 +1187,41 @@ const SettingsView = ({ token, apiUrl, user, settings }) => {
         />
       </div>
 
+      <div className="tab-content">
+        <ScreenTimeSettingsView
+          token={token}
+          apiUrl={apiUrl}
+          user={user}
+          settings={settings}
+        />
+      </div>
+
+      <div className="tab-content">
+        <DigestSettingsView
+          token={token}
+          apiUrl={apiUrl}
+          user={user}
+          settings={settings}
+        />
+      </div>
+
       <div className="tab-content">
         <PlaylistsSettingsView
           token={token}
           apiUrl={apiUrl}
           user={user}
           onPlaylistsChange={() => setVideosRefreshKey((k) => k + 1)}
+          refreshKey={videosRefreshKey}
+        />
+      </div>
+
+      <div className="tab-content">
+        <VideoApprovalsView
+          token={token}
+          apiUrl={apiUrl}
+          user={user}
+          onDecided={() => setVideosRefreshKey((k) => k + 1)}
+          refreshKey={videosRefreshKey}
         />
       </div>
 
@@ -668,6 +1241,7 @@ const SettingsView = ({ token, apiUrl, user, settings }) => {
 export {
   ProblemTypesSettingsView,
   PlaylistsSettingsView,
+  VideoApprovalsView,
   VideosSettingsView,
   SettingsView,
 };
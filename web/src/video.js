@@ -3,9 +3,17 @@ import ReactPlayer from "react-player";
 
 import "./video.scss";
 
-const VideoView = ({ video, eventReporter, interval }) => {
+const VideoView = ({
+  video,
+  cutoffSeconds,
+  preference,
+  onRate,
+  eventReporter,
+  interval,
+}) => {
   const [playing, setPlaying] = useState(false);
   const [elapsed, setElapsed] = useState(0);
+  const doneRef = useRef(false);
 
   const elapsedRef = useRef();
   useEffect(() => {
@@ -31,6 +39,15 @@ const VideoView = ({ video, eventReporter, interval }) => {
     }
   };
 
+  const done = () => {
+    if (doneRef.current) return;
+    doneRef.current = true;
+    setPlaying(false);
+    eventReporter.postEvent("done_watching_video", video.id).then(() => {
+      window.location.pathname = "play";
+    });
+  };
+
   // Remove the playlist parameter from the video url
   var u = new URL(video.url);
   u.searchParams.delete("list");
@@ -53,14 +70,12 @@ const VideoView = ({ video, eventReporter, interval }) => {
               playedMillis - elapsedRef.current
             );
             setElapsed(playedMillis);
+            // Stop at the time the cycle's work earned (0 = no cutoff).
+            if (cutoffSeconds > 0 && e.playedSeconds >= cutoffSeconds) {
+              done();
+            }
           }}
-          onEnded={() => {
-            eventReporter
-              .postEvent("done_watching_video", video.id)
-              .then(() => {
-                window.location.pathname = "play";
-              });
-          }}
+          onEnded={done}
           onError={(e) => {
             eventReporter.postEvent("error_playing_video", e).then(() => {
               window.location.pathname = "play";
@@ -69,6 +84,28 @@ const VideoView = ({ video, eventReporter, interval }) => {
         />
         <div id="click-blocker" onClick={playPause}></div>
       </div>
+      {preference && onRate && (
+        <div id="video-rating">
+          <button
+            className={preference.rating > 0 ? "active" : ""}
+            onClick={() => onRate({ rating: preference.rating > 0 ? 0 : 1 })}
+          >
+            &#128077;
+          </button>
+          <button
+            className={preference.rating < 0 ? "active" : ""}
+            onClick={() => onRate({ rating: preference.rating < 0 ? 0 : -1 })}
+          >
+            &#128078;
+          </button>
+          <button
+            className={preference.favourite ? "active" : ""}
+            onClick={() => onRate({ favourite: !preference.favourite })}
+          >
+            &#9829;
+          </button>
+        </div>
+      )}
     </div>
   );
 };